@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/tar"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/backup"
+	"github.com/pkg/errors"
+)
+
+// tokenEnv 는 -token 을 주지 않았을 때 사용할 Bearer token 의 환경 변수이다.
+const tokenEnv = "DBOLT_TOKEN"
+
+func doRequest(method, url, token string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// runBackup 은 한 노드의 bolt 스냅샷 또는 클러스터 전체 백업(tar)을 파일로 내려받는다.
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	addr := fs.String("addr", "http://localhost:8080", "Base URL of the dbolt-server.")
+	token := fs.String("token", os.Getenv(tokenEnv), "Bearer token with the admin permission. Defaults to $"+tokenEnv+".")
+	cluster := fs.Bool("cluster", false, "Back up every instance in the ring along with the ring state.")
+	out := fs.String("out", "", "Output file path. Defaults to dbolt.db or dbolt-cluster.tar.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := "/admin/backup"
+	if *cluster {
+		path = "/admin/backup/cluster"
+	}
+	if *out == "" {
+		*out = "dbolt.db"
+		if *cluster {
+			*out = "dbolt-cluster.tar"
+		}
+	}
+
+	resp, err := doRequest(http.MethodGet, strings.TrimSuffix(*addr, "/")+path, *token, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to request the backup")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	// 서버가 백업 중에 실패하면 본문이 마지막 chunk 없이 끊기므로 io.Copy 가 실패한다. 잘린 파일은 남기지 않는다.
+	n, err := io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*out)
+		return errors.Wrap(err, "failed to write the backup")
+	}
+	fmt.Printf("Wrote %d bytes to %s\n", n, *out)
+	return nil
+}
+
+// runRestore 는 스냅샷을 서버로 업로드해 Distributor 를 통해 현재 Ring 에 다시 기록하게 한다.
+// 클러스터 백업(tar)이 주어지면 포함된 모든 인스턴스 스냅샷을 차례로 업로드한다.
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	addr := fs.String("addr", "http://localhost:8080", "Base URL of the dbolt-server.")
+	token := fs.String("token", os.Getenv(tokenEnv), "Bearer token with the admin permission. Defaults to $"+tokenEnv+".")
+	in := fs.String("in", "", "Snapshot file (.db) or cluster backup (.tar) to restore.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return errors.New("-in required")
+	}
+
+	url := strings.TrimSuffix(*addr, "/") + "/admin/restore"
+	file, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if !strings.HasSuffix(*in, ".tar") {
+		return uploadSnapshot(url, *token, *in, file)
+	}
+
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read the cluster backup")
+		}
+		if !strings.HasPrefix(header.Name, backup.InstancesDir) {
+			continue
+		}
+		if err := uploadSnapshot(url, *token, header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func uploadSnapshot(url, token, name string, snapshot io.Reader) error {
+	resp, err := doRequest(http.MethodPost, url, token, snapshot)
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload the snapshot : name=%s", name)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("failed to restore %s: %s %s", name, resp.Status, string(body))
+	}
+	fmt.Printf("Restored %s: %s\n", name, string(body))
+	return nil
+}
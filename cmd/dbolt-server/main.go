@@ -1,13 +1,41 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"os"
+
 	"github.com/kwSeo/dbolt/pkg/dbolt"
 )
 
+// commands 는 서버 실행 이외에 dbolt-server 가 제공하는 운영용 하위 명령들이다.
+var commands = map[string]func(args []string) error{
+	"backup":          runBackup,
+	"restore":         runRestore,
+	"migrate":         runMigrate,
+	"validate-config": runValidateConfig,
+}
+
 func main() {
-	configPath := flag.String("config-path", "./config.yml", "Configuration file path.")
-	flag.Parse()
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
 
-	dbolt.NewApp(*configPath).Run()
+	// 설정 파일 이외의 모든 설정도 flag 나 DBOLT_ 환경 변수로 정할 수 있다. -h 로 전체 목록을 볼 수 있다.
+	loader, err := dbolt.NewConfigLoader(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		// flag 오류는 flag 패키지가 사용법과 함께 이미 출력했다.
+		os.Exit(2)
+	}
+	dbolt.NewApp(loader).Run()
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/pkg/errors"
+)
+
+// runMigrate 는 서버가 멈춘 상태에서 한 엔진의 파일을 다른 엔진의 새 파일로 복사한다.
+// 값은 저장된 그대로 복사되므로 암호화된 값도 같은 keyfile 로 계속 읽을 수 있다.
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fromEngine := fs.String("from-engine", store.EngineBoltDB, "Storage engine of the source file. (bbolt, boltdb)")
+	from := fs.String("from", "", "Source file path.")
+	toEngine := fs.String("to-engine", store.EngineBBolt, "Storage engine of the destination file. (bbolt, boltdb)")
+	to := fs.String("to", "", "Destination file path. Must not exist.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return errors.New("-from and -to required")
+	}
+	if *fromEngine == store.EngineMemory || *toEngine == store.EngineMemory {
+		return errors.New("the memory engine has no file to migrate")
+	}
+	if _, err := os.Stat(*to); err == nil {
+		return errors.Errorf("destination already exists : %s", *to)
+	}
+
+	src, err := store.OpenEngine(*fromEngine, *from, 0600, &store.EngineOptions{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := store.OpenEngine(*toEngine, *to, 0600, &store.EngineOptions{})
+	if err != nil {
+		return err
+	}
+
+	keys, err := store.CopyEngine(context.Background(), dst, src)
+	if err != nil {
+		_ = dst.Close()
+		_ = os.Remove(*to)
+		return errors.Wrap(err, "failed to migrate")
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Migrated %d keys from %s (%s) to %s (%s)\n", keys, *from, *fromEngine, *to, *toEngine)
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt"
+	"github.com/kwSeo/dbolt/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// runValidateConfig 는 서버를 시작하지 않고 설정을 읽어서 검증한다. 서버와 같은 flag 와 DBOLT_ 환경 변수를 적용하므로
+// CI 에서 배포할 설정과 환경 변수를 그대로 넘겨서 확인할 수 있다. 잘못된 설정은 한 줄에 하나씩 출력한다.
+func runValidateConfig(args []string) error {
+	loader, err := dbolt.NewConfigLoader(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := loader.Load(); err != nil {
+		var errs util.Errors
+		if !errors.As(err, &errs) {
+			return err
+		}
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "- %v\n", e)
+		}
+		return errors.Errorf("%d invalid settings in %s", len(errs), loader.Path())
+	}
+	fmt.Printf("%s is valid\n", loader.Path())
+	return nil
+}
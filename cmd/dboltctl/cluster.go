@@ -0,0 +1,230 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kwSeo/dbolt/pkg/client"
+	"github.com/kwSeo/dbolt/pkg/dbolt/backup"
+	"github.com/pkg/errors"
+)
+
+func runBucket(c *cli, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: bucket list | bucket delete [-yes] <bucket>")
+	}
+	switch args[0] {
+	case "list":
+		buckets, err := c.client.Buckets(context.Background())
+		if err != nil {
+			return err
+		}
+		return c.out.print(map[string][]string{"buckets": buckets}, func(w io.Writer) error {
+			for _, bucket := range buckets {
+				fmt.Fprintln(w, bucket)
+			}
+			return nil
+		})
+	case "delete":
+		fs := flag.NewFlagSet("bucket delete", flag.ExitOnError)
+		yes := fs.Bool("yes", false, "Delete without confirmation.")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New("usage: bucket delete [-yes] <bucket>")
+		}
+		bucket := fs.Arg(0)
+		if !*yes && !confirm(fmt.Sprintf("Delete every key in bucket %q?", bucket)) {
+			return errors.New("aborted")
+		}
+		deleted, err := c.client.DeleteBucket(context.Background(), bucket)
+		if err != nil {
+			return err
+		}
+		return c.out.print(map[string]int{"deleted": deleted}, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "Deleted %d keys\n", deleted)
+			return err
+		})
+	}
+	return errors.Errorf("unknown bucket command : %s", args[0])
+}
+
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func runRing(c *cli, args []string) error {
+	status, err := c.client.Ring(context.Background())
+	if err != nil {
+		return err
+	}
+	return c.out.print(status, func(w io.Writer) error {
+		fmt.Fprintf(w, "Replication factor: %d\n", status.ReplicationFactor)
+		if status.Tenant != "" {
+			fmt.Fprintf(w, "Tenant: %s\n", status.Tenant)
+		}
+		return table(w, func(w io.Writer) {
+			fmt.Fprintln(w, "ADDR\tURL\tZONE\tTOKENS")
+			for _, instance := range status.Instances {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", instance.Addr, instance.URL, instance.Zone, len(instance.Tokens))
+			}
+		})
+	})
+}
+
+// runCheck 는 key 범위에서 replica 들의 version 이 다른 key 를 출력한다. 다른 key 가 있으면 실패로 끝난다.
+func runCheck(c *cli, args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "Check only the keys with the prefix.")
+	after := fs.String("after", "", "Check the keys after the key.")
+	limit := fs.Int("limit", 1000, "Maximum number of keys per request. (max 1000)")
+	all := fs.Bool("all", false, "Check every page until the end.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: check [-prefix P] [-after K] [-limit N] [-all] <bucket>")
+	}
+	bucket := fs.Arg(0)
+
+	total := &client.ConsistencyReport{Inconsistent: []*client.KeyConsistency{}}
+	opts := client.ScanOptions{Prefix: *prefix, After: *after, Limit: *limit}
+	for {
+		report, err := c.client.CheckConsistency(context.Background(), bucket, opts)
+		if err != nil {
+			return err
+		}
+		total.Checked += report.Checked
+		total.Inconsistent = append(total.Inconsistent, report.Inconsistent...)
+		total.More, total.Next = report.More, report.Next
+		if !*all || !report.More {
+			break
+		}
+		opts.After = report.Next
+	}
+
+	if err := c.out.print(total, func(w io.Writer) error {
+		fmt.Fprintf(w, "Checked %d keys, %d inconsistent\n", total.Checked, len(total.Inconsistent))
+		err := table(w, func(w io.Writer) {
+			if len(total.Inconsistent) > 0 {
+				fmt.Fprintln(w, "KEY\tADDR\tVERSION\tSTATE")
+			}
+			for _, key := range total.Inconsistent {
+				for _, replica := range key.Replicas {
+					fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", key.Key, replica.Addr, replica.Version, replicaState(key, replica))
+				}
+			}
+		})
+		if err == nil && total.More {
+			_, err = fmt.Fprintf(w, "more: -after %q\n", total.Next)
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	if len(total.Inconsistent) > 0 {
+		return errors.Errorf("%d inconsistent keys", len(total.Inconsistent))
+	}
+	return nil
+}
+
+func replicaState(key *client.KeyConsistency, replica *client.ReplicaVersion) string {
+	switch {
+	case !replica.Owner:
+		return "not-owner"
+	case replica.Version == 0:
+		return "missing"
+	case replica.Version < key.Latest:
+		return "stale"
+	}
+	return "latest"
+}
+
+// runBackup 은 한 인스턴스의 bolt 스냅샷 또는 클러스터 전체 백업(tar)을 파일로 내려받는다.
+func runBackup(c *cli, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	cluster := fs.Bool("cluster", false, "Back up every instance in the ring along with the ring state.")
+	out := fs.String("out", "", "Output file path. Defaults to dbolt.db or dbolt-cluster.tar.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = "dbolt.db"
+		if *cluster {
+			*out = "dbolt-cluster.tar"
+		}
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	// 서버가 백업 중에 실패하면 Backup 이 실패하므로 잘린 파일은 남기지 않는다.
+	n, err := c.client.Backup(context.Background(), file, *cluster)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*out)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d bytes to %s\n", n, *out)
+	return nil
+}
+
+// runRestore 는 스냅샷을 서버로 업로드해 현재 Ring 에 다시 기록하게 한다.
+// 클러스터 백업(tar)이 주어지면 포함된 모든 인스턴스 스냅샷을 차례로 업로드한다.
+func runRestore(c *cli, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("in", "", "Snapshot file (.db) or cluster backup (.tar) to restore.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return errors.New("-in required")
+	}
+	file, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if !strings.HasSuffix(*in, ".tar") {
+		return restoreSnapshot(c, *in, file)
+	}
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read the cluster backup")
+		}
+		if !strings.HasPrefix(header.Name, backup.InstancesDir) {
+			continue
+		}
+		if err := restoreSnapshot(c, header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func restoreSnapshot(c *cli, name string, snapshot io.Reader) error {
+	result, err := c.client.Restore(context.Background(), snapshot)
+	if err != nil {
+		return errors.Wrapf(err, "failed to restore %s", name)
+	}
+	fmt.Fprintf(os.Stderr, "Restored %s: %d keys\n", name, result.Keys)
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/client"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// 설정 파일보다 환경 변수가, 환경 변수보다 flag 가 우선한다.
+const (
+	configEnv  = "DBOLTCTL_CONFIG"
+	addrEnv    = "DBOLT_ADDR"
+	tokenEnv   = "DBOLT_TOKEN"
+	tenantEnv  = "DBOLT_TENANT"
+	outputEnv  = "DBOLT_OUTPUT"
+	configFile = ".dboltctl.yaml"
+)
+
+type ctlConfig struct {
+	// Addresses 는 dbolt-server 의 API 주소이다. 여러 개이면 차례로 요청하고 실패하면 다음 주소로 재시도한다.
+	Addresses []string `yaml:"addresses"`
+	Token     string   `yaml:"token"`
+	Tenant    string   `yaml:"tenant"`
+	// Output 은 text, json, yaml 중 하나이다.
+	Output  string        `yaml:"output"`
+	Timeout time.Duration `yaml:"timeout"`
+	TLS     ctlTLSConfig  `yaml:"tls"`
+}
+
+type ctlTLSConfig struct {
+	CAPath             string `yaml:"ca_path"`
+	CertPath           string `yaml:"cert_path"`
+	KeyPath            string `yaml:"key_path"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+type globalFlags struct {
+	fs         *flag.FlagSet
+	configPath string
+	addr       string
+	token      string
+	tenant     string
+	output     string
+	timeout    time.Duration
+	tls        ctlTLSConfig
+}
+
+func registerFlags(fs *flag.FlagSet) *globalFlags {
+	f := &globalFlags{fs: fs}
+	fs.StringVar(&f.configPath, "config", "", "Config file path. Defaults to $"+configEnv+" or ~/"+configFile+" if it exists.")
+	fs.StringVar(&f.addr, "addr", "", "Comma separated base URLs of dbolt-server. ($"+addrEnv+", default http://localhost:8080)")
+	fs.StringVar(&f.token, "token", "", "Bearer token. ($"+tokenEnv+")")
+	fs.StringVar(&f.tenant, "tenant", "", "Tenant ID sent as "+client.TenantHeader+". ($"+tenantEnv+")")
+	fs.StringVar(&f.output, "o", "", "Output format: text, json or yaml. ($"+outputEnv+", default text)")
+	fs.DurationVar(&f.timeout, "timeout", 0, "Timeout of each request except backup, restore and bucket delete. (default 10s)")
+	fs.StringVar(&f.tls.CAPath, "ca-cert", "", "CA certificate to verify the server certificate.")
+	fs.StringVar(&f.tls.CertPath, "cert", "", "Client certificate for mTLS.")
+	fs.StringVar(&f.tls.KeyPath, "key", "", "Client key for mTLS.")
+	fs.StringVar(&f.tls.ServerName, "server-name", "", "Server name to verify the server certificate.")
+	fs.BoolVar(&f.tls.InsecureSkipVerify, "insecure-skip-verify", false, "Skip verifying the server certificate.")
+	return f
+}
+
+// load 는 설정 파일, 환경 변수, flag 순서로 설정을 덮어쓴다.
+func (f *globalFlags) load() (*ctlConfig, error) {
+	cfg := &ctlConfig{}
+	if err := loadConfigFile(f.configPath, cfg); err != nil {
+		return nil, err
+	}
+
+	if addr := os.Getenv(addrEnv); addr != "" {
+		cfg.Addresses = splitAddresses(addr)
+	}
+	setFromEnv(&cfg.Token, tokenEnv)
+	setFromEnv(&cfg.Tenant, tenantEnv)
+	setFromEnv(&cfg.Output, outputEnv)
+
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "addr":
+			cfg.Addresses = splitAddresses(f.addr)
+		case "token":
+			cfg.Token = f.token
+		case "tenant":
+			cfg.Tenant = f.tenant
+		case "o":
+			cfg.Output = f.output
+		case "timeout":
+			cfg.Timeout = f.timeout
+		case "ca-cert":
+			cfg.TLS.CAPath = f.tls.CAPath
+		case "cert":
+			cfg.TLS.CertPath = f.tls.CertPath
+		case "key":
+			cfg.TLS.KeyPath = f.tls.KeyPath
+		case "server-name":
+			cfg.TLS.ServerName = f.tls.ServerName
+		case "insecure-skip-verify":
+			cfg.TLS.InsecureSkipVerify = f.tls.InsecureSkipVerify
+		}
+	})
+
+	if len(cfg.Addresses) == 0 {
+		cfg.Addresses = []string{"http://localhost:8080"}
+	}
+	if cfg.Output == "" {
+		cfg.Output = outputText
+	}
+	return cfg, nil
+}
+
+// loadConfigFile 은 path 가 비어 있으면 $DBOLTCTL_CONFIG, ~/.dboltctl.yaml 순서로 찾는다. 기본 위치에 파일이 없으면 건너뛴다.
+func loadConfigFile(path string, cfg *ctlConfig) error {
+	explicit := path != ""
+	if path == "" {
+		path = os.Getenv(configEnv)
+		explicit = path != ""
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, configFile)
+	}
+	file, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read the config file")
+	}
+	if err := yaml.UnmarshalStrict(file, cfg); err != nil {
+		return errors.Wrapf(err, "invalid config file : path=%s", path)
+	}
+	return nil
+}
+
+func setFromEnv(dst *string, name string) {
+	if value := os.Getenv(name); value != "" {
+		*dst = value
+	}
+}
+
+func splitAddresses(value string) []string {
+	var addresses []string
+	for _, address := range strings.Split(value, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+func (c *ctlTLSConfig) config() (*tls.Config, error) {
+	if *c == (ctlTLSConfig{}) {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAPath != "" {
+		pem, err := os.ReadFile(c.CAPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the CA certificate")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificate in %s", c.CAPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.CertPath != "" || c.KeyPath != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertPath, c.KeyPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load the client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// cli 는 명령들이 함께 사용하는 client 와 출력 형식이다.
+type cli struct {
+	client *client.Client
+	out    *printer
+}
+
+func newCLI(flags *globalFlags) (*cli, error) {
+	cfg, err := flags.load()
+	if err != nil {
+		return nil, err
+	}
+	out, err := newPrinter(os.Stdout, cfg.Output)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := cfg.TLS.config()
+	if err != nil {
+		return nil, err
+	}
+	c, err := client.New(client.Config{
+		Addresses: cfg.Addresses,
+		Token:     cfg.Token,
+		Tenant:    cfg.Tenant,
+		Timeout:   cfg.Timeout,
+		TLSConfig: tlsConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cli{client: c, out: out}, nil
+}
+
+func (c *cli) close() {
+	_ = c.client.Close()
+}
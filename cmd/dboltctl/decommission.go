@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/client"
+	"github.com/pkg/errors"
+)
+
+// runDecommission 은 인스턴스의 decommission 을 시작하고 -wait 이면 끝날 때까지 단계를 출력한다.
+// 다른 인스턴스가 아니라 지울 인스턴스에 직접 요청해야 하므로 인스턴스의 URL 을 받는다.
+func runDecommission(c *cli, args []string) error {
+	fs := flag.NewFlagSet("decommission", flag.ExitOnError)
+	statusOnly := fs.Bool("status", false, "Print the decommission status without starting it.")
+	wait := fs.Bool("wait", false, "Wait until the instance is decommissioned or the decommission fails.")
+	interval := fs.Duration("interval", 2*time.Second, "Polling interval with -wait.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: decommission [-status] [-wait] [-interval D] <instance URL>")
+	}
+	address := fs.Arg(0)
+
+	var status *client.DecommissionStatus
+	var err error
+	if *statusOnly {
+		status, err = c.client.DecommissionStatus(context.Background(), address)
+	} else {
+		status, err = c.client.Decommission(context.Background(), address)
+	}
+	if err != nil {
+		return err
+	}
+	if *wait {
+		phase := status.Phase
+		fmt.Fprintf(os.Stderr, "Phase: %s\n", phase)
+		for !status.Done() {
+			time.Sleep(*interval)
+			if status, err = c.client.DecommissionStatus(context.Background(), address); err != nil {
+				return err
+			}
+			if status.Phase != phase {
+				phase = status.Phase
+				fmt.Fprintf(os.Stderr, "Phase: %s\n", phase)
+			}
+		}
+	}
+
+	if err := c.out.print(status, func(w io.Writer) error {
+		fmt.Fprintf(w, "Phase: %s\n", status.Phase)
+		fmt.Fprintf(w, "Buckets: %d, keys: %d, copies: %d, unconfirmed: %d\n", status.Buckets, status.Keys, status.Copies, status.Unconfirmed)
+		if status.Error != "" {
+			fmt.Fprintf(w, "Error: %s\n", status.Error)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if status.Phase == "failed" {
+		return errors.New("decommission failed")
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/client"
+	"github.com/pkg/errors"
+)
+
+// Entry 는 get, scan 의 출력이며 export, import 의 JSON lines 한 줄이다.
+type Entry struct {
+	Bucket  string `json:"bucket,omitempty"`
+	Key     string `json:"key"`
+	Value   []byte `json:"value"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+func runGet(c *cli, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("usage: get <bucket> <key>")
+	}
+	bucket, key := fs.Arg(0), fs.Arg(1)
+	kv, err := c.client.Get(context.Background(), bucket, key)
+	if err != nil {
+		return err
+	}
+	// text 이면 파일로 저장할 수 있도록 값을 그대로 출력한다.
+	return c.out.print(&Entry{Bucket: bucket, Key: kv.Key, Value: kv.Value, Version: kv.Version}, func(w io.Writer) error {
+		_, err := w.Write(kv.Value)
+		return err
+	})
+}
+
+func runPut(c *cli, args []string) error {
+	fs := flag.NewFlagSet("put", flag.ExitOnError)
+	ifVersion := fs.Uint64("if-version", 0, "Write only if the current version matches.")
+	ifAbsent := fs.Bool("if-absent", false, "Write only if the key does not exist.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 && fs.NArg() != 3 {
+		return errors.New("usage: put [-if-version N|-if-absent] <bucket> <key> [value]")
+	}
+	bucket, key := fs.Arg(0), fs.Arg(1)
+	var value []byte
+	if fs.NArg() == 3 {
+		value = []byte(fs.Arg(2))
+	} else {
+		read, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return errors.Wrap(err, "failed to read the value from stdin")
+		}
+		value = read
+	}
+
+	version, err := c.client.Put(context.Background(), bucket, key, value, writeOptions(*ifVersion, *ifAbsent)...)
+	if err != nil {
+		return err
+	}
+	return c.out.print(&Entry{Bucket: bucket, Key: key, Version: version}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "version: %d\n", version)
+		return err
+	})
+}
+
+func runDelete(c *cli, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	ifVersion := fs.Uint64("if-version", 0, "Delete only if the current version matches.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("usage: delete [-if-version N] <bucket> <key>")
+	}
+	return c.client.Delete(context.Background(), fs.Arg(0), fs.Arg(1), writeOptions(*ifVersion, false)...)
+}
+
+func writeOptions(ifVersion uint64, ifAbsent bool) []client.WriteOption {
+	var opts []client.WriteOption
+	if ifVersion != 0 {
+		opts = append(opts, client.IfVersion(ifVersion))
+	}
+	if ifAbsent {
+		opts = append(opts, client.IfAbsent())
+	}
+	return opts
+}
+
+type scanOutput struct {
+	Entries []*Entry `json:"entries"`
+	More    bool     `json:"more"`
+	Next    string   `json:"next,omitempty"`
+}
+
+func runScan(c *cli, args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "Read only the keys with the prefix.")
+	after := fs.String("after", "", "Read the keys after the key. Pass 'next' of the previous output to continue.")
+	limit := fs.Int("limit", 100, "Maximum number of keys per request. (max 1000)")
+	all := fs.Bool("all", false, "Read every page until the end.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: scan [-prefix P] [-after K] [-limit N] [-all] <bucket>")
+	}
+	bucket := fs.Arg(0)
+
+	output := &scanOutput{Entries: []*Entry{}}
+	err := scanPages(c.client, bucket, client.ScanOptions{Prefix: *prefix, After: *after, Limit: *limit}, *all, func(result *client.ScanResult) error {
+		for _, kv := range result.Entries {
+			output.Entries = append(output.Entries, &Entry{Key: kv.Key, Value: kv.Value, Version: kv.Version})
+		}
+		output.More, output.Next = result.More, result.Next
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.out.print(output, func(w io.Writer) error {
+		err := table(w, func(w io.Writer) {
+			fmt.Fprintln(w, "KEY\tVERSION\tUPDATED\tVALUE")
+			for _, entry := range output.Entries {
+				updated := time.Unix(0, int64(entry.Version)).UTC().Format(time.RFC3339)
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", entry.Key, entry.Version, updated, strconv.Quote(string(entry.Value)))
+			}
+		})
+		if err == nil && output.More {
+			_, err = fmt.Fprintf(w, "more: -after %s\n", strconv.Quote(output.Next))
+		}
+		return err
+	})
+}
+
+// scanPages 는 all 이면 마지막 page 까지 읽고 아니면 한 page 만 읽는다.
+func scanPages(c *client.Client, bucket string, opts client.ScanOptions, all bool, fn func(result *client.ScanResult) error) error {
+	for {
+		result, err := c.Scan(context.Background(), bucket, opts)
+		if err != nil {
+			return err
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+		if !all || !result.More {
+			return nil
+		}
+		opts.After = result.Next
+	}
+}
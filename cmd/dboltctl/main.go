@@ -0,0 +1,71 @@
+// dboltctl 은 dbolt 클러스터를 다루는 명령행 도구이다.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(cli *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"get":          {"get <bucket> <key>", runGet},
+	"put":          {"put [-if-version N|-if-absent] <bucket> <key> [value]  (value 가 없으면 stdin)", runPut},
+	"delete":       {"delete [-if-version N] <bucket> <key>", runDelete},
+	"scan":         {"scan [-prefix P] [-after K] [-limit N] [-all] <bucket>", runScan},
+	"export":       {"export [-prefix P] [-out FILE] <bucket>...  (JSON lines)", runExport},
+	"import":       {"import [-in FILE] [-batch-size N] [-bucket B]  (JSON lines)", runImport},
+	"bucket":       {"bucket list | bucket delete [-yes] <bucket>", runBucket},
+	"ring":         {"ring", runRing},
+	"check":        {"check [-prefix P] [-after K] [-limit N] [-all] <bucket>", runCheck},
+	"backup":       {"backup [-cluster] [-out FILE]", runBackup},
+	"restore":      {"restore -in FILE  (.db 스냅샷 또는 클러스터 백업 .tar)", runRestore},
+	"decommission": {"decommission [-status] [-wait] [-interval D] <instance URL>", runDecommission},
+}
+
+func main() {
+	fs := flag.NewFlagSet("dboltctl", flag.ExitOnError)
+	flags := registerFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: dboltctl [flags] <command> [args]\n\nCommands:\n")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(fs.Output(), "  %s\n", commands[name].usage)
+		}
+		fmt.Fprintf(fs.Output(), "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(os.Args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name := fs.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", name)
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cli, err := newCLI(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dboltctl: %v\n", err)
+		os.Exit(1)
+	}
+	defer cli.close()
+	if err := cmd.run(cli, fs.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		cli.close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case outputText, outputJSON, outputYAML:
+		return &printer{w: w, format: format}, nil
+	}
+	return nil, errors.Errorf("unknown output format : %s", format)
+}
+
+// print 는 json, yaml 이면 v 를 그대로 출력하고 text 이면 text 로 출력한다.
+// yaml 도 json 과 같은 필드 이름을 사용하도록 json 으로 바꾼 뒤 변환한다.
+func (p *printer) print(v interface{}, text func(w io.Writer) error) error {
+	switch p.format {
+	case outputJSON:
+		encoder := json.NewEncoder(p.w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case outputYAML:
+		marshaled, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var doc yaml.MapSlice
+		if err := yaml.Unmarshal(marshaled, &doc); err != nil {
+			return err
+		}
+		out, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		_, err = p.w.Write(out)
+		return err
+	}
+	return text(p.w)
+}
+
+// table 은 text 출력에서 열을 맞춘다.
+func table(w io.Writer, fn func(w io.Writer)) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fn(tw)
+	return tw.Flush()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kwSeo/dbolt/pkg/client"
+	"github.com/pkg/errors"
+)
+
+// exportPageSize 는 export 가 한 번에 읽는 key 의 개수이다.
+const exportPageSize = 1000
+
+// runExport 는 bucket 의 key 를 JSON lines 로 기록한다. bucket 을 주지 않으면 모든 bucket 을 기록한다.
+func runExport(c *cli, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "Export only the keys with the prefix.")
+	out := fs.String("out", "", "Output file path. Defaults to stdout.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	buckets := fs.Args()
+	if len(buckets) == 0 {
+		listed, err := c.client.Buckets(context.Background())
+		if err != nil {
+			return errors.Wrap(err, "failed to list the buckets")
+		}
+		buckets = listed
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+
+	exported := 0
+	for _, bucket := range buckets {
+		opts := client.ScanOptions{Prefix: *prefix, Limit: exportPageSize}
+		err := scanPages(c.client, bucket, opts, true, func(result *client.ScanResult) error {
+			for _, kv := range result.Entries {
+				// chunk 로 나눠서 저장한 값은 Scan 이 값을 주지 않으므로 따로 읽는다.
+				if kv.Chunked {
+					got, err := c.client.Get(context.Background(), bucket, kv.Key)
+					if err != nil {
+						return errors.Wrapf(err, "failed to get the chunked value : key=%s", kv.Key)
+					}
+					kv = got
+				}
+				if err := encoder.Encode(&Entry{Bucket: bucket, Key: kv.Key, Value: kv.Value, Version: kv.Version}); err != nil {
+					return err
+				}
+				exported++
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to export the bucket : bucket=%s", bucket)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d keys from %d buckets\n", exported, len(buckets))
+	return nil
+}
+
+type importOutput struct {
+	Imported int      `json:"imported"`
+	Failed   int      `json:"failed"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// maxImportErrors 는 import 결과에 남기는 오류의 최대 개수이다.
+const maxImportErrors = 100
+
+// runImport 는 export 가 기록한 JSON lines 를 batch 로 기록한다. 실패한 key 가 있어도 끝까지 진행한다.
+func runImport(c *cli, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("in", "", "Input file path. Defaults to stdin.")
+	batchSize := fs.Int("batch-size", 100, "Number of keys per batch request. (max 1000)")
+	bucket := fs.String("bucket", "", "Import every line into the bucket instead of the bucket of the line.")
+	ifAbsent := fs.Bool("if-absent", false, "Skip the keys that already exist.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *batchSize <= 0 || *batchSize > 1000 {
+		return errors.New("-batch-size must be between 1 and 1000")
+	}
+
+	var r io.Reader = os.Stdin
+	if *in != "" {
+		file, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	output := &importOutput{}
+	var opts []client.WriteOption
+	if *ifAbsent {
+		opts = append(opts, client.IfAbsent())
+	}
+	ops := make([]*client.Operation, 0, *batchSize)
+	flush := func() error {
+		if len(ops) == 0 {
+			return nil
+		}
+		results, err := c.client.Batch(context.Background(), ops...)
+		if err != nil {
+			return errors.Wrap(err, "failed to send the batch")
+		}
+		for i, result := range results {
+			if result.Err == nil {
+				output.Imported++
+				continue
+			}
+			output.Failed++
+			if len(output.Errors) < maxImportErrors {
+				output.Errors = append(output.Errors, fmt.Sprintf("%s/%s: %v", ops[i].Bucket, ops[i].Key, result.Err))
+			}
+		}
+		ops = ops[:0]
+		return nil
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for line := 1; ; line++ {
+		entry := new(Entry)
+		err := decoder.Decode(entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "invalid entry : line=%d", line)
+		}
+		if *bucket != "" {
+			entry.Bucket = *bucket
+		}
+		if entry.Bucket == "" || entry.Key == "" {
+			return errors.Errorf("bucket and key required : line=%d", line)
+		}
+		ops = append(ops, client.PutOp(entry.Bucket, entry.Key, entry.Value, opts...))
+		if len(ops) == *batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if err := c.out.print(output, func(w io.Writer) error {
+		fmt.Fprintf(w, "Imported %d keys, %d failed\n", output.Imported, output.Failed)
+		for _, message := range output.Errors {
+			fmt.Fprintln(w, "  "+message)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if output.Failed > 0 {
+		return errors.Errorf("%d keys failed", output.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Buckets 는 key 가 하나 이상 있는 bucket 의 이름을 정렬해서 반환한다.
+func (c *Client) Buckets(ctx context.Context) ([]string, error) {
+	var listed struct {
+		Buckets []string `json:"buckets"`
+	}
+	if err := c.doJSON(ctx, &request{method: http.MethodGet, path: "/api/v1/buckets", retryable: true}, &listed); err != nil {
+		return nil, err
+	}
+	return listed.Buckets, nil
+}
+
+// DeleteBucket 은 bucket 의 모든 key 를 지우고 지운 key 의 개수를 반환한다. admin 권한이 필요하다.
+func (c *Client) DeleteBucket(ctx context.Context, bucket string) (int, error) {
+	var deleted struct {
+		Deleted int `json:"deleted"`
+	}
+	req := &request{method: http.MethodDelete, path: "/api/v1/buckets/" + url.PathEscape(bucket), retryable: true}
+	resp, err := c.doWith(ctx, c.streamClient, req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := decodeJSON(resp.Body, &deleted); err != nil {
+		return 0, err
+	}
+	return deleted.Deleted, nil
+}
+
+type ReplicaVersion struct {
+	Addr string `json:"addr"`
+	// Version 이 0 이면 인스턴스에 key 가 없다.
+	Version uint64 `json:"version"`
+	// Owner 가 false 이면 replica 가 아닌 인스턴스에 남은 값이다.
+	Owner bool `json:"owner"`
+}
+
+type KeyConsistency struct {
+	Key      string            `json:"key"`
+	Latest   uint64            `json:"latest"`
+	Replicas []*ReplicaVersion `json:"replicas"`
+}
+
+type ConsistencyReport struct {
+	Checked      int               `json:"checked"`
+	Inconsistent []*KeyConsistency `json:"inconsistent"`
+	More         bool              `json:"more"`
+	Next         string            `json:"next,omitempty"`
+}
+
+// CheckConsistency 는 bucket 의 key 범위에서 replica 들의 version 을 비교한다. admin 권한이 필요하다.
+// More 이면 Next 를 opts.After 로 넘겨 이어서 확인한다.
+func (c *Client) CheckConsistency(ctx context.Context, bucket string, opts ScanOptions) (*ConsistencyReport, error) {
+	if c.cfg.Tenant != "" {
+		bucket = c.cfg.Tenant + "/" + bucket
+	}
+	query := url.Values{"bucket": {bucket}}
+	if opts.Prefix != "" {
+		query.Set("prefix", opts.Prefix)
+	}
+	if opts.After != "" {
+		query.Set("after", opts.After)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	report := new(ConsistencyReport)
+	req := &request{method: http.MethodGet, path: "/admin/consistency?" + query.Encode(), retryable: true}
+	if err := c.doJSON(ctx, req, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Backup 은 한 인스턴스의 bolt 스냅샷 또는 cluster 이면 클러스터 전체 백업(tar)을 w 에 기록한다. admin 권한이 필요하다.
+func (c *Client) Backup(ctx context.Context, w io.Writer, cluster bool) (int64, error) {
+	path := "/admin/backup"
+	if cluster {
+		path = "/admin/backup/cluster"
+	}
+	resp, err := c.doWith(ctx, c.streamClient, &request{method: http.MethodGet, path: path, retryable: true})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, errors.Wrap(err, "failed to receive the backup")
+	}
+	return n, nil
+}
+
+type RestoreResult struct {
+	Keys int `json:"keys"`
+}
+
+// Restore 는 bolt 스냅샷을 업로드해서 현재 Ring 에 다시 기록하게 한다. admin 권한이 필요하다.
+func (c *Client) Restore(ctx context.Context, snapshot io.Reader) (*RestoreResult, error) {
+	req := &request{
+		method: http.MethodPost,
+		path:   "/admin/restore",
+		header: http.Header{"Content-Type": {"application/octet-stream"}},
+		stream: snapshot,
+	}
+	resp, err := c.doWith(ctx, c.streamClient, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	result := new(RestoreResult)
+	if err := decodeJSON(resp.Body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+type DecommissionStatus struct {
+	// Phase 는 active, leaving, transferring, verifying, unregistering, decommissioned, failed 중 하나이다.
+	Phase       string     `json:"phase"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	Buckets     int        `json:"buckets"`
+	Keys        int        `json:"keys"`
+	Copies      int        `json:"copies"`
+	Unconfirmed int        `json:"unconfirmed"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Done 은 decommission 이 끝났거나 실패했는지이다.
+func (s *DecommissionStatus) Done() bool {
+	return s.Phase == "decommissioned" || s.Phase == "failed"
+}
+
+// Decommission 은 address 의 인스턴스의 decommission 을 시작한다. admin 권한이 필요하다.
+// 진행 상황은 DecommissionStatus 로 확인한다.
+func (c *Client) Decommission(ctx context.Context, address string) (*DecommissionStatus, error) {
+	status := new(DecommissionStatus)
+	if err := c.doJSON(ctx, &request{method: http.MethodPost, path: "/admin/decommission", address: address}, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// DecommissionStatus 는 address 의 인스턴스의 decommission 상태를 반환한다. admin 권한이 필요하다.
+func (c *Client) DecommissionStatus(ctx context.Context, address string) (*DecommissionStatus, error) {
+	status := new(DecommissionStatus)
+	if err := c.doJSON(ctx, &request{method: http.MethodGet, path: "/admin/decommission", address: address, retryable: true}, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
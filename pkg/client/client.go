@@ -0,0 +1,480 @@
+// Package client 는 dbolt-server 의 HTTP API client 이다.
+//
+// 기본적으로 Addresses 의 인스턴스에 차례로 요청하고, 그 인스턴스가 coordinator 가 되어 replica 들에 요청한다.
+// Routing 을 설정하면 key 의 token 으로 replica 를 찾아서 replica 에 직접 요청하므로 coordinator 를 거치는 한 번의 요청을 줄인다.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// TenantHeader 는 tenancy 가 켜진 서버에서 tenant 를 정하는 헤더이다.
+	TenantHeader = "X-Scope-OrgID"
+
+	defaultTimeout      = 10 * time.Second
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 100 * time.Millisecond
+)
+
+// Routing 모드
+const (
+	// RoutingNone 이면 Addresses 에 차례로 요청한다.
+	RoutingNone = ""
+	// RoutingRing 이면 /api/v1/ring 으로 Ring 을 주기적으로 가져온다.
+	RoutingRing = "ring"
+	// RoutingMemberlist 이면 memberlist 에 참여해서 Ring 을 직접 읽는다. Ring 에 인스턴스로 등록하지는 않는다.
+	RoutingMemberlist = "memberlist"
+)
+
+type Config struct {
+	// Addresses 는 dbolt-server 의 API 주소이다. (예: http://dbolt-server:8080)
+	Addresses []string `yaml:"addresses"`
+	// Token 이 있으면 Authorization: Bearer 헤더로 보낸다.
+	Token string `yaml:"token"`
+	// Tenant 가 있으면 X-Scope-OrgID 헤더로 보낸다.
+	Tenant string `yaml:"tenant"`
+	// Timeout 은 watch 를 제외한 요청 하나의 제한 시간이다. 기본 10초.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxRetries 는 연결 오류, 429, 5xx 에서 다른 인스턴스로 다시 요청하는 횟수이다. 기본 3, 음수이면 재시도하지 않는다.
+	MaxRetries int `yaml:"max_retries"`
+	// RetryBackoff 는 첫 재시도 전에 기다리는 시간이며 재시도마다 두 배가 된다. 기본 100ms.
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// Routing 은 none(""), ring, memberlist 중 하나이다.
+	Routing    string           `yaml:"routing"`
+	Ring       RingConfig       `yaml:"ring"`
+	Memberlist MemberlistConfig `yaml:"memberlist"`
+	TLSConfig  *tls.Config      `yaml:"-"`
+}
+
+func (c *Config) Validate() error {
+	if len(c.Addresses) == 0 && c.Routing != RoutingMemberlist {
+		return errors.New("client 'addresses' required")
+	}
+	switch c.Routing {
+	case RoutingNone, RoutingRing:
+		return nil
+	case RoutingMemberlist:
+		return c.Memberlist.Validate()
+	}
+	return errors.Errorf("unknown client 'routing' : %s", c.Routing)
+}
+
+// Client 는 여러 goroutine 에서 함께 사용할 수 있다.
+type Client struct {
+	cfg        Config
+	httpClient *http.Client
+	// streamClient 는 watch 나 백업처럼 오래 걸리는 요청에 사용하며 timeout 이 없다.
+	streamClient *http.Client
+	router       router
+	next         uint32
+}
+
+func New(cfg Config) (*Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+	addresses := make([]string, 0, len(cfg.Addresses))
+	for _, address := range cfg.Addresses {
+		addresses = append(addresses, strings.TrimSuffix(address, "/"))
+	}
+	cfg.Addresses = addresses
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg.TLSConfig
+	c := &Client{
+		cfg:          cfg,
+		httpClient:   &http.Client{Timeout: cfg.Timeout, Transport: transport},
+		streamClient: &http.Client{Transport: transport},
+		next:         rand.Uint32(),
+	}
+	switch cfg.Routing {
+	case RoutingRing:
+		c.router = newRingRouter(&c.cfg.Ring, c.Ring)
+	case RoutingMemberlist:
+		r, err := newMemberlistRouter(&c.cfg.Memberlist, cfg.Tenant)
+		if err != nil {
+			return nil, err
+		}
+		c.router = r
+	}
+	return c, nil
+}
+
+// Close 는 Routing 에 사용한 자원을 정리한다.
+func (c *Client) Close() error {
+	if c.router != nil {
+		return c.router.close()
+	}
+	return nil
+}
+
+// KeyValue 는 읽은 값과 조건부 쓰기에 사용할 수 있는 버전이다.
+type KeyValue struct {
+	Key     string
+	Value   []byte
+	Version uint64
+	// Chunked 는 Scan 에서만 쓰인다. true 이면 값이 chunk 로 나눠서 저장되어 있어 Value 가 비어 있고 Get 으로 읽어야 한다.
+	Chunked bool
+}
+
+// WriteOption 은 Put, Delete 의 조건이다. 조건부 쓰기는 조건을 확인한 coordinator 를 거치는 쓰기끼리만 직렬화된다.
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	ifVersion uint64
+	ifAbsent  bool
+}
+
+// IfVersion 이면 현재 값의 버전이 version 일 때만 쓴다.
+func IfVersion(version uint64) WriteOption {
+	return func(o *writeOptions) {
+		o.ifVersion = version
+	}
+}
+
+// IfAbsent 이면 key 가 없을 때만 쓴다.
+func IfAbsent() WriteOption {
+	return func(o *writeOptions) {
+		o.ifAbsent = true
+	}
+}
+
+func (c *Client) Get(ctx context.Context, bucket, key string) (*KeyValue, error) {
+	req := &request{
+		method:    http.MethodGet,
+		path:      keyPath(bucket, key),
+		header:    http.Header{"Accept": {"application/octet-stream"}},
+		routeKey:  []byte(key),
+		bucket:    bucket,
+		retryable: true,
+	}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	value, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the value")
+	}
+	return &KeyValue{Key: key, Value: value, Version: parseETag(resp.Header.Get("ETag"))}, nil
+}
+
+// Put 은 값을 쓰고 새 버전을 반환한다. 조건을 만족하지 않으면 ErrPreconditionFailed 를 반환한다.
+func (c *Client) Put(ctx context.Context, bucket, key string, value []byte, opts ...WriteOption) (uint64, error) {
+	req := &request{
+		method:   http.MethodPost,
+		path:     keyPath(bucket, key),
+		header:   http.Header{"Content-Type": {"application/octet-stream"}},
+		body:     value,
+		routeKey: []byte(key),
+		bucket:   bucket,
+	}
+	req.retryable = applyWriteOptions(req.header, opts)
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return parseETag(resp.Header.Get("ETag")), nil
+}
+
+// Delete 는 key 를 지운다. key 가 없어도 성공한다.
+func (c *Client) Delete(ctx context.Context, bucket, key string, opts ...WriteOption) error {
+	req := &request{
+		method:   http.MethodDelete,
+		path:     keyPath(bucket, key),
+		header:   http.Header{},
+		routeKey: []byte(key),
+		bucket:   bucket,
+	}
+	req.retryable = applyWriteOptions(req.header, opts)
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// applyWriteOptions 는 조건을 헤더로 옮기고 재시도할 수 있는지 반환한다.
+// 조건부 쓰기는 첫 요청이 적용된 뒤 다시 보내면 자기 쓰기 때문에 실패하므로 재시도하지 않는다.
+func applyWriteOptions(header http.Header, opts []WriteOption) bool {
+	o := new(writeOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.ifVersion != 0 {
+		header.Set("If-Match", `"`+strconv.FormatUint(o.ifVersion, 10)+`"`)
+	}
+	if o.ifAbsent {
+		header.Set("If-None-Match", "*")
+	}
+	return o.ifVersion == 0 && !o.ifAbsent
+}
+
+type ScanOptions struct {
+	Prefix string
+	// After 보다 큰 key 부터 읽는다. 이전 ScanResult 의 Next 를 넘겨서 이어서 읽는다.
+	After string
+	// Limit 은 최대 1000 이며 0 이면 서버의 기본값(100)이다.
+	Limit int
+}
+
+type ScanResult struct {
+	Entries []*KeyValue
+	More    bool
+	Next    string
+}
+
+// Scan 은 bucket 의 key 를 정렬된 순서로 읽는다. 모든 인스턴스에서 읽어야 하므로 Routing 을 사용하지 않는다.
+func (c *Client) Scan(ctx context.Context, bucket string, opts ScanOptions) (*ScanResult, error) {
+	query := url.Values{}
+	if opts.Prefix != "" {
+		query.Set("prefix", opts.Prefix)
+	}
+	if opts.After != "" {
+		query.Set("after", opts.After)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	path := "/api/v1/buckets/" + url.PathEscape(bucket)
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var scanned struct {
+		Entries []struct {
+			Key     string `json:"key"`
+			Value   []byte `json:"value"`
+			Version uint64 `json:"version"`
+			Chunked bool   `json:"chunked"`
+		} `json:"entries"`
+		More bool   `json:"more"`
+		Next string `json:"next"`
+	}
+	if err := c.doJSON(ctx, &request{method: http.MethodGet, path: path, retryable: true}, &scanned); err != nil {
+		return nil, err
+	}
+	result := &ScanResult{More: scanned.More, Next: scanned.Next}
+	for _, entry := range scanned.Entries {
+		result.Entries = append(result.Entries, &KeyValue{Key: entry.Key, Value: entry.Value, Version: entry.Version, Chunked: entry.Chunked})
+	}
+	return result, nil
+}
+
+// Operation 은 Batch 의 연산이다. PutOp, DeleteOp 로 만든다.
+type Operation struct {
+	Op        string `json:"op"`
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	Value     []byte `json:"value,omitempty"`
+	IfVersion uint64 `json:"ifVersion,omitempty"`
+	IfAbsent  bool   `json:"ifAbsent,omitempty"`
+}
+
+func PutOp(bucket, key string, value []byte, opts ...WriteOption) *Operation {
+	return newOperation("put", bucket, key, value, opts)
+}
+
+func DeleteOp(bucket, key string, opts ...WriteOption) *Operation {
+	return newOperation("delete", bucket, key, nil, opts)
+}
+
+func newOperation(op, bucket, key string, value []byte, opts []WriteOption) *Operation {
+	o := new(writeOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Operation{Op: op, Bucket: bucket, Key: key, Value: value, IfVersion: o.ifVersion, IfAbsent: o.ifAbsent}
+}
+
+// OperationResult 는 연산 하나의 결과이다. Err 는 같은 연산을 단건으로 요청했을 때와 같은 오류이다.
+type OperationResult struct {
+	Version uint64
+	Err     error
+}
+
+// Batch 는 여러 연산을 한 요청으로 보낸다. 연산은 차례로 적용되며 원자적이지 않으므로 결과를 연산마다 확인한다.
+// 요청이 적용된 뒤 응답을 받지 못하면 다시 보낼 수 없으므로 재시도하지 않는다.
+func (c *Client) Batch(ctx context.Context, ops ...*Operation) ([]*OperationResult, error) {
+	body, err := json.Marshal(map[string]interface{}{"operations": ops})
+	if err != nil {
+		return nil, err
+	}
+	req := &request{
+		method: http.MethodPost,
+		path:   "/api/v1/batch",
+		header: http.Header{"Content-Type": {"application/json"}},
+		body:   body,
+	}
+	var batched struct {
+		Results []struct {
+			Version uint64 `json:"version"`
+			Status  int    `json:"status"`
+			Error   string `json:"error"`
+		} `json:"results"`
+	}
+	if err := c.doJSON(ctx, req, &batched); err != nil {
+		return nil, err
+	}
+	results := make([]*OperationResult, 0, len(batched.Results))
+	for _, r := range batched.Results {
+		result := &OperationResult{Version: r.Version}
+		if r.Status/100 != 2 {
+			result.Err = &StatusError{StatusCode: r.Status, Message: r.Error}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// request 는 재시도할 때마다 다시 보낼 수 있도록 본문을 []byte 로 가진다.
+type request struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+	// stream 은 스냅샷처럼 큰 본문이다. 다시 읽을 수 없으므로 재시도하지 않는다.
+	stream io.Reader
+	// routeKey 가 있으면 Routing 으로 bucket 과 key 의 replica 에 먼저 요청한다.
+	routeKey  []byte
+	bucket    string
+	retryable bool
+	// address 가 있으면 그 인스턴스에만 요청한다.
+	address string
+}
+
+func (c *Client) doJSON(ctx context.Context, req *request, out interface{}) error {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeJSON(resp.Body, out)
+}
+
+func decodeJSON(r io.Reader, out interface{}) error {
+	if err := json.NewDecoder(r).Decode(out); err != nil {
+		return errors.Wrap(err, "failed to decode the response")
+	}
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, req *request) (*http.Response, error) {
+	return c.doWith(ctx, c.httpClient, req)
+}
+
+// doWith 는 후보 인스턴스에 차례로 요청하고 재시도할 수 있는 오류이면 backoff 후에 다음 인스턴스로 다시 요청한다.
+func (c *Client) doWith(ctx context.Context, httpClient *http.Client, req *request) (*http.Response, error) {
+	addresses := c.candidates(req)
+	if len(addresses) == 0 {
+		return nil, errors.New("no dbolt-server address available")
+	}
+	attempts := 1
+	if req.retryable && c.cfg.MaxRetries > 0 {
+		attempts += c.cfg.MaxRetries
+	}
+	backoff := c.cfg.RetryBackoff
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		resp, err := c.send(ctx, httpClient, addresses[attempt%len(addresses)], req)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		var statusErr *StatusError
+		if ctx.Err() != nil || (errors.As(err, &statusErr) && !statusErr.retryable()) {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func (c *Client) send(ctx context.Context, httpClient *http.Client, address string, req *request) (*http.Response, error) {
+	body := req.stream
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, address+req.path, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	if c.cfg.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	if c.cfg.Tenant != "" {
+		httpReq.Header.Set(TenantHeader, c.cfg.Tenant)
+	}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to request : address=%s", address)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Message: string(message)}
+	}
+	return resp, nil
+}
+
+// candidates 는 요청을 보낼 인스턴스들이다. replica 를 찾을 수 있으면 replica 들을 먼저 두고 Addresses 로 이어 간다.
+func (c *Client) candidates(req *request) []string {
+	if req.address != "" {
+		return []string{req.address}
+	}
+	var addresses []string
+	if req.routeKey != nil && c.router != nil {
+		addresses = append(addresses, c.router.owners(req.bucket, req.routeKey)...)
+	}
+	if n := len(c.cfg.Addresses); n > 0 {
+		start := int(atomic.AddUint32(&c.next, 1) % uint32(n))
+		for i := 0; i < n; i++ {
+			addresses = append(addresses, c.cfg.Addresses[(start+i)%n])
+		}
+	}
+	return addresses
+}
+
+func keyPath(bucket, key string) string {
+	return "/api/v1/buckets/" + url.PathEscape(bucket) + "/" + url.PathEscape(key)
+}
+
+func parseETag(etag string) uint64 {
+	version, _ := strconv.ParseUint(strings.Trim(etag, `"`), 10, 64)
+	return version
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNotFound           = errors.New("key not found")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrForbidden          = errors.New("forbidden")
+	ErrRateLimited        = errors.New("rate limited")
+	ErrQuotaExceeded      = errors.New("quota exceeded")
+	// ErrRevisionCompacted 는 watch 를 시작할 revision 이 이미 변경 기록에서 지워졌다는 뜻이다.
+	ErrRevisionCompacted = errors.New("revision compacted")
+)
+
+var statusErrors = map[int]error{
+	http.StatusNotFound:            ErrNotFound,
+	http.StatusPreconditionFailed:  ErrPreconditionFailed,
+	http.StatusUnauthorized:        ErrUnauthorized,
+	http.StatusForbidden:           ErrForbidden,
+	http.StatusTooManyRequests:     ErrRateLimited,
+	http.StatusInsufficientStorage: ErrQuotaExceeded,
+	http.StatusGone:                ErrRevisionCompacted,
+}
+
+// StatusError 는 서버가 2xx 가 아닌 상태로 응답한 오류이다. errors.Is 로 ErrNotFound 같은 오류와 비교할 수 있다.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("dbolt: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *StatusError) Unwrap() error {
+	return statusErrors[e.StatusCode]
+}
+
+// retryable 은 다른 인스턴스나 잠시 뒤에 다시 요청하면 성공할 수 있는 상태인지 확인한다.
+func (e *StatusError) retryable() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return e.StatusCode == http.StatusInternalServerError
+}
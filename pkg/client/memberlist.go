@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/dns"
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/kv"
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/kv/memberlist"
+	"github.com/grafana/dskit/ring"
+	"github.com/grafana/dskit/services"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type MemberlistConfig struct {
+	// JoinMembers 는 memberlist 에 참여할 때 연결할 dbolt-server 의 memberlist 주소이다. (예: dbolt-server:7946)
+	JoinMembers []string `yaml:"join_members"`
+	// BindPort 는 이 client 의 memberlist 포트이다. 0 이면 임의의 포트를 사용한다.
+	BindPort int `yaml:"bind_port"`
+	// Prefix 는 서버의 lifecycler 'ring.kvstore.prefix' 와 같아야 한다.
+	Prefix string `yaml:"prefix"`
+	// ReplicationFactor, ZoneAwarenessEnabled 는 서버의 Ring 설정과 같아야 한다.
+	ReplicationFactor    int  `yaml:"replication_factor"`
+	ZoneAwarenessEnabled bool `yaml:"zone_awareness_enabled"`
+	// ShardSize 가 있으면 Tenant 의 shuffle sharding subring 에서 replica 를 찾는다. 서버의 'shard_size' 와 같아야 한다.
+	ShardSize int `yaml:"shard_size"`
+	// HTTPScheme, HTTPPort 로 Ring 의 인스턴스 주소를 API 주소로 바꾼다.
+	HTTPScheme string `yaml:"http_scheme"`
+	HTTPPort   int    `yaml:"http_port"`
+}
+
+func (c *MemberlistConfig) Validate() error {
+	if len(c.JoinMembers) == 0 {
+		return errors.New("client 'memberlist.join_members' required")
+	}
+	if c.ReplicationFactor <= 0 {
+		return errors.New("client 'memberlist.replication_factor' required")
+	}
+	if c.HTTPPort <= 0 {
+		return errors.New("client 'memberlist.http_port' required")
+	}
+	return nil
+}
+
+// memberlistRouter 는 memberlist 로 받은 Ring 에서 replica 를 찾는다.
+// memberlist 의 member 가 되지만 lifecycler 가 없으므로 Ring 에는 등록되지 않는다.
+type memberlistRouter struct {
+	cfg    *MemberlistConfig
+	tenant string
+	kv     *memberlist.KVInitService
+	ring   *ring.Ring
+}
+
+func newMemberlistRouter(cfg *MemberlistConfig, tenant string) (*memberlistRouter, error) {
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+
+	var kvConfig memberlist.KVConfig
+	flagext.DefaultValues(&kvConfig)
+	kvConfig.JoinMembers = cfg.JoinMembers
+	kvConfig.TCPTransport.BindPort = cfg.BindPort
+	kvConfig.RandomizeNodeName = true
+	kvConfig.Codecs = []codec.Codec{ring.GetCodec()}
+	dnsProvider := dns.NewProvider(logger, reg, dns.GolangResolverType)
+	kvService := memberlist.NewKVInitService(&kvConfig, logger, dnsProvider, reg)
+
+	var ringConfig ring.Config
+	flagext.DefaultValues(&ringConfig)
+	ringConfig.KVStore = kv.Config{
+		Store:  "memberlist",
+		Prefix: cfg.Prefix,
+		StoreConfig: kv.StoreConfig{
+			MemberlistKV: kvService.GetMemberlistKV,
+		},
+	}
+	ringConfig.ReplicationFactor = cfg.ReplicationFactor
+	ringConfig.ZoneAwarenessEnabled = cfg.ZoneAwarenessEnabled
+	r, err := ring.New(ringConfig, distributor.RingName, distributor.RingKey, logger, reg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the ring client")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := services.StartAndAwaitRunning(ctx, kvService); err != nil {
+		return nil, errors.Wrap(err, "failed to start memberlist")
+	}
+	if err := services.StartAndAwaitRunning(ctx, r); err != nil {
+		_ = services.StopAndAwaitTerminated(context.Background(), kvService)
+		return nil, errors.Wrap(err, "failed to start the ring client")
+	}
+
+	return &memberlistRouter{cfg: cfg, tenant: tenant, kv: kvService, ring: r}, nil
+}
+
+func (r *memberlistRouter) owners(bucket string, key []byte) []string {
+	bucketName := []byte(bucket)
+	if r.tenant != "" {
+		bucketName = []byte(r.tenant + "/" + bucket)
+	}
+	var subring ring.ReadRing = r.ring
+	if r.tenant != "" && r.cfg.ShardSize > 0 {
+		// dskit 이 subring 을 캐시하고 Ring 이 바뀌면 다시 계산한다.
+		subring = r.ring.ShuffleShard(r.tenant, r.cfg.ShardSize)
+	}
+	replicationSet, err := subring.Get(distributor.Token(bucketName, key), ring.Read, nil, nil, nil)
+	if err != nil {
+		return nil
+	}
+	scheme := r.cfg.HTTPScheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	urls := make([]string, 0, len(replicationSet.Instances))
+	for _, instance := range replicationSet.Instances {
+		urls = append(urls, distributor.InstanceURL(scheme, instance.Addr, uint16(r.cfg.HTTPPort)))
+	}
+	return urls
+}
+
+func (r *memberlistRouter) close() error {
+	ctx := context.Background()
+	if err := services.StopAndAwaitTerminated(ctx, r.ring); err != nil {
+		return err
+	}
+	return services.StopAndAwaitTerminated(ctx, r.kv)
+}
@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+)
+
+const defaultRingRefreshInterval = 30 * time.Second
+
+// router 는 bucket 과 key 의 replica 를 가진 인스턴스의 API 주소를 찾는다.
+// 찾지 못하면 빈 값을 반환하고, 그러면 Addresses 의 인스턴스가 coordinator 가 된다.
+type router interface {
+	owners(bucket string, key []byte) []string
+	close() error
+}
+
+type RingConfig struct {
+	// RefreshInterval 마다 /api/v1/ring 을 다시 가져온다. 기본 30초.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// RingStatus 는 요청한 tenant 의 값을 쓰는 Ring 의 정상 인스턴스와 token 이다.
+type RingStatus struct {
+	Tenant            string          `json:"tenant"`
+	ReplicationFactor int             `json:"replicationFactor"`
+	ZoneAwareness     bool            `json:"zoneAwareness"`
+	Instances         []*RingInstance `json:"instances"`
+}
+
+type RingInstance struct {
+	Addr   string   `json:"addr"`
+	URL    string   `json:"url"`
+	Zone   string   `json:"zone"`
+	Tokens []uint32 `json:"tokens"`
+}
+
+type ringToken struct {
+	token    uint32
+	instance int
+}
+
+// ringRouter 는 서버에서 가져온 Ring 으로 replica 를 찾는다. Ring 을 가져오지 못하면 이전 Ring 을 계속 사용한다.
+type ringRouter struct {
+	fetch func(ctx context.Context) (*RingStatus, error)
+
+	mu     sync.RWMutex
+	state  *RingStatus
+	tokens []ringToken
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newRingRouter(cfg *RingConfig, fetch func(ctx context.Context) (*RingStatus, error)) *ringRouter {
+	interval := cfg.RefreshInterval
+	if interval <= 0 {
+		interval = defaultRingRefreshInterval
+	}
+	r := &ringRouter{fetch: fetch, stop: make(chan struct{})}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			r.refresh()
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return r
+}
+
+func (r *ringRouter) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	state, err := r.fetch(ctx)
+	if err != nil {
+		return
+	}
+	var tokens []ringToken
+	for i, instance := range state.Instances {
+		for _, token := range instance.Tokens {
+			tokens = append(tokens, ringToken{token: token, instance: i})
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].token < tokens[j].token
+	})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state, r.tokens = state, tokens
+}
+
+// owners 는 dskit Ring 처럼 key 의 token 다음부터 시계 방향으로 서로 다른 인스턴스(zone awareness 이면 서로 다른 zone)를 고른다.
+func (r *ringRouter) owners(bucket string, key []byte) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.tokens) == 0 {
+		return nil
+	}
+	bucketName := []byte(bucket)
+	if r.state.Tenant != "" {
+		bucketName = []byte(r.state.Tenant + "/" + bucket)
+	}
+	token := distributor.Token(bucketName, key)
+	start := sort.Search(len(r.tokens), func(i int) bool {
+		return r.tokens[i].token >= token
+	})
+
+	var urls []string
+	seenInstances := make(map[int]struct{})
+	seenZones := make(map[string]struct{})
+	for i := 0; i < len(r.tokens) && len(urls) < r.state.ReplicationFactor; i++ {
+		instance := r.tokens[(start+i)%len(r.tokens)].instance
+		if _, ok := seenInstances[instance]; ok {
+			continue
+		}
+		zone := r.state.Instances[instance].Zone
+		if _, ok := seenZones[zone]; ok && r.state.ZoneAwareness {
+			continue
+		}
+		seenInstances[instance] = struct{}{}
+		seenZones[zone] = struct{}{}
+		urls = append(urls, r.state.Instances[instance].URL)
+	}
+	return urls
+}
+
+func (r *ringRouter) close() error {
+	close(r.stop)
+	r.wg.Wait()
+	return nil
+}
+
+// Ring 은 Addresses 의 인스턴스에서 Ring 을 가져온다.
+func (c *Client) Ring(ctx context.Context) (*RingStatus, error) {
+	state := new(RingStatus)
+	if err := c.doJSON(ctx, &request{method: http.MethodGet, path: "/api/v1/ring", retryable: true}, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type WatchOptions struct {
+	// Key 를 지켜본다. Prefix 이면 Key 로 시작하는 모든 key 를 지켜보며, Key 가 비어 있으면 bucket 전체이다.
+	Key    string
+	Prefix bool
+	// StartRevision 이 있으면 그 revision 부터 전달한다. revision 은 인스턴스마다 다르다.
+	StartRevision uint64
+}
+
+type WatchEvent struct {
+	Revision uint64 `json:"revision"`
+	// Type 은 put 또는 delete 이다.
+	Type      string    `json:"type"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// Watch 는 한 인스턴스에 적용된 변경을 전달한다. 변경 기록은 인스턴스마다 따로 있으므로,
+// Routing 이 있으면 Key 의 replica 에 연결하고 연결이 끊기면 같은 인스턴스에 마지막 revision 다음부터 다시 연결한다.
+// ctx 가 끝나거나 다시 연결할 수 없는 오류가 생기면 events 를 닫고 errc 로 오류를 보낸다.
+func (c *Client) Watch(ctx context.Context, bucket string, opts WatchOptions) (<-chan *WatchEvent, <-chan error) {
+	events := make(chan *WatchEvent)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(events)
+		errc <- c.watch(ctx, bucket, opts, events)
+	}()
+	return events, errc
+}
+
+func (c *Client) watch(ctx context.Context, bucket string, opts WatchOptions, events chan<- *WatchEvent) error {
+	var routeKey []byte
+	if !opts.Prefix {
+		routeKey = []byte(opts.Key)
+	}
+	addresses := c.candidates(&request{routeKey: routeKey, bucket: bucket})
+	if len(addresses) == 0 {
+		return errors.New("no dbolt-server address available")
+	}
+	// revision 은 인스턴스마다 다르므로 한 인스턴스에 계속 연결한다.
+	address := addresses[0]
+	nextRevision := opts.StartRevision
+	backoff := c.cfg.RetryBackoff
+	for {
+		received, err := c.watchOnce(ctx, address, bucket, opts, nextRevision, events)
+		if received > 0 {
+			nextRevision = received + 1
+			backoff = c.cfg.RetryBackoff
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && !statusErr.retryable() {
+			return err
+		}
+		if errors.Is(err, errWatchStopped) || errors.Is(err, ErrRevisionCompacted) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// watchOnce 는 연결이 끊길 때까지 event 를 전달하고 마지막으로 전달한 revision 을 반환한다.
+func (c *Client) watchOnce(ctx context.Context, address, bucket string, opts WatchOptions, startRevision uint64, events chan<- *WatchEvent) (uint64, error) {
+	query := url.Values{}
+	if opts.Key != "" {
+		query.Set("key", opts.Key)
+	}
+	if opts.Prefix {
+		query.Set("prefix", "true")
+	}
+	if startRevision > 0 {
+		query.Set("start_revision", strconv.FormatUint(startRevision, 10))
+	}
+	req := &request{
+		method:  http.MethodGet,
+		path:    "/api/v1/watch/" + url.PathEscape(bucket) + "?" + query.Encode(),
+		header:  http.Header{"Accept": {"text/event-stream"}},
+		address: address,
+	}
+	resp, err := c.doWith(ctx, c.streamClient, req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var last uint64
+	var eventType string
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data.Len() == 0 {
+				continue
+			}
+			payload, typ := data.String(), eventType
+			data.Reset()
+			eventType = ""
+			if typ == "error" {
+				return last, watchStopped(payload)
+			}
+			event := new(WatchEvent)
+			if err := json.Unmarshal([]byte(payload), event); err != nil {
+				return last, errors.Wrap(err, "failed to decode the watch event")
+			}
+			select {
+			case events <- event:
+				last = event.Revision
+			case <-ctx.Done():
+				return last, ctx.Err()
+			}
+		case strings.HasPrefix(line, ":"):
+			// keep-alive
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data.WriteString(strings.TrimPrefix(line, "data: "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return last, err
+	}
+	return last, errors.New("watch connection closed")
+}
+
+var errWatchStopped = errors.New("watch stopped by the server")
+
+// watchStopped 는 서버가 error event 로 watch 를 끝낸 오류이다. 같은 revision 부터 다시 연결해도 실패하므로 재연결하지 않는다.
+func watchStopped(message string) error {
+	if strings.Contains(message, "compacted") {
+		return errors.Wrap(ErrRevisionCompacted, message)
+	}
+	return errors.Wrap(errWatchStopped, message)
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"path"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Permission 은 bucket 에 대한 권한이다. 높은 권한은 낮은 권한을 포함한다.
+type Permission int
+
+const (
+	PermissionRead Permission = iota + 1
+	PermissionWrite
+	// PermissionAdmin 은 /admin API 를 포함한다.
+	PermissionAdmin
+)
+
+var permissionNames = map[string]Permission{
+	"read":  PermissionRead,
+	"write": PermissionWrite,
+	"admin": PermissionAdmin,
+}
+
+func (p Permission) String() string {
+	for name, permission := range permissionNames {
+		if permission == p {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// Rule 은 principal 에게 buckets 에 대한 권한을 준다.
+type Rule struct {
+	// Principal 이 "*" 이면 인증된 모든 principal 에 적용된다.
+	Principal string `yaml:"principal"`
+	// Buckets 는 path.Match 패턴이다. "*" 은 모든 bucket 과 bucket 이 없는 클러스터 단위의 요청에 적용된다.
+	// tenancy 가 켜져 있으면 bucket 은 tenant 를 붙인 이름(tenant/bucket)이므로 "team-a/*" 처럼 tenant 를 함께 쓴다.
+	Buckets    []string `yaml:"buckets"`
+	Permission string   `yaml:"permission"`
+}
+
+func (r *Rule) Validate() error {
+	if r.Principal == "" {
+		return errors.New("principal required")
+	}
+	if _, ok := permissionNames[r.Permission]; !ok {
+		return errors.Errorf("unknown permission : %s", r.Permission)
+	}
+	for _, pattern := range r.Buckets {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid bucket pattern : %s", pattern)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) allows(principal *Principal, bucket string, permission Permission) bool {
+	if r.Principal != "*" && r.Principal != principal.Name {
+		return false
+	}
+	if permissionNames[r.Permission] < permission {
+		return false
+	}
+	for _, pattern := range r.Buckets {
+		if pattern == "*" {
+			return true
+		}
+		if matched, _ := path.Match(pattern, bucket); matched {
+			return true
+		}
+	}
+	return false
+}
+
+var ErrForbidden = errors.New("permission denied")
+
+// Authorizer 는 ACL 로 요청을 인가하고 결과를 audit log 로 남긴다.
+type Authorizer struct {
+	cfg   *Config
+	audit *zap.Logger
+}
+
+func NewAuthorizer(cfg *Config, logger *zap.Logger) *Authorizer {
+	return &Authorizer{cfg: cfg, audit: logger.Named("audit")}
+}
+
+// Authorize 는 principal 이 bucket 에 permission 을 가지고 있는지 확인한다. bucket 이 "" 이면 클러스터 단위의 요청이다.
+// tenancy 가 켜져 있으면 bucket 은 저장소의 bucket 이름(tenant/bucket)이어야 principal 이 고른 tenant 까지 확인된다.
+// 다른 인스턴스(InternalPrincipal)는 모든 권한을 가진다.
+func (a *Authorizer) Authorize(principal *Principal, bucket string, permission Permission, action string) error {
+	allowed := principal.Name == InternalPrincipal
+	for i := 0; !allowed && i < len(a.cfg.ACL); i++ {
+		allowed = a.cfg.ACL[i].allows(principal, bucket, permission)
+	}
+
+	if !allowed || a.cfg.AuditLog {
+		fields := []zap.Field{
+			zap.String("principal", principal.Name),
+			zap.String("method", principal.Method),
+			zap.String("tenant", principal.Tenant),
+			zap.String("action", action),
+			zap.String("bucket", bucket),
+			zap.Stringer("permission", permission),
+			zap.Bool("allowed", allowed),
+		}
+		if allowed {
+			a.audit.Info("Authorized.", fields...)
+		} else {
+			a.audit.Warn("Denied.", fields...)
+		}
+	}
+	if !allowed {
+		return errors.Wrapf(ErrForbidden, "principal=%s bucket=%s permission=%s", principal.Name, bucket, permission)
+	}
+	return nil
+}
+
+// Unauthenticated 는 인증에 실패한 요청을 audit log 로 남긴다.
+func (a *Authorizer) Unauthenticated(action string, err error) {
+	a.audit.Warn("Unauthenticated.", zap.String("action", action), zap.Error(err))
+}
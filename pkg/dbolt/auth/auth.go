@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"crypto/x509"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// InternalPrincipal 은 InternalToken 으로 인증한 다른 dbolt 인스턴스이다. 설정의 principal 이름으로 쓸 수 없다.
+const InternalPrincipal = "dbolt:internal"
+
+var (
+	// ErrNoCredentials 는 Authenticator 가 처리할 인증 정보가 요청에 없다는 뜻이다. 다음 Authenticator 로 넘어간다.
+	ErrNoCredentials = errors.New("no credentials")
+	ErrUnauthorized  = errors.New("invalid credentials")
+)
+
+// Principal 은 인증된 요청의 주체이다.
+type Principal struct {
+	Name string `json:"name"`
+	// Tenant 가 있으면 이 principal 의 요청은 항상 그 tenant 로 처리된다.
+	Tenant string `json:"tenant,omitempty"`
+	// Method 는 인증에 사용한 방법이다. (static, hmac, mtls, internal)
+	Method string `json:"method"`
+}
+
+// Request 는 인증에 필요한 요청의 정보이다.
+type Request struct {
+	// BearerToken 은 Authorization 헤더의 Bearer 뒤의 값이다.
+	BearerToken string
+	// PeerCertificates 는 TLS 로 검증된 client 인증서이며 첫 번째가 client 의 인증서이다.
+	PeerCertificates []*x509.Certificate
+}
+
+// BearerToken 은 Authorization 헤더에서 Bearer token 을 꺼낸다.
+func BearerToken(authorization string) string {
+	const prefix = "Bearer "
+	if len(authorization) > len(prefix) && strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return strings.TrimSpace(authorization[len(prefix):])
+	}
+	return ""
+}
+
+type Authenticator interface {
+	Authenticate(req *Request) (*Principal, error)
+}
+
+type StaticToken struct {
+	Principal string `yaml:"principal"`
+	Tenant    string `yaml:"tenant"`
+	Token     string `yaml:"token"`
+	// TokenFile 이 있으면 Token 대신 파일의 내용을 사용한다.
+	TokenFile string `yaml:"token_file"`
+}
+
+type HMACConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Secret     string `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+}
+
+type MTLSConfig struct {
+	// Enabled 이면 검증된 client 인증서의 CommonName 을 principal 로 사용한다.
+	Enabled bool `yaml:"enabled"`
+}
+
+type Config struct {
+	Enabled      bool          `yaml:"enabled"`
+	StaticTokens []StaticToken `yaml:"static_tokens"`
+	HMAC         HMACConfig    `yaml:"hmac"`
+	MTLS         MTLSConfig    `yaml:"mtls"`
+	// InternalToken 은 인스턴스 사이의 /v1/internal 요청에 사용하는 token 이며 모든 인스턴스가 같아야 한다.
+	InternalToken     string `yaml:"internal_token"`
+	InternalTokenFile string `yaml:"internal_token_file"`
+	ACL               []Rule `yaml:"acl"`
+	// AuditLog 이면 모든 인가 결과를 audit logger 로 남긴다. 거부는 항상 남긴다.
+	AuditLog bool `yaml:"audit_log"`
+}
+
+func (c *Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.StaticTokens) == 0 && !c.HMAC.Enabled && !c.MTLS.Enabled {
+		return errors.New("auth requires at least one of 'static_tokens', 'hmac' or 'mtls'")
+	}
+	for i, token := range c.StaticTokens {
+		if token.Principal == "" || token.Principal == InternalPrincipal {
+			return errors.Errorf("invalid auth 'static_tokens[%d].principal'", i)
+		}
+		if (token.Token == "") == (token.TokenFile == "") {
+			return errors.Errorf("auth 'static_tokens[%d]' requires either 'token' or 'token_file'", i)
+		}
+	}
+	if c.HMAC.Enabled && (c.HMAC.Secret == "") == (c.HMAC.SecretFile == "") {
+		return errors.New("auth 'hmac' requires either 'secret' or 'secret_file'")
+	}
+	if c.InternalToken == "" && c.InternalTokenFile == "" {
+		return errors.New("auth 'internal_token' or 'internal_token_file' required")
+	}
+	for i := range c.ACL {
+		if err := c.ACL[i].Validate(); err != nil {
+			return errors.Wrapf(err, "invalid auth 'acl[%d]'", i)
+		}
+	}
+	return nil
+}
+
+// LoadInternalToken 은 인스턴스 사이의 요청에 사용하는 token 을 반환한다. 인증이 꺼져 있으면 "" 이다.
+func (c *Config) LoadInternalToken() (string, error) {
+	if !c.Enabled {
+		return "", nil
+	}
+	return readSecret(c.InternalToken, c.InternalTokenFile)
+}
+
+// NewAuthenticators 는 설정된 인증 방법들을 InternalToken, static, hmac, mtls 순서로 반환한다.
+func NewAuthenticators(cfg *Config) ([]Authenticator, error) {
+	internalToken, err := cfg.LoadInternalToken()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the internal token")
+	}
+	tokens := map[string]*Principal{
+		internalToken: {Name: InternalPrincipal, Method: "internal"},
+	}
+	for _, token := range cfg.StaticTokens {
+		value, err := readSecret(token.Token, token.TokenFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read the token : principal=%s", token.Principal)
+		}
+		if _, ok := tokens[value]; ok {
+			return nil, errors.Errorf("duplicated token : principal=%s", token.Principal)
+		}
+		tokens[value] = &Principal{Name: token.Principal, Tenant: token.Tenant, Method: "static"}
+	}
+	authenticators := []Authenticator{newStaticTokens(tokens)}
+
+	if cfg.HMAC.Enabled {
+		secret, err := readSecret(cfg.HMAC.Secret, cfg.HMAC.SecretFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the hmac secret")
+		}
+		authenticators = append(authenticators, NewHMACAuthenticator([]byte(secret)))
+	}
+	if cfg.MTLS.Enabled {
+		authenticators = append(authenticators, &mtlsAuthenticator{})
+	}
+	return authenticators, nil
+}
+
+func readSecret(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	read, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(read)), nil
+}
+
+// Service 는 설정된 Authenticator 들로 요청을 인증하고 ACL 로 인가한다.
+type Service struct {
+	*Authorizer
+	cfg            *Config
+	authenticators []Authenticator
+}
+
+func New(cfg *Config, logger *zap.Logger) (*Service, error) {
+	s := &Service{Authorizer: NewAuthorizer(cfg, logger), cfg: cfg}
+	if !cfg.Enabled {
+		return s, nil
+	}
+	authenticators, err := NewAuthenticators(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.authenticators = authenticators
+	return s, nil
+}
+
+func (s *Service) Enabled() bool {
+	return s.cfg.Enabled
+}
+
+// Authenticate 는 처리할 수 있는 첫 번째 Authenticator 의 결과를 반환한다.
+func (s *Service) Authenticate(req *Request) (*Principal, error) {
+	for _, authenticator := range s.authenticators {
+		principal, err := authenticator.Authenticate(req)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return principal, err
+	}
+	return nil, errors.Wrap(ErrUnauthorized, "no credentials")
+}
@@ -0,0 +1,38 @@
+// Package authtest 는 front-end 의 테스트에서 사용할 인증 설정을 만든다.
+package authtest
+
+import "github.com/kwSeo/dbolt/pkg/dbolt/auth"
+
+const (
+	// Tenant 는 ACL 로 bucket 의 권한을 준 tenant 이다.
+	Tenant = "team-a"
+	// OtherTenant 는 ACL 에 없는 tenant 이다.
+	OtherTenant = "team-b"
+
+	// UnboundToken 은 tenant 가 없고 Tenant 의 bucket 에만 권한이 있는 principal 의 token 이다.
+	UnboundToken = "unbound-token"
+	// BoundToken 은 Tenant 에 묶이고 Tenant 의 bucket 에 권한이 있는 principal 의 token 이다.
+	BoundToken = "bound-token"
+	// AdminToken 은 tenant 가 없고 "*" 로 모든 tenant 의 bucket 에 권한이 있는 principal 의 token 이다.
+	AdminToken = "admin-token"
+	// InternalToken 은 다른 인스턴스의 token 이다.
+	InternalToken = "internal-token"
+)
+
+// Config 는 tenancy 를 켠 front-end 에서 principal 이 고른 tenant 의 권한을 확인하는 데 쓰는 인증 설정이다.
+func Config() *auth.Config {
+	return &auth.Config{
+		Enabled: true,
+		StaticTokens: []auth.StaticToken{
+			{Principal: "unbound", Token: UnboundToken},
+			{Principal: "bound", Tenant: Tenant, Token: BoundToken},
+			{Principal: "admin", Token: AdminToken},
+		},
+		InternalToken: InternalToken,
+		ACL: []auth.Rule{
+			{Principal: "unbound", Buckets: []string{Tenant + "/*"}, Permission: "write"},
+			{Principal: "bound", Buckets: []string{Tenant + "/*"}, Permission: "write"},
+			{Principal: "admin", Buckets: []string{"*"}, Permission: "write"},
+		},
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// hmacTokenPrefix 로 시작하는 token 은 HMAC 으로 서명된 token 이다.
+//
+//	dbolt.v1.<base64url(claims json)>.<base64url(HMAC-SHA256(secret, "dbolt.v1." + claims))>
+const hmacTokenPrefix = "dbolt.v1."
+
+// staticTokens 는 설정된 token 들과 InternalToken 을 확인한다.
+// token 을 그대로 비교하지 않고 SHA-256 으로 찾으므로 비교 시간으로 token 이 드러나지 않는다.
+type staticTokens struct {
+	principals map[[sha256.Size]byte]*Principal
+}
+
+func newStaticTokens(tokens map[string]*Principal) *staticTokens {
+	principals := make(map[[sha256.Size]byte]*Principal, len(tokens))
+	for token, principal := range tokens {
+		if token != "" {
+			principals[sha256.Sum256([]byte(token))] = principal
+		}
+	}
+	return &staticTokens{principals: principals}
+}
+
+func (a *staticTokens) Authenticate(req *Request) (*Principal, error) {
+	if req.BearerToken == "" || strings.HasPrefix(req.BearerToken, hmacTokenPrefix) {
+		return nil, ErrNoCredentials
+	}
+	principal, ok := a.principals[sha256.Sum256([]byte(req.BearerToken))]
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	return principal, nil
+}
+
+// Claims 는 HMAC token 에 서명된 내용이다.
+type Claims struct {
+	Principal string `json:"sub"`
+	Tenant    string `json:"tenant,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type HMACAuthenticator struct {
+	secret []byte
+}
+
+func NewHMACAuthenticator(secret []byte) *HMACAuthenticator {
+	return &HMACAuthenticator{secret: secret}
+}
+
+// Sign 은 claims 에 서명한 token 을 만든다.
+func (a *HMACAuthenticator) Sign(claims *Claims) (string, error) {
+	if claims.Principal == "" || claims.Principal == InternalPrincipal {
+		return "", errors.New("invalid principal")
+	}
+	marshaled, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := hmacTokenPrefix + base64.RawURLEncoding.EncodeToString(marshaled)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(a.mac(payload)), nil
+}
+
+func (a *HMACAuthenticator) Authenticate(req *Request) (*Principal, error) {
+	if !strings.HasPrefix(req.BearerToken, hmacTokenPrefix) {
+		return nil, ErrNoCredentials
+	}
+	i := strings.LastIndexByte(req.BearerToken, '.')
+	payload, signature := req.BearerToken[:i], req.BearerToken[i+1:]
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decodedSignature, a.mac(payload)) {
+		return nil, errors.Wrap(ErrUnauthorized, "invalid signature")
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(payload, hmacTokenPrefix))
+	if err != nil {
+		return nil, errors.Wrap(ErrUnauthorized, "invalid claims")
+	}
+	claims := new(Claims)
+	if err := json.Unmarshal(decoded, claims); err != nil {
+		return nil, errors.Wrap(ErrUnauthorized, "invalid claims")
+	}
+	if claims.Principal == "" || claims.Principal == InternalPrincipal {
+		return nil, errors.Wrap(ErrUnauthorized, "invalid principal")
+	}
+	if claims.ExpiresAt > 0 && time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errors.Wrap(ErrUnauthorized, "token expired")
+	}
+	return &Principal{Name: claims.Principal, Tenant: claims.Tenant, Method: "hmac"}, nil
+}
+
+func (a *HMACAuthenticator) mac(payload string) []byte {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// mtlsAuthenticator 는 TLS 에서 검증된 client 인증서의 CommonName 을 principal 로 사용한다.
+type mtlsAuthenticator struct{}
+
+func (a *mtlsAuthenticator) Authenticate(req *Request) (*Principal, error) {
+	if len(req.PeerCertificates) == 0 {
+		return nil, ErrNoCredentials
+	}
+	name := req.PeerCertificates[0].Subject.CommonName
+	if name == "" || name == InternalPrincipal {
+		return nil, errors.Wrap(ErrUnauthorized, "invalid certificate subject")
+	}
+	return &Principal{Name: name, Method: "mtls"}, nil
+}
@@ -0,0 +1,199 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/grafana/dskit/kv/memberlist"
+	"github.com/grafana/dskit/ring"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	ManifestFileName = "manifest.json"
+	RingFileName     = "ring.json"
+	InstancesDir     = "instances/"
+)
+
+// Snapshotter 는 일관된 bolt 스냅샷을 기록할 수 있는 Store 이다.
+type Snapshotter interface {
+	Backup(ctx context.Context, w io.Writer) (int64, error)
+}
+
+type Manifest struct {
+	CreatedAt time.Time          `json:"createdAt"`
+	Instances []InstanceSnapshot `json:"instances"`
+}
+
+type InstanceSnapshot struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+	Zone string `json:"zone"`
+	File string `json:"file"`
+	Size int64  `json:"size"`
+}
+
+type RestoreResult struct {
+	Keys int `json:"keys"`
+}
+
+type Service struct {
+	readRing     ring.ReadRing
+	storePool    *distributor.SimpleStorePool
+	dist         *distributor.Distributor
+	memberlistKV *memberlist.KVInitService
+	encryptor    *store.Encryptor
+	ringKey      string
+	logger       *zap.Logger
+}
+
+func New(r ring.ReadRing, storePool *distributor.SimpleStorePool, dist *distributor.Distributor, memberlistKV *memberlist.KVInitService, encryptor *store.Encryptor, ringKey string, logger *zap.Logger) *Service {
+	return &Service{
+		readRing:     r,
+		storePool:    storePool,
+		dist:         dist,
+		memberlistKV: memberlistKV,
+		encryptor:    encryptor,
+		ringKey:      ringKey,
+		logger:       logger,
+	}
+}
+
+// WriteClusterBackup 은 Ring 의 모든 healthy 인스턴스 스냅샷과 memberlist 에 있는 Ring 상태를 tar 로 묶어 w 에 기록한다.
+func (s *Service) WriteClusterBackup(ctx context.Context, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	ringDesc, err := s.ringDesc()
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(tw, RingFileName, ringDesc); err != nil {
+		return err
+	}
+
+	replicationSet, err := s.readRing.GetAllHealthy(ring.Reporting)
+	if err != nil {
+		return errors.Wrap(err, "failed to read all healthy instances")
+	}
+
+	healthy := make(map[string]bool)
+	for _, addr := range replicationSet.GetAddresses() {
+		healthy[addr] = true
+	}
+
+	manifest := &Manifest{CreatedAt: time.Now()}
+	for id, instance := range ringDesc.Ingesters {
+		if !healthy[instance.Addr] {
+			s.logger.Warn("Skipping unhealthy instance.", zap.String("instanceID", id), zap.String("addr", instance.Addr))
+			continue
+		}
+		s.logger.Info("Backing up instance.", zap.String("instanceID", id), zap.String("addr", instance.Addr))
+		snapshot, err := s.writeInstanceSnapshot(ctx, tw, id, instance)
+		if err != nil {
+			return err
+		}
+		manifest.Instances = append(manifest.Instances, snapshot)
+	}
+
+	if err := writeJSON(tw, ManifestFileName, manifest); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func (s *Service) writeInstanceSnapshot(ctx context.Context, tw *tar.Writer, id string, instance ring.InstanceDesc) (InstanceSnapshot, error) {
+	snapshotter, ok := s.storePool.Get(instance.Addr).(Snapshotter)
+	if !ok {
+		return InstanceSnapshot{}, errors.Errorf("store of instance does not support backup : addr=%s", instance.Addr)
+	}
+
+	// tar 헤더에 크기가 먼저 기록되어야 하므로 임시 파일에 받은 뒤 복사한다.
+	tmp, err := os.CreateTemp("", "dbolt-backup-*.db")
+	if err != nil {
+		return InstanceSnapshot{}, errors.Wrap(err, "failed to create a temporary file")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := snapshotter.Backup(ctx, tmp)
+	if err != nil {
+		return InstanceSnapshot{}, errors.Wrapf(err, "failed to back up instance : addr=%s", instance.Addr)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return InstanceSnapshot{}, err
+	}
+
+	snapshot := InstanceSnapshot{
+		ID:   id,
+		Addr: instance.Addr,
+		Zone: instance.Zone,
+		File: InstancesDir + id + ".db",
+		Size: size,
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: snapshot.File, Mode: 0600, Size: size, ModTime: time.Now()}); err != nil {
+		return InstanceSnapshot{}, err
+	}
+	if _, err := io.Copy(tw, tmp); err != nil {
+		return InstanceSnapshot{}, err
+	}
+	return snapshot, nil
+}
+
+func (s *Service) ringDesc() (*ring.Desc, error) {
+	kv, err := s.memberlistKV.GetMemberlistKV()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get memberlist KV")
+	}
+	value, err := kv.Get(s.ringKey, ring.GetCodec())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get ring state : key=%s", s.ringKey)
+	}
+	desc, ok := value.(*ring.Desc)
+	if !ok || desc == nil {
+		return ring.NewDesc(), nil
+	}
+	return desc, nil
+}
+
+// Restore 는 bolt 스냅샷의 모든 key-value 를 Distributor 를 통해 현재 Ring 에 다시 기록한다.
+// 이미 같거나 더 최신 version 이 있는 key 는 덮어쓰지 않는다.
+// 스냅샷을 만든 시점과 Ring 이 달라졌더라도 현재 Ring 기준으로 다시 분배된다.
+// 암호화된 값은 이 노드의 key 로 복호화하므로 스냅샷을 만든 노드와 같은 keyfile 을 사용해야 한다.
+func (s *Service) Restore(ctx context.Context, snapshotPath string) (RestoreResult, error) {
+	var result RestoreResult
+	err := store.ReadSnapshot(snapshotPath, func(bucketName, key, value []byte) error {
+		value, err := s.encryptor.Decrypt(ctx, bucketName, key, value)
+		if err != nil {
+			return err
+		}
+		if err := s.dist.Restore(ctx, bucketName, key, value); err != nil {
+			return err
+		}
+		result.Keys++
+		return nil
+	})
+	if err != nil {
+		return result, errors.Wrapf(err, "failed to restore the snapshot : path=%s", snapshotPath)
+	}
+	s.logger.Info("Restored the snapshot.", zap.String("path", snapshotPath), zap.Int("keys", result.Keys))
+	return result, nil
+}
+
+func writeJSON(tw *tar.Writer, name string, v any) error {
+	marshaled, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal %s", name)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(marshaled)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err = tw.Write(marshaled)
+	return err
+}
@@ -0,0 +1,246 @@
+package changes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/grafana/dskit/ring"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultLimit = 100
+	// MaxLimit 는 한 번에 읽을 수 있는 최대 변경 수이며 LocalStore.Changes 의 상한과 같다.
+	MaxLimit = 1000
+)
+
+// ChangeReader 는 변경 기록을 revision 순서로 읽을 수 있는 Store 이다.
+type ChangeReader interface {
+	Changes(ctx context.Context, since uint64, limit int) ([]*store.ChangeEvent, error)
+}
+
+type Change struct {
+	// Sequence 는 변경을 기록한 노드의 revision 이며 클러스터 스트림에서는 Instance 와 함께 의미가 있다.
+	Sequence  uint64 `json:"sequence"`
+	Instance  string `json:"instance,omitempty"`
+	Operation string `json:"operation"`
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	// Version 은 값의 UpdatedAt(Unix nano) 이며 replica 사이에 같다. 삭제는 0 이다.
+	Version     int64     `json:"version"`
+	Value       []byte    `json:"value,omitempty"`
+	CommittedAt time.Time `json:"committedAt"`
+}
+
+type LocalPage struct {
+	Changes []Change `json:"changes"`
+	// Next 를 다음 요청의 since 로 넘기면 이어서 읽는다.
+	Next uint64 `json:"next"`
+}
+
+type ClusterPage struct {
+	Changes []Change `json:"changes"`
+	// Next 를 다음 요청의 since 로 넘기면 이어서 읽는다.
+	Next string `json:"next"`
+}
+
+// Cursor 는 클러스터 스트림에서 인스턴스 주소별로 마지막으로 읽은 revision 이다.
+type Cursor map[string]uint64
+
+func ParseCursor(encoded string) (Cursor, error) {
+	cursor := make(Cursor)
+	if encoded == "" {
+		return cursor, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid cursor")
+	}
+	if err := json.Unmarshal(decoded, &cursor); err != nil {
+		return nil, errors.Wrap(err, "invalid cursor")
+	}
+	return cursor, nil
+}
+
+func (c Cursor) String() string {
+	marshaled, _ := json.Marshal(map[string]uint64(c))
+	return base64.RawURLEncoding.EncodeToString(marshaled)
+}
+
+type Service struct {
+	readRing   ring.ReadRing
+	storePool  *distributor.SimpleStorePool
+	localStore *store.LocalStore
+	logger     *zap.Logger
+}
+
+func New(r ring.ReadRing, storePool *distributor.SimpleStorePool, localStore *store.LocalStore, logger *zap.Logger) *Service {
+	return &Service{
+		readRing:   r,
+		storePool:  storePool,
+		localStore: localStore,
+		logger:     logger,
+	}
+}
+
+// Local 은 이 노드의 변경 기록을 since 다음 revision 부터 읽는다.
+// tenantID 가 있으면 그 tenant 의 변경만 반환하므로 Changes 가 비어 있어도 Next 는 앞으로 나아갈 수 있다.
+func (s *Service) Local(ctx context.Context, tenantID string, since uint64, limit int) (*LocalPage, error) {
+	events, err := s.localStore.Changes(ctx, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	page := &LocalPage{Changes: make([]Change, 0, len(events)), Next: since}
+	for _, event := range events {
+		page.Next = event.Revision
+		change, ok, err := toChange(tenantID, event)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			page.Changes = append(page.Changes, change)
+		}
+	}
+	return page, nil
+}
+
+// Cluster 는 모든 healthy 인스턴스의 변경 기록을 합쳐 기록된 시각 순서로 반환한다.
+//
+// 인스턴스마다 최대 limit 개를 읽은 뒤, limit 개를 가득 읽은 인스턴스들의 마지막 변경 시각 중 가장 이른 시각까지만 반환한다.
+// 같은 변경의 replica 들은 거의 같은 시각에 기록되므로 한 페이지에 함께 들어오고, 다른 인스턴스의 같은 변경은 하나만 남긴다(dedupe).
+// 늦게 적용된 replica 는 다음 페이지에 다시 나올 수 있으므로 소비자는 Version 으로 멱등하게 처리해야 한다.
+func (s *Service) Cluster(ctx context.Context, tenantID string, cursor Cursor, limit int) (*ClusterPage, error) {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	replicationSet, err := s.readRing.GetAllHealthy(ring.Reporting)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read all healthy instances")
+	}
+
+	type instanceEvents struct {
+		addr   string
+		events []*store.ChangeEvent
+	}
+	var fetched []instanceEvents
+	var horizon time.Time
+	for _, addr := range replicationSet.GetAddresses() {
+		reader, ok := s.storePool.Get(addr).(ChangeReader)
+		if !ok {
+			s.logger.Warn("Skipping instance without change log.", zap.String("addr", addr))
+			continue
+		}
+		events, err := reader.Changes(ctx, cursor[addr], limit)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read changes : addr=%s", addr)
+		}
+		fetched = append(fetched, instanceEvents{addr: addr, events: events})
+		if len(events) == limit {
+			last := events[len(events)-1].CommittedAt
+			if horizon.IsZero() || last.Before(horizon) {
+				horizon = last
+			}
+		}
+	}
+
+	next := make(Cursor, len(cursor))
+	for addr, revision := range cursor {
+		next[addr] = revision
+	}
+	var merged []Change
+	for _, instance := range fetched {
+		for _, event := range instance.events {
+			if !horizon.IsZero() && event.CommittedAt.After(horizon) {
+				break
+			}
+			next[instance.addr] = event.Revision
+			change, ok, err := toChange(tenantID, event)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				change.Instance = instance.addr
+				merged = append(merged, change)
+			}
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].CommittedAt.Before(merged[j].CommittedAt)
+	})
+	return &ClusterPage{Changes: dedupe(merged), Next: next.String()}, nil
+}
+
+// replicaWindow 는 한 변경의 replica 들이 기록되는 시각의 최대 차이로 본다. 버전이 없는 삭제를 구분하는 데 쓴다.
+const replicaWindow = 5 * time.Second
+
+type changeID struct {
+	operation string
+	bucket    string
+	key       string
+	version   int64
+}
+
+// replicaGroup 은 한 변경으로 보는 replica 들의 변경 기록이다.
+type replicaGroup struct {
+	instances   map[string]bool
+	committedAt time.Time
+}
+
+// dedupe 는 기록된 시각 순서인 changes 에서 다른 인스턴스에 기록된 같은 변경을 하나만 남긴다.
+// 같은 인스턴스에 다시 기록된 변경은 새 변경이다. 삭제는 버전이 0 이므로 replicaWindow 안에 기록된 것만 같은 변경으로 본다.
+func dedupe(changes []Change) []Change {
+	groups := make(map[changeID]*replicaGroup, len(changes))
+	deduped := make([]Change, 0, len(changes))
+	for _, change := range changes {
+		id := changeID{operation: change.Operation, bucket: change.Bucket, key: change.Key, version: change.Version}
+		group := groups[id]
+		if group != nil && !group.instances[change.Instance] &&
+			(change.Version != 0 || change.CommittedAt.Sub(group.committedAt) <= replicaWindow) {
+			group.instances[change.Instance] = true
+			continue
+		}
+		groups[id] = &replicaGroup{instances: map[string]bool{change.Instance: true}, committedAt: change.CommittedAt}
+		deduped = append(deduped, change)
+	}
+	return deduped
+}
+
+// toChange 는 변경 기록을 Change 로 바꾼다. tenantID 가 있으면 다른 tenant 의 변경은 false 를 반환하고 bucket 에서 tenant 를 뗀다.
+func toChange(tenantID string, event *store.ChangeEvent) (Change, bool, error) {
+	bucket := event.BucketName
+	if tenantID != "" {
+		owner, tenantBucket, ok := tenant.SplitBucket(bucket)
+		if !ok || owner != tenantID {
+			return Change{}, false, nil
+		}
+		bucket = tenantBucket
+	}
+	change := Change{
+		Sequence:    event.Revision,
+		Operation:   event.Type.String(),
+		Bucket:      string(bucket),
+		Key:         string(event.Key),
+		CommittedAt: event.CommittedAt,
+	}
+	if event.Type != store.ChangePut {
+		return change, true, nil
+	}
+	versionedValue, err := distributor.ParseVersionedValue(event.Value)
+	if err != nil {
+		return Change{}, false, errors.Wrapf(err, "invalid stored value : revision=%d", event.Revision)
+	}
+	change.Version = versionedValue.UpdatedAt.UnixNano()
+	change.Value = versionedValue.Value
+	return change, true, nil
+}
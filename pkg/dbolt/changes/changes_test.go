@@ -0,0 +1,29 @@
+package changes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupe(t *testing.T) {
+	now := time.Now()
+	change := func(instance, operation string, version int64, after time.Duration) Change {
+		return Change{Instance: instance, Operation: operation, Bucket: "bucket", Key: "key", Version: version, CommittedAt: now.Add(after)}
+	}
+	changes := []Change{
+		// 한 번의 쓰기를 세 replica 가 기록했다.
+		change("a", "put", 1, 0),
+		change("b", "put", 1, time.Millisecond),
+		change("c", "put", 1, 2*time.Millisecond),
+		// 같은 인스턴스에 다시 기록된 변경은 새 변경이다.
+		change("a", "delete", 0, time.Second),
+		change("b", "delete", 0, time.Second),
+		change("a", "delete", 0, 2*time.Second),
+		// replicaWindow 가 지난 삭제는 다른 인스턴스에 기록되었더라도 새 변경이다.
+		change("c", "delete", 0, 2*time.Second+replicaWindow+time.Millisecond),
+	}
+	deduped := dedupe(changes)
+	require.Equal(t, []Change{changes[0], changes[3], changes[5], changes[6]}, deduped)
+}
@@ -1,36 +1,330 @@
 package dbolt
 
 import (
+	"path/filepath"
+	"strings"
+	"time"
+
 	"github.com/grafana/dskit/kv/memberlist"
 	"github.com/grafana/dskit/ring"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/decommission"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/etcdserver"
 	"github.com/kwSeo/dbolt/pkg/dbolt/httpserver"
+	"github.com/kwSeo/dbolt/pkg/dbolt/memcachedserver"
+	"github.com/kwSeo/dbolt/pkg/dbolt/ratelimit"
+	"github.com/kwSeo/dbolt/pkg/dbolt/respserver"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
 	"github.com/kwSeo/dbolt/pkg/util"
 	"github.com/pkg/errors"
+	"go.uber.org/zap/zapcore"
 )
 
+// AvailabilityZoneEnv 가 설정되어 있으면 lifecycler 의 availability_zone 대신 사용한다.
+const AvailabilityZoneEnv = "DBOLT_AVAILABILITY_ZONE"
+
 type Config struct {
-	BoltConfig       BoltConfig            `yaml:"bolt"`
-	ServerConfig     httpserver.Config     `yaml:"server"`
-	LifecyclerConfig ring.LifecyclerConfig `yaml:"lifecycler"`
-	MemberlistConfig memberlist.KVConfig   `yaml:"memberlist"`
+	LogConfig          LogConfig              `yaml:"log"`
+	BoltConfig         BoltConfig             `yaml:"bolt"`
+	ServerConfig       httpserver.Config      `yaml:"server"`
+	DistributorConfig  distributor.Config     `yaml:"distributor"`
+	LifecyclerConfig   ring.LifecyclerConfig  `yaml:"lifecycler"`
+	MemberlistConfig   memberlist.KVConfig    `yaml:"memberlist"`
+	TenancyConfig      tenant.Config          `yaml:"tenancy"`
+	RateLimitConfig    ratelimit.Config       `yaml:"rate_limit"`
+	AuthConfig         auth.Config            `yaml:"auth"`
+	RESPConfig         respserver.Config      `yaml:"resp"`
+	MemcachedConfig    memcachedserver.Config `yaml:"memcached"`
+	EtcdConfig         etcdserver.Config      `yaml:"etcd"`
+	DecommissionConfig decommission.Config    `yaml:"decommission"`
 }
 
+// Validate 는 설정을 모두 검사해서 잘못된 설정을 한꺼번에 반환한다. 오류는 util.Errors 이다.
 func (c *Config) Validate() error {
-	return util.And(
+	return util.All(
+		c.LogConfig.Validate,
 		c.BoltConfig.Validate,
 		c.ServerConfig.Validate,
+		c.DistributorConfig.Validate,
+		c.TenancyConfig.Validate,
+		c.RateLimitConfig.Validate,
+		c.AuthConfig.Validate,
+		c.RESPConfig.Validate,
+		c.MemcachedConfig.Validate,
+		c.EtcdConfig.Validate,
+		c.DecommissionConfig.Validate,
+		c.validateShardSizes,
+		c.validateZoneAwareness,
+		c.validateMemberlistTLS,
+		c.validateReplicationFactor,
+		c.validateTokens,
+		c.validateTokensFile,
+		c.validateHeartbeat,
+		c.validateJoinMembers,
+		c.validateAdvertisedPort,
+		c.validatePorts,
 	)
 }
 
+// ephemeralDirs 는 재시작하면 비워질 수 있는 디렉터리이다.
+var ephemeralDirs = []string{"/tmp", "/var/tmp", "/dev/shm", "/run"}
+
+func (c *Config) validateReplicationFactor() error {
+	if c.LifecyclerConfig.RingConfig.ReplicationFactor < 1 {
+		return errors.Errorf("lifecycler 'ring.replication_factor' must be at least 1, got %d", c.LifecyclerConfig.RingConfig.ReplicationFactor)
+	}
+	return nil
+}
+
+func (c *Config) validateTokens() error {
+	lc := &c.LifecyclerConfig
+	if lc.NumTokens < 1 {
+		return errors.Errorf("lifecycler 'num_tokens' must be at least 1, got %d", lc.NumTokens)
+	}
+	if err := lc.Validate(); err != nil {
+		return errors.Wrap(err, "invalid lifecycler")
+	}
+	return nil
+}
+
+// validateTokensFile 은 token 파일이 재시작 후에도 남아 있을 위치인지 확인한다.
+// token 파일이 사라지면 인스턴스가 다른 token 으로 Ring 에 들어와서 bucket 이 다른 인스턴스로 옮겨진다.
+func (c *Config) validateTokensFile() error {
+	lc := &c.LifecyclerConfig
+	if lc.TokensFilePath == "" {
+		return nil
+	}
+	if !filepath.IsAbs(lc.TokensFilePath) {
+		return errors.Errorf("lifecycler 'tokens_file_path' must be an absolute path, got %q", lc.TokensFilePath)
+	}
+	for _, dir := range ephemeralDirs {
+		if strings.HasPrefix(filepath.Clean(lc.TokensFilePath), dir+"/") {
+			return errors.Errorf("lifecycler 'tokens_file_path' %q is under %s which may be cleared on restart, put it on the same volume as bolt 'db.path'", lc.TokensFilePath, dir)
+		}
+	}
+	return nil
+}
+
+// validateHeartbeat 는 heartbeat 이 timeout 전에 도착하는지 확인한다. 그렇지 않으면 인스턴스들이 번갈아 unhealthy 가 된다.
+// heartbeat_timeout 이 0 이면 timeout 이 없다.
+func (c *Config) validateHeartbeat() error {
+	lc := &c.LifecyclerConfig
+	if err := validateHeartbeatTimeout("ring.heartbeat_timeout", lc.RingConfig.HeartbeatTimeout, lc.HeartbeatPeriod); err != nil {
+		return err
+	}
+	if lc.ReadinessCheckRingHealth {
+		return validateHeartbeatTimeout("heartbeat_timeout", lc.HeartbeatTimeout, lc.HeartbeatPeriod)
+	}
+	return nil
+}
+
+func validateHeartbeatTimeout(name string, timeout, period time.Duration) error {
+	if timeout > 0 && (period <= 0 || period >= timeout) {
+		return errors.Errorf("lifecycler '%s' (%s) must be greater than 'heartbeat_period' (%s), use a few heartbeat periods", name, timeout, period)
+	}
+	return nil
+}
+
+// validateJoinMembers 는 memberlist 로 다른 인스턴스를 찾을 수 있는지 확인한다.
+// join_members 가 없는 인스턴스는 다른 인스턴스가 먼저 찾아오기 전까지 혼자 Ring 을 만든다.
+func (c *Config) validateJoinMembers() error {
+	if c.LifecyclerConfig.RingConfig.KVStore.Store != "memberlist" || c.LifecyclerConfig.RingConfig.ReplicationFactor <= 1 {
+		return nil
+	}
+	if len(c.MemberlistConfig.JoinMembers) == 0 {
+		return errors.New("memberlist 'join_members' required when the replication factor is greater than 1, list the other instances or a dns+ address of all instances")
+	}
+	return nil
+}
+
+// validateAdvertisedPort 는 Ring 에 알리는 port 가 HTTP port 와 같은지 확인한다.
+// 다른 인스턴스는 Ring 의 주소에 자기의 http_listen_port 를 붙여서 요청하므로 모든 인스턴스의 http_listen_port 가 같아야 한다.
+func (c *Config) validateAdvertisedPort() error {
+	httpPort := int(c.ServerConfig.HTTPListenPort)
+	if httpPort == 0 {
+		return errors.New("server 'http_listen_port' required, every instance must listen on the same port")
+	}
+	if port := c.LifecyclerConfig.Port; port != 0 && port != httpPort {
+		return errors.Errorf("lifecycler 'port' (%d) must be equal to server 'http_listen_port' (%d), peers connect to the http_listen_port of every instance", port, httpPort)
+	}
+	return nil
+}
+
+// validatePorts 는 같은 bind_ip 에서 listen 하는 port 가 겹치지 않는지 확인한다.
+func (c *Config) validatePorts() error {
+	type listener struct {
+		name string
+		port int
+	}
+	listeners := []listener{
+		{"server 'http_listen_port'", int(c.ServerConfig.HTTPListenPort)},
+		{"server 'grpc_listen_port'", int(c.ServerConfig.GRPCListenPort)},
+	}
+	if c.LifecyclerConfig.RingConfig.KVStore.Store == "memberlist" {
+		listeners = append(listeners, listener{"memberlist 'bind_port'", c.MemberlistConfig.TCPTransport.BindPort})
+	}
+	if c.RESPConfig.Enabled {
+		listeners = append(listeners, listener{"resp 'listen_port'", int(c.RESPConfig.ListenPort)})
+	}
+	if c.MemcachedConfig.Enabled {
+		listeners = append(listeners, listener{"memcached 'listen_port'", int(c.MemcachedConfig.ListenPort)})
+	}
+	if c.EtcdConfig.Enabled {
+		listeners = append(listeners, listener{"etcd 'listen_port'", int(c.EtcdConfig.ListenPort)})
+	}
+	var errs util.Errors
+	used := make(map[int]string, len(listeners))
+	for _, l := range listeners {
+		// 0 이면 빈 port 를 쓰므로 겹치지 않는다.
+		if l.port == 0 {
+			continue
+		}
+		if other, ok := used[l.port]; ok {
+			errs = append(errs, errors.Errorf("%s and %s both use port %d", other, l.name, l.port))
+			continue
+		}
+		used[l.port] = l.name
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateMemberlistTLS 는 memberlist 의 TCP transport 가 TLS listener 로 쓸 인증서를 가지고 있는지 확인한다.
+// memberlist 의 인증서는 dskit 이 시작할 때 한 번 읽으므로 교체하려면 재시작해야 한다.
+func (c *Config) validateMemberlistTLS() error {
+	transport := c.MemberlistConfig.TCPTransport
+	if transport.TLSEnabled && (transport.TLS.CertPath == "" || transport.TLS.KeyPath == "") {
+		return errors.New("memberlist 'tls_cert_path' and 'tls_key_path' required when 'tls_enabled' is set")
+	}
+	return nil
+}
+
+func (c *Config) validateZoneAwareness() error {
+	zoneAwarenessEnabled := c.LifecyclerConfig.RingConfig.ZoneAwarenessEnabled
+	if zoneAwarenessEnabled && c.LifecyclerConfig.Zone == "" {
+		return errors.Errorf("lifecycler 'availability_zone' or %s required when zone awareness is enabled", AvailabilityZoneEnv)
+	}
+	zoneAwareness := c.DistributorConfig.ZoneAwareness
+	if !zoneAwarenessEnabled && (zoneAwareness.LocalReads || zoneAwareness.TolerateZoneFailure) {
+		return errors.New("distributor 'zone_awareness' requires lifecycler 'ring.zone_awareness_enabled'")
+	}
+	return nil
+}
+
+// validateShardSizes 는 shard 가 replica 를 모두 담을 수 있는지 확인한다.
+func (c *Config) validateShardSizes() error {
+	replicationFactor := c.LifecyclerConfig.RingConfig.ReplicationFactor
+	limits := map[string]tenant.Limits{"default_limits": c.TenancyConfig.DefaultLimits}
+	for tenantID, override := range c.TenancyConfig.Overrides {
+		limits["overrides."+tenantID] = override
+	}
+	for name, l := range limits {
+		if l.ShardSize > 0 && l.ShardSize < replicationFactor {
+			return errors.Errorf("tenancy '%s.shard_size' must not be less than the replication factor %d", name, replicationFactor)
+		}
+	}
+	return nil
+}
+
+// 로그의 출력 형식이다.
+const (
+	LogFormatConsole = "console"
+	LogFormatJSON    = "json"
+)
+
+type LogConfig struct {
+	// Level 은 debug, info, warn, error 중 하나이다. 기본값은 info 이다.
+	Level string `yaml:"level"`
+	// Format 은 console 또는 json 이다. 기본값은 console 이다. 바꾸려면 재시작해야 한다.
+	Format string `yaml:"format"`
+	// Components 는 component 별 level 이다. 비어 있으면 Level 을 따른다.
+	Components LogComponentsConfig `yaml:"components"`
+}
+
+func (lc *LogConfig) Validate() error {
+	if _, err := lc.level(); err != nil {
+		return errors.Wrap(err, "invalid log 'level'")
+	}
+	if format := lc.format(); format != LogFormatConsole && format != LogFormatJSON {
+		return errors.Errorf("invalid log 'format' : %s", format)
+	}
+	return lc.Components.Validate()
+}
+
+func (lc *LogConfig) level() (zapcore.Level, error) {
+	if lc.Level == "" {
+		return zapcore.InfoLevel, nil
+	}
+	return zapcore.ParseLevel(lc.Level)
+}
+
+func (lc *LogConfig) format() string {
+	if lc.Format == "" {
+		return LogFormatConsole
+	}
+	return lc.Format
+}
+
+type LogComponentsConfig struct {
+	// DSKit 은 lifecycler, Ring, KV client 의 level 이다.
+	DSKit       string `yaml:"dskit"`
+	Memberlist  string `yaml:"memberlist"`
+	Distributor string `yaml:"distributor"`
+	HTTP        string `yaml:"http"`
+}
+
+func (cc *LogComponentsConfig) Validate() error {
+	for _, name := range []string{ComponentDSKit, ComponentMemberlist, ComponentDistributor, ComponentHTTP} {
+		if _, err := cc.level(name); err != nil {
+			return errors.Wrapf(err, "invalid log 'components.%s'", name)
+		}
+	}
+	return nil
+}
+
+// level 은 component 의 level 이다. 정하지 않았으면 nil 이다.
+func (cc *LogComponentsConfig) level(name string) (*zapcore.Level, error) {
+	levels := map[string]string{
+		ComponentDSKit:       cc.DSKit,
+		ComponentMemberlist:  cc.Memberlist,
+		ComponentDistributor: cc.Distributor,
+		ComponentHTTP:        cc.HTTP,
+	}
+	if levels[name] == "" {
+		return nil, nil
+	}
+	level, err := zapcore.ParseLevel(levels[name])
+	if err != nil {
+		return nil, err
+	}
+	return &level, nil
+}
+
 type BoltConfig struct {
 	DB struct {
-		Path string `yaml:"path"`
+		// Engine 은 저장소 엔진이다. (bbolt, boltdb, memory) 기본값은 bbolt 이다.
+		Engine string `yaml:"engine"`
+		Path   string `yaml:"path"`
 	} `yaml:"db"`
+	Compaction store.CompactionConfig `yaml:"compaction"`
+	Encryption store.EncryptionConfig `yaml:"encryption"`
+	ChangeLog  store.ChangeLogConfig  `yaml:"change_log"`
 }
 
 func (bc *BoltConfig) Validate() error {
-	if bc.DB.Path == "" {
+	if err := store.ValidateEngine(bc.DB.Engine); err != nil {
+		return errors.Wrap(err, "invalid bolt 'db.engine'")
+	}
+	if bc.DB.Engine == store.EngineMemory {
+		if bc.Compaction.Interval > 0 {
+			return errors.New("bolt 'compaction.interval' is not supported by the memory engine")
+		}
+	} else if bc.DB.Path == "" {
 		return errors.New("bolt 'db.path' required")
 	}
-	return nil
+	return bc.Encryption.Validate()
 }
@@ -0,0 +1,120 @@
+package dbolt
+
+import (
+	"flag"
+	"os"
+	"regexp"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// envReference 는 설정 파일 안의 ${VAR} 와 ${VAR:-default} 이다.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// ConfigLoader 는 기본값, 설정 파일, DBOLT_ 환경 변수, flag 순서로 설정을 읽는다. 뒤에 오는 것이 앞의 값을 덮어쓴다.
+// SIGHUP 으로 설정을 다시 읽을 때도 처음과 같은 flag 를 사용한다.
+type ConfigLoader struct {
+	args []string
+	path string
+}
+
+// NewConfigLoader 는 flag 를 검사해서 설정 파일의 경로를 찾는다. -h 이면 사용법을 출력하고 flag.ErrHelp 를 반환한다.
+func NewConfigLoader(args []string) (*ConfigLoader, error) {
+	f, path := newFlagSet(DefaultConfig())
+	if err := f.Parse(args); err != nil {
+		return nil, err
+	}
+	if f.NArg() > 0 {
+		return nil, errors.Errorf("unexpected arguments : %v", f.Args())
+	}
+	return &ConfigLoader{args: args, path: *path}, nil
+}
+
+func newFlagSet(cfg *Config) (*flag.FlagSet, *string) {
+	f := flag.NewFlagSet("dbolt-server", flag.ContinueOnError)
+	path := f.String("config-path", "./config.yml", "Configuration file path.")
+	cfg.RegisterFlags(f)
+	return f, path
+}
+
+// DefaultConfig 는 dskit 설정에 dskit 의 기본값을 채운 Config 를 반환한다. dbolt 의 설정은 0 이 기본값이다.
+func DefaultConfig() *Config {
+	cfg := new(Config)
+	flagext.DefaultValues(&cfg.LifecyclerConfig, &cfg.MemberlistConfig)
+	// 인스턴스 하나를 재시작하는 동안 나머지 인스턴스까지 /readyz 가 실패하지 않도록 자기 자신의 상태만 확인한다.
+	cfg.LifecyclerConfig.ReadinessCheckRingHealth = false
+	// dbolt 의 Ring 은 항상 memberlist 에 있고, prefix 가 바뀌면 이전 버전의 인스턴스와 다른 Ring 을 보게 된다.
+	cfg.LifecyclerConfig.RingConfig.KVStore.Store = "memberlist"
+	cfg.LifecyclerConfig.RingConfig.KVStore.Prefix = ""
+	return cfg
+}
+
+// Path 는 설정 파일의 경로이다.
+func (l *ConfigLoader) Path() string {
+	return l.path
+}
+
+// Load 는 설정을 읽어서 검증한다.
+func (l *ConfigLoader) Load() (*Config, error) {
+	cfg := DefaultConfig()
+	file, err := os.ReadFile(l.path)
+	if err != nil {
+		return nil, err
+	}
+	file, err = expandEnv(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to expand the environment variables : path=%s", l.path)
+	}
+	if err := yaml.Unmarshal(file, cfg); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the config file : path=%s", l.path)
+	}
+	// Kubernetes 에서는 downward API 로 zone 을 넘길 수 있도록 환경 변수가 설정 파일보다 우선한다.
+	if zone := os.Getenv(AvailabilityZoneEnv); zone != "" {
+		cfg.LifecyclerConfig.Zone = zone
+	}
+
+	f, _ := newFlagSet(cfg)
+	var envErr error
+	f.VisitAll(func(fl *flag.Flag) {
+		value, ok := os.LookupEnv(EnvName(fl.Name))
+		if !ok || envErr != nil {
+			return
+		}
+		if err := fl.Value.Set(value); err != nil {
+			envErr = errors.Wrapf(err, "invalid %s", EnvName(fl.Name))
+		}
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+	if err := f.Parse(l.args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// expandEnv 는 ${VAR} 를 환경 변수의 값으로 바꾼다. 환경 변수가 없으면 ${VAR:-default} 의 default 를 쓰고, default 도 없으면 오류이다.
+func expandEnv(file []byte) ([]byte, error) {
+	var missing []string
+	expanded := envReference.ReplaceAllFunc(file, func(ref []byte) []byte {
+		match := envReference.FindSubmatch(ref)
+		if value, ok := os.LookupEnv(string(match[1])); ok {
+			return []byte(value)
+		}
+		if match[2] != nil {
+			return match[3]
+		}
+		missing = append(missing, string(match[1]))
+		return ref
+	})
+	if len(missing) > 0 {
+		return nil, errors.Errorf("environment variables not set : %v", missing)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,134 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.12
+// source: dboltpb/dbolt.proto
+
+package dboltpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// DBoltClient is the client API for DBolt service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DBoltClient interface {
+	// Watch 는 이 노드에 적용된 key 또는 prefix 의 변경을 start_revision 부터 전달한다.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (DBolt_WatchClient, error)
+}
+
+type dBoltClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDBoltClient(cc grpc.ClientConnInterface) DBoltClient {
+	return &dBoltClient{cc}
+}
+
+func (c *dBoltClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (DBolt_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DBolt_ServiceDesc.Streams[0], "/dbolt.DBolt/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dBoltWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DBolt_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type dBoltWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *dBoltWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DBoltServer is the server API for DBolt service.
+// All implementations must embed UnimplementedDBoltServer
+// for forward compatibility
+type DBoltServer interface {
+	// Watch 는 이 노드에 적용된 key 또는 prefix 의 변경을 start_revision 부터 전달한다.
+	Watch(*WatchRequest, DBolt_WatchServer) error
+	mustEmbedUnimplementedDBoltServer()
+}
+
+// UnimplementedDBoltServer must be embedded to have forward compatible implementations.
+type UnimplementedDBoltServer struct {
+}
+
+func (UnimplementedDBoltServer) Watch(*WatchRequest, DBolt_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedDBoltServer) mustEmbedUnimplementedDBoltServer() {}
+
+// UnsafeDBoltServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DBoltServer will
+// result in compilation errors.
+type UnsafeDBoltServer interface {
+	mustEmbedUnimplementedDBoltServer()
+}
+
+func RegisterDBoltServer(s grpc.ServiceRegistrar, srv DBoltServer) {
+	s.RegisterService(&DBolt_ServiceDesc, srv)
+}
+
+func _DBolt_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DBoltServer).Watch(m, &dBoltWatchServer{stream})
+}
+
+type DBolt_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type dBoltWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *dBoltWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// DBolt_ServiceDesc is the grpc.ServiceDesc for DBolt service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DBolt_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dbolt.DBolt",
+	HandlerType: (*DBoltServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _DBolt_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "dboltpb/dbolt.proto",
+}
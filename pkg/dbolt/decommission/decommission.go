@@ -0,0 +1,376 @@
+package decommission
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grafana/dskit/ring"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Phase 는 decommission 의 진행 단계이다.
+type Phase string
+
+const (
+	// PhaseActive 는 decommission 을 시작하지 않은 상태이다.
+	PhaseActive Phase = "active"
+	// PhaseLeaving 은 LEAVING 으로 바꾸고 다른 인스턴스들이 알게 될 때까지 기다리는 단계이다.
+	PhaseLeaving Phase = "leaving"
+	// PhaseTransferring 은 이 인스턴스의 값을 새 replica 들에 옮기는 단계이다.
+	PhaseTransferring Phase = "transferring"
+	// PhaseVerifying 은 옮긴 값을 새 replica 들에서 다시 읽어서 확인하는 단계이다.
+	PhaseVerifying Phase = "verifying"
+	// PhaseUnregistering 은 Ring 에서 이 인스턴스를 지우는 단계이다.
+	PhaseUnregistering Phase = "unregistering"
+	// PhaseDecommissioned 이면 Ring 에서 빠졌으므로 pod 를 지워도 된다.
+	PhaseDecommissioned Phase = "decommissioned"
+	// PhaseFailed 이면 LEAVING 상태로 남아 있으며 다시 시작하면 값을 옮기는 단계부터 이어서 한다.
+	PhaseFailed Phase = "failed"
+)
+
+var (
+	ErrDecommissionInProgress = errors.New("decommission in progress")
+	// ErrNotServing 은 decommission 을 시작해서 client 요청을 받지 않는다는 뜻이다.
+	ErrNotServing = errors.New("instance is decommissioning")
+)
+
+type Config struct {
+	// PropagationDelay 는 LEAVING 이 Ring 에 반영된 뒤 값을 옮기기 전에 기다리는 시간이다.
+	// 다른 인스턴스가 아직 이 인스턴스에 쓰고 있을 수 있으므로 memberlist 로 전파될 시간을 준다. 기본값은 10초이다.
+	PropagationDelay time.Duration `yaml:"propagation_delay"`
+	// Concurrency 는 동시에 옮기는 key 의 개수이다. 기본값은 16이다.
+	Concurrency int `yaml:"concurrency"`
+}
+
+func (c *Config) Validate() error {
+	if c.PropagationDelay < 0 {
+		return errors.New("decommission 'propagation_delay' must not be negative")
+	}
+	if c.Concurrency < 0 {
+		return errors.New("decommission 'concurrency' must not be negative")
+	}
+	return nil
+}
+
+func (c *Config) propagationDelay() time.Duration {
+	if c.PropagationDelay == 0 {
+		return 10 * time.Second
+	}
+	return c.PropagationDelay
+}
+
+func (c *Config) concurrency() int {
+	if c.Concurrency <= 0 {
+		return 16
+	}
+	return c.Concurrency
+}
+
+// batchSize 는 LocalStore 에서 한 번에 읽는 key 의 개수이다.
+const batchSize = 1000
+
+type Status struct {
+	Phase      Phase      `json:"phase"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// Buckets 와 Keys 는 지금까지 옮기거나 확인한 bucket 과 key 의 개수이다.
+	Buckets int `json:"buckets"`
+	Keys    int `json:"keys"`
+	// Copies 는 새 replica 에 기록한 값의 개수이다. 이미 최신 값을 가진 replica 에는 기록하지 않는다.
+	Copies int `json:"copies"`
+	// Unconfirmed 는 확인 단계에서 새 replica 에 아직 없는 key 의 개수이다.
+	Unconfirmed int    `json:"unconfirmed"`
+	Error       string `json:"error,omitempty"`
+}
+
+type metrics struct {
+	phase       *prometheus.GaugeVec
+	transferred prometheus.Counter
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	factory := promauto.With(reg)
+	return &metrics{
+		phase: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dbolt_decommission_phase",
+			Help: "Current decommission phase of this instance. The gauge of the current phase is 1.",
+		}, []string{"phase"}),
+		transferred: factory.NewCounter(prometheus.CounterOpts{
+			Name: "dbolt_decommission_transferred_values_total",
+			Help: "Total number of values written to new replicas while decommissioning.",
+		}),
+	}
+}
+
+// Decommissioner 는 이 인스턴스를 LEAVING 으로 바꾸고 값을 새 replica 들에 옮긴 뒤 Ring 에서 지운다.
+// decommission 을 시작하면 client 요청을 받지 않고 readiness probe 도 실패한다. 인스턴스 사이의 /v1/internal 요청은 계속 처리한다.
+type Decommissioner struct {
+	cfg        *Config
+	lifecycler *ring.Lifecycler
+	ring       ring.ReadRing
+	localStore *store.LocalStore
+	dist       *distributor.Distributor
+	metrics    *metrics
+	logger     *zap.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running bool
+	status  Status
+}
+
+func New(cfg *Config, lifecycler *ring.Lifecycler, r ring.ReadRing, localStore *store.LocalStore, dist *distributor.Distributor, reg prometheus.Registerer, logger *zap.Logger) *Decommissioner {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Decommissioner{
+		cfg:        cfg,
+		lifecycler: lifecycler,
+		ring:       r,
+		localStore: localStore,
+		dist:       dist,
+		metrics:    newMetrics(reg),
+		logger:     logger,
+		ctx:        ctx,
+		cancel:     cancel,
+		status:     Status{Phase: PhaseActive},
+	}
+	d.metrics.phase.WithLabelValues(string(PhaseActive)).Set(1)
+	return d
+}
+
+// Stop 은 진행 중인 decommission 을 멈춘다. 멈춘 decommission 은 failed 로 남는다.
+func (d *Decommissioner) Stop(ctx context.Context) error {
+	d.cancel()
+	d.wg.Wait()
+	return nil
+}
+
+// Start 는 decommission 을 백그라운드에서 시작하고 시작한 시점의 상태를 반환한다.
+// 진행 중이면 ErrDecommissionInProgress 를 반환하고, 이미 끝났으면 아무것도 하지 않는다.
+func (d *Decommissioner) Start() (Status, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.running {
+		return d.status, ErrDecommissionInProgress
+	}
+	if d.status.Phase == PhaseDecommissioned {
+		return d.status, nil
+	}
+	now := time.Now()
+	d.running = true
+	d.status = Status{Phase: PhaseLeaving, StartedAt: &now}
+	d.setPhaseMetric(PhaseLeaving)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.run(d.ctx)
+	}()
+	return d.status, nil
+}
+
+func (d *Decommissioner) Status() Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status
+}
+
+// Serving 은 client 요청을 처리해도 되는지이다. decommission 을 한 번 시작하면 실패하더라도 다시 받지 않는다.
+func (d *Decommissioner) Serving() bool {
+	return d.Status().Phase == PhaseActive
+}
+
+func (d *Decommissioner) run(ctx context.Context) {
+	d.logger.Info("Decommissioning the instance.", zap.String("id", d.lifecycler.ID))
+	err := d.decommission(ctx)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.running = false
+	d.status.FinishedAt = &now
+	if err != nil {
+		d.logger.Error("Failed to decommission the instance.", zap.String("phase", string(d.status.Phase)), zap.Error(err))
+		d.status.Phase = PhaseFailed
+		d.status.Error = err.Error()
+	} else {
+		d.logger.Info("Decommissioned the instance.", zap.Int("keys", d.status.Keys), zap.Int("copies", d.status.Copies))
+		d.status.Phase = PhaseDecommissioned
+	}
+	d.setPhaseMetric(d.status.Phase)
+}
+
+func (d *Decommissioner) decommission(ctx context.Context) error {
+	if err := d.leave(ctx); err != nil {
+		return err
+	}
+
+	d.setPhase(PhaseTransferring)
+	err := d.forEach(ctx, func(ctx context.Context, bucketName, key, value []byte) error {
+		copies, err := d.dist.Handoff(ctx, bucketName, key, value)
+		if err != nil {
+			return err
+		}
+		d.metrics.transferred.Add(float64(copies))
+		d.update(func(status *Status) { status.Copies += copies })
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to transfer the values")
+	}
+
+	// 옮기는 동안 새로 기록된 값이나 다시 바뀐 Ring 때문에 빠진 replica 가 없는지 처음부터 다시 확인한다.
+	d.setPhase(PhaseVerifying)
+	err = d.forEach(ctx, func(ctx context.Context, bucketName, key, value []byte) error {
+		outdated, err := d.dist.VerifyHandoff(ctx, bucketName, key, value)
+		if err != nil {
+			return err
+		}
+		if outdated > 0 {
+			d.logger.Warn("Value is not transferred yet.", zap.ByteString("bucket", bucketName), zap.ByteString("key", key), zap.Int("replicas", outdated))
+			d.update(func(status *Status) { status.Unconfirmed++ })
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to verify the transferred values")
+	}
+	if unconfirmed := d.Status().Unconfirmed; unconfirmed > 0 {
+		return errors.Errorf("%d keys are not confirmed on the new replicas", unconfirmed)
+	}
+
+	d.setPhase(PhaseUnregistering)
+	d.lifecycler.SetUnregisterOnShutdown(true)
+	d.lifecycler.StopAsync()
+	if err := d.lifecycler.AwaitTerminated(ctx); err != nil {
+		return errors.Wrap(err, "failed to unregister from the ring")
+	}
+	return nil
+}
+
+// leave 는 Lifecycler 를 LEAVING 으로 바꾸고 Ring 에 반영된 뒤 PropagationDelay 만큼 기다린다.
+func (d *Decommissioner) leave(ctx context.Context) error {
+	switch state := d.lifecycler.GetState(); state {
+	case ring.ACTIVE:
+		if err := d.lifecycler.ChangeState(ctx, ring.LEAVING); err != nil {
+			return errors.Wrap(err, "failed to change the state to LEAVING")
+		}
+	case ring.LEAVING:
+	default:
+		return errors.Errorf("instance is not ACTIVE : state=%s", state)
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		state, err := d.ring.GetInstanceState(d.lifecycler.ID)
+		if err == nil && state == ring.LEAVING {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	d.logger.Info("Instance is LEAVING. Waiting for the other instances to notice.", zap.Duration("delay", d.cfg.propagationDelay()))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d.cfg.propagationDelay()):
+	}
+	return nil
+}
+
+// forEach 는 LocalStore 의 모든 key 에 대해 fn 을 Concurrency 만큼 동시에 호출한다.
+func (d *Decommissioner) forEach(ctx context.Context, fn func(ctx context.Context, bucketName, key, value []byte) error) error {
+	d.update(func(status *Status) {
+		status.Buckets = 0
+		status.Keys = 0
+	})
+	bucketNames, err := d.localStore.Buckets(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list the buckets")
+	}
+	for _, bucketName := range bucketNames {
+		var after []byte
+		for {
+			kvs, err := d.localStore.Scan(ctx, bucketName, nil, after, batchSize)
+			if err != nil {
+				return errors.Wrapf(err, "failed to scan the bucket : bucketName=%s", string(bucketName))
+			}
+			if err := d.forBatch(ctx, bucketName, kvs, fn); err != nil {
+				return err
+			}
+			d.update(func(status *Status) { status.Keys += len(kvs) })
+			if len(kvs) < batchSize {
+				break
+			}
+			after = kvs[len(kvs)-1].Key
+		}
+		d.update(func(status *Status) { status.Buckets++ })
+	}
+	return nil
+}
+
+func (d *Decommissioner) forBatch(ctx context.Context, bucketName []byte, kvs []*store.KeyValue, fn func(ctx context.Context, bucketName, key, value []byte) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, d.cfg.concurrency())
+	for _, kv := range kvs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(kv *store.KeyValue) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(ctx, bucketName, kv.Key, kv.Value); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(kv)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func (d *Decommissioner) setPhase(phase Phase) {
+	d.logger.Info("Decommission phase changed.", zap.String("phase", string(phase)))
+	d.update(func(status *Status) { status.Phase = phase })
+	d.setPhaseMetric(phase)
+}
+
+func (d *Decommissioner) setPhaseMetric(phase Phase) {
+	d.metrics.phase.Reset()
+	d.metrics.phase.WithLabelValues(string(phase)).Set(1)
+}
+
+func (d *Decommissioner) update(fn func(status *Status)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn(&d.status)
+}
@@ -0,0 +1,241 @@
+package distributor
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/dskit/ring"
+	"github.com/pkg/errors"
+)
+
+// deleteBucketBatchSize 는 DeleteBucket 이 한 번에 읽어서 지우는 key 의 개수이다.
+const deleteBucketBatchSize = 1000
+
+// BucketLister 는 bucket 이름을 읽을 수 있는 Store 이다.
+type BucketLister interface {
+	Buckets(ctx context.Context) ([][]byte, error)
+}
+
+// Buckets 는 Ring 의 모든 정상 인스턴스에 있는 저장소의 bucket 이름을 정렬해서 반환한다. chunk 를 저장하는 bucket 은 제외한다.
+func (d *Distributor) Buckets(ctx context.Context) ([][]byte, error) {
+	replicationSet, err := d.readRing.GetAllHealthy(ring.Read)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the healthy instances")
+	}
+	var mu sync.Mutex
+	seen := make(map[string]struct{})
+	_, err = replicationSet.Do(ctx, 0, func(ctx context.Context, instance *ring.InstanceDesc) (interface{}, error) {
+		lister, ok := d.storePool.Get(instance.Addr).(BucketLister)
+		if !ok {
+			return nil, errors.Errorf("store does not support listing buckets : addr=%s", instance.Addr)
+		}
+		bucketNames, err := lister.Buckets(ctx)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, bucketName := range bucketNames {
+			if !IsChunkBucket(bucketName) {
+				seen[string(bucketName)] = struct{}{}
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list the buckets")
+	}
+
+	bucketNames := make([][]byte, 0, len(seen))
+	for bucketName := range seen {
+		bucketNames = append(bucketNames, []byte(bucketName))
+	}
+	sort.Slice(bucketNames, func(i, j int) bool {
+		return bytes.Compare(bucketNames[i], bucketNames[j]) < 0
+	})
+	return bucketNames, nil
+}
+
+// DeleteBucket 은 bucket 의 모든 key 를 Delete 로 지우고 지운 key 의 개수를 반환한다.
+// 지우는 동안 기록된 key 는 남을 수 있다. 만료된 key 도 지우지만 개수에는 넣지 않는다.
+// 마지막으로 bucket 의 chunk 를 모두 지워서 Manifest 를 잃은 chunk 도 남지 않게 한다.
+func (d *Distributor) DeleteBucket(ctx context.Context, bucketName []byte) (int, error) {
+	if err := CheckBucket(bucketName); err != nil {
+		return 0, err
+	}
+	deleted := 0
+	var after []byte
+	for {
+		now := time.Now()
+		entries, more, err := d.scan(ctx, bucketName, nil, after, deleteBucketBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+		for _, entry := range entries {
+			if err := d.Delete(ctx, bucketName, entry.Key); err != nil {
+				return deleted, err
+			}
+			if !entry.expired(now) {
+				deleted++
+			}
+		}
+		if !more || len(entries) == 0 {
+			break
+		}
+		after = entries[len(entries)-1].Key
+	}
+	return deleted, d.deleteAll(ctx, ChunkBucket(bucketName))
+}
+
+// deleteAll 은 bucket 의 모든 key 를 지운다.
+func (d *Distributor) deleteAll(ctx context.Context, bucketName []byte) error {
+	var after []byte
+	for {
+		entries, more, err := d.scan(ctx, bucketName, nil, after, deleteBucketBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := d.delete(ctx, bucketName, entry.Key); err != nil {
+				return err
+			}
+		}
+		if !more || len(entries) == 0 {
+			return nil
+		}
+		after = entries[len(entries)-1].Key
+	}
+}
+
+// ReplicaVersion 은 한 인스턴스에 있는 key 의 version 이다. Version 이 0 이면 key 가 없다.
+type ReplicaVersion struct {
+	Addr    string `json:"addr"`
+	Version uint64 `json:"version"`
+	// Owner 가 false 이면 Ring 이 바뀌기 전에 기록되어 replica 가 아닌 인스턴스에 남은 값이다.
+	Owner bool `json:"owner"`
+}
+
+type KeyConsistency struct {
+	Key      string            `json:"key"`
+	Latest   uint64            `json:"latest"`
+	Replicas []*ReplicaVersion `json:"replicas"`
+}
+
+type ConsistencyReport struct {
+	// Checked 는 확인한 key 의 개수이다.
+	Checked int `json:"checked"`
+	// Inconsistent 는 replica 중 하나라도 key 가 없거나 최신 version 이 아닌 key 들이다.
+	Inconsistent []*KeyConsistency `json:"inconsistent"`
+	More         bool              `json:"more"`
+	Next         string            `json:"next,omitempty"`
+}
+
+// CheckConsistency 는 bucket 에서 prefix 로 시작하고 after 보다 큰 key 를 최대 limit 개 골라 replica 들의 version 을 비교한다.
+// bucket 의 현재 shard 에 있는 모든 정상 인스턴스를 직접 읽으므로 Scan 처럼 최신 값을 고르지 않고 인스턴스별 차이를 그대로 보고한다.
+func (d *Distributor) CheckConsistency(ctx context.Context, bucketName, prefix, after []byte, limit int) (*ConsistencyReport, error) {
+	r, err := d.WriteRing(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	replicationSet, err := r.GetAllHealthy(ring.Read)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the healthy instances")
+	}
+
+	var mu sync.Mutex
+	// versions 는 key 별로 인스턴스 주소와 version 이다.
+	versions := make(map[string]map[string]uint64)
+	more := false
+	_, err = replicationSet.Do(ctx, 0, func(ctx context.Context, instance *ring.InstanceDesc) (interface{}, error) {
+		scanner, ok := d.storePool.Get(instance.Addr).(Scanner)
+		if !ok {
+			return nil, errors.Errorf("store does not support scan : addr=%s", instance.Addr)
+		}
+		kvs, err := scanner.Scan(ctx, bucketName, prefix, after, limit)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if len(kvs) == limit {
+			more = true
+		}
+		for _, kv := range kvs {
+			versionedValue, err := unmarshalVersionedValue(kv.Value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid stored value : addr=%s key=%s", instance.Addr, string(kv.Key))
+			}
+			if versions[string(kv.Key)] == nil {
+				versions[string(kv.Key)] = make(map[string]uint64)
+			}
+			versions[string(kv.Key)][instance.Addr] = versionedValue.Version()
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to scan the replicas")
+	}
+
+	keys := make([]string, 0, len(versions))
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// 각 인스턴스는 limit 개까지만 반환하므로 모든 인스턴스의 결과가 모인 앞쪽 limit 개만 비교한다.
+	if len(keys) > limit {
+		keys = keys[:limit]
+		more = true
+	}
+
+	report := &ConsistencyReport{Checked: len(keys), Inconsistent: []*KeyConsistency{}, More: more}
+	for _, key := range keys {
+		owners, err := r.Get(Token(bucketName, []byte(key)), ring.Read, nil, nil, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find the replicas : key=%s", key)
+		}
+		if result := compareReplicas(key, owners.GetAddresses(), versions[key]); result != nil {
+			report.Inconsistent = append(report.Inconsistent, result)
+		}
+	}
+	if more && len(keys) > 0 {
+		report.Next = keys[len(keys)-1]
+	}
+	return report, nil
+}
+
+// compareReplicas 는 모든 replica 가 최신 version 을 가지고 있으면 nil 을 반환한다.
+func compareReplicas(key string, owners []string, versions map[string]uint64) *KeyConsistency {
+	result := &KeyConsistency{Key: key}
+	for _, version := range versions {
+		if version > result.Latest {
+			result.Latest = version
+		}
+	}
+	consistent := true
+	isOwner := make(map[string]bool, len(owners))
+	for _, addr := range owners {
+		isOwner[addr] = true
+		version := versions[addr]
+		if version != result.Latest {
+			consistent = false
+		}
+		result.Replicas = append(result.Replicas, &ReplicaVersion{Addr: addr, Version: version, Owner: true})
+	}
+	var others []*ReplicaVersion
+	for addr, version := range versions {
+		if !isOwner[addr] {
+			others = append(others, &ReplicaVersion{Addr: addr, Version: version})
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].Addr < others[j].Addr
+	})
+	result.Replicas = append(result.Replicas, others...)
+	if consistent {
+		return nil
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package distributor
+
+import (
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Codec 은 VersionedValue 의 값을 압축하는 방식이며 binary envelope 헤더에 1 byte 로 기록된다.
+type Codec byte
+
+const (
+	CodecNone Codec = iota
+	CodecSnappy
+	CodecZstd
+)
+
+var codecNames = map[string]Codec{
+	"":       CodecNone,
+	"none":   CodecNone,
+	"snappy": CodecSnappy,
+	"zstd":   CodecZstd,
+}
+
+func ParseCodec(name string) (Codec, error) {
+	codec, ok := codecNames[name]
+	if !ok {
+		return CodecNone, errors.Errorf("unknown compression codec : %s", name)
+	}
+	return codec, nil
+}
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecSnappy:
+		return "snappy"
+	case CodecZstd:
+		return "zstd"
+	}
+	return "unknown"
+}
+
+// zstd encoder/decoder 는 생성 비용이 크므로 공유한다. EncodeAll/DecodeAll 은 동시에 호출해도 안전하다.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func compress(codec Codec, value []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return value, nil
+	case CodecSnappy:
+		return snappy.Encode(nil, value), nil
+	case CodecZstd:
+		return zstdEncoder.EncodeAll(value, nil), nil
+	}
+	return nil, errors.Errorf("unknown compression codec : %d", codec)
+}
+
+func decompress(codec Codec, value []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return value, nil
+	case CodecSnappy:
+		decoded, err := snappy.Decode(nil, value)
+		return decoded, errors.Wrap(err, "failed to decode snappy")
+	case CodecZstd:
+		decoded, err := zstdDecoder.DecodeAll(value, nil)
+		return decoded, errors.Wrap(err, "failed to decode zstd")
+	}
+	return nil, errors.Errorf("unknown compression codec : %d", codec)
+}
+
+type CompressionConfig struct {
+	// Default 는 Buckets 에 없는 bucket 에 사용하는 codec 이다. (none, snappy, zstd)
+	Default string `yaml:"default"`
+	// Buckets 는 bucket 이름별 codec 이다.
+	Buckets map[string]string `yaml:"buckets"`
+	// MinSize 보다 작은 값은 압축하지 않는다.
+	MinSize int `yaml:"min_size"`
+}
+
+func (cc *CompressionConfig) Validate() error {
+	if _, err := ParseCodec(cc.Default); err != nil {
+		return errors.Wrap(err, "invalid 'compression.default'")
+	}
+	for bucket, name := range cc.Buckets {
+		if _, err := ParseCodec(name); err != nil {
+			return errors.Wrapf(err, "invalid 'compression.buckets' of bucket %s", bucket)
+		}
+	}
+	return nil
+}
+
+func (cc *CompressionConfig) codecFor(bucketName []byte, value []byte) Codec {
+	if len(value) < cc.MinSize {
+		return CodecNone
+	}
+	name, ok := cc.Buckets[string(bucketName)]
+	if !ok {
+		name = cc.Default
+	}
+	// Validate 에서 이미 확인했으므로 에러는 무시한다.
+	codec, _ := ParseCodec(name)
+	return codec
+}
@@ -2,6 +2,12 @@ package distributor
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -16,21 +22,76 @@ const (
 
 var ErrKeyValueNotFound = errors.New("key-value not found")
 
+type Config struct {
+	Compression     CompressionConfig     `yaml:"compression"`
+	ShuffleSharding ShuffleShardingConfig `yaml:"shuffle_sharding"`
+	ZoneAwareness   ZoneAwarenessConfig   `yaml:"zone_awareness"`
+	// MaxKeySize, MaxValueSize 는 쓰는 key 와 값의 최대 byte 수이다. 0 이면 제한하지 않는다.
+	MaxKeySize   int                `yaml:"max_key_size"`
+	MaxValueSize int64              `yaml:"max_value_size"`
+	LargeObjects LargeObjectsConfig `yaml:"large_objects"`
+}
+
+func (c *Config) Validate() error {
+	if err := c.Compression.Validate(); err != nil {
+		return err
+	}
+	if c.MaxKeySize < 0 {
+		return errors.New("distributor 'max_key_size' must not be negative")
+	}
+	if c.MaxValueSize < 0 {
+		return errors.New("distributor 'max_value_size' must not be negative")
+	}
+	if err := c.LargeObjects.Validate(c.MaxValueSize); err != nil {
+		return err
+	}
+	return c.ShuffleSharding.Validate()
+}
+
 type Distributor struct {
+	cfg       *Config
 	readRing  ring.ReadRing
 	storePool *SimpleStorePool
-	logger    *zap.Logger
+	sharding  *shuffleSharding
+	keyLocks  keyLocks
+	// inflight 는 replica 에 요청하고 있는 get, put, delete, scan 의 수이다.
+	inflight atomic.Int64
+	// zone 은 이 노드의 availability zone 이다.
+	zone   string
+	logger *zap.Logger
 }
 
-func New(ring ring.ReadRing, storePool *SimpleStorePool, logger *zap.Logger) *Distributor {
+// New 는 Distributor 를 만든다. limits 가 nil 이면 모든 bucket 을 전체 Ring 에 배치한다.
+func New(cfg *Config, ring ring.ReadRing, storePool *SimpleStorePool, limits ShardingLimits, metadata MetadataStore, zone string, logger *zap.Logger) *Distributor {
 	return &Distributor{
+		cfg:       cfg,
 		readRing:  ring,
 		storePool: storePool,
+		zone:      zone,
+		sharding:  newShuffleSharding(&cfg.ShuffleSharding, limits, metadata),
 		logger:    logger,
 	}
 }
 
+// InFlight 는 replica 에 요청하고 있는 get, put, delete, scan 의 수이다. load shedding 에 쓴다.
+func (d *Distributor) InFlight() int64 {
+	return d.inflight.Load()
+}
+
+// track 은 진행 중인 요청 수를 늘리고 요청이 끝나면 호출할 함수를 반환한다.
+func (d *Distributor) track() func() {
+	d.inflight.Add(1)
+	return func() {
+		d.inflight.Add(-1)
+	}
+}
+
 func (d *Distributor) tokenFromBytes(bytesArr ...[]byte) uint32 {
+	return Token(bytesArr...)
+}
+
+// Token 은 저장소의 bucket 이름과 key 로 Ring 의 token 을 계산한다. client 가 replica 를 찾을 때도 사용한다.
+func Token(bytesArr ...[]byte) uint32 {
 	var token uint32 = 0
 	for _, bytes := range bytesArr {
 		for _, b := range bytes {
@@ -40,46 +101,214 @@ func (d *Distributor) tokenFromBytes(bytesArr ...[]byte) uint32 {
 	return token
 }
 
+// InstanceURL 은 Ring 에 등록된 인스턴스 주소(host:port)의 host 로 API 주소를 만든다.
+func InstanceURL(scheme, addr string, port uint16) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(int(port))))
+}
+
+// WriteRing 은 bucket 의 값을 쓰는 Ring 이다. shuffle sharding 이 켜져 있으면 tenant 의 현재 shard 이다.
+func (d *Distributor) WriteRing(ctx context.Context, bucketName []byte) (ring.ReadRing, error) {
+	rings, err := d.sharding.rings(ctx, d.readRing, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return rings[0], nil
+}
+
+// Get 은 replica 들 중 가장 최근에 기록된 값을 반환한다. 가장 최근 값이 만료되었으면 ErrKeyValueNotFound 를 반환한다.
+// shard 크기가 바뀐 뒤 lookback 기간에는 이전 shard 에서도 읽고, 이전 shard 에만 있던 최신 값은 현재 shard 에 다시 기록한다.
+// chunk 로 나눠서 저장한 값은 chunk 를 모두 읽어서 합친다. local_reads 가 켜져 있으면 GetVersionedLocal 처럼 읽는다.
 func (d *Distributor) Get(ctx context.Context, bucketName, key []byte) ([]byte, error) {
+	versionedValue, err := d.GetVersionedLocal(ctx, bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+	return versionedValue.Value, nil
+}
+
+// getLatest 는 만료되지 않은 가장 최근 값을 읽는다. local 이면 같은 zone 의 replica 에서 먼저 읽을 수 있다.
+func (d *Distributor) getLatest(ctx context.Context, bucketName, key []byte, local bool) (*VersionedValue, error) {
+	versionedValue, err := d.getLatestIncludingExpired(ctx, bucketName, key, local)
+	if err != nil {
+		return nil, err
+	}
+	if versionedValue.Expired(time.Now()) {
+		return nil, ErrKeyValueNotFound
+	}
+	return versionedValue, nil
+}
+
+func (d *Distributor) getLatestIncludingExpired(ctx context.Context, bucketName, key []byte, local bool) (*VersionedValue, error) {
+	rings, err := d.sharding.rings(ctx, d.readRing, bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastUpdated *storedValue
+	inCurrentShard := false
+	for i, r := range rings {
+		storedValues, err := d.get(ctx, r, bucketName, key, local)
+		if err != nil {
+			return nil, err
+		}
+		for _, stored := range storedValues {
+			if lastUpdated == nil || lastUpdated.UpdatedAt.Before(stored.UpdatedAt) {
+				lastUpdated = stored
+				inCurrentShard = i == 0
+			}
+		}
+	}
+	if lastUpdated == nil {
+		return nil, ErrKeyValueNotFound
+	}
+	if !inCurrentShard && !lastUpdated.Expired(time.Now()) {
+		if err := d.put(ctx, rings[0], bucketName, key, lastUpdated.raw); err != nil {
+			d.logger.Warn("Failed to move the value to the current shard.", zap.String("key", string(key)), zap.Error(err))
+		}
+	}
+	return lastUpdated.VersionedValue, nil
+}
+
+// storedValue 는 replica 에 저장된 값과 그 값을 해석한 결과이다.
+type storedValue struct {
+	*VersionedValue
+	raw []byte
+}
+
+// get 은 quorum 으로 읽는다. local 이고 local_reads 가 켜져 있으면 같은 zone 의 replica 에서 먼저 읽는다.
+func (d *Distributor) get(ctx context.Context, r ring.ReadRing, bucketName, key []byte, local bool) ([]*storedValue, error) {
+	defer d.track()()
+	if local && d.cfg.ZoneAwareness.LocalReads && d.zone != "" {
+		if storedValues, ok := d.getLocalZone(ctx, r, bucketName, key); ok {
+			return storedValues, nil
+		}
+	}
 	token := []uint32{d.tokenFromBytes(bucketName, key)}
-	var versionedValues []*VersionedValue
+	var mu sync.Mutex
+	var storedValues []*storedValue
 
-	if err := ring.DoBatch(ctx, ring.Read, d.readRing, token, func(id ring.InstanceDesc, _ []int) error {
+	if err := ring.DoBatch(ctx, ring.Read, r, token, func(id ring.InstanceDesc, _ []int) error {
 		d.logger.Debug("Do batch on Ring for Get.", zap.String("instanceAddr", id.Addr))
 		store := d.storePool.Get(id.Addr)
 		value, err := store.Get(ctx, bucketName, key)
+		if err != nil {
+			return err
+		}
+		if len(value) == 0 {
+			return nil
+		}
 
 		versionedValue, err := unmarshalVersionedValue(value)
 		if err != nil {
 			return err
 		}
-		versionedValues = append(versionedValues, versionedValue)
+		mu.Lock()
+		defer mu.Unlock()
+		storedValues = append(storedValues, &storedValue{VersionedValue: versionedValue, raw: value})
 		return nil
 	}, doNothing); err != nil {
 		return nil, errors.Wrapf(err, "failed to get value by key: key=%s", string(key))
 	}
-	if len(versionedValues) == 0 {
-		return nil, ErrKeyValueNotFound
+	mu.Lock()
+	defer mu.Unlock()
+	return storedValues, nil
+}
+
+func (d *Distributor) Put(ctx context.Context, bucketName, key, value []byte) error {
+	_, err := d.PutIf(ctx, bucketName, key, value, nil)
+	return err
+}
+
+// Restore 는 스냅샷에 저장되어 있던 버전 정보가 포함된 값을 현재 Ring 의 replica 들에 다시 기록한다.
+// Handoff 처럼 같거나 더 최신 version 을 가진 replica 는 덮어쓰지 않으므로 스냅샷 이후의 쓰기는 유지된다.
+// tombstone 이 없으므로 스냅샷 이후에 지운 key 는 다시 기록된다.
+func (d *Distributor) Restore(ctx context.Context, bucketName, key, storedValue []byte) error {
+	restored, err := unmarshalVersionedValue(storedValue)
+	if err != nil {
+		return errors.Wrapf(err, "invalid stored value : key=%s", string(key))
 	}
+	rings, err := d.sharding.rings(ctx, d.readRing, bucketName)
+	if err != nil {
+		return err
+	}
+	defer d.track()()
+	token := []uint32{d.tokenFromBytes(bucketName, key)}
 
-	var lastUpdated *VersionedValue
-	for _, versioned := range versionedValues {
-		if lastUpdated == nil || lastUpdated.UpdatedAt.Before(versioned.UpdatedAt) {
-			lastUpdated = versioned
+	if err := ring.DoBatch(ctx, putOp, rings[0], token, func(id ring.InstanceDesc, _ []int) error {
+		store := d.storePool.Get(id.Addr)
+		current, err := store.Get(ctx, bucketName, key)
+		if err != nil {
+			return err
 		}
+		if len(current) > 0 {
+			currentValue, err := unmarshalVersionedValue(current)
+			if err != nil {
+				return errors.Wrapf(err, "invalid stored value : addr=%s key=%s", id.Addr, string(key))
+			}
+			if currentValue.Version() >= restored.Version() {
+				return nil
+			}
+		}
+		return store.Put(ctx, bucketName, key, storedValue)
+	}, doNothing); err != nil {
+		return errors.Wrap(err, "failed to restore key-value : key="+string(key))
 	}
-	return lastUpdated.Value, nil
+	return nil
 }
 
-func (d *Distributor) Put(ctx context.Context, bucketName, key, value []byte) error {
+// Delete 는 key 를 가진 모든 replica 에서 key 를 지운다.
+// tombstone 을 남기지 않으므로 삭제가 적용되지 않은 replica 가 있으면 Get 에서 다시 보일 수 있다.
+// lookback 기간에는 이전 shard 에서도 지워서 Get 이 이전 shard 의 값을 되살리지 않게 한다.
+// chunk 로 나눠서 저장한 값이면 key 를 지운 뒤 chunk 도 지운다.
+func (d *Distributor) Delete(ctx context.Context, bucketName, key []byte) error {
+	if err := CheckBucket(bucketName); err != nil {
+		return err
+	}
+	replaced := d.replacedManifest(ctx, bucketName, key)
+	if err := d.delete(ctx, bucketName, key); err != nil {
+		return err
+	}
+	d.deleteChunks(ctx, bucketName, key, replaced)
+	return nil
+}
+
+func (d *Distributor) delete(ctx context.Context, bucketName, key []byte) error {
+	defer d.track()()
+	rings, err := d.sharding.rings(ctx, d.readRing, bucketName)
+	if err != nil {
+		return err
+	}
 	token := []uint32{d.tokenFromBytes(bucketName, key)}
-	versionedValue := newVersionedValueNow(value)
-	marshaledVersionedValue, err := marshalVersionedValue(versionedValue)
+
+	for _, r := range rings {
+		if err := ring.DoBatch(ctx, deleteOp, r, token, func(id ring.InstanceDesc, _ []int) error {
+			d.logger.Debug("Do batch on Ring for Delete.", zap.String("instanceAddr", id.Addr))
+			store := d.storePool.Get(id.Addr)
+			return store.Delete(ctx, bucketName, key)
+		}, doNothing); err != nil {
+			return errors.Wrap(err, "failed to delete key : key="+string(key))
+		}
+	}
+	return nil
+}
+
+func (d *Distributor) putCurrentShard(ctx context.Context, bucketName, key, marshaledVersionedValue []byte) error {
+	rings, err := d.sharding.rings(ctx, d.readRing, bucketName)
 	if err != nil {
 		return err
 	}
+	return d.put(ctx, rings[0], bucketName, key, marshaledVersionedValue)
+}
+
+func (d *Distributor) put(ctx context.Context, r ring.ReadRing, bucketName, key, marshaledVersionedValue []byte) error {
+	defer d.track()()
+	token := []uint32{d.tokenFromBytes(bucketName, key)}
 
-	if err := ring.DoBatch(ctx, ring.WriteNoExtend, d.readRing, token, func(id ring.InstanceDesc, _ []int) error {
+	if err := ring.DoBatch(ctx, putOp, r, token, func(id ring.InstanceDesc, _ []int) error {
 		d.logger.Debug("Do batch on Ring for Put.", zap.String("instanceAddr", id.Addr))
 		store := d.storePool.Get(id.Addr)
 		return store.Put(ctx, bucketName, key, marshaledVersionedValue)
@@ -0,0 +1,52 @@
+package distributor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreKeepsNewerValues(t *testing.T) {
+	cfg := &Config{}
+	zones := []string{"zone-a", "zone-b", "zone-c"}
+	c := newZoneCluster(t, cfg, zones, 1, 3, "")
+	d := c.distributor(cfg, "zone-a")
+
+	ctx := context.Background()
+	bucketName, key := []byte("bucket"), []byte("key")
+	require.NoError(t, d.Put(ctx, bucketName, key, []byte("v1")))
+	c.waitReplicas(t, bucketName, key, map[string]int{"zone-a": 1, "zone-b": 1, "zone-c": 1})
+	local := c.localReplica(t, bucketName, key, "zone-a")
+	snapshot, err := local.Get(ctx, bucketName, key)
+	require.NoError(t, err)
+
+	require.NoError(t, d.Put(ctx, bucketName, key, []byte("v2")))
+	require.Eventually(t, func() bool {
+		value, err := local.Get(ctx, bucketName, key)
+		return err == nil && string(value) != string(snapshot)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// 스냅샷보다 최신인 값은 덮어쓰지 않는다.
+	require.NoError(t, d.Restore(ctx, bucketName, key, snapshot))
+	for _, store := range c.stores {
+		stored, err := store.Get(ctx, bucketName, key)
+		require.NoError(t, err)
+		require.NotEqual(t, snapshot, stored)
+	}
+	value, err := d.Get(ctx, bucketName, key)
+	require.NoError(t, err)
+	require.Equal(t, []byte("v2"), value)
+
+	// 값이 없는 replica 에는 스냅샷의 값을 기록한다.
+	require.NoError(t, d.Delete(ctx, bucketName, key))
+	c.waitReplicas(t, bucketName, key, map[string]int{})
+	require.NoError(t, d.Restore(ctx, bucketName, key, snapshot))
+	c.waitReplicas(t, bucketName, key, map[string]int{"zone-a": 1, "zone-b": 1, "zone-c": 1})
+	value, err = d.Get(ctx, bucketName, key)
+	require.NoError(t, err)
+	require.Equal(t, []byte("v1"), value)
+
+	require.Error(t, d.Restore(ctx, bucketName, key, []byte("not a versioned value")))
+}
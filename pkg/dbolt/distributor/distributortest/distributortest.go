@@ -0,0 +1,59 @@
+// Package distributortest 는 다른 패키지의 테스트에서 사용할 Distributor 를 만든다.
+package distributortest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/kv/consul"
+	"github.com/grafana/dskit/ring"
+	"github.com/grafana/dskit/services"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// Addr 는 인스턴스의 주소이다. 실제로 listen 하지 않고 StorePool 에서 LocalStore 를 찾는 데만 쓴다.
+const Addr = "127.0.0.1:7946"
+
+// Cluster 는 memory 엔진의 LocalStore 를 가진 인스턴스 하나로 된 클러스터이다.
+type Cluster struct {
+	Ring        *ring.Ring
+	StorePool   *distributor.SimpleStorePool
+	LocalStore  *store.LocalStore
+	Distributor *distributor.Distributor
+}
+
+// New 는 cfg 로 Cluster 를 만든다. 테스트가 끝나면 Ring 을 멈춘다.
+func New(t testing.TB, cfg *distributor.Config) *Cluster {
+	t.Helper()
+	localStore, err := store.Open(&store.ChangeLogConfig{}, store.EngineMemory, "", 0o600, nil, store.NewEncryptor(nil), nil, zap.NewNop())
+	require.NoError(t, err)
+	storePool := distributor.NewSimpleStorePool()
+	storePool.Register(Addr, localStore)
+
+	kvClient, closer := consul.NewInMemoryClient(ring.GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { closer.Close() })
+	desc := ring.NewDesc()
+	desc.AddIngester("instance-0", Addr, "", []uint32{1, 1 << 30, 2 << 30, 3 << 30}, ring.ACTIVE, time.Now())
+	require.NoError(t, kvClient.CAS(context.Background(), distributor.RingKey, func(interface{}) (interface{}, bool, error) {
+		return desc, true, nil
+	}))
+
+	ringCfg := ring.Config{HeartbeatTimeout: time.Hour, ReplicationFactor: 1}
+	r, err := ring.NewWithStoreClientAndStrategy(ringCfg, distributor.RingName, distributor.RingKey, kvClient, distributor.NewReplicationStrategy(cfg), prometheus.NewRegistry(), log.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), r))
+	t.Cleanup(func() { _ = services.StopAndAwaitTerminated(context.Background(), r) })
+
+	return &Cluster{
+		Ring:        r,
+		StorePool:   storePool,
+		LocalStore:  localStore,
+		Distributor: distributor.New(cfg, r, storePool, nil, nil, "", zap.NewNop()),
+	}
+}
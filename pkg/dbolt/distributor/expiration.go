@@ -0,0 +1,39 @@
+package distributor
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Expire 는 key 의 만료 시각을 바꾸고 key 가 있었는지 반환한다. expiresAt 이 0 이면 만료되지 않게 한다.
+// 값은 그대로 두고 새 version 으로 다시 기록하며, expiresAt 이 이미 지났으면 key 를 지운다.
+func (d *Distributor) Expire(ctx context.Context, bucketName, key []byte, expiresAt time.Time) (bool, error) {
+	if err := CheckBucket(bucketName); err != nil {
+		return false, err
+	}
+	defer d.keyLocks.lock(bucketName, key)()
+	current, err := d.getLatest(ctx, bucketName, key, false)
+	if errors.Is(err, ErrKeyValueNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !expiresAt.IsZero() && !time.Now().Before(expiresAt) {
+		return true, d.Delete(ctx, bucketName, key)
+	}
+
+	versionedValue := newVersionedValueNow(current.Value)
+	versionedValue.CreatedAt = current.CreatedAt
+	versionedValue.ExpiresAt = expiresAt
+	versionedValue.Flags = current.Flags
+	versionedValue.Manifest = current.Manifest
+	codec := d.cfg.Compression.codecFor(bucketName, current.Value)
+	marshaledVersionedValue, err := marshalVersionedValue(versionedValue, codec)
+	if err != nil {
+		return false, err
+	}
+	return true, d.putCurrentShard(ctx, bucketName, key, marshaledVersionedValue)
+}
@@ -0,0 +1,88 @@
+package distributor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grafana/dskit/ring"
+	"github.com/pkg/errors"
+)
+
+var (
+	// putOp 은 LEAVING 인스턴스 대신 그 다음 인스턴스에 기록한다. decommission 중에 기록된 값이 새 replica 에도 남는다.
+	putOp = ring.NewOp([]ring.InstanceState{ring.ACTIVE}, func(s ring.InstanceState) bool {
+		return s == ring.LEAVING
+	})
+	// deleteOp 는 LEAVING 인스턴스와 그 다음 인스턴스 모두에서 지운다. 옮기는 중인 값이 새 replica 에서 되살아나지 않는다.
+	deleteOp = ring.NewOp([]ring.InstanceState{ring.ACTIVE, ring.LEAVING}, func(s ring.InstanceState) bool {
+		return s == ring.LEAVING
+	})
+)
+
+// Handoff 는 LEAVING 인스턴스에 저장되어 있던 값을 현재 shard 에서 key 를 맡게 될 replica 들에 그대로 기록하고 기록한 replica 의 개수를 반환한다.
+// 같거나 더 최신 version 을 가진 replica 는 덮어쓰지 않고, 만료된 값은 옮기지 않는다. 모든 replica 에 기록되어야 성공이다.
+func (d *Distributor) Handoff(ctx context.Context, bucketName, key, storedValue []byte) (int, error) {
+	return d.handoff(ctx, bucketName, key, storedValue, true)
+}
+
+// VerifyHandoff 는 Handoff 와 같은 replica 들을 읽기만 해서 storedValue 보다 오래된 값을 가진 replica 의 개수를 반환한다.
+func (d *Distributor) VerifyHandoff(ctx context.Context, bucketName, key, storedValue []byte) (int, error) {
+	return d.handoff(ctx, bucketName, key, storedValue, false)
+}
+
+func (d *Distributor) handoff(ctx context.Context, bucketName, key, storedValue []byte, write bool) (int, error) {
+	versionedValue, err := unmarshalVersionedValue(storedValue)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid stored value : key=%s", string(key))
+	}
+	if versionedValue.Expired(time.Now()) {
+		return 0, nil
+	}
+	r, err := d.WriteRing(ctx, bucketName)
+	if err != nil {
+		return 0, err
+	}
+	replicationSet, err := r.Get(d.tokenFromBytes(bucketName, key), putOp, nil, nil, nil)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to find the replicas : key=%s", string(key))
+	}
+	// quorum 이 아니라 모든 replica 에 기록되어야 이 인스턴스가 빠져도 replication factor 가 유지된다.
+	replicationSet.MaxErrors = 0
+	replicationSet.MaxUnavailableZones = 0
+
+	var mu sync.Mutex
+	outdated := 0
+	_, err = replicationSet.Do(ctx, 0, func(ctx context.Context, instance *ring.InstanceDesc) (interface{}, error) {
+		store := d.storePool.Get(instance.Addr)
+		if store == nil {
+			return nil, errors.Errorf("store is not registered : addr=%s", instance.Addr)
+		}
+		current, err := store.Get(ctx, bucketName, key)
+		if err != nil {
+			return nil, err
+		}
+		if len(current) > 0 {
+			currentValue, err := unmarshalVersionedValue(current)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid stored value : addr=%s key=%s", instance.Addr, string(key))
+			}
+			if currentValue.Version() >= versionedValue.Version() {
+				return nil, nil
+			}
+		}
+		if write {
+			if err := store.Put(ctx, bucketName, key, storedValue); err != nil {
+				return nil, err
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		outdated++
+		return nil, nil
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to hand off the key : key=%s", string(key))
+	}
+	return outdated, nil
+}
@@ -0,0 +1,286 @@
+package distributor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrKeyTooLarge   = errors.New("key too large")
+	ErrValueTooLarge = errors.New("value too large")
+	ErrInvalidBucket = errors.New("invalid bucket name")
+)
+
+const (
+	defaultLargeObjectThreshold = 1 << 20
+	defaultChunkSize            = 1 << 20
+)
+
+// chunkBucketSuffix 를 붙인 bucket 에 큰 값의 chunk 를 저장한다.
+// tenant 의 bucket 과 namespace 가 같으므로 같은 shard 에 배치되고 사용량과 quota 에 포함되며, 백업과 복원에도 포함된다.
+// RESP 나 etcd 의 key 처럼 bucket 이름에 NUL 을 넣을 수 있는 front-end 가 있으므로
+// 사용자가 읽고 쓰는 bucket 이름은 CheckBucket 으로 확인해서 chunk bucket 과 겹치지 않게 한다.
+const chunkBucketSuffix = "\x00chunks"
+
+// ChunkBucket 은 bucket 의 chunk 를 저장하는 bucket 이름이다.
+func ChunkBucket(bucketName []byte) []byte {
+	return append(bytes.Clone(bucketName), chunkBucketSuffix...)
+}
+
+// IsChunkBucket 은 ChunkBucket 이 만든 bucket 이름인지 확인한다. bucket 목록에서는 보이지 않는다.
+func IsChunkBucket(bucketName []byte) bool {
+	return bytes.HasSuffix(bucketName, []byte(chunkBucketSuffix))
+}
+
+// CheckBucket 은 bucket 이름에 NUL 이 없는지 확인한다. NUL 은 chunk bucket 에만 쓴다.
+// 값을 읽고 쓰는 메서드는 모두 확인하며, Distributor 를 거치지 않는 watch 는 front-end 가 확인한다.
+func CheckBucket(bucketName []byte) error {
+	if bytes.IndexByte(bucketName, 0) >= 0 {
+		return errors.Wrapf(ErrInvalidBucket, "bucket name must not contain NUL : bucket=%q", bucketName)
+	}
+	return nil
+}
+
+// LargeObjectsConfig 는 큰 값을 chunk 로 나눠서 저장하는 설정이다.
+// 켜져 있으면 덮어쓰거나 지운 큰 값의 chunk 를 지우기 위해 쓰기 전에 현재 값을 한 번 읽는다.
+type LargeObjectsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Threshold 보다 큰 값은 chunk 로 나눠서 저장한다. 0 이면 1 MiB 이다.
+	Threshold int `yaml:"threshold"`
+	// ChunkSize 는 chunk 하나의 크기이다. 0 이면 1 MiB 이다.
+	ChunkSize int `yaml:"chunk_size"`
+}
+
+// Validate 는 maxValueSize 가 0 이 아니면 threshold 와 chunk_size 가 maxValueSize 보다 크지 않은지도 확인한다.
+// replica 는 max_value_size 에 맞춰서 값을 받으므로 그보다 큰 chunk 는 기록할 수 없다.
+func (c *LargeObjectsConfig) Validate(maxValueSize int64) error {
+	if c.Threshold < 0 {
+		return errors.New("distributor 'large_objects.threshold' must not be negative")
+	}
+	if c.ChunkSize < 0 {
+		return errors.New("distributor 'large_objects.chunk_size' must not be negative")
+	}
+	if !c.Enabled || maxValueSize == 0 {
+		return nil
+	}
+	if int64(c.threshold()) > maxValueSize {
+		return errors.Errorf("distributor 'large_objects.threshold' (%d) must not exceed 'max_value_size' (%d)", c.threshold(), maxValueSize)
+	}
+	if int64(c.chunkSize()) > maxValueSize {
+		return errors.Errorf("distributor 'large_objects.chunk_size' (%d) must not exceed 'max_value_size' (%d)", c.chunkSize(), maxValueSize)
+	}
+	return nil
+}
+
+func (c *LargeObjectsConfig) threshold() int {
+	if c.Threshold == 0 {
+		return defaultLargeObjectThreshold
+	}
+	return c.Threshold
+}
+
+func (c *LargeObjectsConfig) chunkSize() int {
+	if c.ChunkSize == 0 {
+		return defaultChunkSize
+	}
+	return c.ChunkSize
+}
+
+// Manifest 는 chunk 로 나눠서 저장한 값의 정보이며 key 에는 값 대신 Manifest 가 저장된다.
+type Manifest struct {
+	Size      int64 `json:"size"`
+	ChunkSize int   `json:"chunkSize"`
+	Chunks    int   `json:"chunks"`
+	// UploadID 는 chunk key 에 들어가므로 같은 key 에 다시 쓰는 동안에도 이전 값의 chunk 를 덮어쓰지 않는다.
+	UploadID uint64 `json:"uploadId"`
+}
+
+// chunkKey 는 key | 0 | uploadID(8) | index(4) 이다. uploadID 와 index 는 big-endian 이다.
+func (m *Manifest) chunkKey(key []byte, index int) []byte {
+	chunkKey := make([]byte, 0, len(key)+1+8+4)
+	chunkKey = append(chunkKey, key...)
+	chunkKey = append(chunkKey, 0)
+	chunkKey = binary.BigEndian.AppendUint64(chunkKey, m.UploadID)
+	return binary.BigEndian.AppendUint32(chunkKey, uint32(index))
+}
+
+// CheckSize 는 key 와 값의 크기가 max_key_size, max_value_size 를 넘지 않는지 확인한다.
+// size 가 음수이면 값의 크기는 확인하지 않는다. 본문을 읽기 전에 Content-Length 로 확인할 때도 쓴다.
+func (d *Distributor) CheckSize(key []byte, size int64) error {
+	if d.cfg.MaxKeySize > 0 && len(key) > d.cfg.MaxKeySize {
+		return errors.Wrapf(ErrKeyTooLarge, "size=%d max=%d", len(key), d.cfg.MaxKeySize)
+	}
+	if d.cfg.MaxValueSize > 0 && size > d.cfg.MaxValueSize {
+		return errors.Wrapf(ErrValueTooLarge, "size=%d max=%d", size, d.cfg.MaxValueSize)
+	}
+	return nil
+}
+
+// MaxValueSize 는 max_value_size 이다. 0 이면 제한하지 않는다.
+func (d *Distributor) MaxValueSize() int64 {
+	return d.cfg.MaxValueSize
+}
+
+// MaxStoredValueSize 는 replica 에 기록하는 값의 최대 byte 수이며, 버전 정보와 압축으로 늘어나는 크기를 포함한다.
+// chunk 는 max_value_size 보다 클 수 없으므로 max_value_size 로 정해진다. 0 이면 제한하지 않는다.
+func (d *Distributor) MaxStoredValueSize() int64 {
+	if d.cfg.MaxValueSize == 0 {
+		return 0
+	}
+	// 압축할 수 없는 값은 snappy 에서 최대 1/6 만큼 커진다.
+	return d.cfg.MaxValueSize + d.cfg.MaxValueSize/6 + maxEnvelopeOverhead
+}
+
+// LargeObject 는 size 의 값을 PutLarge 로 써야 하는지 확인한다. size 가 음수이면 크기를 모르는 값이다.
+func (d *Distributor) LargeObject(size int64) bool {
+	return d.cfg.LargeObjects.Enabled && (size < 0 || size > int64(d.cfg.LargeObjects.threshold()))
+}
+
+// PutLarge 는 r 의 값을 chunk_size 씩 읽어서 chunk 마다 replica 에 기록한 뒤 Manifest 를 key 의 값으로 기록한다.
+// 값 전체를 메모리에 올리지 않으며, 값이 threshold 보다 작으면 PutWithOptions 처럼 그대로 기록한다.
+// 조건은 chunk 를 모두 기록한 뒤 Manifest 를 쓰기 직전에 확인한다. 실패하면 기록한 chunk 를 지운다.
+func (d *Distributor) PutLarge(ctx context.Context, bucketName, key []byte, r io.Reader, opts *PutOptions) (uint64, error) {
+	if err := CheckBucket(bucketName); err != nil {
+		return 0, err
+	}
+	if err := d.CheckSize(key, -1); err != nil {
+		return 0, err
+	}
+	threshold := d.cfg.LargeObjects.threshold()
+	head := make([]byte, threshold+1)
+	n, err := io.ReadFull(r, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return d.PutWithOptions(ctx, bucketName, key, head[:n], opts)
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read the value : key=%s", string(key))
+	}
+	r = io.MultiReader(bytes.NewReader(head), r)
+
+	manifest := &Manifest{ChunkSize: d.cfg.LargeObjects.chunkSize(), UploadID: uint64(time.Now().UnixNano())}
+	if err := d.putChunks(ctx, bucketName, key, manifest, r); err != nil {
+		d.deleteChunks(context.WithoutCancel(ctx), bucketName, key, manifest)
+		return 0, err
+	}
+	versionedValue := newVersionedValueNow(nil)
+	versionedValue.ExpiresAt = opts.ExpiresAt
+	versionedValue.Flags = opts.Flags
+	versionedValue.Manifest = manifest
+	version, err := d.write(ctx, bucketName, key, versionedValue, opts.Cond)
+	if err != nil {
+		d.deleteChunks(context.WithoutCancel(ctx), bucketName, key, manifest)
+		return 0, err
+	}
+	return version, nil
+}
+
+func (d *Distributor) putChunks(ctx context.Context, bucketName, key []byte, manifest *Manifest, r io.Reader) error {
+	chunkBucket := ChunkBucket(bucketName)
+	chunk := make([]byte, manifest.ChunkSize)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			manifest.Size += int64(n)
+			if err := d.CheckSize(key, manifest.Size); err != nil {
+				return err
+			}
+			// marshalVersionedValue 는 새 slice 에 복사하므로 chunk 를 다시 써도 된다.
+			marshaled, err := marshalVersionedValue(newVersionedValueNow(chunk[:n]), d.cfg.Compression.codecFor(bucketName, chunk[:n]))
+			if err != nil {
+				return err
+			}
+			if err := d.putCurrentShard(ctx, chunkBucket, manifest.chunkKey(key, manifest.Chunks), marshaled); err != nil {
+				return errors.Wrapf(err, "failed to put the chunk : key=%s chunk=%d", string(key), manifest.Chunks)
+			}
+			manifest.Chunks++
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to read the value : key=%s", string(key))
+		}
+	}
+}
+
+// GetVersionedStream 은 GetVersionedLocal 과 같지만 chunk 로 나눠서 저장한 값을 합치지 않는다.
+// Manifest 가 있으면 Value 는 비어 있고 WriteChunks 로 값을 읽는다.
+func (d *Distributor) GetVersionedStream(ctx context.Context, bucketName, key []byte) (*VersionedValue, error) {
+	if err := CheckBucket(bucketName); err != nil {
+		return nil, err
+	}
+	return d.getLatest(ctx, bucketName, key, true)
+}
+
+// WriteChunks 는 manifest 의 chunk 를 차례로 읽어서 w 에 쓴다. 한 번에 chunk 하나만 메모리에 올린다.
+func (d *Distributor) WriteChunks(ctx context.Context, bucketName, key []byte, manifest *Manifest, w io.Writer) error {
+	chunkBucket := ChunkBucket(bucketName)
+	var written int64
+	for i := 0; i < manifest.Chunks; i++ {
+		// chunk 는 uploadID 마다 한 번만 쓰고 바뀌지 않으므로 같은 zone 의 replica 에서 읽어도 된다.
+		chunk, err := d.getLatest(ctx, chunkBucket, manifest.chunkKey(key, i), true)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get the chunk : key=%s chunk=%d", string(key), i)
+		}
+		n, err := w.Write(chunk.Value)
+		written += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	if written != manifest.Size {
+		return errors.Errorf("chunks do not match the manifest : key=%s size=%d written=%d", string(key), manifest.Size, written)
+	}
+	return nil
+}
+
+// assemble 은 chunk 로 나눠서 저장한 값이면 chunk 를 모두 읽어서 합친 값을 반환한다.
+func (d *Distributor) assemble(ctx context.Context, bucketName, key []byte, versionedValue *VersionedValue) (*VersionedValue, error) {
+	if versionedValue.Manifest == nil {
+		return versionedValue, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(int(versionedValue.Manifest.Size))
+	if err := d.WriteChunks(ctx, bucketName, key, versionedValue.Manifest, &buf); err != nil {
+		return nil, err
+	}
+	assembled := *versionedValue
+	assembled.Manifest = nil
+	assembled.Value = buf.Bytes()
+	return &assembled, nil
+}
+
+// replacedManifest 는 쓰기나 삭제로 바뀔 key 의 현재 Manifest 이다. 쓴 뒤에 이전 chunk 를 지우는 데 쓴다.
+// 만료된 값의 chunk 도 지워야 하므로 만료 여부는 보지 않는다. large_objects 가 꺼져 있으면 읽지 않는다.
+func (d *Distributor) replacedManifest(ctx context.Context, bucketName, key []byte) *Manifest {
+	if !d.cfg.LargeObjects.Enabled {
+		return nil
+	}
+	current, err := d.getLatestIncludingExpired(ctx, bucketName, key, false)
+	if err != nil {
+		if !errors.Is(err, ErrKeyValueNotFound) {
+			d.logger.Warn("Failed to read the value to replace.", zap.String("key", string(key)), zap.Error(err))
+		}
+		return nil
+	}
+	return current.Manifest
+}
+
+// deleteChunks 는 manifest 의 chunk 를 지운다. 값은 이미 바뀌었으므로 실패해도 오류를 반환하지 않는다.
+func (d *Distributor) deleteChunks(ctx context.Context, bucketName, key []byte, manifest *Manifest) {
+	if manifest == nil {
+		return
+	}
+	chunkBucket := ChunkBucket(bucketName)
+	for i := 0; i < manifest.Chunks; i++ {
+		if err := d.delete(ctx, chunkBucket, manifest.chunkKey(key, i)); err != nil {
+			d.logger.Warn("Failed to delete the chunk.", zap.String("key", string(key)), zap.Int("chunk", i), zap.Error(err))
+		}
+	}
+}
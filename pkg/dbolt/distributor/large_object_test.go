@@ -0,0 +1,109 @@
+package distributor
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLargeObjectsConfigValidate(t *testing.T) {
+	cfg := &LargeObjectsConfig{Enabled: true}
+	require.NoError(t, cfg.Validate(0))
+	require.NoError(t, cfg.Validate(defaultChunkSize))
+	require.Error(t, cfg.Validate(defaultChunkSize-1))
+
+	cfg = &LargeObjectsConfig{Enabled: true, Threshold: 1 << 20, ChunkSize: 8 << 20}
+	require.Error(t, cfg.Validate(4<<20))
+
+	// chunk 로 나누지 않으면 replica 에는 max_value_size 보다 큰 값이 기록되지 않는다.
+	cfg.Enabled = false
+	require.NoError(t, cfg.Validate(4<<20))
+}
+
+// chunks 는 저장소에 남아 있는 bucketName 의 chunk 개수이다.
+func (c *zoneCluster) chunks(bucketName []byte) int {
+	prefix := string(ChunkBucket(bucketName)) + "/"
+	n := 0
+	for _, store := range c.stores {
+		store.mu.Lock()
+		for k := range store.m {
+			if strings.HasPrefix(k, prefix) {
+				n++
+			}
+		}
+		store.mu.Unlock()
+	}
+	return n
+}
+
+func TestLargeObjectChunkCleanup(t *testing.T) {
+	cfg := &Config{LargeObjects: LargeObjectsConfig{Enabled: true, Threshold: 16, ChunkSize: 10}}
+	// replica 가 하나이면 DoBatch 가 모든 쓰기를 기다리므로 chunk 개수를 바로 확인할 수 있다.
+	c := newZoneCluster(t, cfg, []string{"zone-a"}, 1, 1, "")
+	d := c.distributor(cfg, "zone-a")
+
+	ctx := context.Background()
+	bucketName, key := []byte("bucket"), []byte("key")
+	first := bytes.Repeat([]byte("a"), 35)
+	_, err := d.PutLarge(ctx, bucketName, key, bytes.NewReader(first), &PutOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 4, c.chunks(bucketName))
+	value, err := d.Get(ctx, bucketName, key)
+	require.NoError(t, err)
+	require.Equal(t, first, value)
+
+	streamed, err := d.GetVersionedStream(ctx, bucketName, key)
+	require.NoError(t, err)
+	require.NotNil(t, streamed.Manifest)
+	require.Empty(t, streamed.Value)
+	var buf bytes.Buffer
+	require.NoError(t, d.WriteChunks(ctx, bucketName, key, streamed.Manifest, &buf))
+	require.Equal(t, first, buf.Bytes())
+
+	// 다른 큰 값으로 덮어쓰면 이전 값의 chunk 를 지운다.
+	second := bytes.Repeat([]byte("b"), 21)
+	_, err = d.PutWithOptions(ctx, bucketName, key, second, &PutOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 3, c.chunks(bucketName))
+	value, err = d.Get(ctx, bucketName, key)
+	require.NoError(t, err)
+	require.Equal(t, second, value)
+
+	// 조건을 만족하지 않으면 새로 기록한 chunk 를 지우고 이전 값은 남긴다.
+	_, err = d.PutLarge(ctx, bucketName, key, bytes.NewReader(first), &PutOptions{Cond: &Precondition{IfAbsent: true}})
+	require.ErrorIs(t, err, ErrPreconditionFailed)
+	require.Equal(t, 3, c.chunks(bucketName))
+	value, err = d.Get(ctx, bucketName, key)
+	require.NoError(t, err)
+	require.Equal(t, second, value)
+
+	// threshold 이하의 값으로 덮어써도 chunk 를 지운다.
+	require.NoError(t, d.Put(ctx, bucketName, key, []byte("small")))
+	require.Zero(t, c.chunks(bucketName))
+
+	_, err = d.PutLarge(ctx, bucketName, key, bytes.NewReader(first), &PutOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 4, c.chunks(bucketName))
+	require.NoError(t, d.Delete(ctx, bucketName, key))
+	require.Zero(t, c.chunks(bucketName))
+	_, err = d.Get(ctx, bucketName, key)
+	require.ErrorIs(t, err, ErrKeyValueNotFound)
+}
+
+func TestLargeObjectTooLarge(t *testing.T) {
+	cfg := &Config{MaxValueSize: 40, LargeObjects: LargeObjectsConfig{Enabled: true, Threshold: 16, ChunkSize: 10}}
+	c := newZoneCluster(t, cfg, []string{"zone-a"}, 1, 1, "")
+	d := c.distributor(cfg, "zone-a")
+
+	// max_value_size 를 넘으면 이미 기록한 chunk 를 지운다.
+	ctx := context.Background()
+	bucketName, key := []byte("bucket"), []byte("key")
+	_, err := d.PutLarge(ctx, bucketName, key, bytes.NewReader(bytes.Repeat([]byte("a"), 41)), &PutOptions{})
+	require.ErrorIs(t, err, ErrValueTooLarge)
+	require.Zero(t, c.chunks(bucketName))
+	_, err = d.Get(ctx, bucketName, key)
+	require.ErrorIs(t, err, ErrKeyValueNotFound)
+}
@@ -0,0 +1,157 @@
+package distributor
+
+import (
+	"bytes"
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// keyLockStripes 는 조건부 쓰기를 직렬화하는 잠금의 개수이다.
+const keyLockStripes = 256
+
+// Precondition 은 조건부 쓰기의 조건이다. 값이 비어 있으면 조건 없이 쓴다.
+type Precondition struct {
+	// IfAbsent 이면 key 가 없을 때만 쓴다.
+	IfAbsent bool
+	// IfExists 이면 key 가 있을 때만 쓴다.
+	IfExists bool
+	// IfVersion 이 0 이 아니면 현재 값의 버전이 같을 때만 쓴다.
+	IfVersion uint64
+}
+
+func (p *Precondition) empty() bool {
+	return p == nil || (!p.IfAbsent && !p.IfExists && p.IfVersion == 0)
+}
+
+// keyLocks 는 같은 coordinator 를 거치는 조건부 쓰기를 key 단위로 직렬화한다.
+// 다른 coordinator 를 거치는 쓰기와는 직렬화되지 않으므로 조건 확인과 쓰기 사이에 끼어든 쓰기를 덮어쓸 수 있다.
+type keyLocks struct {
+	stripes [keyLockStripes]sync.Mutex
+}
+
+func (l *keyLocks) lock(bucketName, key []byte) func() {
+	h := fnv.New32a()
+	h.Write(bucketName)
+	h.Write([]byte{0})
+	h.Write(key)
+	mu := &l.stripes[h.Sum32()%keyLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// GetVersioned 는 Get 과 같지만 값의 버전 정보를 함께 반환한다.
+// local_reads 와 관계없이 quorum 으로 읽으므로 조건부 쓰기나 읽은 값을 고쳐 쓰는 데 쓴다.
+func (d *Distributor) GetVersioned(ctx context.Context, bucketName, key []byte) (*VersionedValue, error) {
+	return d.getVersioned(ctx, bucketName, key, false)
+}
+
+// GetVersionedLocal 은 GetVersioned 와 같지만 local_reads 가 켜져 있으면 같은 zone 의 replica 에서 먼저 읽는다.
+// 같은 zone 의 replica 는 최신 쓰기나 삭제를 받지 못했을 수 있으므로 값을 읽기만 하는 요청에만 쓴다.
+func (d *Distributor) GetVersionedLocal(ctx context.Context, bucketName, key []byte) (*VersionedValue, error) {
+	return d.getVersioned(ctx, bucketName, key, true)
+}
+
+func (d *Distributor) getVersioned(ctx context.Context, bucketName, key []byte, local bool) (*VersionedValue, error) {
+	if err := CheckBucket(bucketName); err != nil {
+		return nil, err
+	}
+	versionedValue, err := d.getLatest(ctx, bucketName, key, local)
+	if err != nil {
+		return nil, err
+	}
+	return d.assemble(ctx, bucketName, key, versionedValue)
+}
+
+// PutIf 는 cond 를 만족할 때 값을 쓰고 새 버전을 반환한다. 만족하지 않으면 ErrPreconditionFailed 를 반환한다.
+func (d *Distributor) PutIf(ctx context.Context, bucketName, key, value []byte, cond *Precondition) (uint64, error) {
+	return d.PutWithOptions(ctx, bucketName, key, value, &PutOptions{Cond: cond})
+}
+
+// PutOptions 는 값과 함께 기록할 정보와 쓰기 조건이다.
+type PutOptions struct {
+	Cond *Precondition
+	// ExpiresAt 이 지나면 값이 없는 것으로 본다. 0 이면 만료되지 않는다.
+	ExpiresAt time.Time
+	// Flags 는 memcached 의 client flags 처럼 client 가 값과 함께 저장하는 값이다.
+	Flags uint32
+}
+
+// PutWithOptions 는 PutIf 와 같지만 만료 시각과 flags 를 함께 기록한다.
+// key 나 값이 max_key_size, max_value_size 보다 크면 ErrKeyTooLarge, ErrValueTooLarge 를 반환하고,
+// large_objects 가 켜져 있고 값이 threshold 보다 크면 PutLarge 처럼 chunk 로 나눠서 저장한다.
+func (d *Distributor) PutWithOptions(ctx context.Context, bucketName, key, value []byte, opts *PutOptions) (uint64, error) {
+	if err := CheckBucket(bucketName); err != nil {
+		return 0, err
+	}
+	if err := d.CheckSize(key, int64(len(value))); err != nil {
+		return 0, err
+	}
+	if d.LargeObject(int64(len(value))) {
+		return d.PutLarge(ctx, bucketName, key, bytes.NewReader(value), opts)
+	}
+	versionedValue := newVersionedValueNow(value)
+	versionedValue.ExpiresAt = opts.ExpiresAt
+	versionedValue.Flags = opts.Flags
+	return d.write(ctx, bucketName, key, versionedValue, opts.Cond)
+}
+
+// write 는 cond 를 만족하면 versionedValue 를 기록하고 새 버전을 반환한다. 덮어쓴 값의 chunk 는 기록한 뒤에 지운다.
+func (d *Distributor) write(ctx context.Context, bucketName, key []byte, versionedValue *VersionedValue, cond *Precondition) (uint64, error) {
+	if !cond.empty() {
+		defer d.keyLocks.lock(bucketName, key)()
+		if err := d.checkPrecondition(ctx, bucketName, key, cond); err != nil {
+			return 0, err
+		}
+	}
+	replaced := d.replacedManifest(ctx, bucketName, key)
+	codec := d.cfg.Compression.codecFor(bucketName, versionedValue.Value)
+	marshaledVersionedValue, err := marshalVersionedValue(versionedValue, codec)
+	if err != nil {
+		return 0, err
+	}
+	if err := d.putCurrentShard(ctx, bucketName, key, marshaledVersionedValue); err != nil {
+		return 0, err
+	}
+	d.deleteChunks(ctx, bucketName, key, replaced)
+	return versionedValue.Version(), nil
+}
+
+// DeleteIf 는 cond 를 만족할 때 key 를 지운다. 만족하지 않으면 ErrPreconditionFailed 를 반환한다.
+func (d *Distributor) DeleteIf(ctx context.Context, bucketName, key []byte, cond *Precondition) error {
+	if err := CheckBucket(bucketName); err != nil {
+		return err
+	}
+	if !cond.empty() {
+		defer d.keyLocks.lock(bucketName, key)()
+		if err := d.checkPrecondition(ctx, bucketName, key, cond); err != nil {
+			return err
+		}
+	}
+	return d.Delete(ctx, bucketName, key)
+}
+
+func (d *Distributor) checkPrecondition(ctx context.Context, bucketName, key []byte, cond *Precondition) error {
+	current, err := d.getLatest(ctx, bucketName, key, false)
+	if errors.Is(err, ErrKeyValueNotFound) {
+		if cond.IfVersion != 0 || cond.IfExists {
+			return errors.Wrapf(ErrPreconditionFailed, "key not found : key=%s", string(key))
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if cond.IfAbsent {
+		return errors.Wrapf(ErrPreconditionFailed, "key exists : key=%s", string(key))
+	}
+	if cond.IfVersion != 0 && cond.IfVersion != current.Version() {
+		return errors.Wrapf(ErrPreconditionFailed, "version mismatch : key=%s version=%d", string(key), current.Version())
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package distributor
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/dskit/ring"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/pkg/errors"
+)
+
+// Scanner 는 Scan 을 지원하는 Store 이다.
+type Scanner interface {
+	Scan(ctx context.Context, bucketName, prefix, after []byte, limit int) ([]*store.KeyValue, error)
+}
+
+// Entry 는 Scan 의 결과이다.
+type Entry struct {
+	Key       []byte
+	Value     []byte
+	Version   uint64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// Manifest 가 있으면 값은 chunk 로 나눠서 저장되어 있고 Value 는 비어 있다. Scan 은 chunk 를 읽지 않는다.
+	Manifest *Manifest
+}
+
+func (e *Entry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// Scan 은 bucket 에서 prefix 로 시작하고 after 보다 큰 key 를 정렬된 순서로 최대 limit 개 반환한다.
+// key 는 Ring 전체에 흩어져 있으므로 bucket 이 배치된 모든 인스턴스에서 읽고 key 마다 가장 최근 값을 고른다.
+// 더 읽을 key 가 있을 수 있으면 more 가 true 이며 마지막 key 를 after 로 넘겨 이어서 읽는다.
+// 만료된 key 는 빠지므로 limit 보다 적게 반환할 수 있지만 more 가 true 이면 적어도 한 개는 반환한다.
+func (d *Distributor) Scan(ctx context.Context, bucketName, prefix, after []byte, limit int) ([]*Entry, bool, error) {
+	if err := CheckBucket(bucketName); err != nil {
+		return nil, false, err
+	}
+	now := time.Now()
+	for {
+		entries, more, err := d.scan(ctx, bucketName, prefix, after, limit)
+		if err != nil {
+			return nil, false, err
+		}
+		live := make([]*Entry, 0, len(entries))
+		for _, entry := range entries {
+			if !entry.expired(now) {
+				live = append(live, entry)
+			}
+		}
+		if len(live) > 0 || !more {
+			return live, more, nil
+		}
+		after = entries[len(entries)-1].Key
+	}
+}
+
+// scan 은 만료된 key 를 포함해서 읽는다. 만료된 key 는 가장 최근 값을 고른 뒤에 빼야 이전 version 이 보이지 않는다.
+func (d *Distributor) scan(ctx context.Context, bucketName, prefix, after []byte, limit int) ([]*Entry, bool, error) {
+	defer d.track()()
+	rings, err := d.sharding.rings(ctx, d.readRing, bucketName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var mu sync.Mutex
+	latest := make(map[string]*Entry)
+	more := false
+	for _, r := range rings {
+		replicationSet, err := r.GetReplicationSetForOperation(ring.Read)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "failed to read the instances to scan")
+		}
+		_, err = replicationSet.Do(ctx, 0, func(ctx context.Context, instance *ring.InstanceDesc) (interface{}, error) {
+			scanner, ok := d.storePool.Get(instance.Addr).(Scanner)
+			if !ok {
+				return nil, errors.Errorf("store does not support scan : addr=%s", instance.Addr)
+			}
+			kvs, err := scanner.Scan(ctx, bucketName, prefix, after, limit)
+			if err != nil {
+				return nil, err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(kvs) == limit {
+				more = true
+			}
+			for _, kv := range kvs {
+				versionedValue, err := unmarshalVersionedValue(kv.Value)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid stored value : key=%s", string(kv.Key))
+				}
+				entry := &Entry{Key: kv.Key, Value: versionedValue.Value, Version: versionedValue.Version(), CreatedAt: versionedValue.CreatedAt, ExpiresAt: versionedValue.ExpiresAt, Manifest: versionedValue.Manifest}
+				if existing, ok := latest[string(kv.Key)]; !ok || existing.Version < entry.Version {
+					latest[string(kv.Key)] = entry
+				}
+			}
+			return nil, nil
+		})
+		if err != nil {
+			return nil, false, errors.Wrap(err, "failed to scan the bucket")
+		}
+	}
+
+	entries := make([]*Entry, 0, len(latest))
+	for _, entry := range latest {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+		more = true
+	}
+	return entries, more, nil
+}
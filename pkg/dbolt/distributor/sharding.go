@@ -0,0 +1,143 @@
+package distributor
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/grafana/dskit/ring"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/pkg/errors"
+)
+
+// shardHistoryKeyPrefix 뒤에 tenant ID 를 붙인 key 로 tenant 의 shard 크기 이력을 MetadataStore 에 저장한다.
+const shardHistoryKeyPrefix = "shard-size/"
+
+// ShardingLimits 는 tenant 별 shuffle sharding 크기를 정한다. 0 이면 전체 Ring 을 사용한다.
+type ShardingLimits interface {
+	ShardSize(tenantID string) int
+}
+
+// MetadataStore 는 shard 크기 이력을 재시작 후에도 유지하기 위한 저장소이다.
+type MetadataStore interface {
+	GetMetadata(ctx context.Context, key []byte) ([]byte, error)
+	PutMetadata(ctx context.Context, key, value []byte) error
+}
+
+type ShuffleShardingConfig struct {
+	// LookbackPeriod 동안은 shard 크기가 바뀌기 전의 shard 에서도 값을 읽는다.
+	LookbackPeriod time.Duration `yaml:"lookback_period"`
+}
+
+func (c *ShuffleShardingConfig) Validate() error {
+	if c.LookbackPeriod < 0 {
+		return errors.New("distributor 'shuffle_sharding.lookback_period' must not be negative")
+	}
+	return nil
+}
+
+type shardHistory struct {
+	Size         int       `json:"size"`
+	PreviousSize int       `json:"previousSize"`
+	ChangedAt    time.Time `json:"changedAt"`
+}
+
+// shuffleSharding 은 tenant 의 bucket 을 dskit 의 ShuffleShard subring 에 배치한다.
+// shard 크기가 바뀌면 그 시각을 기록해 두고, LookbackPeriod 동안은 이전 크기의 subring 도 읽기 대상에 포함한다.
+// 노드마다 처음으로 바뀐 크기를 본 시각을 기록하므로 실제로 바뀐 시각보다 늦을 수는 있어도 이르지는 않다.
+type shuffleSharding struct {
+	cfg      *ShuffleShardingConfig
+	limits   ShardingLimits
+	metadata MetadataStore
+
+	mu      sync.Mutex
+	history map[string]*shardHistory
+}
+
+func newShuffleSharding(cfg *ShuffleShardingConfig, limits ShardingLimits, metadata MetadataStore) *shuffleSharding {
+	return &shuffleSharding{
+		cfg:      cfg,
+		limits:   limits,
+		metadata: metadata,
+		history:  make(map[string]*shardHistory),
+	}
+}
+
+// rings 는 bucket 의 key 가 있을 수 있는 Ring 들을 반환한다. 첫 번째는 쓰기에 사용하는 현재 shard 이다.
+func (s *shuffleSharding) rings(ctx context.Context, r ring.ReadRing, bucketName []byte) ([]ring.ReadRing, error) {
+	tenantID := store.Namespace(bucketName)
+	if s.limits == nil || tenantID == "" {
+		return []ring.ReadRing{r}, nil
+	}
+	history, err := s.shardHistory(ctx, tenantID, s.limits.ShardSize(tenantID))
+	if err != nil {
+		return nil, err
+	}
+	rings := []ring.ReadRing{subring(r, tenantID, history.Size)}
+	if history.PreviousSize != history.Size && time.Since(history.ChangedAt) < s.cfg.LookbackPeriod {
+		rings = append(rings, subring(r, tenantID, history.PreviousSize))
+	}
+	return rings, nil
+}
+
+func (s *shuffleSharding) shardHistory(ctx context.Context, tenantID string, size int) (*shardHistory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, ok := s.history[tenantID]
+	if !ok {
+		loaded, err := s.loadShardHistory(ctx, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		history = loaded
+	}
+	if history != nil && history.Size == size {
+		s.history[tenantID] = history
+		return history, nil
+	}
+
+	changed := &shardHistory{Size: size, PreviousSize: size, ChangedAt: time.Now()}
+	if history != nil {
+		changed.PreviousSize = history.Size
+	}
+	if err := s.saveShardHistory(ctx, tenantID, changed); err != nil {
+		return nil, err
+	}
+	s.history[tenantID] = changed
+	return changed, nil
+}
+
+func (s *shuffleSharding) loadShardHistory(ctx context.Context, tenantID string) (*shardHistory, error) {
+	value, err := s.metadata.GetMetadata(ctx, []byte(shardHistoryKeyPrefix+tenantID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load the shard history : tenant=%s", tenantID)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	history := new(shardHistory)
+	if err := json.Unmarshal(value, history); err != nil {
+		return nil, errors.Wrapf(err, "invalid shard history : tenant=%s", tenantID)
+	}
+	return history, nil
+}
+
+func (s *shuffleSharding) saveShardHistory(ctx context.Context, tenantID string, history *shardHistory) error {
+	value, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	if err := s.metadata.PutMetadata(ctx, []byte(shardHistoryKeyPrefix+tenantID), value); err != nil {
+		return errors.Wrapf(err, "failed to save the shard history : tenant=%s", tenantID)
+	}
+	return nil
+}
+
+func subring(r ring.ReadRing, tenantID string, size int) ring.ReadRing {
+	if size <= 0 {
+		return r
+	}
+	return r.ShuffleShard(tenantID, size)
+}
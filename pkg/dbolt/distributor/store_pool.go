@@ -1,14 +1,21 @@
 package distributor
 
-import "context"
+import (
+	"context"
+	"sort"
+	"sync"
+)
 
 type Store interface {
 	Get(ctx context.Context, bucket, key []byte) ([]byte, error)
 	Put(ctx context.Context, bucket, key, value []byte) error
+	Delete(ctx context.Context, bucket, key []byte) error
 }
 
+// SimpleStorePool 은 인스턴스 주소별 Store 이다. 주기적으로 Ring 과 동기화하면서 동시에 읽으므로 잠금으로 보호한다.
 type SimpleStorePool struct {
-	m map[string]Store
+	mu sync.RWMutex
+	m  map[string]Store
 }
 
 func NewSimpleStorePool() *SimpleStorePool {
@@ -18,14 +25,32 @@ func NewSimpleStorePool() *SimpleStorePool {
 }
 
 func (sp *SimpleStorePool) Get(key string) Store {
+	sp.mu.RLock()
+	defer sp.mu.RUnlock()
 	return sp.m[key]
 }
 
 func (sp *SimpleStorePool) Register(key string, store Store) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
 	sp.m[key] = store
 }
 
 func (sp *SimpleStorePool) Contains(key string) bool {
+	sp.mu.RLock()
+	defer sp.mu.RUnlock()
 	_, ok := sp.m[key]
 	return ok
 }
+
+// Addrs 는 등록된 인스턴스 주소를 정렬해서 반환한다.
+func (sp *SimpleStorePool) Addrs() []string {
+	sp.mu.RLock()
+	defer sp.mu.RUnlock()
+	addrs := make([]string, 0, len(sp.m))
+	for addr := range sp.m {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
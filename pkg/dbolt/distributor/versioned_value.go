@@ -1,13 +1,42 @@
 package distributor
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
+// VersionedValue 는 아래의 binary envelope 로 저장된다.
+//
+//	magic(1) | format(1) | flags(1) | codec(1) | createdAt(8) | updatedAt(8) | [expiresAt(8)] | [clientFlags(4)] | payload
+//
+// createdAt, updatedAt, expiresAt 은 big-endian Unix nano 이고 payload 는 codec 으로 압축된 값이다.
+// expiresAt, clientFlags 는 flags 에 flagExpiresAt, flagClientFlags 가 있을 때만 기록된다.
+// flags 에 flagChunked 가 있으면 payload 는 값 대신 JSON 으로 기록한 Manifest 이다.
+// 이전 버전은 JSON 으로 저장했으므로 첫 byte 가 magic 이 아니면 JSON 으로 읽는다.
+const (
+	envelopeMagic      byte = 0xDB
+	envelopeFormatV1   byte = 1
+	envelopeHeaderSize      = 4 + 8 + 8
+	// maxEnvelopeOverhead 는 값 외에 envelope 와 압축 형식이 더하는 크기의 상한이다.
+	maxEnvelopeOverhead = 1024
+
+	flagExpiresAt   byte = 1 << 0
+	flagClientFlags byte = 1 << 1
+	flagChunked     byte = 1 << 2
+)
+
+// ParseVersionedValue 는 Store 에 저장된 값을 읽는다. watch 처럼 Store 의 값을 직접 다루는 곳에서 사용한다.
+func ParseVersionedValue(value []byte) (*VersionedValue, error) {
+	return unmarshalVersionedValue(value)
+}
+
 func unmarshalVersionedValue(value []byte) (*VersionedValue, error) {
+	if len(value) > 0 && value[0] == envelopeMagic {
+		return unmarshalEnvelope(value)
+	}
 	versionedValue := new(VersionedValue)
 	if err := json.Unmarshal(value, versionedValue); err != nil {
 		return nil, errors.Wrap(err, "failed to unmarshal the value")
@@ -15,18 +44,100 @@ func unmarshalVersionedValue(value []byte) (*VersionedValue, error) {
 	return versionedValue, nil
 }
 
-func marshalVersionedValue(versionedValue *VersionedValue) ([]byte, error) {
-	marshaled, err := json.Marshal(versionedValue)
+func unmarshalEnvelope(value []byte) (*VersionedValue, error) {
+	if len(value) < envelopeHeaderSize {
+		return nil, errors.New("failed to unmarshal the value: envelope too short")
+	}
+	if value[1] != envelopeFormatV1 {
+		return nil, errors.Errorf("failed to unmarshal the value: unknown envelope format %d", value[1])
+	}
+	versionedValue := &VersionedValue{
+		CreatedAt: time.Unix(0, int64(binary.BigEndian.Uint64(value[4:12]))),
+		UpdatedAt: time.Unix(0, int64(binary.BigEndian.Uint64(value[12:20]))),
+	}
+	body := value[envelopeHeaderSize:]
+	if value[2]&flagExpiresAt != 0 {
+		if len(body) < 8 {
+			return nil, errors.New("failed to unmarshal the value: envelope too short")
+		}
+		versionedValue.ExpiresAt = time.Unix(0, int64(binary.BigEndian.Uint64(body[:8])))
+		body = body[8:]
+	}
+	if value[2]&flagClientFlags != 0 {
+		if len(body) < 4 {
+			return nil, errors.New("failed to unmarshal the value: envelope too short")
+		}
+		versionedValue.Flags = binary.BigEndian.Uint32(body[:4])
+		body = body[4:]
+	}
+	payload, err := decompress(Codec(value[3]), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the value")
+	}
+	if value[2]&flagChunked != 0 {
+		versionedValue.Manifest = new(Manifest)
+		if err := json.Unmarshal(payload, versionedValue.Manifest); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal the manifest")
+		}
+		return versionedValue, nil
+	}
+	versionedValue.Value = payload
+	return versionedValue, nil
+}
+
+func marshalVersionedValue(versionedValue *VersionedValue, codec Codec) ([]byte, error) {
+	value := versionedValue.Value
+	if versionedValue.Manifest != nil {
+		manifest, err := json.Marshal(versionedValue.Manifest)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal the manifest")
+		}
+		value = manifest
+	}
+	payload, err := compress(codec, value)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to marshal the value")
 	}
-	return marshaled, nil
+	marshaled := make([]byte, envelopeHeaderSize, envelopeHeaderSize+len(payload))
+	marshaled[0] = envelopeMagic
+	marshaled[1] = envelopeFormatV1
+	marshaled[3] = byte(codec)
+	binary.BigEndian.PutUint64(marshaled[4:12], uint64(versionedValue.CreatedAt.UnixNano()))
+	binary.BigEndian.PutUint64(marshaled[12:20], uint64(versionedValue.UpdatedAt.UnixNano()))
+	if !versionedValue.ExpiresAt.IsZero() {
+		marshaled[2] |= flagExpiresAt
+		marshaled = binary.BigEndian.AppendUint64(marshaled, uint64(versionedValue.ExpiresAt.UnixNano()))
+	}
+	if versionedValue.Flags != 0 {
+		marshaled[2] |= flagClientFlags
+		marshaled = binary.BigEndian.AppendUint32(marshaled, versionedValue.Flags)
+	}
+	if versionedValue.Manifest != nil {
+		marshaled[2] |= flagChunked
+	}
+	return append(marshaled, payload...), nil
 }
 
 type VersionedValue struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Value     []byte
+	// ExpiresAt 이 지나면 값이 없는 것으로 본다. 0 이면 만료되지 않는다.
+	ExpiresAt time.Time
+	// Flags 는 client 가 값과 함께 저장한 flags 이다.
+	Flags uint32
+	Value []byte
+	// Manifest 가 있으면 값은 chunk 로 나눠서 저장되어 있고 Value 는 비어 있다.
+	Manifest *Manifest `json:"-"`
+}
+
+// Expired 는 now 에 값이 만료되었는지 확인한다.
+func (v *VersionedValue) Expired(now time.Time) bool {
+	return !v.ExpiresAt.IsZero() && !now.Before(v.ExpiresAt)
+}
+
+// Version 은 조건부 쓰기에 사용하는 값의 버전이며 UpdatedAt 의 Unix nano 이다.
+func (v *VersionedValue) Version() uint64 {
+	return uint64(v.UpdatedAt.UnixNano())
 }
 
 func newVersionedValueNow(value []byte) *VersionedValue {
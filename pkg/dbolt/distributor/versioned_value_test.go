@@ -0,0 +1,91 @@
+package distributor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestVersionedValueRoundTrip(t *testing.T) {
+	now := time.Unix(0, time.Now().UnixNano())
+	values := map[string]*VersionedValue{
+		"plain":        {CreatedAt: now, UpdatedAt: now, Value: []byte("value")},
+		"empty":        {CreatedAt: now, UpdatedAt: now, Value: []byte{}},
+		"expires":      {CreatedAt: now, UpdatedAt: now.Add(time.Second), ExpiresAt: now.Add(time.Hour), Value: []byte("value")},
+		"client flags": {CreatedAt: now, UpdatedAt: now, Flags: 42, Value: []byte("value")},
+		"all":          {CreatedAt: now, UpdatedAt: now, ExpiresAt: now.Add(time.Hour), Flags: 42, Value: bytes.Repeat([]byte("value"), 100)},
+		"manifest":     {CreatedAt: now, UpdatedAt: now, Manifest: &Manifest{Size: 10, ChunkSize: 4, Chunks: 3, UploadID: 7}},
+	}
+	for name, versionedValue := range values {
+		for _, codec := range []Codec{CodecNone, CodecSnappy, CodecZstd} {
+			t.Run(name+"/"+codec.String(), func(t *testing.T) {
+				marshaled, err := marshalVersionedValue(versionedValue, codec)
+				require.NoError(t, err)
+				require.Equal(t, envelopeMagic, marshaled[0])
+
+				unmarshaled, err := unmarshalVersionedValue(marshaled)
+				require.NoError(t, err)
+				require.True(t, versionedValue.CreatedAt.Equal(unmarshaled.CreatedAt))
+				require.True(t, versionedValue.UpdatedAt.Equal(unmarshaled.UpdatedAt))
+				require.Equal(t, versionedValue.ExpiresAt.IsZero(), unmarshaled.ExpiresAt.IsZero())
+				require.True(t, versionedValue.ExpiresAt.Equal(unmarshaled.ExpiresAt))
+				require.Equal(t, versionedValue.Flags, unmarshaled.Flags)
+				require.Equal(t, versionedValue.Manifest, unmarshaled.Manifest)
+				require.Equal(t, versionedValue.Version(), unmarshaled.Version())
+				if versionedValue.Manifest == nil {
+					require.Equal(t, len(versionedValue.Value), len(unmarshaled.Value))
+					require.True(t, bytes.Equal(versionedValue.Value, unmarshaled.Value))
+				}
+			})
+		}
+	}
+}
+
+func TestUnmarshalLegacyJSONValue(t *testing.T) {
+	// 이전 버전은 VersionedValue 를 JSON 으로 저장했다.
+	now := time.Unix(0, time.Now().UnixNano())
+	legacy, err := json.Marshal(&VersionedValue{CreatedAt: now, UpdatedAt: now, ExpiresAt: now.Add(time.Hour), Value: []byte("value")})
+	require.NoError(t, err)
+	require.NotEqual(t, envelopeMagic, legacy[0])
+
+	versionedValue, err := unmarshalVersionedValue(legacy)
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), versionedValue.Value)
+	require.True(t, now.Equal(versionedValue.UpdatedAt))
+	require.True(t, now.Add(time.Hour).Equal(versionedValue.ExpiresAt))
+	require.Nil(t, versionedValue.Manifest)
+}
+
+func TestUnmarshalInvalidValue(t *testing.T) {
+	marshaled, err := marshalVersionedValue(&VersionedValue{ExpiresAt: time.Now(), Flags: 1, Value: []byte("value")}, CodecNone)
+	require.NoError(t, err)
+	for _, invalid := range [][]byte{
+		marshaled[:envelopeHeaderSize-1],
+		marshaled[:envelopeHeaderSize+4],
+		append([]byte{envelopeMagic, envelopeFormatV1 + 1}, marshaled[2:]...),
+		[]byte("not a value"),
+	} {
+		_, err := unmarshalVersionedValue(invalid)
+		require.Error(t, err, "value=%x", invalid)
+	}
+}
+
+func TestMaxStoredValueSize(t *testing.T) {
+	// 압축할 수 없는 max_value_size 크기의 값도 MaxStoredValueSize 안에 기록된다.
+	const maxValueSize = 1 << 20
+	d := New(&Config{MaxValueSize: maxValueSize}, nil, NewSimpleStorePool(), nil, nil, "", zap.NewNop())
+	value := make([]byte, maxValueSize)
+	_, err := rand.Read(value)
+	require.NoError(t, err)
+	now := time.Now()
+	for _, codec := range []Codec{CodecNone, CodecSnappy, CodecZstd} {
+		marshaled, err := marshalVersionedValue(&VersionedValue{CreatedAt: now, UpdatedAt: now, ExpiresAt: now, Flags: 1, Value: value}, codec)
+		require.NoError(t, err)
+		require.LessOrEqual(t, int64(len(marshaled)), d.MaxStoredValueSize(), "codec=%s", codec)
+	}
+}
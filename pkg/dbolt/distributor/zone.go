@@ -0,0 +1,88 @@
+package distributor
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/dskit/ring"
+	"go.uber.org/zap"
+)
+
+type ZoneAwarenessConfig struct {
+	// LocalReads 이면 Get, GetVersionedLocal 은 먼저 같은 zone 의 replica 에서 읽고, 값이 없거나 실패하면 quorum 으로 읽는다.
+	// 같은 zone 의 replica 가 최신 쓰기나 삭제를 받지 못했다면 이전 값을 반환할 수 있으므로
+	// 조건부 쓰기, 만료 시각 변경, 덮어쓸 chunk 확인처럼 읽은 값으로 쓰는 경로는 항상 quorum 으로 읽는다.
+	LocalReads bool `yaml:"local_reads"`
+	// TolerateZoneFailure 이면 응답하지 않는 replica 가 모두 한 zone 에 있을 때 quorum 이 모자라도
+	// 나머지 zone 의 replica 가 모두 성공하면 성공으로 본다.
+	TolerateZoneFailure bool `yaml:"tolerate_zone_failure"`
+}
+
+// zoneFailureTolerantStrategy 는 dskit 의 기본 replication strategy 에 한 zone 의 장애를 견디는 규칙을 더한다.
+type zoneFailureTolerantStrategy struct {
+	ring.ReplicationStrategy
+}
+
+// NewReplicationStrategy 는 설정에 맞는 Ring 의 replication strategy 를 반환한다.
+func NewReplicationStrategy(cfg *Config) ring.ReplicationStrategy {
+	strategy := ring.NewDefaultReplicationStrategy()
+	if cfg.ZoneAwareness.TolerateZoneFailure {
+		return &zoneFailureTolerantStrategy{ReplicationStrategy: strategy}
+	}
+	return strategy
+}
+
+func (s *zoneFailureTolerantStrategy) Filter(instances []ring.InstanceDesc, op ring.Operation, replicationFactor int, heartbeatTimeout time.Duration, zoneAwarenessEnabled bool) ([]ring.InstanceDesc, int, error) {
+	// 기본 strategy 가 instances 를 덮어쓰므로 복사해서 넘긴다.
+	candidates := append([]ring.InstanceDesc(nil), instances...)
+	healthy, maxFailures, err := s.ReplicationStrategy.Filter(candidates, op, replicationFactor, heartbeatTimeout, zoneAwarenessEnabled)
+	if err == nil || !zoneAwarenessEnabled {
+		return healthy, maxFailures, err
+	}
+
+	now := time.Now()
+	var available []ring.InstanceDesc
+	unavailableZones := make(map[string]struct{})
+	for _, instance := range instances {
+		if instance.IsHealthy(op, heartbeatTimeout, now) {
+			available = append(available, instance)
+		} else {
+			unavailableZones[instance.Zone] = struct{}{}
+		}
+	}
+	if len(available) == 0 || len(unavailableZones) > 1 {
+		return nil, 0, err
+	}
+	return available, 0, nil
+}
+
+// getLocalZone 은 이 노드와 같은 zone 의 replica 에서 값을 읽는다. 값을 찾지 못하면 false 를 반환한다.
+func (d *Distributor) getLocalZone(ctx context.Context, r ring.ReadRing, bucketName, key []byte) ([]*storedValue, bool) {
+	replicationSet, err := r.Get(d.tokenFromBytes(bucketName, key), ring.Read, nil, nil, nil)
+	if err != nil {
+		return nil, false
+	}
+	for _, instance := range replicationSet.Instances {
+		if instance.Zone != d.zone {
+			continue
+		}
+		store := d.storePool.Get(instance.Addr)
+		if store == nil {
+			continue
+		}
+		value, err := store.Get(ctx, bucketName, key)
+		if err != nil {
+			d.logger.Debug("Failed to read from the local zone.", zap.String("instanceAddr", instance.Addr), zap.Error(err))
+			continue
+		}
+		if len(value) == 0 {
+			continue
+		}
+		versionedValue, err := unmarshalVersionedValue(value)
+		if err != nil {
+			continue
+		}
+		return []*storedValue{{VersionedValue: versionedValue, raw: value}}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,257 @@
+package distributor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/kv/consul"
+	"github.com/grafana/dskit/ring"
+	"github.com/grafana/dskit/services"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// memoryStore 는 인스턴스 하나의 저장소이다. down 이면 모든 요청이 실패한다.
+type memoryStore struct {
+	mu   sync.Mutex
+	m    map[string][]byte
+	down bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{m: make(map[string][]byte)}
+}
+
+func (s *memoryStore) Get(_ context.Context, bucket, key []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return nil, errors.New("store is down")
+	}
+	return s.m[string(bucket)+"/"+string(key)], nil
+}
+
+func (s *memoryStore) Put(_ context.Context, bucket, key, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("store is down")
+	}
+	s.m[string(bucket)+"/"+string(key)] = value
+	return nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, bucket, key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("store is down")
+	}
+	delete(s.m, string(bucket)+"/"+string(key))
+	return nil
+}
+
+func (s *memoryStore) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+// zoneCluster 는 zone 마다 인스턴스를 두고 Ring 과 저장소를 한 프로세스 안에 만든 클러스터이다.
+type zoneCluster struct {
+	ring   *ring.Ring
+	stores map[string]*memoryStore
+	zones  map[string]string
+	pool   *SimpleStorePool
+}
+
+// newZoneCluster 는 zones 의 zone 마다 perZone 개의 인스턴스를 만든다. downZone 의 인스턴스는 heartbeat 가 끊긴 상태이다.
+func newZoneCluster(t *testing.T, cfg *Config, zones []string, perZone, replicationFactor int, downZone string) *zoneCluster {
+	t.Helper()
+	kvClient, closer := consul.NewInMemoryClient(ring.GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { closer.Close() })
+
+	c := &zoneCluster{stores: make(map[string]*memoryStore), zones: make(map[string]string), pool: NewSimpleStorePool()}
+	desc := ring.NewDesc()
+	for i, zone := range zones {
+		for j := 0; j < perZone; j++ {
+			id := fmt.Sprintf("%s-%d", zone, j)
+			addr := fmt.Sprintf("127.0.0.%d:%d", i+1, 7946+j)
+			tokens := make([]uint32, 0, 16)
+			for k := 0; k < 16; k++ {
+				tokens = append(tokens, uint32(((i*perZone+j)*16+k)*97+1))
+			}
+			instance := desc.AddIngester(id, addr, zone, tokens, ring.ACTIVE, time.Now())
+			if zone == downZone {
+				instance.Timestamp = time.Now().Add(-time.Hour).Unix()
+				desc.Ingesters[id] = instance
+			}
+			store := newMemoryStore()
+			store.setDown(zone == downZone)
+			c.stores[addr] = store
+			c.zones[addr] = zone
+			c.pool.Register(addr, store)
+		}
+	}
+	require.NoError(t, kvClient.CAS(context.Background(), RingKey, func(interface{}) (interface{}, bool, error) {
+		return desc, true, nil
+	}))
+
+	ringCfg := ring.Config{HeartbeatTimeout: time.Minute, ReplicationFactor: replicationFactor, ZoneAwarenessEnabled: true}
+	r, err := ring.NewWithStoreClientAndStrategy(ringCfg, RingName, RingKey, kvClient, NewReplicationStrategy(cfg), prometheus.NewRegistry(), log.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), r))
+	t.Cleanup(func() { services.StopAndAwaitTerminated(context.Background(), r) })
+	c.ring = r
+	return c
+}
+
+func (c *zoneCluster) distributor(cfg *Config, zone string) *Distributor {
+	return New(cfg, c.ring, c.pool, nil, nil, zone, zap.NewNop())
+}
+
+// replicaZones 는 key 의 값을 가진 저장소의 zone 별 개수이다.
+func (c *zoneCluster) replicaZones(bucketName, key []byte) map[string]int {
+	zones := make(map[string]int)
+	for addr, store := range c.stores {
+		store.mu.Lock()
+		_, ok := store.m[string(bucketName)+"/"+string(key)]
+		store.mu.Unlock()
+		if ok {
+			zones[c.zones[addr]]++
+		}
+	}
+	return zones
+}
+
+// waitReplicas 는 key 의 값이 want 의 zone 별 개수만큼 기록될 때까지 기다린다.
+// DoBatch 는 quorum 이 성공하면 반환하므로 나머지 replica 에는 조금 늦게 기록될 수 있다.
+func (c *zoneCluster) waitReplicas(t *testing.T, bucketName, key []byte, want map[string]int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		zones := c.replicaZones(bucketName, key)
+		if len(zones) != len(want) {
+			return false
+		}
+		for zone, n := range want {
+			if zones[zone] != n {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond, "key=%s", key)
+}
+
+// localReplica 는 key 의 replica 중 zone 에 있는 저장소이다.
+func (c *zoneCluster) localReplica(t *testing.T, bucketName, key []byte, zone string) *memoryStore {
+	t.Helper()
+	replicationSet, err := c.ring.Get(Token(bucketName, key), ring.Read, nil, nil, nil)
+	require.NoError(t, err)
+	for _, instance := range replicationSet.Instances {
+		if instance.Zone == zone {
+			return c.stores[instance.Addr]
+		}
+	}
+	t.Fatalf("no replica in zone %s", zone)
+	return nil
+}
+
+func TestZoneAwarePlacement(t *testing.T) {
+	cfg := &Config{}
+	zones := []string{"zone-a", "zone-b", "zone-c"}
+	c := newZoneCluster(t, cfg, zones, 2, 3, "")
+	d := c.distributor(cfg, "zone-a")
+
+	ctx := context.Background()
+	bucketName := []byte("bucket")
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		require.NoError(t, d.Put(ctx, bucketName, key, []byte("value")))
+		c.waitReplicas(t, bucketName, key, map[string]int{"zone-a": 1, "zone-b": 1, "zone-c": 1})
+	}
+}
+
+func TestZoneLocalReads(t *testing.T) {
+	cfg := &Config{ZoneAwareness: ZoneAwarenessConfig{LocalReads: true}}
+	zones := []string{"zone-a", "zone-b", "zone-c"}
+	c := newZoneCluster(t, cfg, zones, 2, 3, "")
+	d := c.distributor(cfg, "zone-a")
+
+	ctx := context.Background()
+	bucketName, key := []byte("bucket"), []byte("key")
+	_, err := d.PutIf(ctx, bucketName, key, []byte("v1"), nil)
+	require.NoError(t, err)
+	c.waitReplicas(t, bucketName, key, map[string]int{"zone-a": 1, "zone-b": 1, "zone-c": 1})
+	local := c.localReplica(t, bucketName, key, "zone-a")
+	stale, err := local.Get(ctx, bucketName, key)
+	require.NoError(t, err)
+	latest, err := d.PutIf(ctx, bucketName, key, []byte("v2"), nil)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		value, err := local.Get(ctx, bucketName, key)
+		return err == nil && string(value) != string(stale)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// 같은 zone 의 replica 가 최신 쓰기를 받지 못한 상태를 만든다.
+	require.NoError(t, local.Put(ctx, bucketName, key, stale))
+
+	// 값만 읽는 요청은 같은 zone 의 replica 에서 읽으므로 다른 zone 이 모두 실패해도 읽을 수 있다.
+	for addr, store := range c.stores {
+		if c.zones[addr] != "zone-a" {
+			store.setDown(true)
+		}
+	}
+	value, err := d.Get(ctx, bucketName, key)
+	require.NoError(t, err)
+	require.Equal(t, []byte("v1"), value)
+	for _, store := range c.stores {
+		store.setDown(false)
+	}
+
+	// 조건부 쓰기와 GetVersioned 는 quorum 으로 읽으므로 이전 값의 version 으로는 쓸 수 없다.
+	versionedValue, err := d.GetVersioned(ctx, bucketName, key)
+	require.NoError(t, err)
+	require.Equal(t, []byte("v2"), versionedValue.Value)
+	require.Equal(t, latest, versionedValue.Version())
+
+	staleValue, err := unmarshalVersionedValue(stale)
+	require.NoError(t, err)
+	_, err = d.PutIf(ctx, bucketName, key, []byte("v3"), &Precondition{IfVersion: staleValue.Version()})
+	require.ErrorIs(t, err, ErrPreconditionFailed)
+	_, err = d.PutIf(ctx, bucketName, key, []byte("v3"), &Precondition{IfVersion: latest})
+	require.NoError(t, err)
+}
+
+func TestZoneFailureTolerantWrites(t *testing.T) {
+	zones := []string{"zone-a", "zone-b"}
+	ctx := context.Background()
+	bucketName, key := []byte("bucket"), []byte("key")
+
+	t.Run("default quorum", func(t *testing.T) {
+		cfg := &Config{}
+		c := newZoneCluster(t, cfg, zones, 2, 2, "zone-b")
+		d := c.distributor(cfg, "zone-a")
+		require.Error(t, d.Put(ctx, bucketName, key, []byte("value")))
+	})
+
+	t.Run("tolerate zone failure", func(t *testing.T) {
+		cfg := &Config{ZoneAwareness: ZoneAwarenessConfig{TolerateZoneFailure: true}}
+		c := newZoneCluster(t, cfg, zones, 2, 2, "zone-b")
+		d := c.distributor(cfg, "zone-a")
+		require.NoError(t, d.Put(ctx, bucketName, key, []byte("value")))
+		c.waitReplicas(t, bucketName, key, map[string]int{"zone-a": 1})
+
+		value, err := d.Get(ctx, bucketName, key)
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), value)
+		require.NoError(t, d.Delete(ctx, bucketName, key))
+		_, err = d.Get(ctx, bucketName, key)
+		require.ErrorIs(t, err, ErrKeyValueNotFound)
+	})
+}
@@ -0,0 +1,426 @@
+package etcdserver
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/dskit/user"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/changes"
+	"github.com/kwSeo/dbolt/pkg/dbolt/decommission"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tlsconfig"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// consistencyHeader 는 모든 응답의 gRPC header 로 보내는 일관성 모델이다. etcd 의 linearizable 과 다르다는 것을 알린다.
+const (
+	consistencyHeader = "dbolt-consistency"
+	consistencyModel  = "eventual"
+)
+
+type Config struct {
+	Enabled    bool   `yaml:"enabled"`
+	ListenPort uint16 `yaml:"listen_port"`
+	// Prefixes 는 etcd key 의 prefix 와 그 key 를 저장할 bucket 이다. prefix 를 뗀 나머지를 bucket 의 key 로 사용한다.
+	// prefix 는 서로의 prefix 가 될 수 없고, 어느 prefix 에도 속하지 않는 key 는 쓸 수 없다.
+	Prefixes []PrefixConfig `yaml:"prefixes"`
+	// Timeout 은 한 요청의 제한 시간이다. 기본값은 10초이다.
+	Timeout time.Duration `yaml:"timeout"`
+	// WatchPollInterval 은 Watch 가 클러스터의 변경 기록을 읽는 주기이다. 기본값은 1초이다.
+	WatchPollInterval time.Duration `yaml:"watch_poll_interval"`
+}
+
+type PrefixConfig struct {
+	Prefix string `yaml:"prefix"`
+	Bucket string `yaml:"bucket"`
+}
+
+func (c *Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.ListenPort == 0 {
+		return errors.New("etcd 'listen_port' required")
+	}
+	if len(c.Prefixes) == 0 {
+		return errors.New("etcd 'prefixes' required")
+	}
+	for i, p := range c.Prefixes {
+		if p.Bucket == "" {
+			return errors.Errorf("etcd 'prefixes[%d].bucket' required", i)
+		}
+		for _, other := range c.Prefixes[i+1:] {
+			if bytes.HasPrefix([]byte(p.Prefix), []byte(other.Prefix)) || bytes.HasPrefix([]byte(other.Prefix), []byte(p.Prefix)) {
+				return errors.Errorf("etcd 'prefixes' must not overlap : %q, %q", p.Prefix, other.Prefix)
+			}
+			if p.Bucket == other.Bucket {
+				return errors.Errorf("etcd 'prefixes' must use different buckets : %s", p.Bucket)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Config) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return c.Timeout
+}
+
+func (c *Config) watchPollInterval() time.Duration {
+	if c.WatchPollInterval <= 0 {
+		return time.Second
+	}
+	return c.WatchPollInterval
+}
+
+// mapping 은 prefix 하나와 bucket 이다. end 는 prefix 로 시작하는 key 의 상한이며 nil 이면 상한이 없다.
+type mapping struct {
+	prefix []byte
+	end    []byte
+	bucket []byte
+}
+
+func newMappings(cfg *Config) []*mapping {
+	mappings := make([]*mapping, 0, len(cfg.Prefixes))
+	for _, p := range cfg.Prefixes {
+		mappings = append(mappings, &mapping{
+			prefix: []byte(p.Prefix),
+			end:    prefixEnd([]byte(p.Prefix)),
+			bucket: []byte(p.Bucket),
+		})
+	}
+	// prefix 가 겹치지 않으므로 prefix 순서가 key 순서와 같다.
+	sort.Slice(mappings, func(i, j int) bool {
+		return bytes.Compare(mappings[i].prefix, mappings[j].prefix) < 0
+	})
+	return mappings
+}
+
+// prefixEnd 는 etcd 의 GetPrefixRangeEnd 와 같이 prefix 로 시작하는 key 보다 큰 가장 작은 key 를 구한다.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Server 는 etcd v3 의 KV, Watch API 로 Distributor 의 key 를 읽고 쓰는 gRPC listener 이다.
+//
+// etcd 와 달리 클러스터 전체의 revision 이 없으므로 key 의 버전(UpdatedAt 의 Unix nano)을 mod_revision 으로,
+// 생성 시각을 create_revision 으로 사용하고, 응답 header 의 revision 은 응답한 시각이다.
+// 읽기는 replica 중 가장 최근 값을 고르는 eventual consistency 이며 Txn 은 여러 key 에 걸쳐 원자적이지 않다.
+type Server struct {
+	etcdserverpb.UnimplementedKVServer
+	etcdserverpb.UnimplementedAuthServer
+
+	cfg      *Config
+	addr     string
+	mappings []*mapping
+
+	grpcServer *grpc.Server
+	dist       *distributor.Distributor
+	changes    *changes.Service
+	tenants    *tenant.Service
+	auth       *auth.Service
+	// decommission 을 시작하면 모든 요청을 Unavailable 로 거절해서 client 가 다른 endpoint 로 옮겨 가게 한다.
+	decommission *decommission.Decommissioner
+	metrics      *metrics
+	logger       *zap.Logger
+	// timeout 은 한 요청의 제한 시간이다. 설정을 다시 읽으면 ApplyConfig 로 바뀐다.
+	timeout atomic.Int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func New(cfg *Config, addr string, serverTLS *tlsconfig.Server, dist *distributor.Distributor, changesService *changes.Service, tenants *tenant.Service, authService *auth.Service, decommissioner *decommission.Decommissioner, reg prometheus.Registerer, logger *zap.Logger) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		cfg:          cfg,
+		addr:         addr,
+		mappings:     newMappings(cfg),
+		dist:         dist,
+		changes:      changesService,
+		tenants:      tenants,
+		auth:         authService,
+		decommission: decommissioner,
+		metrics:      newMetrics(reg),
+		logger:       logger,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	s.timeout.Store(int64(cfg.timeout()))
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.streamInterceptor),
+	}
+	if serverTLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(serverTLS.Config())))
+	}
+	s.grpcServer = grpc.NewServer(opts...)
+	etcdserverpb.RegisterKVServer(s.grpcServer, s)
+	etcdserverpb.RegisterWatchServer(s.grpcServer, &watchServer{s: s})
+	etcdserverpb.RegisterAuthServer(s.grpcServer, s)
+	return s
+}
+
+// ApplyConfig 는 다시 읽은 설정 중 timeout 만 적용한다. 나머지는 재시작해야 바뀐다.
+func (s *Server) ApplyConfig(cfg *Config) {
+	s.timeout.Store(int64(cfg.timeout()))
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen : addr=%s", s.addr)
+	}
+	s.logger.Info("Starting etcd server.", zap.String("bindAddress", s.addr), zap.Int("prefixes", len(s.mappings)))
+	go func() {
+		if err := s.grpcServer.Serve(lis); err != nil {
+			s.logger.Error("etcd server stopped.", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+func (s *Server) Stop(ctx context.Context) error {
+	s.logger.Info("Stopping etcd server.")
+	s.cancel()
+	stopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.grpcServer.Stop()
+	}
+	return nil
+}
+
+func (s *Server) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	_ = grpc.SetHeader(ctx, metadata.Pairs(consistencyHeader, consistencyModel))
+	if !s.decommission.Serving() {
+		s.metrics.requests.WithLabelValues(path.Base(info.FullMethod), codes.Unavailable.String()).Inc()
+		return nil, status.Error(codes.Unavailable, decommission.ErrNotServing.Error())
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeout.Load()))
+	defer cancel()
+	resp, err := handler(ctx, req)
+	s.metrics.requests.WithLabelValues(path.Base(info.FullMethod), status.Code(err).String()).Inc()
+	return resp, err
+}
+
+func (s *Server) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	_ = stream.SetHeader(metadata.Pairs(consistencyHeader, consistencyModel))
+	if !s.decommission.Serving() {
+		s.metrics.requests.WithLabelValues(path.Base(info.FullMethod), codes.Unavailable.String()).Inc()
+		return status.Error(codes.Unavailable, decommission.ErrNotServing.Error())
+	}
+	err := handler(srv, stream)
+	s.metrics.requests.WithLabelValues(path.Base(info.FullMethod), status.Code(err).String()).Inc()
+	return err
+}
+
+// Authenticate 는 etcd client 의 사용자 인증이다. password 를 dbolt 의 token 으로 확인하고 그대로 token 으로 돌려준다.
+// 사용자 이름은 사용하지 않는다.
+func (s *Server) Authenticate(ctx context.Context, req *etcdserverpb.AuthenticateRequest) (*etcdserverpb.AuthenticateResponse, error) {
+	if !s.auth.Enabled() {
+		return nil, rpctypes.ErrGRPCAuthNotEnabled
+	}
+	if _, err := s.auth.Authenticate(&auth.Request{BearerToken: req.GetPassword()}); err != nil {
+		s.auth.Unauthenticated("etcd Authenticate", err)
+		return nil, rpctypes.ErrGRPCAuthFailed
+	}
+	return &etcdserverpb.AuthenticateResponse{Header: s.header(0), Token: req.GetPassword()}, nil
+}
+
+// caller 는 요청한 client 의 principal 과 tenant 이다.
+type caller struct {
+	principal *auth.Principal
+	tenantID  string
+}
+
+// authenticate 는 인증이 켜져 있으면 metadata 의 token(etcd client) 또는 authorization, 아니면 client 인증서로 인증하고
+// tenancy 가 켜져 있으면 tenant 를 정한다. principal 에 tenant 가 있으면 metadata 의 tenant 대신 그 tenant 를 사용한다.
+func (s *Server) authenticate(ctx context.Context, action string) (*caller, error) {
+	c := new(caller)
+	if s.auth.Enabled() {
+		req := new(auth.Request)
+		if values := metadata.ValueFromIncomingContext(ctx, rpctypes.TokenFieldNameGRPC); len(values) > 0 {
+			req.BearerToken = values[0]
+		} else if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+			req.BearerToken = auth.BearerToken(values[0])
+		}
+		if p, ok := peer.FromContext(ctx); ok {
+			if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				req.PeerCertificates = tlsInfo.State.PeerCertificates
+			}
+		}
+		principal, err := s.auth.Authenticate(req)
+		if err != nil {
+			s.auth.Unauthenticated(action, err)
+			// etcd 와 같이 token 이 없으면 사용자 이름이 없다는 오류를 반환한다.
+			if req.BearerToken == "" && len(req.PeerCertificates) == 0 {
+				return nil, rpctypes.ErrGRPCUserEmpty
+			}
+			return nil, rpctypes.ErrGRPCInvalidAuthToken
+		}
+		c.principal = principal
+	}
+	if !s.tenants.Enabled() {
+		return c, nil
+	}
+	if c.principal != nil && c.principal.Tenant != "" {
+		ctx = tenant.Inject(ctx, c.principal.Tenant)
+	} else {
+		_, ctx, _ = user.ExtractFromGRPCRequest(ctx)
+	}
+	tenantID, err := tenant.ID(ctx)
+	if errors.Is(err, tenant.ErrNoTenant) {
+		return nil, status.Error(codes.Unauthenticated, "tenant required")
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !s.tenants.Allow(tenantID) {
+		return nil, status.Error(codes.ResourceExhausted, "request rate limit exceeded : tenant="+tenantID)
+	}
+	c.tenantID = tenantID
+	return c, nil
+}
+
+// bucketName 은 bucket 을 저장소의 bucket 이름으로 바꾸고 principal 이 그 이름에 권한을 가지고 있는지 확인한다.
+// tenancy 가 켜져 있으면 tenant 를 붙인 이름으로 확인하므로 ACL 은 tenant 마다 따로 준다.
+func (s *Server) bucketName(c *caller, m *mapping, permission auth.Permission, action string) ([]byte, error) {
+	bucketName := m.bucket
+	if c.tenantID != "" {
+		bucketName = tenant.Bucket(c.tenantID, m.bucket)
+	}
+	if s.auth.Enabled() {
+		if err := s.auth.Authorize(c.principal, string(bucketName), permission, action); err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+	return bucketName, nil
+}
+
+// locate 는 key 가 속한 prefix 를 찾는다.
+func (s *Server) locate(key []byte) (*mapping, []byte, error) {
+	for _, m := range s.mappings {
+		if bytes.HasPrefix(key, m.prefix) {
+			if len(key) == len(m.prefix) {
+				return nil, nil, status.Errorf(codes.InvalidArgument, "key must be longer than its prefix : key=%q", key)
+			}
+			return m, key[len(m.prefix):], nil
+		}
+	}
+	return nil, nil, status.Errorf(codes.InvalidArgument, "key is not under any configured prefix : key=%q", key)
+}
+
+// segment 는 etcd 의 key 범위 중 bucket 하나에 속한 부분이다. start, end 는 bucket 의 key 이며 end 가 nil 이면 상한이 없다.
+type segment struct {
+	mapping *mapping
+	start   []byte
+	end     []byte
+}
+
+// segments 는 [key, rangeEnd) 를 prefix 별로 나눈다. rangeEnd 가 "\x00" 이면 key 이상인 모든 key 이다.
+func (s *Server) segments(key, rangeEnd []byte) []*segment {
+	unbounded := len(rangeEnd) == 1 && rangeEnd[0] == 0
+	var segments []*segment
+	for _, m := range s.mappings {
+		// 범위와 [m.prefix, m.end) 의 교집합을 구한다.
+		start := m.prefix
+		if bytes.Compare(key, start) > 0 {
+			start = key
+		}
+		end := m.end
+		if !unbounded && (end == nil || bytes.Compare(rangeEnd, end) < 0) {
+			end = rangeEnd
+		}
+		if end != nil && bytes.Compare(start, end) >= 0 {
+			continue
+		}
+		if !bytes.HasPrefix(start, m.prefix) {
+			continue
+		}
+		seg := &segment{mapping: m, start: start[len(m.prefix):]}
+		if end != nil && !bytes.Equal(end, m.end) {
+			seg.end = end[len(m.prefix):]
+		}
+		segments = append(segments, seg)
+	}
+	return segments
+}
+
+// header 는 응답 header 이다. revision 이 0 이면 응답한 시각을 사용한다.
+func (s *Server) header(revision int64) *etcdserverpb.ResponseHeader {
+	if revision == 0 {
+		revision = time.Now().UnixNano()
+	}
+	return &etcdserverpb.ResponseHeader{Revision: revision}
+}
+
+// grpcError 는 Distributor 의 오류를 gRPC status 로 바꾼다. 이미 status 이면 그대로 반환한다.
+func grpcError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, store.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, distributor.ErrKeyTooLarge), errors.Is(err, distributor.ErrValueTooLarge), errors.Is(err, distributor.ErrInvalidBucket):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+type metrics struct {
+	requests *prometheus.CounterVec
+	watchers prometheus.Gauge
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	factory := promauto.With(reg)
+	return &metrics{
+		requests: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbolt_etcd_requests_total",
+			Help: "Total number of requests received by the etcd server.",
+		}, []string{"method", "code"}),
+		watchers: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "dbolt_etcd_watchers",
+			Help: "Number of active etcd watchers.",
+		}),
+	}
+}
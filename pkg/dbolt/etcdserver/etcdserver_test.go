@@ -0,0 +1,82 @@
+package etcdserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth/authtest"
+	"github.com/kwSeo/dbolt/pkg/dbolt/decommission"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor/distributortest"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func newTestServer(t *testing.T, cfg *Config, authCfg *auth.Config, tenantCfg *tenant.Config) *Server {
+	t.Helper()
+	cluster := distributortest.New(t, &distributor.Config{})
+	reg := prometheus.NewRegistry()
+	authService, err := auth.New(authCfg, zap.NewNop())
+	require.NoError(t, err)
+	tenants := tenant.New(tenantCfg, tenant.NewQuotas(tenantCfg, tenant.NewOverrides(tenantCfg), reg), cluster.Ring, cluster.StorePool, cluster.LocalStore, reg, zap.NewNop())
+	decommissioner := decommission.New(&decommission.Config{}, nil, nil, nil, nil, reg, zap.NewNop())
+	s := New(cfg, "", nil, cluster.Distributor, nil, tenants, authService, decommissioner, reg, zap.NewNop())
+	t.Cleanup(s.cancel)
+	return s
+}
+
+// incoming 은 etcd client 가 token 과 tenant 를 metadata 로 보낸 요청의 context 이다.
+func incoming(token, tenantID string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(rpctypes.TokenFieldNameGRPC, token, tenant.HeaderName, tenantID))
+}
+
+func TestTenantAuthorization(t *testing.T) {
+	s := newTestServer(t, &Config{Enabled: true, Prefixes: []PrefixConfig{{Prefix: "/app/", Bucket: "app"}}}, authtest.Config(), &tenant.Config{Enabled: true})
+	put := func(ctx context.Context, value string) error {
+		_, err := s.Put(ctx, &etcdserverpb.PutRequest{Key: []byte("/app/k"), Value: []byte(value)})
+		return err
+	}
+	get := func(ctx context.Context) (string, error) {
+		resp, err := s.Range(ctx, &etcdserverpb.RangeRequest{Key: []byte("/app/k")})
+		if err != nil || len(resp.Kvs) == 0 {
+			return "", err
+		}
+		return string(resp.Kvs[0].Value), nil
+	}
+
+	require.ErrorIs(t, put(metadata.NewIncomingContext(context.Background(), metadata.MD{}), "v"), rpctypes.ErrGRPCUserEmpty)
+	require.ErrorIs(t, put(incoming("invalid", authtest.Tenant), "v"), rpctypes.ErrGRPCInvalidAuthToken)
+
+	// tenant 가 없는 principal 은 metadata 로 tenant 를 고르지만 그 tenant 의 bucket 에 권한이 있어야 한다.
+	require.NoError(t, put(incoming(authtest.UnboundToken, authtest.Tenant), "unbound"))
+	err := put(incoming(authtest.UnboundToken, authtest.OtherTenant), "v")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = get(incoming(authtest.UnboundToken, authtest.OtherTenant))
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// tenant 에 묶인 principal 은 metadata 의 tenant 대신 자기 tenant 를 사용한다.
+	value, err := get(incoming(authtest.BoundToken, authtest.OtherTenant))
+	require.NoError(t, err)
+	require.Equal(t, "unbound", value)
+	require.NoError(t, put(incoming(authtest.BoundToken, authtest.OtherTenant), "bound"))
+
+	value, err = get(incoming(authtest.AdminToken, authtest.OtherTenant))
+	require.NoError(t, err)
+	require.Empty(t, value)
+	require.NoError(t, put(incoming(authtest.AdminToken, authtest.OtherTenant), "admin"))
+
+	value, err = get(incoming(authtest.UnboundToken, authtest.Tenant))
+	require.NoError(t, err)
+	require.Equal(t, "bound", value)
+	value, err = get(incoming(authtest.AdminToken, authtest.OtherTenant))
+	require.NoError(t, err)
+	require.Equal(t, "admin", value)
+}
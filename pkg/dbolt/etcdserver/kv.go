@@ -0,0 +1,574 @@
+package etcdserver
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// scanPageSize 는 범위를 읽을 때 한 번에 읽는 key 의 수이다.
+	scanPageSize = 500
+	// maxWriteRetries 는 이전 값을 읽고 조건부로 쓸 때 다른 쓰기와 겹치면 다시 시도하는 횟수이다.
+	maxWriteRetries = 10
+)
+
+// errTxnConflict 는 Txn 에서 비교한 key 가 비교한 뒤에 바뀌어 쓰지 못했을 때의 오류이다. 앞의 연산은 되돌리지 않는다.
+var errTxnConflict = status.Error(codes.Aborted, "txn conflict: a compared key changed before the write, retry the txn")
+
+func (s *Server) Range(ctx context.Context, req *etcdserverpb.RangeRequest) (*etcdserverpb.RangeResponse, error) {
+	c, err := s.authenticate(ctx, "etcd Range")
+	if err != nil {
+		return nil, err
+	}
+	return s.rangeKeys(ctx, c, req)
+}
+
+func (s *Server) Put(ctx context.Context, req *etcdserverpb.PutRequest) (*etcdserverpb.PutResponse, error) {
+	c, err := s.authenticate(ctx, "etcd Put")
+	if err != nil {
+		return nil, err
+	}
+	return s.put(ctx, c, req, nil)
+}
+
+func (s *Server) DeleteRange(ctx context.Context, req *etcdserverpb.DeleteRangeRequest) (*etcdserverpb.DeleteRangeResponse, error) {
+	c, err := s.authenticate(ctx, "etcd DeleteRange")
+	if err != nil {
+		return nil, err
+	}
+	return s.deleteRange(ctx, c, req, nil)
+}
+
+// Txn 은 compare 를 모두 만족하면 success 를, 아니면 failure 의 연산을 실행한다.
+//
+// etcd 와 달리 원자적이지 않다. 하나의 key 를 비교했다면 그 key 에 대한 put, delete 는 비교한 버전일 때만 쓰고,
+// 그 사이에 다른 쓰기가 있으면 Aborted 를 반환한다. 이런 조건부 연산을 먼저 실행하므로 충돌하면 대개 아무것도 쓰지 않지만,
+// 조건부 연산이 여러 개면 앞의 연산은 이미 기록되었을 수 있다.
+func (s *Server) Txn(ctx context.Context, req *etcdserverpb.TxnRequest) (*etcdserverpb.TxnResponse, error) {
+	c, err := s.authenticate(ctx, "etcd Txn")
+	if err != nil {
+		return nil, err
+	}
+	guards := make(map[string]*distributor.Precondition)
+	succeeded := true
+	for _, cmp := range req.GetCompare() {
+		ok, err := s.compare(ctx, c, cmp, guards)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			succeeded = false
+		}
+	}
+	ops := req.GetSuccess()
+	if !succeeded {
+		ops = req.GetFailure()
+	}
+	if err := checkOps(ops); err != nil {
+		return nil, err
+	}
+
+	responses := make([]*etcdserverpb.ResponseOp, len(ops))
+	var revision int64
+	run := func(i int, guard *distributor.Precondition) error {
+		switch op := ops[i].GetRequest().(type) {
+		case *etcdserverpb.RequestOp_RequestRange:
+			resp, err := s.rangeKeys(ctx, c, op.RequestRange)
+			if err != nil {
+				return err
+			}
+			responses[i] = &etcdserverpb.ResponseOp{Response: &etcdserverpb.ResponseOp_ResponseRange{ResponseRange: resp}}
+		case *etcdserverpb.RequestOp_RequestPut:
+			resp, err := s.put(ctx, c, op.RequestPut, guard)
+			if err != nil {
+				return err
+			}
+			if resp.Header.Revision > revision {
+				revision = resp.Header.Revision
+			}
+			responses[i] = &etcdserverpb.ResponseOp{Response: &etcdserverpb.ResponseOp_ResponsePut{ResponsePut: resp}}
+		case *etcdserverpb.RequestOp_RequestDeleteRange:
+			resp, err := s.deleteRange(ctx, c, op.RequestDeleteRange, guard)
+			if err != nil {
+				return err
+			}
+			responses[i] = &etcdserverpb.ResponseOp{Response: &etcdserverpb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: resp}}
+		}
+		return nil
+	}
+	// 비교한 key 를 쓰는 연산을 먼저 실행한다.
+	guarded := make([]bool, len(ops))
+	for i, op := range ops {
+		if guard := guardFor(op, guards); guard != nil {
+			guarded[i] = true
+			if err := run(i, guard); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i := range ops {
+		if !guarded[i] {
+			if err := run(i, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &etcdserverpb.TxnResponse{Header: s.header(revision), Succeeded: succeeded, Responses: responses}, nil
+}
+
+// checkOps 는 etcd 와 같이 같은 key 를 두 번 쓰는 Txn 을 거부한다. 중첩된 Txn 은 지원하지 않는다.
+func checkOps(ops []*etcdserverpb.RequestOp) error {
+	puts := make(map[string]bool)
+	for _, op := range ops {
+		switch op := op.GetRequest().(type) {
+		case *etcdserverpb.RequestOp_RequestPut:
+			key := string(op.RequestPut.GetKey())
+			if puts[key] {
+				return rpctypes.ErrGRPCDuplicateKey
+			}
+			puts[key] = true
+		case *etcdserverpb.RequestOp_RequestTxn:
+			return status.Error(codes.Unimplemented, "nested txn is not supported")
+		case *etcdserverpb.RequestOp_RequestRange, *etcdserverpb.RequestOp_RequestDeleteRange:
+		default:
+			return status.Error(codes.InvalidArgument, "empty request op")
+		}
+	}
+	return nil
+}
+
+// guardFor 는 op 가 비교한 key 하나를 쓰면 그 key 의 조건을 반환한다.
+func guardFor(op *etcdserverpb.RequestOp, guards map[string]*distributor.Precondition) *distributor.Precondition {
+	switch op := op.GetRequest().(type) {
+	case *etcdserverpb.RequestOp_RequestPut:
+		return guards[string(op.RequestPut.GetKey())]
+	case *etcdserverpb.RequestOp_RequestDeleteRange:
+		if len(op.RequestDeleteRange.GetRangeEnd()) == 0 {
+			return guards[string(op.RequestDeleteRange.GetKey())]
+		}
+	}
+	return nil
+}
+
+// compare 는 범위의 모든 key 가 조건을 만족하는지 확인한다. key 하나를 비교하면 읽은 버전을 guards 에 남긴다.
+func (s *Server) compare(ctx context.Context, c *caller, cmp *etcdserverpb.Compare, guards map[string]*distributor.Precondition) (bool, error) {
+	var kvs []*mvccpb.KeyValue
+	err := s.readRange(ctx, c, cmp.GetKey(), cmp.GetRangeEnd(), "etcd Txn", func(kv *mvccpb.KeyValue) bool {
+		kvs = append(kvs, kv)
+		return true
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(cmp.GetRangeEnd()) == 0 {
+		guard := &distributor.Precondition{IfAbsent: true}
+		if len(kvs) > 0 {
+			guard = &distributor.Precondition{IfVersion: uint64(kvs[0].ModRevision)}
+		}
+		guards[string(cmp.GetKey())] = guard
+	}
+	// etcd 와 같이 key 가 없으면 값 비교는 실패하고 나머지는 0 과 비교한다.
+	if len(kvs) == 0 {
+		if cmp.GetTarget() == etcdserverpb.Compare_VALUE {
+			return false, nil
+		}
+		return compareKV(cmp, &mvccpb.KeyValue{}), nil
+	}
+	for _, kv := range kvs {
+		if !compareKV(cmp, kv) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func compareKV(cmp *etcdserverpb.Compare, kv *mvccpb.KeyValue) bool {
+	var result int
+	switch cmp.GetTarget() {
+	case etcdserverpb.Compare_VALUE:
+		result = bytes.Compare(kv.Value, cmp.GetValue())
+	case etcdserverpb.Compare_VERSION:
+		result = compareInt64(kv.Version, cmp.GetVersion())
+	case etcdserverpb.Compare_CREATE:
+		result = compareInt64(kv.CreateRevision, cmp.GetCreateRevision())
+	case etcdserverpb.Compare_MOD:
+		result = compareInt64(kv.ModRevision, cmp.GetModRevision())
+	case etcdserverpb.Compare_LEASE:
+		result = compareInt64(kv.Lease, cmp.GetLease())
+	}
+	switch cmp.GetResult() {
+	case etcdserverpb.Compare_EQUAL:
+		return result == 0
+	case etcdserverpb.Compare_NOT_EQUAL:
+		return result != 0
+	case etcdserverpb.Compare_GREATER:
+		return result > 0
+	case etcdserverpb.Compare_LESS:
+		return result < 0
+	}
+	return false
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (s *Server) rangeKeys(ctx context.Context, c *caller, req *etcdserverpb.RangeRequest) (*etcdserverpb.RangeResponse, error) {
+	if req.GetRevision() > 0 {
+		return nil, status.Error(codes.Unimplemented, "reading at a past revision is not supported")
+	}
+	// key 의 오름차순이 아닌 정렬은 범위를 모두 읽은 뒤에 정렬하고 limit 을 적용한다.
+	// etcd 와 같이 key 가 아닌 대상의 정렬 순서가 없으면 오름차순이다.
+	sorted := req.GetSortTarget() != etcdserverpb.RangeRequest_KEY || req.GetSortOrder() == etcdserverpb.RangeRequest_DESCEND
+	limit := int(req.GetLimit())
+	resp := &etcdserverpb.RangeResponse{}
+	err := s.readRange(ctx, c, req.GetKey(), req.GetRangeEnd(), "etcd Range", func(kv *mvccpb.KeyValue) bool {
+		if !revisionInRange(kv.ModRevision, req.GetMinModRevision(), req.GetMaxModRevision()) ||
+			!revisionInRange(kv.CreateRevision, req.GetMinCreateRevision(), req.GetMaxCreateRevision()) {
+			return true
+		}
+		// etcd 와 같이 count 는 limit 과 관계없이 범위의 모든 key 의 수이다.
+		resp.Count++
+		if req.GetCountOnly() {
+			return true
+		}
+		if !sorted && limit > 0 && len(resp.Kvs) >= limit {
+			resp.More = true
+			return true
+		}
+		resp.Kvs = append(resp.Kvs, kv)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sorted {
+		sortKVs(resp.Kvs, req.GetSortTarget(), req.GetSortOrder() == etcdserverpb.RangeRequest_DESCEND)
+		if limit > 0 && len(resp.Kvs) > limit {
+			resp.Kvs = resp.Kvs[:limit]
+			resp.More = true
+		}
+	}
+	if req.GetKeysOnly() {
+		for _, kv := range resp.Kvs {
+			kv.Value = nil
+		}
+	}
+	resp.Header = s.header(0)
+	return resp, nil
+}
+
+func revisionInRange(revision, min, max int64) bool {
+	return (min == 0 || revision >= min) && (max == 0 || revision <= max)
+}
+
+func sortKVs(kvs []*mvccpb.KeyValue, target etcdserverpb.RangeRequest_SortTarget, descend bool) {
+	less := func(a, b *mvccpb.KeyValue) bool {
+		switch target {
+		case etcdserverpb.RangeRequest_VERSION:
+			return a.Version < b.Version
+		case etcdserverpb.RangeRequest_CREATE:
+			return a.CreateRevision < b.CreateRevision
+		case etcdserverpb.RangeRequest_MOD:
+			return a.ModRevision < b.ModRevision
+		case etcdserverpb.RangeRequest_VALUE:
+			return bytes.Compare(a.Value, b.Value) < 0
+		}
+		return bytes.Compare(a.Key, b.Key) < 0
+	}
+	sort.SliceStable(kvs, func(i, j int) bool {
+		if descend {
+			return less(kvs[j], kvs[i])
+		}
+		return less(kvs[i], kvs[j])
+	})
+}
+
+// readRange 는 [key, rangeEnd) 의 key 를 key 순서대로 fn 에 넘긴다. rangeEnd 가 비어 있으면 key 하나만 읽는다.
+// fn 이 false 를 반환하면 멈춘다.
+func (s *Server) readRange(ctx context.Context, c *caller, key, rangeEnd []byte, action string, fn func(*mvccpb.KeyValue) bool) error {
+	if len(key) == 0 {
+		return rpctypes.ErrGRPCEmptyKey
+	}
+	if len(rangeEnd) == 0 {
+		m, k, err := s.locate(key)
+		if err != nil {
+			return err
+		}
+		bucketName, err := s.bucketName(c, m, auth.PermissionRead, action)
+		if err != nil {
+			return err
+		}
+		current, err := s.dist.GetVersioned(ctx, bucketName, k)
+		if errors.Is(err, distributor.ErrKeyValueNotFound) {
+			return nil
+		}
+		if err != nil {
+			return grpcError(err)
+		}
+		fn(toKeyValue(key, current.Value, current.Version(), current.CreatedAt.UnixNano()))
+		return nil
+	}
+	for _, seg := range s.segments(key, rangeEnd) {
+		bucketName, err := s.bucketName(c, seg.mapping, auth.PermissionRead, action)
+		if err != nil {
+			return err
+		}
+		stopped, err := s.scanSegment(ctx, bucketName, seg, func(entry *distributor.Entry) bool {
+			fullKey := append(append([]byte(nil), seg.mapping.prefix...), entry.Key...)
+			return fn(toKeyValue(fullKey, entry.Value, entry.Version, entry.CreatedAt.UnixNano()))
+		})
+		if err != nil {
+			return grpcError(err)
+		}
+		if stopped {
+			return nil
+		}
+	}
+	return nil
+}
+
+// scanSegment 는 segment 의 key 를 key 순서대로 fn 에 넘기고, fn 이 멈췄는지 반환한다.
+func (s *Server) scanSegment(ctx context.Context, bucketName []byte, seg *segment, fn func(*distributor.Entry) bool) (bool, error) {
+	var prefix []byte
+	if seg.end != nil {
+		prefix = commonPrefix(seg.start, seg.end)
+	}
+	after := scanAfter(seg.start)
+	for {
+		entries, more, err := s.dist.Scan(ctx, bucketName, prefix, after, scanPageSize)
+		if err != nil {
+			return false, err
+		}
+		for _, entry := range entries {
+			if bytes.Compare(entry.Key, seg.start) < 0 {
+				continue
+			}
+			if seg.end != nil && bytes.Compare(entry.Key, seg.end) >= 0 {
+				return false, nil
+			}
+			if !fn(entry) {
+				return true, nil
+			}
+		}
+		if !more || len(entries) == 0 {
+			return false, nil
+		}
+		after = entries[len(entries)-1].Key
+	}
+}
+
+// scanAfter 는 Scan 이 start 이상인 key 부터 읽도록 start 보다 작은 key 를 만든다.
+// 그 key 와 start 사이의 key 는 호출하는 쪽에서 건너뛴다.
+func scanAfter(start []byte) []byte {
+	if len(start) == 0 {
+		return nil
+	}
+	after := append([]byte(nil), start...)
+	last := len(after) - 1
+	if after[last] == 0 {
+		return after[:last]
+	}
+	after[last]--
+	return after
+}
+
+func commonPrefix(a, b []byte) []byte {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return a[:n]
+}
+
+// toKeyValue 는 dbolt 의 값을 etcd 의 KeyValue 로 바꾼다. key 의 수정 횟수는 기록하지 않으므로 version 은 항상 1 이다.
+func toKeyValue(key, value []byte, version uint64, createdAt int64) *mvccpb.KeyValue {
+	return &mvccpb.KeyValue{
+		Key:            key,
+		Value:          value,
+		ModRevision:    int64(version),
+		CreateRevision: createdAt,
+		Version:        1,
+	}
+}
+
+// put 은 key 를 쓴다. guard 가 있으면 guard 를 만족할 때만 쓰고, 아니면 errTxnConflict 를 반환한다.
+// prev_kv 나 ignore_value 가 있으면 이전 값을 읽고 그 버전일 때만 쓰며, 다른 쓰기와 겹치면 다시 시도한다.
+func (s *Server) put(ctx context.Context, c *caller, req *etcdserverpb.PutRequest, guard *distributor.Precondition) (*etcdserverpb.PutResponse, error) {
+	if len(req.GetKey()) == 0 {
+		return nil, rpctypes.ErrGRPCEmptyKey
+	}
+	if req.GetLease() != 0 {
+		return nil, status.Error(codes.Unimplemented, "leases are not supported")
+	}
+	m, key, err := s.locate(req.GetKey())
+	if err != nil {
+		return nil, err
+	}
+	bucketName, err := s.bucketName(c, m, auth.PermissionWrite, "etcd Put")
+	if err != nil {
+		return nil, err
+	}
+	if !req.GetPrevKv() && !req.GetIgnoreValue() {
+		version, err := s.dist.PutIf(ctx, bucketName, key, req.GetValue(), guard)
+		if errors.Is(err, distributor.ErrPreconditionFailed) {
+			return nil, errTxnConflict
+		}
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		return &etcdserverpb.PutResponse{Header: s.header(int64(version))}, nil
+	}
+
+	for attempt := 0; attempt < maxWriteRetries; attempt++ {
+		current, err := s.dist.GetVersioned(ctx, bucketName, key)
+		if err != nil && !errors.Is(err, distributor.ErrKeyValueNotFound) {
+			return nil, grpcError(err)
+		}
+		cond := &distributor.Precondition{IfAbsent: true}
+		if current != nil {
+			cond = &distributor.Precondition{IfVersion: current.Version()}
+		}
+		if guard != nil && *guard != *cond {
+			return nil, errTxnConflict
+		}
+		value := req.GetValue()
+		if req.GetIgnoreValue() {
+			if current == nil {
+				return nil, rpctypes.ErrGRPCKeyNotFound
+			}
+			value = current.Value
+		}
+		version, err := s.dist.PutIf(ctx, bucketName, key, value, cond)
+		if errors.Is(err, distributor.ErrPreconditionFailed) {
+			if guard != nil {
+				return nil, errTxnConflict
+			}
+			continue
+		}
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		resp := &etcdserverpb.PutResponse{Header: s.header(int64(version))}
+		if req.GetPrevKv() && current != nil {
+			resp.PrevKv = toKeyValue(req.GetKey(), current.Value, current.Version(), current.CreatedAt.UnixNano())
+		}
+		return resp, nil
+	}
+	return nil, status.Error(codes.Aborted, "too many concurrent writes to the key, retry")
+}
+
+// deleteRange 는 범위의 key 를 지운다. 여러 key 를 지우는 것은 원자적이지 않다.
+func (s *Server) deleteRange(ctx context.Context, c *caller, req *etcdserverpb.DeleteRangeRequest, guard *distributor.Precondition) (*etcdserverpb.DeleteRangeResponse, error) {
+	if len(req.GetKey()) == 0 {
+		return nil, rpctypes.ErrGRPCEmptyKey
+	}
+	resp := &etcdserverpb.DeleteRangeResponse{}
+	if len(req.GetRangeEnd()) == 0 {
+		m, key, err := s.locate(req.GetKey())
+		if err != nil {
+			return nil, err
+		}
+		bucketName, err := s.bucketName(c, m, auth.PermissionWrite, "etcd DeleteRange")
+		if err != nil {
+			return nil, err
+		}
+		deleted, err := s.deleteKey(ctx, bucketName, key, guard)
+		if err != nil {
+			return nil, err
+		}
+		if deleted != nil {
+			resp.Deleted = 1
+			if req.GetPrevKv() {
+				resp.PrevKvs = []*mvccpb.KeyValue{toKeyValue(req.GetKey(), deleted.Value, deleted.Version(), deleted.CreatedAt.UnixNano())}
+			}
+		}
+		resp.Header = s.header(0)
+		return resp, nil
+	}
+
+	for _, seg := range s.segments(req.GetKey(), req.GetRangeEnd()) {
+		bucketName, err := s.bucketName(c, seg.mapping, auth.PermissionWrite, "etcd DeleteRange")
+		if err != nil {
+			return nil, err
+		}
+		var entries []*distributor.Entry
+		if _, err := s.scanSegment(ctx, bucketName, seg, func(entry *distributor.Entry) bool {
+			entries = append(entries, entry)
+			return true
+		}); err != nil {
+			return nil, grpcError(err)
+		}
+		for _, entry := range entries {
+			err := s.dist.DeleteIf(ctx, bucketName, entry.Key, &distributor.Precondition{IfVersion: entry.Version})
+			if errors.Is(err, distributor.ErrPreconditionFailed) {
+				// 읽은 뒤에 바뀌었거나 지워졌으면 현재 값을 지운다.
+				deleted, err := s.deleteKey(ctx, bucketName, entry.Key, nil)
+				if err != nil {
+					return nil, err
+				}
+				if deleted == nil {
+					continue
+				}
+				entry = &distributor.Entry{Key: entry.Key, Value: deleted.Value, Version: deleted.Version(), CreatedAt: deleted.CreatedAt}
+			} else if err != nil {
+				return nil, grpcError(err)
+			}
+			resp.Deleted++
+			if req.GetPrevKv() {
+				fullKey := append(append([]byte(nil), seg.mapping.prefix...), entry.Key...)
+				resp.PrevKvs = append(resp.PrevKvs, toKeyValue(fullKey, entry.Value, entry.Version, entry.CreatedAt.UnixNano()))
+			}
+		}
+	}
+	resp.Header = s.header(0)
+	return resp, nil
+}
+
+// deleteKey 는 key 를 지우고 지운 값을 반환한다. key 가 없으면 nil 을 반환한다.
+// guard 가 있으면 guard 를 만족할 때만 지우고, 아니면 errTxnConflict 를 반환한다.
+func (s *Server) deleteKey(ctx context.Context, bucketName, key []byte, guard *distributor.Precondition) (*distributor.VersionedValue, error) {
+	for attempt := 0; attempt < maxWriteRetries; attempt++ {
+		current, err := s.dist.GetVersioned(ctx, bucketName, key)
+		if errors.Is(err, distributor.ErrKeyValueNotFound) {
+			if guard != nil && !guard.IfAbsent {
+				return nil, errTxnConflict
+			}
+			return nil, nil
+		}
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		if guard != nil && guard.IfVersion != current.Version() {
+			return nil, errTxnConflict
+		}
+		err = s.dist.DeleteIf(ctx, bucketName, key, &distributor.Precondition{IfVersion: current.Version()})
+		if errors.Is(err, distributor.ErrPreconditionFailed) {
+			if guard != nil {
+				return nil, errTxnConflict
+			}
+			continue
+		}
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		return current, nil
+	}
+	return nil, status.Error(codes.Aborted, "too many concurrent writes to the key, retry")
+}
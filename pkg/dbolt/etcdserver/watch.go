@@ -0,0 +1,308 @@
+package etcdserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/changes"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.uber.org/zap"
+)
+
+// watchServer 는 etcd 의 Watch API 이다. KV 와 같은 이름의 method 가 없도록 Server 와 나눈다.
+//
+// 클러스터의 변경 기록(changes.Service.Cluster)을 주기적으로 읽어서 보내므로 쓰기보다 늦게 도착하고,
+// 변경 기록에 남아 있는 범위에서만 start_revision 이전의 변경을 다시 볼 수 있다. prev_kv 와 progress_notify 는 지원하지 않는다.
+type watchServer struct {
+	s *Server
+}
+
+func (w *watchServer) Watch(stream etcdserverpb.Watch_WatchServer) error {
+	c, err := w.s.authenticate(stream.Context(), "etcd Watch")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	ws := &watchStream{
+		s:        w.s,
+		c:        c,
+		ctx:      ctx,
+		stream:   stream,
+		watchers: make(map[int64]context.CancelFunc),
+	}
+	defer ws.wg.Wait()
+	defer cancel()
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch r := req.GetRequestUnion().(type) {
+		case *etcdserverpb.WatchRequest_CreateRequest:
+			if err := ws.create(r.CreateRequest); err != nil {
+				return err
+			}
+		case *etcdserverpb.WatchRequest_CancelRequest:
+			if err := ws.cancel(r.CancelRequest.GetWatchId()); err != nil {
+				return err
+			}
+		case *etcdserverpb.WatchRequest_ProgressRequest:
+			if err := ws.send(ctx, &etcdserverpb.WatchResponse{Header: w.s.header(0), WatchId: -1}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watchStream 은 Watch 스트림 하나에서 만든 watcher 들이다.
+type watchStream struct {
+	s      *Server
+	c      *caller
+	ctx    context.Context
+	stream etcdserverpb.Watch_WatchServer
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	watchers map[int64]context.CancelFunc
+	nextID   int64
+	sendMu   sync.Mutex
+}
+
+// send 는 ctx 가 끝나지 않았을 때만 응답을 보낸다. cancel 의 응답 뒤에 그 watcher 의 이벤트가 가지 않도록 sendMu 안에서 확인한다.
+func (ws *watchStream) send(ctx context.Context, resp *etcdserverpb.WatchResponse) error {
+	ws.sendMu.Lock()
+	defer ws.sendMu.Unlock()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return ws.stream.Send(resp)
+}
+
+func (ws *watchStream) create(req *etcdserverpb.WatchCreateRequest) error {
+	ws.mu.Lock()
+	id := req.GetWatchId()
+	if id == 0 {
+		for ws.watchers[ws.nextID] != nil {
+			ws.nextID++
+		}
+		id = ws.nextID
+		ws.nextID++
+	}
+	_, duplicated := ws.watchers[id]
+	ws.mu.Unlock()
+
+	created := &etcdserverpb.WatchResponse{Header: ws.s.header(0), WatchId: id, Created: true}
+	if duplicated {
+		created.Canceled = true
+		created.CancelReason = "duplicate watch id"
+		return ws.send(ws.ctx, created)
+	}
+	if err := ws.check(req); err != nil {
+		created.Canceled = true
+		created.CancelReason = err.Error()
+		return ws.send(ws.ctx, created)
+	}
+
+	ctx, cancel := context.WithCancel(ws.ctx)
+	ws.mu.Lock()
+	ws.watchers[id] = cancel
+	ws.mu.Unlock()
+	if err := ws.send(ctx, created); err != nil {
+		cancel()
+		return err
+	}
+	start := req.GetStartRevision()
+	if start <= 0 {
+		start = time.Now().UnixNano()
+	}
+	w := &watcher{
+		ws:       ws,
+		id:       id,
+		key:      req.GetKey(),
+		rangeEnd: req.GetRangeEnd(),
+		start:    start,
+		last:     make(map[string]lastEvent),
+	}
+	for _, filter := range req.GetFilters() {
+		switch filter {
+		case etcdserverpb.WatchCreateRequest_NOPUT:
+			w.noPut = true
+		case etcdserverpb.WatchCreateRequest_NODELETE:
+			w.noDelete = true
+		}
+	}
+	ws.wg.Add(1)
+	go func() {
+		defer ws.wg.Done()
+		ws.s.metrics.watchers.Inc()
+		defer ws.s.metrics.watchers.Dec()
+		w.run(ctx)
+	}()
+	return nil
+}
+
+// check 는 watch 할 범위의 bucket 을 읽을 권한이 있는지 확인한다.
+func (ws *watchStream) check(req *etcdserverpb.WatchCreateRequest) error {
+	if len(req.GetKey()) == 0 {
+		return errors.New("key required")
+	}
+	if len(req.GetRangeEnd()) == 0 {
+		m, _, err := ws.s.locate(req.GetKey())
+		if err != nil {
+			return err
+		}
+		_, err = ws.s.bucketName(ws.c, m, auth.PermissionRead, "etcd Watch")
+		return err
+	}
+	for _, seg := range ws.s.segments(req.GetKey(), req.GetRangeEnd()) {
+		if _, err := ws.s.bucketName(ws.c, seg.mapping, auth.PermissionRead, "etcd Watch"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ws *watchStream) cancel(id int64) error {
+	ws.mu.Lock()
+	cancel, ok := ws.watchers[id]
+	delete(ws.watchers, id)
+	ws.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	ws.sendMu.Lock()
+	cancel()
+	ws.sendMu.Unlock()
+	return ws.send(ws.ctx, &etcdserverpb.WatchResponse{Header: ws.s.header(0), WatchId: id, Canceled: true})
+}
+
+// stop 은 watcher 가 스스로 끝날 때 client 에 이유를 알린다.
+func (ws *watchStream) stop(ctx context.Context, id int64, reason string) {
+	ws.mu.Lock()
+	cancel, ok := ws.watchers[id]
+	delete(ws.watchers, id)
+	ws.mu.Unlock()
+	if !ok {
+		return
+	}
+	_ = ws.send(ctx, &etcdserverpb.WatchResponse{Header: ws.s.header(0), WatchId: id, Canceled: true, CancelReason: reason})
+	cancel()
+}
+
+// lastEvent 는 key 마다 마지막으로 보낸 이벤트이다. replica 마다 기록된 같은 변경을 다시 보내지 않는 데 사용한다.
+type lastEvent struct {
+	revision int64
+	deleted  bool
+}
+
+type watcher struct {
+	ws       *watchStream
+	id       int64
+	key      []byte
+	rangeEnd []byte
+	start    int64
+	noPut    bool
+	noDelete bool
+	last     map[string]lastEvent
+}
+
+// run 은 클러스터의 변경 기록을 처음부터 읽어서 start 이후의 변경을 보낸다. 새 변경이 없으면 poll interval 만큼 기다린다.
+func (w *watcher) run(ctx context.Context) {
+	cursor := make(changes.Cursor)
+	for {
+		page, err := w.ws.s.changes.Cluster(ctx, w.ws.c.tenantID, cursor, changes.MaxLimit)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.ws.s.logger.Warn("Failed to read the changes for etcd watch.", zap.Int64("watchID", w.id), zap.Error(err))
+				w.ws.stop(ctx, w.id, err.Error())
+			}
+			return
+		}
+		if events := w.events(page.Changes); len(events) > 0 {
+			resp := &etcdserverpb.WatchResponse{
+				Header:  w.ws.s.header(events[len(events)-1].Kv.ModRevision),
+				WatchId: w.id,
+				Events:  events,
+			}
+			if err := w.ws.send(ctx, resp); err != nil {
+				return
+			}
+		}
+		if page.Next != cursor.String() {
+			if cursor, err = changes.ParseCursor(page.Next); err != nil {
+				w.ws.stop(ctx, w.id, err.Error())
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.ws.s.cfg.watchPollInterval()):
+		}
+	}
+}
+
+func (w *watcher) events(cs []changes.Change) []*mvccpb.Event {
+	var events []*mvccpb.Event
+	for _, change := range cs {
+		m := w.ws.s.mappingOf([]byte(change.Bucket))
+		if m == nil {
+			continue
+		}
+		key := append(append([]byte(nil), m.prefix...), change.Key...)
+		if !w.matches(key) {
+			continue
+		}
+		deleted := change.Operation != store.ChangePut.String()
+		revision := change.Version
+		if deleted {
+			revision = change.CommittedAt.UnixNano()
+		}
+		last := w.last[string(key)]
+		if revision < w.start || revision <= last.revision || (deleted && last.deleted) {
+			continue
+		}
+		w.last[string(key)] = lastEvent{revision: revision, deleted: deleted}
+		if (deleted && w.noDelete) || (!deleted && w.noPut) {
+			continue
+		}
+		event := &mvccpb.Event{Type: mvccpb.PUT, Kv: toKeyValue(key, change.Value, uint64(revision), revision)}
+		if deleted {
+			event = &mvccpb.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: key, ModRevision: revision}}
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func (w *watcher) matches(key []byte) bool {
+	if len(w.rangeEnd) == 0 {
+		return bytes.Equal(key, w.key)
+	}
+	if bytes.Compare(key, w.key) < 0 {
+		return false
+	}
+	unbounded := len(w.rangeEnd) == 1 && w.rangeEnd[0] == 0
+	return unbounded || bytes.Compare(key, w.rangeEnd) < 0
+}
+
+// mappingOf 는 bucket 에 대응하는 prefix 를 찾는다.
+func (s *Server) mappingOf(bucket []byte) *mapping {
+	for _, m := range s.mappings {
+		if bytes.Equal(m.bucket, bucket) {
+			return m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package dbolt
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix 는 설정을 덮어쓰는 환경 변수의 접두사이다. lifecycler.ring.replication_factor 는 DBOLT_LIFECYCLER_RING_REPLICATION_FACTOR 이다.
+const EnvPrefix = "DBOLT_"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// RegisterFlags 는 Config 의 모든 설정을 yaml 경로로 된 flag 로 등록한다. lifecycler.ring.replication_factor 는 -lifecycler.ring.replication-factor 이다.
+// flag 의 기본값은 등록할 때의 값이다. map 과 struct 의 slice 는 설정 파일로만 정할 수 있다.
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	registerFlags(f, "", reflect.ValueOf(c).Elem())
+}
+
+// EnvName 은 flag 이름에 해당하는 환경 변수 이름이다.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(flagName))
+}
+
+func registerFlags(f *flag.FlagSet, prefix string, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		inline := strings.Contains(field.Tag.Get("yaml"), ",inline")
+		if tag == "-" {
+			continue
+		}
+		if tag == "" && !inline {
+			// yaml tag 가 없으면 yaml.v2 처럼 소문자로 바꾼 필드 이름을 쓴다. (lifecycler 의 id, port)
+			tag = strings.ToLower(field.Name)
+		}
+		name := prefix
+		if !inline {
+			name += strings.ReplaceAll(tag, "_", "-")
+		}
+		fv := v.Field(i)
+		if value := flagValue(fv); value != nil {
+			f.Var(value, name, "env "+EnvName(name))
+			continue
+		}
+		if fv.Kind() == reflect.Struct {
+			if inline {
+				registerFlags(f, name, fv)
+			} else {
+				registerFlags(f, name+".", fv)
+			}
+		}
+	}
+}
+
+// flagValue 는 설정 값을 flag.Value 로 감싼다. flag 로 정할 수 없는 값이면 nil 이다.
+// 문자열 slice 는 flag.Value 를 구현하더라도 쉼표로 구분한 목록으로 통째로 바꾼다. 환경 변수와 같은 방식으로 쓰기 위해서이다.
+func flagValue(v reflect.Value) flag.Value {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String {
+		return &listValue{v: v}
+	}
+	if value, ok := v.Addr().Interface().(flag.Value); ok {
+		return value
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &scalarValue{v: v}
+	}
+	return nil
+}
+
+type scalarValue struct {
+	v reflect.Value
+}
+
+func (s *scalarValue) String() string {
+	if !s.v.IsValid() {
+		return ""
+	}
+	if s.v.Type() == durationType {
+		return time.Duration(s.v.Int()).String()
+	}
+	return fmt.Sprint(s.v.Interface())
+}
+
+func (s *scalarValue) Set(str string) error {
+	switch {
+	case s.v.Type() == durationType:
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return err
+		}
+		s.v.SetInt(int64(d))
+	case s.v.Kind() == reflect.String:
+		s.v.SetString(str)
+	case s.v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return err
+		}
+		s.v.SetBool(b)
+	case s.v.CanFloat():
+		n, err := strconv.ParseFloat(str, s.v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		s.v.SetFloat(n)
+	case s.v.CanInt():
+		n, err := strconv.ParseInt(str, 0, s.v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		s.v.SetInt(n)
+	default:
+		n, err := strconv.ParseUint(str, 0, s.v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		s.v.SetUint(n)
+	}
+	return nil
+}
+
+// IsBoolFlag 는 bool 설정을 -flag 처럼 값 없이 켤 수 있게 한다.
+func (s *scalarValue) IsBoolFlag() bool {
+	return s.v.IsValid() && s.v.Kind() == reflect.Bool
+}
+
+type listValue struct {
+	v reflect.Value
+}
+
+func (l *listValue) String() string {
+	if !l.v.IsValid() {
+		return ""
+	}
+	items := make([]string, l.v.Len())
+	for i := range items {
+		items[i] = l.v.Index(i).String()
+	}
+	return strings.Join(items, ",")
+}
+
+func (l *listValue) Set(str string) error {
+	var items []string
+	if str != "" {
+		items = strings.Split(str, ",")
+	}
+	list := reflect.MakeSlice(l.v.Type(), len(items), len(items))
+	for i, item := range items {
+		list.Index(i).SetString(strings.TrimSpace(item))
+	}
+	l.v.Set(list)
+	return nil
+}
@@ -0,0 +1,203 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+
+	"github.com/grafana/dskit/user"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/dboltpb"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tlsconfig"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type Server struct {
+	dboltpb.UnimplementedDBoltServer
+
+	addr       string
+	grpcServer *grpc.Server
+	localStore *store.LocalStore
+	tenants    *tenant.Service
+	auth       *auth.Service
+	logger     *zap.Logger
+}
+
+func New(addr string, serverTLS *tlsconfig.Server, localStore *store.LocalStore, tenants *tenant.Service, authService *auth.Service, logger *zap.Logger) *Server {
+	var opts []grpc.ServerOption
+	if serverTLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(serverTLS.Config())))
+	}
+	s := &Server{
+		addr:       addr,
+		grpcServer: grpc.NewServer(opts...),
+		localStore: localStore,
+		tenants:    tenants,
+		auth:       authService,
+		logger:     logger,
+	}
+	dboltpb.RegisterDBoltServer(s.grpcServer, s)
+	return s
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen : addr=%s", s.addr)
+	}
+	s.logger.Info("Starting gRPC server.", zap.String("bindAddress", s.addr))
+	go func() {
+		if err := s.grpcServer.Serve(lis); err != nil {
+			s.logger.Error("gRPC server stopped.", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+func (s *Server) Stop(ctx context.Context) error {
+	s.logger.Info("Stopping gRPC server.")
+	stopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		// watch 스트림은 끝나지 않으므로 기다리지 않고 끊는다.
+		s.grpcServer.Stop()
+	}
+	return nil
+}
+
+func (s *Server) Watch(req *dboltpb.WatchRequest, stream dboltpb.DBolt_WatchServer) error {
+	if len(req.GetBucket()) == 0 {
+		return status.Error(codes.InvalidArgument, "bucket required")
+	}
+	if err := distributor.CheckBucket(req.GetBucket()); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	principal, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	bucketName, err := s.bucketName(stream.Context(), principal, req.GetBucket())
+	if err != nil {
+		return err
+	}
+	if err := s.authorize(principal, bucketName, auth.PermissionRead); err != nil {
+		return err
+	}
+	events, errc := s.localStore.Watch(stream.Context(), store.WatchRequest{
+		BucketName:    bucketName,
+		Key:           req.GetKey(),
+		Prefix:        req.GetPrefix(),
+		StartRevision: req.GetStartRevision(),
+	})
+	for event := range events {
+		watchEvent, err := toWatchEvent(event)
+		if err != nil {
+			return status.Error(codes.DataLoss, err.Error())
+		}
+		watchEvent.Bucket = req.GetBucket()
+		if err := stream.Send(watchEvent); err != nil {
+			return err
+		}
+	}
+	if err := <-errc; err != nil {
+		if errors.Is(err, store.ErrRevisionCompacted) {
+			return status.Error(codes.OutOfRange, err.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+const watchAction = "grpc Watch"
+
+// authenticate 는 인증이 켜져 있으면 metadata 의 authorization 또는 client 인증서로 인증한다.
+// 인증이 꺼져 있으면 nil 을 반환한다.
+func (s *Server) authenticate(ctx context.Context) (*auth.Principal, error) {
+	if !s.auth.Enabled() {
+		return nil, nil
+	}
+	req := new(auth.Request)
+	if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+		req.BearerToken = auth.BearerToken(values[0])
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			req.PeerCertificates = tlsInfo.State.PeerCertificates
+		}
+	}
+	principal, err := s.auth.Authenticate(req)
+	if err != nil {
+		s.auth.Unauthenticated(watchAction, err)
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return principal, nil
+}
+
+// authorize 는 principal 이 저장소의 bucket 이름(tenancy 가 켜져 있으면 tenant/bucket)에 permission 을 가지고 있는지 확인한다.
+func (s *Server) authorize(principal *auth.Principal, bucketName []byte, permission auth.Permission) error {
+	if !s.auth.Enabled() {
+		return nil
+	}
+	if err := s.auth.Authorize(principal, string(bucketName), permission, watchAction); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// bucketName 은 tenancy 가 켜져 있으면 tenant 를 bucket 에 붙이고 tenant 의 요청 속도 제한을 적용한다.
+// principal 에 tenant 가 있으면 metadata 의 tenant 대신 그 tenant 를 사용한다.
+func (s *Server) bucketName(ctx context.Context, principal *auth.Principal, bucket []byte) ([]byte, error) {
+	if !s.tenants.Enabled() {
+		return bucket, nil
+	}
+	if principal != nil && principal.Tenant != "" {
+		ctx = tenant.Inject(ctx, principal.Tenant)
+	} else {
+		_, ctx, _ = user.ExtractFromGRPCRequest(ctx)
+	}
+	tenantID, err := tenant.ID(ctx)
+	if errors.Is(err, tenant.ErrNoTenant) {
+		return nil, status.Error(codes.Unauthenticated, "tenant required")
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !s.tenants.Allow(tenantID) {
+		return nil, status.Error(codes.ResourceExhausted, "request rate limit exceeded : tenant="+tenantID)
+	}
+	return tenant.Bucket(tenantID, bucket), nil
+}
+
+func toWatchEvent(event *store.ChangeEvent) (*dboltpb.WatchEvent, error) {
+	watchEvent := &dboltpb.WatchEvent{
+		Revision: event.Revision,
+		Type:     dboltpb.WatchEvent_PUT,
+		Bucket:   event.BucketName,
+		Key:      event.Key,
+	}
+	if event.Type == store.ChangeDelete {
+		watchEvent.Type = dboltpb.WatchEvent_DELETE
+		return watchEvent, nil
+	}
+	versionedValue, err := distributor.ParseVersionedValue(event.Value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid stored value : revision=%d", event.Revision)
+	}
+	watchEvent.Value = versionedValue.Value
+	watchEvent.UpdatedAtUnixNano = versionedValue.UpdatedAt.UnixNano()
+	return watchEvent, nil
+}
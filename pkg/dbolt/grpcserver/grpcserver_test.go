@@ -0,0 +1,92 @@
+package grpcserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth/authtest"
+	"github.com/kwSeo/dbolt/pkg/dbolt/dboltpb"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor/distributortest"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func newTestServer(t *testing.T, authCfg *auth.Config, tenantCfg *tenant.Config) (*Server, *distributortest.Cluster) {
+	t.Helper()
+	cluster := distributortest.New(t, &distributor.Config{})
+	reg := prometheus.NewRegistry()
+	authService, err := auth.New(authCfg, zap.NewNop())
+	require.NoError(t, err)
+	tenants := tenant.New(tenantCfg, tenant.NewQuotas(tenantCfg, tenant.NewOverrides(tenantCfg), reg), cluster.Ring, cluster.StorePool, cluster.LocalStore, reg, zap.NewNop())
+	return New("", nil, cluster.LocalStore, tenants, authService, zap.NewNop()), cluster
+}
+
+// watchStream 은 Watch 가 보낸 첫 번째 event 를 받으면 스트림을 끝낸다.
+type watchStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	cancel context.CancelFunc
+	event  *dboltpb.WatchEvent
+}
+
+func newWatchStream(token, tenantID string) *watchStream {
+	md := metadata.Pairs("authorization", "Bearer "+token, tenant.HeaderName, tenantID)
+	ctx, cancel := context.WithCancel(metadata.NewIncomingContext(context.Background(), md))
+	return &watchStream{ctx: ctx, cancel: cancel}
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *watchStream) Send(event *dboltpb.WatchEvent) error {
+	s.event = event
+	s.cancel()
+	return nil
+}
+
+// watch 는 bucket 의 첫 번째 변경을 읽는다.
+func (s *Server) watch(token, tenantID string) (*dboltpb.WatchEvent, error) {
+	stream := newWatchStream(token, tenantID)
+	defer stream.cancel()
+	err := s.Watch(&dboltpb.WatchRequest{Bucket: []byte("bucket"), Key: []byte("k"), StartRevision: 1}, stream)
+	if stream.event != nil {
+		return stream.event, nil
+	}
+	return nil, err
+}
+
+func TestTenantAuthorization(t *testing.T) {
+	s, cluster := newTestServer(t, authtest.Config(), &tenant.Config{Enabled: true})
+	ctx := context.Background()
+	require.NoError(t, cluster.Distributor.Put(ctx, tenant.Bucket(authtest.Tenant, []byte("bucket")), []byte("k"), []byte("team-a")))
+	require.NoError(t, cluster.Distributor.Put(ctx, tenant.Bucket(authtest.OtherTenant, []byte("bucket")), []byte("k"), []byte("team-b")))
+
+	_, err := s.watch("invalid", authtest.Tenant)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// tenant 가 없는 principal 은 metadata 로 tenant 를 고르지만 그 tenant 의 bucket 에 권한이 있어야 한다.
+	event, err := s.watch(authtest.UnboundToken, authtest.Tenant)
+	require.NoError(t, err)
+	require.Equal(t, []byte("team-a"), event.Value)
+	_, err = s.watch(authtest.UnboundToken, authtest.OtherTenant)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// tenant 에 묶인 principal 은 metadata 의 tenant 대신 자기 tenant 를 사용한다.
+	event, err = s.watch(authtest.BoundToken, authtest.OtherTenant)
+	require.NoError(t, err)
+	require.Equal(t, []byte("team-a"), event.Value)
+
+	event, err = s.watch(authtest.AdminToken, authtest.OtherTenant)
+	require.NoError(t, err)
+	require.Equal(t, []byte("team-b"), event.Value)
+	require.Equal(t, []byte("bucket"), event.Bucket)
+}
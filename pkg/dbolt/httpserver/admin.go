@@ -0,0 +1,154 @@
+package httpserver
+
+import (
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/grafana/dskit/ring"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/pkg/errors"
+)
+
+// StorePoolEntry 는 store pool 에 등록된 Store 또는 Ring 에는 있지만 아직 등록되지 않은 인스턴스이다.
+type StorePoolEntry struct {
+	Addr string `json:"addr"`
+	// Type 은 local, http 중 하나이며 등록되지 않았으면 비어 있다.
+	Type string `json:"type,omitempty"`
+	URL  string `json:"url,omitempty"`
+	// Registered 가 false 이면 다음 동기화 때 등록된다.
+	Registered bool `json:"registered"`
+	// Healthy 는 Ring 에서 heartbeat 가 제때 오는 ACTIVE 인스턴스인지이다.
+	Healthy bool `json:"healthy"`
+}
+
+type NodeResponse struct {
+	ID        string            `json:"id"`
+	Addr      string            `json:"addr"`
+	Zone      string            `json:"zone,omitempty"`
+	State     string            `json:"state"`
+	Tokens    []uint32          `json:"tokens"`
+	StartedAt time.Time         `json:"startedAt"`
+	Storage   store.EngineStats `json:"storage"`
+	Build     BuildInfo         `json:"build"`
+}
+
+type BuildInfo struct {
+	GoVersion    string `json:"goVersion"`
+	Module       string `json:"module,omitempty"`
+	Version      string `json:"version,omitempty"`
+	Revision     string `json:"revision,omitempty"`
+	RevisionTime string `json:"revisionTime,omitempty"`
+	Modified     bool   `json:"modified,omitempty"`
+}
+
+// getAdminRing 은 dskit 의 Ring 상태 페이지이다. Accept 가 application/json 이면 JSON 으로 응답하고 POST 로 인스턴스를 forget 할 수 있다.
+func (s *Server) getAdminRing(c *fiber.Ctx) error {
+	handler, ok := s.ring.(http.Handler)
+	if !ok {
+		return fiber.NewError(http.StatusNotImplemented, "ring status page is not available")
+	}
+	return adaptor.HTTPHandler(handler)(c)
+}
+
+// getAdminMemberlist 는 dskit 의 memberlist 상태 페이지이다.
+func (s *Server) getAdminMemberlist(c *fiber.Ctx) error {
+	return adaptor.HTTPHandler(s.memberlist)(c)
+}
+
+// getAdminStorePool 은 store pool 에 등록된 Store 와 Ring 의 healthy 인스턴스를 합쳐서 반환한다.
+func (s *Server) getAdminStorePool(c *fiber.Ctx) error {
+	healthy := make(map[string]bool)
+	replicationSet, err := s.ring.GetAllHealthy(ring.Reporting)
+	if err != nil && !errors.Is(err, ring.ErrEmptyRing) {
+		return errors.Wrap(err, "failed to read all healthy instances")
+	}
+	for _, addr := range replicationSet.GetAddresses() {
+		healthy[addr] = true
+	}
+
+	entries := make([]*StorePoolEntry, 0, len(healthy))
+	for _, addr := range s.storePool.Addrs() {
+		entry := &StorePoolEntry{Addr: addr, Registered: true, Healthy: healthy[addr]}
+		switch st := s.storePool.Get(addr).(type) {
+		case *store.LocalStore:
+			entry.Type = "local"
+		case *store.HTTPStore:
+			entry.Type = "http"
+			entry.URL = st.BaseURL()
+		}
+		entries = append(entries, entry)
+		delete(healthy, addr)
+	}
+	for addr := range healthy {
+		entries = append(entries, &StorePoolEntry{Addr: addr, Healthy: true})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Addr < entries[j].Addr
+	})
+	return c.JSON(entries)
+}
+
+// getAdminNode 는 이 인스턴스의 Ring 정보, 저장소 통계와 빌드 정보를 반환한다.
+func (s *Server) getAdminNode(c *fiber.Ctx) error {
+	resp := &NodeResponse{
+		ID:        s.lifecycler.ID,
+		Addr:      s.lifecycler.Addr,
+		Zone:      s.lifecycler.Zone,
+		State:     s.lifecycler.GetState().String(),
+		Tokens:    []uint32{},
+		StartedAt: s.startedAt,
+		Storage:   s.localStore.Stats(),
+		Build:     readBuildInfo(),
+	}
+	// Lifecycler 는 token 을 공개하지 않으므로 Ring 에서 같은 주소의 인스턴스를 찾는다.
+	replicationSet, err := s.ring.GetAllHealthy(ring.Reporting)
+	if err != nil && !errors.Is(err, ring.ErrEmptyRing) {
+		return errors.Wrap(err, "failed to read all healthy instances")
+	}
+	for _, instance := range replicationSet.Instances {
+		if instance.Addr == resp.Addr {
+			resp.Tokens = instance.Tokens
+			break
+		}
+	}
+	return c.JSON(resp)
+}
+
+func readBuildInfo() BuildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return BuildInfo{}
+	}
+	build := BuildInfo{GoVersion: info.GoVersion, Module: info.Main.Path, Version: info.Main.Version}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.RevisionTime = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
+
+// ConfigSource 는 /admin/config 가 보여주는 설정이다.
+type ConfigSource interface {
+	// RedactedYAML 은 지금 적용된 설정을 비밀 값을 가려서 YAML 로 반환한다.
+	RedactedYAML() ([]byte, error)
+}
+
+// getAdminConfig 는 기본값, 설정 파일, 환경 변수, flag 와 다시 읽은 설정이 모두 반영된 설정을 반환한다.
+func (s *Server) getAdminConfig(c *fiber.Ctx) error {
+	out, err := s.configSource.RedactedYAML()
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the config")
+	}
+	c.Set(fiber.HeaderContentType, "application/yaml")
+	return c.Send(out)
+}
@@ -0,0 +1,85 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/pkg/errors"
+)
+
+const principalKey = "dbolt.principal"
+
+// authenticate 는 인증이 켜져 있으면 요청의 principal 을 정한다.
+// principal 에 tenant 가 있으면 resolveTenant 가 헤더 대신 그 tenant 를 사용한다.
+func (s *Server) authenticate(c *fiber.Ctx) error {
+	if !s.auth.Enabled() {
+		return c.Next()
+	}
+	req := &auth.Request{BearerToken: auth.BearerToken(c.Get(fiber.HeaderAuthorization))}
+	if state := c.Context().TLSConnectionState(); state != nil {
+		req.PeerCertificates = state.PeerCertificates
+	}
+	principal, err := s.auth.Authenticate(req)
+	if err != nil {
+		s.auth.Unauthenticated(action(c), err)
+		c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+		return fiber.NewError(http.StatusUnauthorized, "unauthorized")
+	}
+	c.Locals(principalKey, principal)
+	if principal.Tenant != "" {
+		c.SetUserContext(tenant.Inject(c.UserContext(), principal.Tenant))
+	}
+	return c.Next()
+}
+
+// authorize 는 principal 이 요청의 bucket 에 permission 을 가지고 있는지 확인한다.
+// bucket 이 없는 요청은 클러스터 단위의 요청으로 확인한다.
+func (s *Server) authorize(permission auth.Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !s.auth.Enabled() {
+			return c.Next()
+		}
+		if err := s.authorizeBucket(c, c.Params("bucket"), permission); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// authorizeBucket 은 요청 본문에 bucket 이 있는 batch 처럼 핸들러 안에서 권한을 확인할 때 사용한다.
+// tenancy 가 켜져 있으면 resolveTenant 가 정한 tenant 를 붙인 이름으로 확인하므로 ACL 은 tenant 마다 따로 준다.
+func (s *Server) authorizeBucket(c *fiber.Ctx, bucket string, permission auth.Permission) error {
+	if !s.auth.Enabled() {
+		return nil
+	}
+	if bucket != "" {
+		if tenantID := s.tenantID(c); tenantID != "" {
+			bucket = string(tenant.Bucket(tenantID, []byte(bucket)))
+		}
+	}
+	principal := c.Locals(principalKey).(*auth.Principal)
+	err := s.auth.Authorize(principal, bucket, permission, action(c))
+	if errors.Is(err, auth.ErrForbidden) {
+		return fiber.NewError(http.StatusForbidden, err.Error())
+	}
+	return err
+}
+
+// authorizeInternal 은 /v1/internal 요청을 다른 인스턴스에게만 허용한다.
+func (s *Server) authorizeInternal(c *fiber.Ctx) error {
+	if !s.auth.Enabled() {
+		return c.Next()
+	}
+	principal := c.Locals(principalKey).(*auth.Principal)
+	if principal.Name != auth.InternalPrincipal {
+		s.auth.Unauthenticated(action(c), errors.Errorf("internal API is not allowed : principal=%s", principal.Name))
+		return fiber.NewError(http.StatusForbidden, "internal API is not allowed")
+	}
+	return c.Next()
+}
+
+func action(c *fiber.Ctx) string {
+	return c.Method() + " " + c.Path()
+}
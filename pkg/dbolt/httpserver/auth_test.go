@@ -0,0 +1,106 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth/authtest"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor/distributortest"
+	"github.com/kwSeo/dbolt/pkg/dbolt/ratelimit"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newAuthServer 는 Start 와 같은 순서로 인증, tenant, 인가를 거치는 key API 만 있는 Server 를 만든다.
+func newAuthServer(t *testing.T, authCfg *auth.Config, tenantCfg *tenant.Config) *Server {
+	t.Helper()
+	cluster := distributortest.New(t, &distributor.Config{})
+	reg := prometheus.NewRegistry()
+	authService, err := auth.New(authCfg, zap.NewNop())
+	require.NoError(t, err)
+	s := &Server{
+		app:     newApp(),
+		dist:    cluster.Distributor,
+		tenants: tenant.New(tenantCfg, tenant.NewQuotas(tenantCfg, tenant.NewOverrides(tenantCfg), reg), cluster.Ring, cluster.StorePool, cluster.LocalStore, reg, zap.NewNop()),
+		auth:    authService,
+		limiter: ratelimit.New(&ratelimit.Config{}, nil, nil, reg),
+		logger:  zap.NewNop(),
+	}
+	s.app.Use(s.authenticate)
+	s.app.Use("/api/v1", s.resolveTenant)
+	s.app.Get("/api/v1/buckets/:bucket/:key", s.authorize(auth.PermissionRead), s.limitBucket, s.getValueByKey)
+	s.app.Post("/api/v1/buckets/:bucket/:key", s.authorize(auth.PermissionWrite), s.limitBucket, s.postValueByKey)
+	s.app.Post("/api/v1/batch", s.postBatch)
+	return s
+}
+
+// do 는 token 과 tenant 헤더를 붙여서 요청하고 상태와 본문을 반환한다.
+func (s *Server) do(t *testing.T, method, target, token, tenantID, contentType, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	req.Header.Set(tenant.HeaderName, tenantID)
+	req.Header.Set(fiber.HeaderAccept, fiber.MIMEOctetStream)
+	if contentType != "" {
+		req.Header.Set(fiber.HeaderContentType, contentType)
+	}
+	resp, err := s.app.Test(req, -1)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(respBody)
+}
+
+func (s *Server) put(t *testing.T, token, tenantID, value string) int {
+	t.Helper()
+	code, _ := s.do(t, http.MethodPost, "/api/v1/buckets/bucket/k", token, tenantID, fiber.MIMEOctetStream, value)
+	return code
+}
+
+func (s *Server) get(t *testing.T, token, tenantID string) (int, string) {
+	t.Helper()
+	return s.do(t, http.MethodGet, "/api/v1/buckets/bucket/k", token, tenantID, "", "")
+}
+
+func TestTenantAuthorization(t *testing.T) {
+	s := newAuthServer(t, authtest.Config(), &tenant.Config{Enabled: true})
+
+	require.Equal(t, http.StatusUnauthorized, s.put(t, "invalid", authtest.Tenant, "v"))
+
+	// tenant 가 없는 principal 은 헤더로 tenant 를 고르지만 그 tenant 의 bucket 에 권한이 있어야 한다.
+	require.Equal(t, http.StatusOK, s.put(t, authtest.UnboundToken, authtest.Tenant, "unbound"))
+	require.Equal(t, http.StatusForbidden, s.put(t, authtest.UnboundToken, authtest.OtherTenant, "v"))
+	code, _ := s.get(t, authtest.UnboundToken, authtest.OtherTenant)
+	require.Equal(t, http.StatusForbidden, code)
+	// batch 는 operation 의 bucket 마다 확인한다.
+	batch := `{"operations":[{"op":"put","bucket":"bucket","key":"k","value":"dg=="}]}`
+	code, _ = s.do(t, http.MethodPost, "/api/v1/batch", authtest.UnboundToken, authtest.OtherTenant, fiber.MIMEApplicationJSON, batch)
+	require.Equal(t, http.StatusForbidden, code)
+
+	// tenant 에 묶인 principal 은 헤더의 tenant 대신 자기 tenant 를 사용한다.
+	code, value := s.get(t, authtest.BoundToken, authtest.OtherTenant)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "unbound", value)
+	require.Equal(t, http.StatusOK, s.put(t, authtest.BoundToken, authtest.OtherTenant, "bound"))
+
+	code, _ = s.get(t, authtest.AdminToken, authtest.OtherTenant)
+	require.Equal(t, http.StatusNotFound, code)
+	code, _ = s.do(t, http.MethodPost, "/api/v1/batch", authtest.AdminToken, authtest.OtherTenant, fiber.MIMEApplicationJSON, batch)
+	require.Equal(t, http.StatusOK, code)
+
+	code, value = s.get(t, authtest.UnboundToken, authtest.Tenant)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "bound", value)
+	code, value = s.get(t, authtest.AdminToken, authtest.OtherTenant)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "v", value)
+}
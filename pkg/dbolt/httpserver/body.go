@@ -0,0 +1,84 @@
+package httpserver
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// maxBodySize 는 스트림으로 읽지 않는 요청 본문의 최대 크기이며 fiber 의 기본값과 같다.
+const maxBodySize = fiber.DefaultBodyLimit
+
+// limitBody 는 본문을 스트림으로 읽는 요청이 아니면 본문을 maxBodySize 까지만 읽고, 넘으면 413 을 반환한다.
+// StreamRequestBody 를 켜면 fasthttp 가 본문의 크기를 제한하지 않으므로 c.Body() 와 BodyParser 가 본문 전체를 메모리에 올리기 때문이다.
+// 다른 인스턴스가 보내는 /v1/internal 요청은 replica 에 쓰는 값을 담으므로 internalBodyLimit 으로 제한한다.
+func (s *Server) limitBody(c *fiber.Ctx) error {
+	if s.streamsBody(c) {
+		return c.Next()
+	}
+	limit := int64(maxBodySize)
+	if strings.HasPrefix(c.Path(), "/v1/internal/") {
+		if limit = s.internalBodyLimit(); limit == 0 {
+			return c.Next()
+		}
+	}
+	if err := readBody(c, limit); err != nil {
+		return err
+	}
+	return c.Next()
+}
+
+// streamsBody 는 handler 가 본문을 직접 읽는 요청인지 확인한다.
+// postValueByKey 는 큰 값을 스트림으로 읽고, 나머지 값은 max_value_size 로 제한해서 읽는다.
+func (s *Server) streamsBody(c *fiber.Ctx) bool {
+	if c.Method() != http.MethodPost {
+		return false
+	}
+	if c.Path() == "/admin/restore" {
+		return true
+	}
+	bucketAndKey, ok := strings.CutPrefix(c.Path(), "/api/v1/buckets/")
+	return ok && strings.Count(bucketAndKey, "/") == 1
+}
+
+// valueBodyLimit 은 값을 메모리에 올려서 쓰는 요청의 본문 최대 크기이다. max_value_size 가 0 이면 maxBodySize 이다.
+func (s *Server) valueBodyLimit() int64 {
+	if limit := s.dist.MaxValueSize(); limit > 0 {
+		return limit
+	}
+	return maxBodySize
+}
+
+// internalBodyLimit 은 /v1/internal 요청 본문의 최대 크기이며 0 이면 제한하지 않는다.
+// PutReq 는 JSON 이라 값이 base64 로 4/3 배가 되므로 replica 에 쓰는 가장 큰 값에 bucket, key 를 담을 여유를 더한다.
+func (s *Server) internalBodyLimit() int64 {
+	stored := s.dist.MaxStoredValueSize()
+	if stored == 0 {
+		return 0
+	}
+	return int64(base64.StdEncoding.EncodedLen(int(stored))) + maxBodySize
+}
+
+// readBody 는 본문을 limit 까지만 읽어서 요청의 본문으로 바꾼다. 본문이 limit 보다 크면 413 이다.
+func readBody(c *fiber.Ctx, limit int64) error {
+	if int64(c.Request().Header.ContentLength()) > limit {
+		return fiber.ErrRequestEntityTooLarge
+	}
+	body := c.Context().RequestBodyStream()
+	if body == nil {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return errors.Wrap(err, "failed to read the request body")
+	}
+	if int64(len(data)) > limit {
+		return fiber.ErrRequestEntityTooLarge
+	}
+	c.Request().SetBody(data)
+	return nil
+}
@@ -0,0 +1,64 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newInternalServer 는 /v1/internal/put 만 있는 Server 를 띄우고 그 Server 로 보내는 HTTPStore 를 반환한다.
+func newInternalServer(t *testing.T, cfg *distributor.Config) (*Server, *store.HTTPStore) {
+	t.Helper()
+	localStore, err := store.Open(&store.ChangeLogConfig{}, store.EngineMemory, "", 0o600, nil, store.NewEncryptor(nil), nil, zap.NewNop())
+	require.NoError(t, err)
+	s := &Server{
+		app:        newApp(),
+		dist:       distributor.New(cfg, nil, distributor.NewSimpleStorePool(), nil, nil, "", zap.NewNop()),
+		localStore: localStore,
+		logger:     zap.NewNop(),
+	}
+	s.app.Use(s.limitBody)
+	s.app.Post("/v1/internal/put", s.internalPut)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = s.app.Listener(ln) }()
+	t.Cleanup(func() { _ = s.app.Shutdown() })
+	return s, store.NewHTTPStore(&store.HttpStoreConfig{Timeout: 10 * time.Second}, "http://"+ln.Addr().String())
+}
+
+func TestInternalPutAcceptsMaxValueSize(t *testing.T) {
+	// base64 로 보내면 4MB 를 넘는 크기이다.
+	const maxValueSize = 6 << 20
+	s, remote := newInternalServer(t, &distributor.Config{MaxValueSize: maxValueSize})
+
+	ctx := context.Background()
+	bucketName, key := []byte("bucket"), []byte("key")
+	value := bytes.Repeat([]byte{0xdb}, maxValueSize-1)
+	require.NoError(t, remote.Put(ctx, bucketName, key, value))
+	stored, err := s.localStore.Get(ctx, bucketName, key)
+	require.NoError(t, err)
+	require.Equal(t, value, stored)
+
+	tooLarge := make([]byte, s.internalBodyLimit())
+	require.Error(t, remote.Put(ctx, bucketName, key, tooLarge))
+}
+
+func TestInternalPutWithoutMaxValueSize(t *testing.T) {
+	s, remote := newInternalServer(t, &distributor.Config{})
+
+	ctx := context.Background()
+	bucketName, key := []byte("bucket"), []byte("key")
+	value := bytes.Repeat([]byte{0xdb}, 5<<20)
+	require.NoError(t, remote.Put(ctx, bucketName, key, value))
+	stored, err := s.localStore.Get(ctx, bucketName, key)
+	require.NoError(t, err)
+	require.Equal(t, value, stored)
+}
@@ -0,0 +1,76 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/pkg/errors"
+)
+
+type BucketsResponse struct {
+	Buckets []string `json:"buckets"`
+}
+
+type DeleteBucketResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// getBuckets 는 요청한 tenant 의 bucket 이름을 반환한다. tenancy 가 꺼져 있으면 tenant 의 bucket 은 제외한다.
+func (s *Server) getBuckets(c *fiber.Ctx) error {
+	bucketNames, err := s.dist.Buckets(c.UserContext())
+	if err != nil {
+		return err
+	}
+	tenantID := s.tenantID(c)
+	resp := &BucketsResponse{Buckets: []string{}}
+	for _, bucketName := range bucketNames {
+		bucketTenant, bucket, ok := tenant.SplitBucket(bucketName)
+		if !ok {
+			bucketTenant, bucket = "", bucketName
+		}
+		if bucketTenant == tenantID {
+			resp.Buckets = append(resp.Buckets, string(bucket))
+		}
+	}
+	return c.JSON(resp)
+}
+
+// deleteBucket 은 bucket 의 모든 key 를 지운다.
+func (s *Server) deleteBucket(c *fiber.Ctx) error {
+	deleted, err := s.dist.DeleteBucket(c.UserContext(), s.bucketName(c))
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete the bucket, bucket=%v, deleted=%d", c.Params("bucket"), deleted)
+	}
+	return c.JSON(&DeleteBucketResponse{Deleted: deleted})
+}
+
+// getConsistency 는 저장소의 bucket 에서 key 범위를 골라 replica 들의 version 을 비교한다. (?bucket=&prefix=&after=&limit=)
+// tenant 의 bucket 은 "<tenant>/<bucket>" 으로 지정한다.
+func (s *Server) getConsistency(c *fiber.Ctx) error {
+	bucketName := c.Query("bucket")
+	if bucketName == "" {
+		return fiber.NewError(http.StatusBadRequest, "bucket required")
+	}
+	limit := c.QueryInt("limit", defaultScanLimit)
+	if limit <= 0 || limit > maxScanLimit {
+		return fiber.NewError(http.StatusBadRequest, "limit must be between 1 and 1000")
+	}
+	var after []byte
+	if value := c.Query("after"); value != "" {
+		after = []byte(value)
+	}
+	report, err := s.dist.CheckConsistency(c.UserContext(), []byte(bucketName), []byte(c.Query("prefix")), after, limit)
+	if err != nil {
+		return errors.Wrapf(err, "failed to check the consistency, bucket=%v", bucketName)
+	}
+	return c.JSON(report)
+}
+
+func (s *Server) internalBuckets(c *fiber.Ctx) error {
+	bucketNames, err := s.localStore.Buckets(c.UserContext())
+	if err != nil {
+		return errors.Wrap(err, "failed to list the buckets of local store")
+	}
+	return c.JSON(bucketNames)
+}
@@ -0,0 +1,68 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kwSeo/dbolt/pkg/dbolt/changes"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/pkg/errors"
+)
+
+// getChanges 는 변경 기록을 since 이후부터 반환한다.
+// cluster=true 이면 모든 인스턴스의 변경을 합친 스트림이며 since 는 이전 응답의 next 커서이다.
+func (s *Server) getChanges(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", changes.DefaultLimit)
+	if c.QueryBool("cluster") {
+		cursor, err := changes.ParseCursor(c.Query("since"))
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+		page, err := s.changes.Cluster(c.UserContext(), s.tenantID(c), cursor, limit)
+		if err != nil {
+			return changesError(err)
+		}
+		return c.JSON(page)
+	}
+
+	since, err := parseSince(c)
+	if err != nil {
+		return err
+	}
+	page, err := s.changes.Local(c.UserContext(), s.tenantID(c), since, limit)
+	if err != nil {
+		return changesError(err)
+	}
+	return c.JSON(page)
+}
+
+func (s *Server) internalChanges(c *fiber.Ctx) error {
+	since, err := parseSince(c)
+	if err != nil {
+		return err
+	}
+	events, err := s.localStore.Changes(c.UserContext(), since, c.QueryInt("limit", changes.DefaultLimit))
+	if err != nil {
+		return changesError(err)
+	}
+	return c.JSON(events)
+}
+
+func parseSince(c *fiber.Ctx) (uint64, error) {
+	if c.Query("since") == "" {
+		return 0, nil
+	}
+	since, err := strconv.ParseUint(c.Query("since"), 10, 64)
+	if err != nil {
+		return 0, fiber.NewError(http.StatusBadRequest, "invalid since")
+	}
+	return since, nil
+}
+
+func changesError(err error) error {
+	if errors.Is(err, store.ErrRevisionCompacted) {
+		return fiber.NewError(http.StatusGone, err.Error())
+	}
+	return errors.Wrap(err, "failed to read the changes")
+}
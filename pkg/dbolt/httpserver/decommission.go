@@ -0,0 +1,37 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kwSeo/dbolt/pkg/dbolt/decommission"
+	"github.com/pkg/errors"
+)
+
+// PhaseHeaderName 은 readiness probe 의 응답에 decommission 단계를 알려주는 헤더이다.
+const PhaseHeaderName = "X-Dbolt-Decommission-Phase"
+
+// serving 은 decommission 중에 client 요청을 503 으로 거절한다. /v1/internal 은 값을 옮기는 동안에도 필요하므로 적용하지 않는다.
+func (s *Server) serving(c *fiber.Ctx) error {
+	if !s.decommission.Serving() {
+		c.Set(fiber.HeaderRetryAfter, "1")
+		return fiber.NewError(http.StatusServiceUnavailable, decommission.ErrNotServing.Error())
+	}
+	return c.Next()
+}
+
+func (s *Server) getDecommission(c *fiber.Ctx) error {
+	return c.JSON(s.decommission.Status())
+}
+
+// postDecommission 은 decommission 을 시작하고 바로 응답한다. 진행 상황은 GET 으로 확인한다.
+func (s *Server) postDecommission(c *fiber.Ctx) error {
+	status, err := s.decommission.Start()
+	if errors.Is(err, decommission.ErrDecommissionInProgress) {
+		return fiber.NewError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to start the decommission")
+	}
+	return c.Status(http.StatusAccepted).JSON(status)
+}
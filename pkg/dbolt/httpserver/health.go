@@ -0,0 +1,134 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/grafana/dskit/ring"
+	"github.com/kwSeo/dbolt/pkg/dbolt/decommission"
+	"github.com/pkg/errors"
+)
+
+type HealthConfig struct {
+	// ReadTimeout 은 readiness probe 가 lifecycler 와 bolt 의 읽기 트랜잭션을 기다리는 시간이다. 기본값은 1초이다.
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+	// LockTimeout 은 liveness probe 가 bolt 파일의 lock 을 기다리는 시간이다. 기본값은 10초이다.
+	LockTimeout time.Duration `yaml:"lock_timeout"`
+	// StuckTxTimeout 보다 오래 열려 있거나 쓰기 lock 을 기다리는 트랜잭션이 있으면 liveness probe 가 실패한다. 기본값은 1분이다.
+	StuckTxTimeout time.Duration `yaml:"stuck_tx_timeout"`
+}
+
+func (hc *HealthConfig) Validate() error {
+	if hc.ReadTimeout < 0 || hc.LockTimeout < 0 || hc.StuckTxTimeout < 0 {
+		return errors.New("health timeouts must not be negative")
+	}
+	return nil
+}
+
+func (hc *HealthConfig) readTimeout() time.Duration {
+	if hc.ReadTimeout == 0 {
+		return time.Second
+	}
+	return hc.ReadTimeout
+}
+
+func (hc *HealthConfig) lockTimeout() time.Duration {
+	if hc.LockTimeout == 0 {
+		return 10 * time.Second
+	}
+	return hc.LockTimeout
+}
+
+func (hc *HealthConfig) stuckTxTimeout() time.Duration {
+	if hc.StuckTxTimeout == 0 {
+		return time.Minute
+	}
+	return hc.StuckTxTimeout
+}
+
+// HealthResponse 는 /readyz, /livez 의 응답이다. Checks 는 확인한 항목별로 "ok" 나 실패한 이유를 담는다.
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// getReadyz 는 readiness probe 이다. decommission 중이거나, lifecycler 가 ACTIVE 가 아니거나,
+// quorum 만큼의 인스턴스가 healthy 하지 않거나, bolt 가 읽기 트랜잭션을 열지 못하면 실패한다.
+func (s *Server) getReadyz(c *fiber.Ctx) error {
+	c.Set(PhaseHeaderName, string(s.decommission.Status().Phase))
+	ctx, cancel := context.WithTimeout(c.UserContext(), s.healthCfg.Load().readTimeout())
+	defer cancel()
+	return s.health(ctx, c, []healthCheck{
+		{name: "decommission", check: s.checkServing},
+		{name: "lifecycler", check: s.lifecycler.CheckReady},
+		{name: "quorum", check: s.checkQuorum},
+		{name: "bolt", check: s.localStore.CheckRead},
+	})
+}
+
+// getLivez 는 liveness probe 이다. bolt 파일의 lock 을 얻지 못하거나 멈춘 트랜잭션이 있으면 실패한다.
+// 다른 인스턴스의 상태는 재시작으로 해결되지 않으므로 확인하지 않는다.
+func (s *Server) getLivez(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), s.healthCfg.Load().lockTimeout())
+	defer cancel()
+	return s.health(ctx, c, []healthCheck{
+		{name: "lock", check: s.localStore.CheckLock},
+		{name: "transactions", check: s.checkTransactions},
+	})
+}
+
+// health 는 checks 를 순서대로 모두 실행하고 하나라도 실패하면 503 으로 응답한다.
+func (s *Server) health(ctx context.Context, c *fiber.Ctx, checks []healthCheck) error {
+	resp := &HealthResponse{Status: "ok", Checks: make(map[string]string, len(checks))}
+	for _, hc := range checks {
+		if err := hc.check(ctx); err != nil {
+			resp.Status = "failed"
+			resp.Checks[hc.name] = err.Error()
+			continue
+		}
+		resp.Checks[hc.name] = "ok"
+	}
+	if resp.Status != "ok" {
+		c.Status(http.StatusServiceUnavailable)
+	}
+	return c.JSON(resp)
+}
+
+func (s *Server) checkServing(context.Context) error {
+	if !s.decommission.Serving() {
+		return decommission.ErrNotServing
+	}
+	return nil
+}
+
+// checkQuorum 은 쓰기를 받을 수 있는 healthy 인스턴스가 replication factor 의 과반 이상인지 확인한다.
+func (s *Server) checkQuorum(context.Context) error {
+	healthy, err := s.ring.GetAllHealthy(ring.Write)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the healthy instances")
+	}
+	quorum := s.ring.ReplicationFactor()/2 + 1
+	if len(healthy.Instances) < quorum {
+		return errors.Errorf("%d healthy instances, %d required", len(healthy.Instances), quorum)
+	}
+	return nil
+}
+
+func (s *Server) checkTransactions(context.Context) error {
+	health := s.localStore.TxHealth()
+	timeout := s.healthCfg.Load().stuckTxTimeout()
+	if health.Waiting > timeout {
+		return errors.Errorf("a transaction has been waiting for the write lock for %s", health.Waiting)
+	}
+	if health.Running > timeout {
+		return errors.Errorf("a transaction has been running for %s", health.Running)
+	}
+	return nil
+}
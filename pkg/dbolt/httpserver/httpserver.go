@@ -2,12 +2,30 @@ package httpserver
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/healthcheck"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/grafana/dskit/kv/memberlist"
+	"github.com/grafana/dskit/ring"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/backup"
+	"github.com/kwSeo/dbolt/pkg/dbolt/changes"
+	"github.com/kwSeo/dbolt/pkg/dbolt/decommission"
 	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/ratelimit"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tlsconfig"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"net/http"
 )
@@ -16,47 +34,162 @@ type Config struct {
 	BindIP         string `yaml:"bind_ip"`
 	HTTPListenPort uint16 `yaml:"http_listen_port"`
 	GRPCListenPort uint16 `yaml:"grpc_listen_port"`
+	// TLS 는 HTTP 와 gRPC listener 에 적용된다.
+	TLS tlsconfig.ServerConfig `yaml:"tls"`
+	// InternalTLS 는 다른 인스턴스의 /v1/internal API 를 호출할 때 사용한다. 켜면 https 로 요청한다.
+	InternalTLS tlsconfig.ClientConfig `yaml:"internal_tls"`
+	// Health 는 /readyz, /livez 가 기다리는 시간이다.
+	Health HealthConfig `yaml:"health"`
 }
 
 func (sc *Config) Validate() error {
 	if sc.BindIP == "" {
 		return errors.New("BindIP required")
 	}
+	if err := sc.TLS.Validate(); err != nil {
+		return errors.Wrap(err, "invalid server 'tls'")
+	}
+	if err := sc.InternalTLS.Validate(); err != nil {
+		return errors.Wrap(err, "invalid server 'internal_tls'")
+	}
+	if err := sc.Health.Validate(); err != nil {
+		return errors.Wrap(err, "invalid server 'health'")
+	}
 	return nil
 }
 
 type Server struct {
-	cfg    *Config
-	app    *fiber.App
-	dist   *distributor.Distributor
-	logger *zap.Logger
+	cfg          *Config
+	tls          *tlsconfig.Server
+	app          *fiber.App
+	dist         *distributor.Distributor
+	localStore   *store.LocalStore
+	compactor    *store.Compactor
+	reencryptor  *store.Reencryptor
+	changes      *changes.Service
+	tenants      *tenant.Service
+	auth         *auth.Service
+	backup       *backup.Service
+	ring         ring.ReadRing
+	lifecycler   *ring.Lifecycler
+	storePool    *distributor.SimpleStorePool
+	memberlist   *memberlist.KVInitService
+	decommission *decommission.Decommissioner
+	limiter      *ratelimit.Limiter
+	configSource ConfigSource
+	// healthCfg 는 설정을 다시 읽으면 ApplyConfig 로 바뀐다.
+	healthCfg atomic.Pointer[HealthConfig]
+	startedAt time.Time
+	logger    *zap.Logger
 }
 
-func New(cfg *Config, dist *distributor.Distributor, logger *zap.Logger) *Server {
-	app := fiber.New(
+// newApp 은 dbolt 의 fiber 설정으로 app 을 만든다.
+func newApp() *fiber.App {
+	return fiber.New(
 		fiber.Config{
 			ErrorHandler: nil,
 			AppName:      "dbolt",
+			// 스냅샷 복원처럼 큰 요청 본문은 메모리에 모두 올리지 않고 스트림으로 읽는다.
+			// 나머지 요청의 본문 크기는 limitBody 가 제한한다.
+			StreamRequestBody: true,
 		},
 	)
-	return &Server{
-		cfg:    cfg,
-		dist:   dist,
-		app:    app,
-		logger: logger,
+}
+
+func New(cfg *Config, serverTLS *tlsconfig.Server, dist *distributor.Distributor, localStore *store.LocalStore, compactor *store.Compactor, reencryptor *store.Reencryptor, changesService *changes.Service, tenants *tenant.Service, authService *auth.Service, backupService *backup.Service, r ring.ReadRing, lifecycler *ring.Lifecycler, storePool *distributor.SimpleStorePool, memberlistKV *memberlist.KVInitService, decommissioner *decommission.Decommissioner, limiter *ratelimit.Limiter, configSource ConfigSource, logger *zap.Logger) *Server {
+	s := &Server{
+		app:          newApp(),
+		cfg:          cfg,
+		tls:          serverTLS,
+		dist:         dist,
+		localStore:   localStore,
+		compactor:    compactor,
+		reencryptor:  reencryptor,
+		changes:      changesService,
+		tenants:      tenants,
+		auth:         authService,
+		backup:       backupService,
+		ring:         r,
+		lifecycler:   lifecycler,
+		storePool:    storePool,
+		memberlist:   memberlistKV,
+		decommission: decommissioner,
+		limiter:      limiter,
+		configSource: configSource,
+		startedAt:    time.Now(),
+		logger:       logger,
 	}
+	s.healthCfg.Store(&cfg.Health)
+	return s
+}
+
+// ApplyConfig 는 다시 읽은 설정 중 health 만 적용한다. 나머지는 재시작해야 바뀐다.
+func (s *Server) ApplyConfig(cfg *Config) {
+	s.healthCfg.Store(&cfg.Health)
 }
 
 func (s *Server) Start() error {
 	s.logger.Info("Initializing HTTP server.")
 	s.app.Use(logger.New())
-	s.app.Use(healthcheck.New())
-	s.app.Get("/api/v1/buckets/:bucket/:key", s.getValueByKey)
-	s.app.Post("/api/v1/buckets/:bucket/:key", s.postValueByKey)
+	s.app.Use(s.limitBody)
+	s.app.Get("/livez", s.getLivez)
+	s.app.Get("/readyz", s.getReadyz)
+	s.app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
+	s.app.Use(s.authenticate)
+	s.app.Use("/api/v1", s.serving, s.limit, s.resolveTenant)
+	s.app.Use("/v1/internal", s.authorizeInternal)
+	s.app.Use("/admin", s.authorize(auth.PermissionAdmin))
+	s.app.Get("/api/v1/ring", s.getRing)
+	s.app.Get("/api/v1/buckets", s.authorize(auth.PermissionRead), s.getBuckets)
+	s.app.Get("/api/v1/buckets/:bucket", s.authorize(auth.PermissionRead), s.limitBucket, s.getBucket)
+	s.app.Delete("/api/v1/buckets/:bucket", s.authorize(auth.PermissionAdmin), s.limitBucket, s.deleteBucket)
+	s.app.Get("/api/v1/buckets/:bucket/:key", s.authorize(auth.PermissionRead), s.limitBucket, s.getValueByKey)
+	s.app.Post("/api/v1/buckets/:bucket/:key", s.authorize(auth.PermissionWrite), s.limitBucket, s.postValueByKey)
+	s.app.Delete("/api/v1/buckets/:bucket/:key", s.authorize(auth.PermissionWrite), s.limitBucket, s.deleteValueByKey)
+	s.app.Post("/api/v1/batch", s.postBatch)
+	s.app.Get("/api/v1/watch/:bucket", s.authorize(auth.PermissionRead), s.limitBucket, s.getWatch)
+	s.app.Get("/api/v1/changes", s.authorize(auth.PermissionRead), s.getChanges)
+	s.app.Post("/v1/internal/get", s.internalGet)
+	s.app.Post("/v1/internal/put", s.internalPut)
+	s.app.Post("/v1/internal/delete", s.internalDelete)
+	s.app.Post("/v1/internal/scan", s.internalScan)
+	s.app.Get("/v1/internal/buckets", s.internalBuckets)
+	s.app.Get("/v1/internal/changes", s.internalChanges)
+	s.app.Get("/v1/internal/usage", s.internalUsage)
+	s.app.Get("/admin/backup", s.getBackup)
+	s.app.Get("/admin/backup/cluster", s.getClusterBackup)
+	s.app.Post("/admin/restore", s.postRestore)
+	s.app.Get("/admin/compaction", s.getCompaction)
+	s.app.Post("/admin/compaction", s.postCompaction)
+	s.app.Get("/admin/reencryption", s.getReencryption)
+	s.app.Post("/admin/reencryption", s.postReencryption)
+	s.app.Get("/admin/tenants", s.getTenants)
+	s.app.Get("/admin/tenants/:tenant", s.getTenant)
+	s.app.Get("/admin/consistency", s.getConsistency)
+	s.app.All("/admin/ring", s.getAdminRing)
+	s.app.All("/admin/memberlist", s.getAdminMemberlist)
+	s.app.Get("/admin/store-pool", s.getAdminStorePool)
+	s.app.Get("/admin/decommission", s.getDecommission)
+	s.app.Post("/admin/decommission", s.postDecommission)
+	s.app.Get("/admin/node", s.getAdminNode)
+	s.app.Get("/admin/config", s.getAdminConfig)
 
 	addr := fmt.Sprintf("%v:%v", s.cfg.BindIP, s.cfg.HTTPListenPort)
-	s.logger.Info("Starting HTTP server.", zap.String("bindAddress", addr))
-	return s.app.Listen(addr)
+	s.logger.Info("Starting HTTP server.", zap.String("bindAddress", addr), zap.Bool("tls", s.tls != nil))
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen : addr=%s", addr)
+	}
+	if s.tls != nil {
+		ln = tls.NewListener(ln, s.tls.Config())
+	}
+	// fx 의 OnStart hook 이 끝나야 다음 hook 이 실행되므로 요청은 별도의 goroutine 에서 처리한다.
+	go func() {
+		if err := s.app.Listener(ln); err != nil {
+			s.logger.Error("HTTP server stopped.", zap.Error(err))
+		}
+	}()
+	return nil
 }
 
 func (s *Server) Stop(ctx context.Context) error {
@@ -64,33 +197,264 @@ func (s *Server) Stop(ctx context.Context) error {
 	return s.app.ShutdownWithContext(ctx)
 }
 
+// getValueByKey 는 chunk 로 나눠서 저장한 값이면 Accept 와 관계없이 chunk 를 차례로 읽어서 본문으로 보낸다.
 func (s *Server) getValueByKey(c *fiber.Ctx) error {
 	bucket := c.Params("bucket")
 	key := c.Params("key")
-	value, err := s.dist.Get(c.UserContext(), []byte(bucket), []byte(key))
+	bucketName := s.bucketName(c)
+	versionedValue, err := s.dist.GetVersionedStream(c.UserContext(), bucketName, []byte(key))
+	if errors.Is(err, distributor.ErrKeyValueNotFound) {
+		return fiber.NewError(http.StatusNotFound, "key not found")
+	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to find a value by key, bucket=%v, key=%v", bucket, key)
 	}
 
+	c.Set(fiber.HeaderETag, formatETag(versionedValue.Version()))
+	if manifest := versionedValue.Manifest; manifest != nil {
+		c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+		// 중간에 실패하면 본문이 Content-Length 보다 짧게 끝나므로 client 가 알 수 있다.
+		// fasthttp 가 본문을 다 보내거나 연결이 끊기면 pr 을 닫으므로 goroutine 도 끝난다.
+		pr, pw := io.Pipe()
+		go func() {
+			err := s.dist.WriteChunks(context.Background(), bucketName, []byte(key), manifest, pw)
+			if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				s.logger.Error("Failed to stream the chunks.", zap.String("bucket", bucket), zap.String("key", key), zap.Error(err))
+			}
+			pw.CloseWithError(err)
+		}()
+		c.Context().SetBodyStream(pr, int(manifest.Size))
+		return nil
+	}
 	if c.Accepts("application/json") != "" {
-		return c.JSON(&GetValueResponse{Value: value})
+		return c.JSON(&GetValueResponse{Value: versionedValue.Value})
 	}
-	return c.Send(value)
+	return c.Send(versionedValue.Value)
 }
 
+// postValueByKey 는 application/octet-stream 요청이면 본문을 그대로 값으로 쓰고, 아니면 Value 필드를 읽는다.
+// If-Match, If-None-Match: * 헤더가 있으면 조건부로 쓴다.
+// Content-Length 가 max_value_size 보다 크면 본문을 읽지 않고 413 을 반환하고, large_objects 의 threshold 보다 크거나
+// chunked 로 보낸 octet-stream 본문은 메모리에 모두 올리지 않고 PutLarge 로 chunk 마다 기록한다.
+// 나머지 본문은 max_value_size(없으면 4MB) 까지만 읽고 넘으면 413 을 반환한다.
 func (s *Server) postValueByKey(c *fiber.Ctx) error {
 	bucket := c.Params("bucket")
 	key := c.Params("key")
-	var req PostValueByKeyRequest
+	contentLength := int64(c.Request().Header.ContentLength())
+	if err := s.dist.CheckSize([]byte(key), contentLength); err != nil {
+		return writeError(err, "invalid value, bucket=%v, key=%v", bucket, key)
+	}
+	if body := c.Context().RequestBodyStream(); body != nil && c.Is("bin") && s.dist.LargeObject(contentLength) {
+		return s.putLarge(c, body)
+	}
+	if err := readBody(c, s.valueBodyLimit()); err != nil {
+		return err
+	}
+	var value []byte
+	if c.Is("bin") {
+		value = c.Body()
+	} else {
+		var req PostValueByKeyRequest
+		if err := c.BodyParser(&req); err != nil {
+			return errors.Wrap(err, "failed to parse the request body")
+		}
+		value = []byte(req.Value)
+	}
+	cond, err := parsePrecondition(c)
+	if err != nil {
+		return err
+	}
+	version, err := s.dist.PutIf(c.UserContext(), s.bucketName(c), []byte(key), value, cond)
+	if err != nil {
+		return writeError(err, "failed to put the value by key, bucket=%v, key=%v", bucket, key)
+	}
+	c.Set(fiber.HeaderETag, formatETag(version))
+	return c.SendStatus(http.StatusOK)
+}
+
+func (s *Server) putLarge(c *fiber.Ctx, body io.Reader) error {
+	bucket := c.Params("bucket")
+	key := c.Params("key")
+	cond, err := parsePrecondition(c)
+	if err != nil {
+		return err
+	}
+	version, err := s.dist.PutLarge(c.UserContext(), s.bucketName(c), []byte(key), body, &distributor.PutOptions{Cond: cond})
+	if err != nil {
+		return writeError(err, "failed to put the large value by key, bucket=%v, key=%v", bucket, key)
+	}
+	c.Set(fiber.HeaderETag, formatETag(version))
+	return c.SendStatus(http.StatusOK)
+}
+
+func (s *Server) deleteValueByKey(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+	key := c.Params("key")
+	cond, err := parsePrecondition(c)
+	if err != nil {
+		return err
+	}
+	if err := s.dist.DeleteIf(c.UserContext(), s.bucketName(c), []byte(key), cond); err != nil {
+		return writeError(err, "failed to delete the value by key, bucket=%v, key=%v", bucket, key)
+	}
+	return c.SendStatus(http.StatusOK)
+}
+
+func (s *Server) internalGet(c *fiber.Ctx) error {
+	var req store.GetReq
+	if err := c.BodyParser(&req); err != nil {
+		return errors.Wrap(err, "failed to parse the request body")
+	}
+	value, err := s.localStore.Get(c.UserContext(), req.BucketName, req.Key)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get the value from local store, bucket=%s, key=%s", req.BucketName, req.Key)
+	}
+	return c.Send(value)
+}
+
+func (s *Server) internalPut(c *fiber.Ctx) error {
+	var req store.PutReq
+	if err := c.BodyParser(&req); err != nil {
+		return errors.Wrap(err, "failed to parse the request body")
+	}
+	err := s.localStore.Put(c.UserContext(), req.BucketName, req.Key, req.Value)
+	if errors.Is(err, store.ErrQuotaExceeded) {
+		return fiber.NewError(http.StatusInsufficientStorage, err.Error())
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to put the value to local store, bucket=%s, key=%s", req.BucketName, req.Key)
+	}
+	return c.SendStatus(http.StatusOK)
+}
+
+func (s *Server) internalDelete(c *fiber.Ctx) error {
+	var req store.DeleteReq
 	if err := c.BodyParser(&req); err != nil {
 		return errors.Wrap(err, "failed to parse the request body")
 	}
-	if err := s.dist.Put(c.UserContext(), []byte(bucket), []byte(key), []byte(req.Value)); err != nil {
-		return errors.Wrapf(err, "failed to put the value by key, bucket=%v, key=%v, value=%v", bucket, key, req.Value)
+	if err := s.localStore.Delete(c.UserContext(), req.BucketName, req.Key); err != nil {
+		return errors.Wrapf(err, "failed to delete the value from local store, bucket=%s, key=%s", req.BucketName, req.Key)
 	}
 	return c.SendStatus(http.StatusOK)
 }
 
+func (s *Server) internalScan(c *fiber.Ctx) error {
+	var req store.ScanReq
+	if err := c.BodyParser(&req); err != nil {
+		return errors.Wrap(err, "failed to parse the request body")
+	}
+	kvs, err := s.localStore.Scan(c.UserContext(), req.BucketName, req.Prefix, req.After, req.Limit)
+	if err != nil {
+		return errors.Wrapf(err, "failed to scan the local store, bucket=%s", req.BucketName)
+	}
+	return c.JSON(kvs)
+}
+
+func (s *Server) getBackup(c *fiber.Ctx) error {
+	s.logger.Info("Streaming the snapshot of local store.")
+	c.Attachment(fmt.Sprintf("dbolt-%s.db", time.Now().Format("20060102T150405")))
+	s.streamBody(c, "snapshot", func(ctx context.Context, w io.Writer) error {
+		_, err := s.localStore.Backup(ctx, w)
+		return err
+	})
+	return nil
+}
+
+func (s *Server) getClusterBackup(c *fiber.Ctx) error {
+	s.logger.Info("Streaming the cluster backup.")
+	c.Attachment(fmt.Sprintf("dbolt-cluster-%s.tar", time.Now().Format("20060102T150405")))
+	s.streamBody(c, "cluster backup", s.backup.WriteClusterBackup)
+	return nil
+}
+
+// streamBody 는 write 가 쓰는 내용(name)을 chunked 본문으로 보낸다.
+// 200 을 보낸 뒤에 write 가 실패하면 마지막 chunk 없이 연결을 끊으므로 client 는 본문이 잘린 것을 알 수 있다.
+// client 가 연결을 끊으면 fasthttp 가 본문을 닫으면서 ctx 를 취소하므로 write 도 멈춘다.
+func (s *Server) streamBody(c *fiber.Ctx, name string, write func(ctx context.Context, w io.Writer) error) {
+	ctx, cancel := context.WithCancel(c.UserContext())
+	pr, pw := io.Pipe()
+	go func() {
+		err := write(ctx, pw)
+		if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			s.logger.Error("Failed to stream the "+name+".", zap.Error(err))
+		}
+		pw.CloseWithError(err)
+	}()
+	c.Context().SetBodyStream(&cancelReader{PipeReader: pr, cancel: cancel}, -1)
+}
+
+// cancelReader 는 닫힐 때 본문을 쓰는 goroutine 의 context 를 취소한다.
+type cancelReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
+
+func (s *Server) postRestore(c *fiber.Ctx) error {
+	tmp, err := os.CreateTemp("", "dbolt-restore-*.db")
+	if err != nil {
+		return errors.Wrap(err, "failed to create a temporary file")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	body := c.Context().RequestBodyStream()
+	if body == nil {
+		_, err = tmp.Write(c.Body())
+	} else {
+		_, err = io.Copy(tmp, body)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to receive the snapshot")
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	result, err := s.backup.Restore(c.UserContext(), tmp.Name())
+	if err != nil {
+		return err
+	}
+	return c.JSON(result)
+}
+
+func (s *Server) getCompaction(c *fiber.Ctx) error {
+	return c.JSON(s.compactor.Status())
+}
+
+func (s *Server) postCompaction(c *fiber.Ctx) error {
+	result, err := s.compactor.Compact(c.UserContext())
+	if errors.Is(err, store.ErrCompactionInProgress) {
+		return fiber.NewError(http.StatusConflict, err.Error())
+	}
+	if errors.Is(err, store.ErrCompactionUnsupported) {
+		return fiber.NewError(http.StatusNotImplemented, err.Error())
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to compact the bolt file")
+	}
+	return c.JSON(result)
+}
+
+func (s *Server) getReencryption(c *fiber.Ctx) error {
+	return c.JSON(s.reencryptor.Status())
+}
+
+func (s *Server) postReencryption(c *fiber.Ctx) error {
+	result, err := s.reencryptor.Reencrypt(c.UserContext())
+	if errors.Is(err, store.ErrReencryptionInProgress) {
+		return fiber.NewError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to re-encrypt the values")
+	}
+	return c.JSON(result)
+}
+
 type GetValueResponse struct {
 	Value []byte
 }
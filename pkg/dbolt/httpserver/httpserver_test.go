@@ -0,0 +1,84 @@
+package httpserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newStreamServer 는 write 가 쓰는 내용을 streamBody 로 보내는 Server 를 띄우고 주소를 반환한다.
+func newStreamServer(t *testing.T, write func(ctx context.Context, w io.Writer) error) string {
+	t.Helper()
+	s := &Server{app: newApp(), logger: zap.NewNop()}
+	s.app.Get("/stream", func(c *fiber.Ctx) error {
+		s.streamBody(c, "test", write)
+		return nil
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = s.app.Listener(ln) }()
+	t.Cleanup(func() { _ = s.app.Shutdown() })
+	return "http://" + ln.Addr().String() + "/stream"
+}
+
+func TestStreamBody(t *testing.T) {
+	url := newStreamServer(t, func(_ context.Context, w io.Writer) error {
+		_, err := w.Write([]byte("snapshot"))
+		return err
+	})
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "snapshot", string(body))
+}
+
+func TestStreamBodyTruncated(t *testing.T) {
+	url := newStreamServer(t, func(_ context.Context, w io.Writer) error {
+		if _, err := w.Write([]byte("partial")); err != nil {
+			return err
+		}
+		return errors.New("instance is down")
+	})
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = io.ReadAll(resp.Body)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestStreamBodyCancelledByClient(t *testing.T) {
+	cancelled := make(chan error, 1)
+	url := newStreamServer(t, func(ctx context.Context, w io.Writer) error {
+		defer func() { cancelled <- ctx.Err() }()
+		chunk := make([]byte, 32<<10)
+		for ctx.Err() == nil {
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	})
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	_, err = io.ReadFull(resp.Body, make([]byte, 1<<20))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	select {
+	case err := <-cancelled:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream was not cancelled after the client went away")
+	}
+}
@@ -0,0 +1,53 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/pkg/errors"
+)
+
+// formatETag 는 값의 버전을 ETag 로 만든다.
+func formatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// parsePrecondition 은 If-Match: "<version>" 과 If-None-Match: * 헤더를 읽는다.
+func parsePrecondition(c *fiber.Ctx) (*distributor.Precondition, error) {
+	cond := new(distributor.Precondition)
+	if ifMatch := c.Get(fiber.HeaderIfMatch); ifMatch != "" {
+		version, err := strconv.ParseUint(strings.Trim(ifMatch, `"`), 10, 64)
+		if err != nil || version == 0 {
+			return nil, fiber.NewError(http.StatusBadRequest, "invalid If-Match")
+		}
+		cond.IfVersion = version
+	}
+	if ifNoneMatch := c.Get(fiber.HeaderIfNoneMatch); ifNoneMatch != "" {
+		if ifNoneMatch != "*" {
+			return nil, fiber.NewError(http.StatusBadRequest, "only If-None-Match: * is supported")
+		}
+		cond.IfAbsent = true
+	}
+	return cond, nil
+}
+
+// writeError 는 쓰기의 오류를 응답 상태로 바꾼다.
+func writeError(err error, format string, args ...interface{}) error {
+	if errors.Is(err, store.ErrQuotaExceeded) {
+		return fiber.NewError(http.StatusInsufficientStorage, err.Error())
+	}
+	if errors.Is(err, distributor.ErrPreconditionFailed) {
+		return fiber.NewError(http.StatusPreconditionFailed, err.Error())
+	}
+	if errors.Is(err, distributor.ErrKeyTooLarge) || errors.Is(err, distributor.ErrInvalidBucket) {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, distributor.ErrValueTooLarge) {
+		return fiber.NewError(http.StatusRequestEntityTooLarge, err.Error())
+	}
+	return errors.Wrapf(err, format, args...)
+}
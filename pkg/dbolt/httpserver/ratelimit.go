@@ -0,0 +1,63 @@
+package httpserver
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/ratelimit"
+	"github.com/pkg/errors"
+)
+
+// limit 은 서버의 부하가 높으면 요청을 거절하고, client 별 요청 속도 제한을 적용한다.
+// client 는 인증이 켜져 있으면 principal 이고 꺼져 있으면 client IP 이다.
+func (s *Server) limit(c *fiber.Ctx) error {
+	if err := s.limiter.Shed(isWrite(c)); err != nil {
+		return tooManyRequests(c, err)
+	}
+	if err := s.limiter.AllowClient(s.clientID(c)); err != nil {
+		return tooManyRequests(c, err)
+	}
+	return c.Next()
+}
+
+// limitBucket 은 요청의 bucket 에 bucket 별 요청 속도 제한을 적용한다. resolveTenant 다음에 실행해야 한다.
+func (s *Server) limitBucket(c *fiber.Ctx) error {
+	if err := s.limiter.AllowBucket(string(s.bucketName(c))); err != nil {
+		return tooManyRequests(c, err)
+	}
+	return c.Next()
+}
+
+func (s *Server) clientID(c *fiber.Ctx) string {
+	if principal, ok := c.Locals(principalKey).(*auth.Principal); ok {
+		return "principal:" + principal.Name
+	}
+	return "ip:" + c.IP()
+}
+
+func isWrite(c *fiber.Ctx) bool {
+	switch c.Method() {
+	case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// tooManyRequests 는 제한으로 거절한 요청에 429 와 Retry-After 로 응답한다.
+func tooManyRequests(c *fiber.Ctx, err error) error {
+	var rejection *ratelimit.Rejection
+	if !errors.As(err, &rejection) {
+		return err
+	}
+	setRetryAfter(c, rejection.RetryAfter)
+	return fiber.NewError(http.StatusTooManyRequests, rejection.Error())
+}
+
+// setRetryAfter 는 d 를 올림한 초를 Retry-After 로 정한다. 최소 1초이다.
+func setRetryAfter(c *fiber.Ctx, d time.Duration) {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Max(1, math.Ceil(d.Seconds())))))
+}
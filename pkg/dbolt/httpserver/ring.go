@@ -0,0 +1,60 @@
+package httpserver
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/grafana/dskit/ring"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/pkg/errors"
+)
+
+type RingInstance struct {
+	Addr string `json:"addr"`
+	// URL 은 인스턴스의 API 주소이다. 모든 인스턴스가 같은 포트와 scheme 을 사용한다고 가정한다.
+	URL    string   `json:"url"`
+	Zone   string   `json:"zone,omitempty"`
+	Tokens []uint32 `json:"tokens"`
+}
+
+// RingResponse 는 client 가 key 의 replica 를 직접 찾기 위한 Ring 의 상태이다.
+type RingResponse struct {
+	// Tenant 가 있으면 저장소의 bucket 이름은 "<tenant>/<bucket>" 이고 Instances 는 tenant 의 shard 이다.
+	Tenant            string          `json:"tenant,omitempty"`
+	ReplicationFactor int             `json:"replicationFactor"`
+	ZoneAwareness     bool            `json:"zoneAwareness"`
+	Instances         []*RingInstance `json:"instances"`
+}
+
+// getRing 은 요청한 tenant 의 값을 쓰는 Ring 의 정상 인스턴스와 token 을 반환한다.
+func (s *Server) getRing(c *fiber.Ctx) error {
+	tenantID := s.tenantID(c)
+	var bucketName []byte
+	if tenantID != "" {
+		bucketName = tenant.Bucket(tenantID, nil)
+	}
+	r, err := s.dist.WriteRing(c.UserContext(), bucketName)
+	if err != nil {
+		return err
+	}
+	replicationSet, err := r.GetAllHealthy(ring.Read)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the healthy instances")
+	}
+	scheme := "http"
+	if s.tls != nil {
+		scheme = "https"
+	}
+	resp := &RingResponse{Tenant: tenantID, ReplicationFactor: r.ReplicationFactor()}
+	for _, instance := range replicationSet.Instances {
+		if instance.Zone != "" {
+			resp.ZoneAwareness = true
+		}
+		resp.Instances = append(resp.Instances, &RingInstance{
+			Addr:   instance.Addr,
+			URL:    distributor.InstanceURL(scheme, instance.Addr, s.cfg.HTTPListenPort),
+			Zone:   instance.Zone,
+			Tokens: instance.Tokens,
+		})
+	}
+	return c.JSON(resp)
+}
@@ -0,0 +1,143 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultScanLimit = 100
+	maxScanLimit     = 1000
+	// maxBatchOperations 는 한 batch 요청에 넣을 수 있는 연산의 최대 개수이다.
+	maxBatchOperations = 1000
+)
+
+type ScanEntry struct {
+	Key     string `json:"key"`
+	Value   []byte `json:"value"`
+	Version uint64 `json:"version"`
+	// Chunked 이면 값은 chunk 로 나눠서 저장되어 있으므로 Value 가 비어 있고 key 를 GET 으로 읽어야 한다.
+	Chunked bool `json:"chunked,omitempty"`
+}
+
+type ScanResponse struct {
+	Entries []*ScanEntry `json:"entries"`
+	// More 이면 Next 를 after 로 넘겨 이어서 읽는다.
+	More bool   `json:"more"`
+	Next string `json:"next,omitempty"`
+}
+
+// getBucket 은 bucket 의 key 를 정렬된 순서로 읽는다. (?prefix=&after=&limit=)
+func (s *Server) getBucket(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", defaultScanLimit)
+	if limit <= 0 || limit > maxScanLimit {
+		return fiber.NewError(http.StatusBadRequest, "limit must be between 1 and 1000")
+	}
+	var after []byte
+	if value := c.Query("after"); value != "" {
+		after = []byte(value)
+	}
+	entries, more, err := s.dist.Scan(c.UserContext(), s.bucketName(c), []byte(c.Query("prefix")), after, limit)
+	if err != nil {
+		return errors.Wrapf(err, "failed to scan the bucket, bucket=%v", c.Params("bucket"))
+	}
+	resp := &ScanResponse{Entries: make([]*ScanEntry, 0, len(entries)), More: more}
+	for _, entry := range entries {
+		resp.Entries = append(resp.Entries, &ScanEntry{Key: string(entry.Key), Value: entry.Value, Version: entry.Version, Chunked: entry.Manifest != nil})
+	}
+	if more && len(entries) > 0 {
+		resp.Next = string(entries[len(entries)-1].Key)
+	}
+	return c.JSON(resp)
+}
+
+type BatchOperation struct {
+	// Op 는 put 또는 delete 이다.
+	Op     string `json:"op"`
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+	// IfVersion, IfAbsent 는 If-Match, If-None-Match: * 와 같다.
+	IfVersion uint64 `json:"ifVersion,omitempty"`
+	IfAbsent  bool   `json:"ifAbsent,omitempty"`
+}
+
+type BatchRequest struct {
+	Operations []*BatchOperation `json:"operations"`
+}
+
+type BatchResult struct {
+	Version uint64 `json:"version,omitempty"`
+	// Status 는 같은 연산을 단건 API 로 요청했을 때의 HTTP 상태이다.
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []*BatchResult `json:"results"`
+}
+
+// postBatch 는 여러 put, delete 를 한 요청으로 처리한다. 연산은 차례로 적용되며 원자적이지 않다.
+// 연산마다 결과를 반환하므로 일부가 실패해도 200 을 반환한다.
+func (s *Server) postBatch(c *fiber.Ctx) error {
+	var req BatchRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, "failed to parse the request body")
+	}
+	if len(req.Operations) > maxBatchOperations {
+		return fiber.NewError(http.StatusBadRequest, "too many operations")
+	}
+	for _, op := range req.Operations {
+		if op.Op != "put" && op.Op != "delete" {
+			return fiber.NewError(http.StatusBadRequest, "unknown op : "+op.Op)
+		}
+		if op.Bucket == "" || op.Key == "" {
+			return fiber.NewError(http.StatusBadRequest, "bucket and key required")
+		}
+		if err := s.authorizeBucket(c, op.Bucket, auth.PermissionWrite); err != nil {
+			return err
+		}
+	}
+	// bucket 별 요청 속도 제한은 operation 마다 적용하고, 하나라도 넘으면 batch 전체를 거절한다.
+	for _, op := range req.Operations {
+		if err := s.limiter.AllowBucket(string(s.batchBucketName(c, op.Bucket))); err != nil {
+			return tooManyRequests(c, err)
+		}
+	}
+
+	resp := &BatchResponse{Results: make([]*BatchResult, 0, len(req.Operations))}
+	for _, op := range req.Operations {
+		bucketName := s.batchBucketName(c, op.Bucket)
+		cond := &distributor.Precondition{IfVersion: op.IfVersion, IfAbsent: op.IfAbsent}
+		result := &BatchResult{Status: http.StatusOK}
+		var err error
+		if op.Op == "put" {
+			result.Version, err = s.dist.PutIf(c.UserContext(), bucketName, []byte(op.Key), op.Value, cond)
+		} else {
+			err = s.dist.DeleteIf(c.UserContext(), bucketName, []byte(op.Key), cond)
+		}
+		if err != nil {
+			result.Status = http.StatusInternalServerError
+			var fiberErr *fiber.Error
+			if errors.As(writeError(err, "batch"), &fiberErr) {
+				result.Status = fiberErr.Code
+			}
+			result.Error = err.Error()
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return c.JSON(resp)
+}
+
+// batchBucketName 은 batch operation 의 bucket 을 저장소의 bucket 이름으로 바꾼다.
+func (s *Server) batchBucketName(c *fiber.Ctx, bucket string) []byte {
+	if tenantID := s.tenantID(c); tenantID != "" {
+		return tenant.Bucket(tenantID, []byte(bucket))
+	}
+	return []byte(bucket)
+}
@@ -0,0 +1,83 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/pkg/errors"
+)
+
+// resolveTenant 는 tenancy 가 켜져 있으면 요청의 tenant 를 정하고 tenant 의 요청 속도 제한을 적용한다.
+// 인증에서 tenant 가 정해지지 않았으면 tenant.HeaderName 헤더를 사용한다.
+func (s *Server) resolveTenant(c *fiber.Ctx) error {
+	if !s.tenants.Enabled() {
+		return c.Next()
+	}
+	ctx := c.UserContext()
+	if _, err := tenant.ID(ctx); errors.Is(err, tenant.ErrNoTenant) {
+		if header := c.Get(tenant.HeaderName); header != "" {
+			ctx = tenant.Inject(ctx, header)
+		}
+	}
+	tenantID, err := tenant.ID(ctx)
+	if errors.Is(err, tenant.ErrNoTenant) {
+		return fiber.NewError(http.StatusUnauthorized, "tenant required : header="+tenant.HeaderName)
+	}
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if !s.tenants.Allow(tenantID) {
+		setRetryAfter(c, s.tenants.RetryAfter(tenantID))
+		return fiber.NewError(http.StatusTooManyRequests, "request rate limit exceeded : tenant="+tenantID)
+	}
+	c.SetUserContext(ctx)
+	return c.Next()
+}
+
+// tenantID 는 resolveTenant 가 정한 tenant 를 반환한다. tenancy 가 꺼져 있으면 "" 이다.
+func (s *Server) tenantID(c *fiber.Ctx) string {
+	if !s.tenants.Enabled() {
+		return ""
+	}
+	tenantID, _ := tenant.ID(c.UserContext())
+	return tenantID
+}
+
+// bucketName 은 요청의 bucket 을 저장소의 bucket 이름으로 바꾼다.
+func (s *Server) bucketName(c *fiber.Ctx) []byte {
+	bucket := []byte(c.Params("bucket"))
+	if tenantID := s.tenantID(c); tenantID != "" {
+		return tenant.Bucket(tenantID, bucket)
+	}
+	return bucket
+}
+
+func (s *Server) getTenants(c *fiber.Ctx) error {
+	usages, err := s.tenants.Usage(c.UserContext(), c.QueryBool("cluster"))
+	if err != nil {
+		return errors.Wrap(err, "failed to read the tenant usage")
+	}
+	return c.JSON(usages)
+}
+
+func (s *Server) getTenant(c *fiber.Ctx) error {
+	usages, err := s.tenants.Usage(c.UserContext(), c.QueryBool("cluster"))
+	if err != nil {
+		return errors.Wrap(err, "failed to read the tenant usage")
+	}
+	for _, usage := range usages {
+		if usage.Tenant == c.Params("tenant") {
+			return c.JSON(usage)
+		}
+	}
+	return fiber.NewError(http.StatusNotFound, "tenant not found")
+}
+
+func (s *Server) internalUsage(c *fiber.Ctx) error {
+	usage, err := s.localStore.Usage(c.UserContext())
+	if err != nil {
+		return err
+	}
+	return c.JSON(usage)
+}
@@ -0,0 +1,117 @@
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// watchKeepAliveInterval 마다 주석을 보내서 프록시가 유휴 연결을 끊지 않게 하고 끊긴 연결을 감지한다.
+const watchKeepAliveInterval = 15 * time.Second
+
+type WatchEvent struct {
+	Revision  uint64    `json:"revision"`
+	Type      string    `json:"type"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// getWatch 는 이 노드에 적용된 변경을 Server-Sent Events 로 전달한다.
+// 재연결할 때 Last-Event-ID 헤더가 있으면 그 다음 revision 부터 이어서 전달한다.
+func (s *Server) getWatch(c *fiber.Ctx) error {
+	bucket := c.Params("bucket")
+	req := store.WatchRequest{
+		BucketName: s.bucketName(c),
+		Key:        []byte(c.Query("key")),
+		Prefix:     c.QueryBool("prefix"),
+	}
+	if revision := c.Query("start_revision"); revision != "" {
+		startRevision, err := strconv.ParseUint(revision, 10, 64)
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, "invalid start_revision")
+		}
+		req.StartRevision = startRevision
+	}
+	if lastEventID := c.Get("Last-Event-ID"); lastEventID != "" {
+		lastRevision, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, "invalid Last-Event-ID")
+		}
+		req.StartRevision = lastRevision + 1
+	}
+	if !req.Prefix && len(req.Key) == 0 {
+		return fiber.NewError(http.StatusBadRequest, "key required unless prefix=true")
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events, errc := s.localStore.Watch(ctx, req)
+
+		keepAlive := time.NewTicker(watchKeepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					if err := <-errc; err != nil {
+						s.logger.Warn("Watch stopped.", zap.Error(err))
+						fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
+						_ = w.Flush()
+					}
+					return
+				}
+				if err := writeWatchEvent(w, bucket, event); err != nil {
+					s.logger.Debug("Watch client disconnected.", zap.Error(err))
+					return
+				}
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				if err := w.Flush(); err != nil {
+					s.logger.Debug("Watch client disconnected.", zap.Error(err))
+					return
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// writeWatchEvent 는 event 를 기록한다. 저장소의 bucket 이름에는 tenant 가 붙어 있을 수 있으므로 요청한 bucket 으로 내보낸다.
+func writeWatchEvent(w *bufio.Writer, bucket string, event *store.ChangeEvent) error {
+	watchEvent := &WatchEvent{
+		Revision: event.Revision,
+		Type:     event.Type.String(),
+		Bucket:   bucket,
+		Key:      string(event.Key),
+	}
+	if event.Type == store.ChangePut {
+		versionedValue, err := distributor.ParseVersionedValue(event.Value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid stored value : revision=%d", event.Revision)
+		}
+		watchEvent.Value = versionedValue.Value
+		watchEvent.UpdatedAt = versionedValue.UpdatedAt
+	}
+	data, err := json.Marshal(watchEvent)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Revision, watchEvent.Type, data)
+	return w.Flush()
+}
@@ -1,44 +1,189 @@
 package dbolt
 
 import (
+	"fmt"
+	"runtime"
+	"strings"
+
 	"github.com/go-kit/log"
-	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// component 별로 log level 을 따로 정할 수 있는 logger 의 이름이다.
+const (
+	ComponentDSKit       = "dskit"
+	ComponentMemberlist  = "memberlist"
+	ComponentDistributor = "distributor"
+	ComponentHTTP        = "http"
 )
 
+// Logging 은 root logger 와 component 별 logger 를 만든다. 모든 logger 는 같은 출력을 쓰고 level 만 다르다.
+// level 은 설정을 다시 읽으면 ApplyConfig 로 바뀐다.
+type Logging struct {
+	base       *zap.Logger
+	logger     *zap.Logger
+	level      zap.AtomicLevel
+	components map[string]zap.AtomicLevel
+}
+
+func NewLogging(cfg *LogConfig) (*Logging, error) {
+	zapConfig := zap.NewProductionConfig()
+	// level 은 logger 마다 levelCore 가 확인하므로 출력은 모든 level 을 받는다.
+	zapConfig.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+	zapConfig.Sampling = nil
+	zapConfig.Encoding = cfg.format()
+	if zapConfig.Encoding == LogFormatConsole {
+		zapConfig.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	}
+	base, err := zapConfig.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Logging{
+		base:  base,
+		level: zap.NewAtomicLevel(),
+		components: map[string]zap.AtomicLevel{
+			ComponentDSKit:       zap.NewAtomicLevel(),
+			ComponentMemberlist:  zap.NewAtomicLevel(),
+			ComponentDistributor: zap.NewAtomicLevel(),
+			ComponentHTTP:        zap.NewAtomicLevel(),
+		},
+	}
+	l.logger = withLevel(base, l.level)
+	l.ApplyConfig(cfg)
+	return l, nil
+}
+
+// Logger 는 component 가 아닌 곳에서 쓰는 root logger 이다. level 은 log.level 이다.
+func (l *Logging) Logger() *zap.Logger {
+	return l.logger
+}
+
+// Component 는 name 의 level 을 따르는 logger 이다. name 은 Component 상수 중 하나이다.
+func (l *Logging) Component(name string) *zap.Logger {
+	level, ok := l.components[name]
+	if !ok {
+		return l.logger.Named(name)
+	}
+	return withLevel(l.base, level).Named(name)
+}
+
+// GoKit 은 dskit 에 넘기는 go-kit logger 이다.
+func (l *Logging) GoKit(name string) log.Logger {
+	return NewZapGoKitLogger(l.Component(name))
+}
+
+// ApplyConfig 는 log.level 과 log.components 를 적용한다. cfg 는 검증된 설정이어야 한다.
+func (l *Logging) ApplyConfig(cfg *LogConfig) {
+	level, _ := cfg.level()
+	l.level.SetLevel(level)
+	for name, componentLevel := range l.components {
+		override, _ := cfg.Components.level(name)
+		if override == nil {
+			componentLevel.SetLevel(level)
+			continue
+		}
+		componentLevel.SetLevel(*override)
+	}
+}
+
+func withLevel(logger *zap.Logger, level zapcore.LevelEnabler) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &levelCore{Core: core, level: level}
+	}))
+}
+
+// levelCore 는 core 의 level 대신 level 로 출력 여부를 정한다. root logger 보다 낮은 level 도 출력할 수 있다.
+type levelCore struct {
+	zapcore.Core
+	level zapcore.LevelEnabler
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(entry.Level) {
+		return ce
+	}
+	return ce.AddCore(entry, c)
+}
+
 var _ log.Logger = (*ZapGoKitLogger)(nil)
 
+// ZapGoKitLogger 는 go-kit logger 를 쓰는 dskit 의 로그를 zap 으로 보낸다.
+// go-kit 의 level 값을 zap 의 level 로 바꾸고, caller 는 go-kit 을 호출한 dskit 의 코드이다.
 type ZapGoKitLogger struct {
 	logger *zap.Logger
 }
 
+func NewZapGoKitLogger(logger *zap.Logger) *ZapGoKitLogger {
+	return &ZapGoKitLogger{logger: logger}
+}
+
 func (z *ZapGoKitLogger) Log(keyvals ...any) error {
-	msg, others, err := SplitMsgAndOthers(keyvals)
-	if err != nil {
-		return err
+	level, msg, others := SplitMsgAndOthers(keyvals)
+	ce := z.logger.Check(level, msg)
+	if ce == nil {
+		return nil
+	}
+	if caller, ok := goKitCaller(); ok {
+		ce.Caller = caller
 	}
-	z.logger.Info(msg, others...)
+	ce.Write(others...)
 	return nil
 }
 
-func SplitMsgAndOthers(keyvals []any) (string, []zap.Field, error) {
+// SplitMsgAndOthers 는 go-kit 의 keyvals 에서 level 과 msg 를 꺼내고 나머지를 zap 의 field 로 바꾼다.
+// level 이 없거나 알 수 없으면 info 이다. 문자열이 아닌 key 와 msg 는 fmt.Sprint 로 바꾸고,
+// 값이 없는 마지막 key 는 go-kit 처럼 log.ErrMissingValue 를 값으로 쓴다.
+func SplitMsgAndOthers(keyvals []any) (zapcore.Level, string, []zap.Field) {
+	level := zapcore.InfoLevel
 	var msg string
 	var others []zap.Field
 
-	end := len(keyvals) - 1
-	for i := 0; i < end; i += 2 {
-		keyval, ok := keyvals[i].(string)
-		if !ok {
-			return "", nil, errors.New("key of log must be string type")
-		} else if keyval == "msg" {
-			msg, ok = keyvals[i+1].(string)
-			if !ok {
-				return "", nil, errors.New("log message must be string type")
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		var value any = log.ErrMissingValue
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		switch key {
+		case "msg":
+			msg = fmt.Sprint(value)
+		case "level":
+			if l, err := zapcore.ParseLevel(fmt.Sprint(value)); err == nil {
+				level = l
+			} else {
+				others = append(others, zap.Any(key, value))
 			}
-		} else {
-			others = append(others, zap.Any(keyval, keyvals[i+1]))
+		default:
+			others = append(others, zap.Any(key, value))
 		}
 	}
 
-	return msg, others, nil
+	return level, msg, others
+}
+
+// goKitCaller 는 go-kit 과 이 파일의 함수를 건너뛴 첫 번째 호출자이다.
+func goKitCaller() (zapcore.EntryCaller, bool) {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "github.com/go-kit/log") {
+			return zapcore.NewEntryCaller(frame.PC, frame.File, frame.Line, true), true
+		}
+		if !more {
+			return zapcore.EntryCaller{}, false
+		}
+	}
 }
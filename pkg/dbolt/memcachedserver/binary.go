@@ -0,0 +1,327 @@
+package memcachedserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/pkg/errors"
+)
+
+const (
+	binaryHeaderSize    = 24
+	binaryResponseMagic = 0x81
+	// maxBodyOverhead 는 값 외의 key, extras 로 허용하는 body 의 크기이다.
+	maxBodyOverhead = 1024
+	// noInitial 은 incr, decr 의 expiration 이 이 값이면 key 가 없을 때 만들지 않는다는 뜻이다.
+	noInitial = 0xffffffff
+)
+
+const (
+	opGet       byte = 0x00
+	opSet       byte = 0x01
+	opAdd       byte = 0x02
+	opReplace   byte = 0x03
+	opDelete    byte = 0x04
+	opIncrement byte = 0x05
+	opDecrement byte = 0x06
+	opQuit      byte = 0x07
+	opGetQ      byte = 0x09
+	opNoop      byte = 0x0a
+	opVersion   byte = 0x0b
+	opGetK      byte = 0x0c
+	opGetKQ     byte = 0x0d
+	opStat      byte = 0x10
+	opSetQ      byte = 0x11
+	opAddQ      byte = 0x12
+	opReplaceQ  byte = 0x13
+	opDeleteQ   byte = 0x14
+	opIncrQ     byte = 0x15
+	opDecrQ     byte = 0x16
+	opQuitQ     byte = 0x17
+	opTouch     byte = 0x1c
+	opSASLList  byte = 0x20
+	opSASLAuth  byte = 0x21
+)
+
+const (
+	statusOK             uint16 = 0x00
+	statusKeyNotFound    uint16 = 0x01
+	statusKeyExists      uint16 = 0x02
+	statusTooLarge       uint16 = 0x03
+	statusInvalidArgs    uint16 = 0x04
+	statusNonNumeric     uint16 = 0x06
+	statusAuthError      uint16 = 0x20
+	statusUnknownCommand uint16 = 0x81
+	statusInternalError  uint16 = 0x84
+)
+
+// opNames 는 metric 의 command label 이다. quiet 명령은 quiet 가 아닌 명령과 같은 이름을 사용한다.
+var opNames = map[byte]string{
+	opGet: "get", opGetQ: "get", opGetK: "get", opGetKQ: "get",
+	opSet: "set", opSetQ: "set", opAdd: "add", opAddQ: "add", opReplace: "replace", opReplaceQ: "replace",
+	opDelete: "delete", opDeleteQ: "delete", opIncrement: "incr", opIncrQ: "incr", opDecrement: "decr", opDecrQ: "decr",
+	opTouch: "touch", opNoop: "noop", opVersion: "version", opStat: "stats", opSASLList: "sasl_list_mechs", opSASLAuth: "sasl_auth",
+}
+
+// binaryRequest 는 binary protocol 의 요청이다. body 는 extras, key, value 순서이다.
+type binaryRequest struct {
+	opcode byte
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    []byte
+	value  []byte
+}
+
+var errBinaryProtocol = errors.New("invalid binary request")
+
+// serveBinary 는 binary protocol 의 요청을 처리한다. quiet 요청은 성공하면 응답하지 않으므로 읽을 요청이 없을 때 모아서 보낸다.
+func (c *conn) serveBinary() {
+	for {
+		req, err := c.readBinaryRequest()
+		if errors.Is(err, errTooLarge) {
+			c.binaryError(req, statusTooLarge, err)
+		} else if err != nil {
+			return
+		} else if quit := c.binaryCommand(req); quit {
+			_ = c.bw.Flush()
+			return
+		}
+		if c.br.Buffered() == 0 {
+			if err := c.bw.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readBinaryRequest 는 요청 하나를 읽는다. body 가 너무 크면 읽고 버린 뒤 errTooLarge 와 함께 header 만 반환한다.
+func (c *conn) readBinaryRequest() (*binaryRequest, error) {
+	header := make([]byte, binaryHeaderSize)
+	if _, err := io.ReadFull(c.br, header); err != nil {
+		return nil, err
+	}
+	if header[0] != binaryRequestMagic {
+		return nil, errBinaryProtocol
+	}
+	keyLength := int(binary.BigEndian.Uint16(header[2:4]))
+	extrasLength := int(header[4])
+	bodyLength := int(binary.BigEndian.Uint32(header[8:12]))
+	req := &binaryRequest{
+		opcode: header[1],
+		opaque: binary.BigEndian.Uint32(header[12:16]),
+		cas:    binary.BigEndian.Uint64(header[16:24]),
+	}
+	if keyLength+extrasLength > bodyLength {
+		return nil, errBinaryProtocol
+	}
+	if bodyLength > c.s.cfg.maxItemSize()+maxBodyOverhead {
+		if _, err := io.CopyN(io.Discard, c.br, int64(bodyLength)); err != nil {
+			return nil, err
+		}
+		return req, errTooLarge
+	}
+	body := make([]byte, bodyLength)
+	if _, err := io.ReadFull(c.br, body); err != nil {
+		return nil, err
+	}
+	req.extras = body[:extrasLength]
+	req.key = body[extrasLength : extrasLength+keyLength]
+	req.value = body[extrasLength+keyLength:]
+	return req, nil
+}
+
+// binaryCommand 는 요청 하나를 처리하고 연결을 끊어야 하면 true 를 반환한다.
+func (c *conn) binaryCommand(req *binaryRequest) bool {
+	switch req.opcode {
+	case opQuit:
+		c.binaryResponse(req, statusOK, 0, nil, nil, nil)
+		return true
+	case opQuitQ:
+		return true
+	}
+	name, ok := opNames[req.opcode]
+	if !ok {
+		c.s.metrics.commands.WithLabelValues("binary", "unknown", "error").Inc()
+		c.binaryResponse(req, statusUnknownCommand, 0, nil, nil, []byte("Unknown command"))
+		return false
+	}
+	ctx, cancel := context.WithTimeout(c.s.ctx, time.Duration(c.s.timeout.Load()))
+	defer cancel()
+
+	var err error
+	switch req.opcode {
+	case opGet, opGetQ, opGetK, opGetKQ:
+		err = c.binaryGet(ctx, name, req)
+	case opSet, opSetQ, opAdd, opAddQ, opReplace, opReplaceQ:
+		err = c.binaryStore(ctx, name, req)
+	case opDelete, opDeleteQ:
+		err = c.binaryDelete(ctx, name, req)
+	case opIncrement, opIncrQ, opDecrement, opDecrQ:
+		err = c.binaryIncr(ctx, name, req)
+	case opTouch:
+		err = c.binaryTouch(ctx, name, req)
+	case opNoop, opStat:
+		// stats 는 빈 목록의 끝만 보낸다.
+		c.binaryResponse(req, statusOK, 0, nil, nil, nil)
+	case opVersion:
+		c.binaryResponse(req, statusOK, 0, nil, nil, []byte("dbolt"))
+	case opSASLList:
+		c.binaryResponse(req, statusOK, 0, nil, nil, []byte("PLAIN"))
+	case opSASLAuth:
+		err = c.binarySASLAuth(req)
+	}
+	c.s.metrics.commands.WithLabelValues("binary", name, result(err)).Inc()
+	if err != nil {
+		c.binaryError(req, binaryStatus(err), err)
+	}
+	return false
+}
+
+// binaryStatus 는 오류를 binary protocol 의 status 로 바꾼다. binary protocol 의 add 는 key 가 있으면 KeyExists 이다.
+func binaryStatus(err error) uint16 {
+	switch {
+	case errors.Is(err, errNotFound):
+		return statusKeyNotFound
+	case errors.Is(err, errExists), errors.Is(err, errNotStored):
+		return statusKeyExists
+	case errors.Is(err, errTooLarge):
+		return statusTooLarge
+	case errors.Is(err, errNotNumeric):
+		return statusNonNumeric
+	case errors.Is(err, errUnauthorized), errors.Is(err, auth.ErrUnauthorized), errors.Is(err, auth.ErrForbidden):
+		return statusAuthError
+	case errors.Is(err, errInvalidKey), errors.Is(err, errBadFormat):
+		return statusInvalidArgs
+	}
+	return statusInternalError
+}
+
+func (c *conn) binaryGet(ctx context.Context, name string, req *binaryRequest) error {
+	if len(req.extras) != 0 || len(req.value) != 0 || !validKey(req.key) {
+		return errBadFormat
+	}
+	it, err := c.get(ctx, name, req.key)
+	if errors.Is(err, errNotFound) && (req.opcode == opGetQ || req.opcode == opGetKQ) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	extras := binary.BigEndian.AppendUint32(nil, it.flags)
+	var key []byte
+	if req.opcode == opGetK || req.opcode == opGetKQ {
+		key = req.key
+	}
+	c.binaryResponse(req, statusOK, it.cas, extras, key, it.value)
+	return nil
+}
+
+func (c *conn) binaryStore(ctx context.Context, name string, req *binaryRequest) error {
+	if len(req.extras) != 8 || !validKey(req.key) {
+		return errBadFormat
+	}
+	flags := binary.BigEndian.Uint32(req.extras[0:4])
+	exptime := int64(binary.BigEndian.Uint32(req.extras[4:8]))
+	mode, cas := modeSet, req.cas
+	switch req.opcode {
+	case opAdd, opAddQ:
+		mode, cas = modeAdd, 0
+	case opReplace, opReplaceQ:
+		mode = modeReplace
+	}
+	version, err := c.store(ctx, name, mode, req.key, flags, exptime, req.value, cas)
+	if err != nil {
+		return err
+	}
+	if req.opcode != opSetQ && req.opcode != opAddQ && req.opcode != opReplaceQ {
+		c.binaryResponse(req, statusOK, version, nil, nil, nil)
+	}
+	return nil
+}
+
+func (c *conn) binaryDelete(ctx context.Context, name string, req *binaryRequest) error {
+	if len(req.extras) != 0 || len(req.value) != 0 || !validKey(req.key) {
+		return errBadFormat
+	}
+	if err := c.delete(ctx, name, req.key, req.cas); err != nil {
+		return err
+	}
+	if req.opcode == opDelete {
+		c.binaryResponse(req, statusOK, 0, nil, nil, nil)
+	}
+	return nil
+}
+
+func (c *conn) binaryIncr(ctx context.Context, name string, req *binaryRequest) error {
+	if len(req.extras) != 20 || len(req.value) != 0 || !validKey(req.key) {
+		return errBadFormat
+	}
+	delta := binary.BigEndian.Uint64(req.extras[0:8])
+	initial := binary.BigEndian.Uint64(req.extras[8:16])
+	expiration := binary.BigEndian.Uint32(req.extras[16:20])
+	initialPtr := &initial
+	if expiration == noInitial {
+		initialPtr = nil
+	}
+	decr := req.opcode == opDecrement || req.opcode == opDecrQ
+	n, version, err := c.incr(ctx, name, req.key, delta, decr, initialPtr, int64(expiration))
+	if err != nil {
+		return err
+	}
+	if req.opcode == opIncrement || req.opcode == opDecrement {
+		c.binaryResponse(req, statusOK, version, nil, nil, binary.BigEndian.AppendUint64(nil, n))
+	}
+	return nil
+}
+
+func (c *conn) binaryTouch(ctx context.Context, name string, req *binaryRequest) error {
+	if len(req.extras) != 4 || len(req.value) != 0 || !validKey(req.key) {
+		return errBadFormat
+	}
+	if err := c.touch(ctx, name, req.key, int64(binary.BigEndian.Uint32(req.extras))); err != nil {
+		return err
+	}
+	c.binaryResponse(req, statusOK, 0, nil, nil, nil)
+	return nil
+}
+
+// binarySASLAuth 는 SASL PLAIN (authzid \0 authcid \0 password) 의 password 를 token 으로 인증한다.
+func (c *conn) binarySASLAuth(req *binaryRequest) error {
+	if string(req.key) != "PLAIN" {
+		return errors.Wrap(auth.ErrUnauthorized, "unsupported SASL mechanism")
+	}
+	parts := bytes.SplitN(req.value, []byte{0}, 3)
+	if len(parts) != 3 {
+		return errors.Wrap(auth.ErrUnauthorized, "invalid SASL PLAIN message")
+	}
+	if err := c.authenticateToken(string(parts[2])); err != nil {
+		return err
+	}
+	c.binaryResponse(req, statusOK, 0, nil, nil, []byte("Authenticated"))
+	return nil
+}
+
+func (c *conn) binaryError(req *binaryRequest, status uint16, err error) {
+	c.binaryResponse(req, status, 0, nil, nil, []byte(err.Error()))
+}
+
+func (c *conn) binaryResponse(req *binaryRequest, status uint16, cas uint64, extras, key, value []byte) {
+	header := make([]byte, binaryHeaderSize)
+	header[0] = binaryResponseMagic
+	header[1] = req.opcode
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(header[6:8], status)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:16], req.opaque)
+	binary.BigEndian.PutUint64(header[16:24], cas)
+	c.bw.Write(header)
+	c.bw.Write(extras)
+	c.bw.Write(key)
+	c.bw.Write(value)
+}
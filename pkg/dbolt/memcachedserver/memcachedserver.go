@@ -0,0 +1,541 @@
+package memcachedserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/decommission"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tlsconfig"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxKeyLength 는 memcached 의 key 최대 길이이다.
+	maxKeyLength = 250
+	// maxRelativeExptime 보다 큰 exptime 은 Unix 시각이다. (30일)
+	maxRelativeExptime = 60 * 60 * 24 * 30
+	// casRetries 는 incr, decr 이 다른 쓰기와 겹쳤을 때 다시 시도하는 횟수이다.
+	casRetries = 10
+)
+
+type Config struct {
+	Enabled    bool   `yaml:"enabled"`
+	ListenPort uint16 `yaml:"listen_port"`
+	// Bucket 은 모든 key 를 저장할 bucket 이다. 기본값은 memcached 이다.
+	Bucket string `yaml:"bucket"`
+	// Tenant 는 tenancy 가 켜져 있고 principal 에 tenant 가 없을 때 사용할 tenant 이다.
+	Tenant string `yaml:"tenant"`
+	// MaxItemSize 는 값의 최대 크기이다. 기본값은 memcached 와 같은 1MiB 이다.
+	MaxItemSize int `yaml:"max_item_size"`
+	// Timeout 은 한 명령의 제한 시간이다. 기본값은 10초이다.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c *Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.ListenPort == 0 {
+		return errors.New("memcached 'listen_port' required")
+	}
+	if c.MaxItemSize < 0 {
+		return errors.New("memcached 'max_item_size' must not be negative")
+	}
+	if c.Tenant != "" {
+		if _, err := tenant.ID(tenant.Inject(context.Background(), c.Tenant)); err != nil {
+			return errors.Wrap(err, "invalid memcached 'tenant'")
+		}
+	}
+	return nil
+}
+
+func (c *Config) bucket() []byte {
+	if c.Bucket == "" {
+		return []byte("memcached")
+	}
+	return []byte(c.Bucket)
+}
+
+func (c *Config) maxItemSize() int {
+	if c.MaxItemSize == 0 {
+		return 1 << 20
+	}
+	return c.MaxItemSize
+}
+
+func (c *Config) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return c.Timeout
+}
+
+// Server 는 memcached 의 text, binary protocol 로 Distributor 의 key 를 읽고 쓰는 listener 이다.
+// 연결의 첫 byte 로 protocol 을 정한다.
+type Server struct {
+	cfg     *Config
+	addr    string
+	tls     *tlsconfig.Server
+	dist    *distributor.Distributor
+	tenants *tenant.Service
+	auth    *auth.Service
+	// decommission 을 시작하면 key 를 다루는 명령을 거절한다.
+	decommission *decommission.Decommissioner
+	metrics      *metrics
+	logger       *zap.Logger
+	// timeout 은 한 명령의 제한 시간이다. 설정을 다시 읽으면 ApplyConfig 로 바뀐다.
+	timeout atomic.Int64
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*conn]struct{}
+	wg       sync.WaitGroup
+}
+
+func New(cfg *Config, addr string, serverTLS *tlsconfig.Server, dist *distributor.Distributor, tenants *tenant.Service, authService *auth.Service, decommissioner *decommission.Decommissioner, reg prometheus.Registerer, logger *zap.Logger) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		cfg:          cfg,
+		addr:         addr,
+		tls:          serverTLS,
+		dist:         dist,
+		tenants:      tenants,
+		auth:         authService,
+		decommission: decommissioner,
+		metrics:      newMetrics(reg),
+		logger:       logger,
+		ctx:          ctx,
+		cancel:       cancel,
+		conns:        make(map[*conn]struct{}),
+	}
+	s.timeout.Store(int64(cfg.timeout()))
+	return s
+}
+
+// ApplyConfig 는 다시 읽은 설정 중 timeout 만 적용한다. 나머지는 재시작해야 바뀐다.
+func (s *Server) ApplyConfig(cfg *Config) {
+	s.timeout.Store(int64(cfg.timeout()))
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen : addr=%s", s.addr)
+	}
+	if s.tls != nil {
+		ln = tls.NewListener(ln, s.tls.Config())
+	}
+	s.logger.Info("Starting memcached server.", zap.String("bindAddress", s.addr), zap.Bool("tls", s.tls != nil), zap.ByteString("bucket", s.cfg.bucket()))
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+	go s.accept(ln)
+	return nil
+}
+
+func (s *Server) Stop(ctx context.Context) error {
+	s.logger.Info("Stopping memcached server.")
+	s.cancel()
+	s.mu.Lock()
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	for c := range s.conns {
+		_ = c.netConn.Close()
+	}
+	s.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) accept(ln net.Listener) {
+	for {
+		netConn, err := ln.Accept()
+		if err != nil {
+			if s.ctx.Err() == nil {
+				s.logger.Error("memcached server stopped.", zap.Error(err))
+			}
+			return
+		}
+		c := &conn{
+			s:       s,
+			netConn: netConn,
+			br:      bufio.NewReader(netConn),
+			bw:      bufio.NewWriter(netConn),
+		}
+		s.mu.Lock()
+		if s.ctx.Err() != nil {
+			s.mu.Unlock()
+			_ = netConn.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.metrics.connections.Inc()
+			defer s.metrics.connections.Dec()
+			c.serve()
+			_ = netConn.Close()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// conn 은 client 연결 하나의 상태이다. 명령은 받은 순서대로 하나씩 처리한다.
+type conn struct {
+	s       *Server
+	netConn net.Conn
+	br      *bufio.Reader
+	bw      *bufio.Writer
+
+	authenticated bool
+	principal     *auth.Principal
+	tenantID      string
+}
+
+const binaryRequestMagic = 0x80
+
+func (c *conn) serve() {
+	if c.s.auth.Enabled() {
+		c.authenticateCertificate()
+	} else {
+		c.setPrincipal(nil)
+	}
+	first, err := c.br.Peek(1)
+	if err != nil {
+		return
+	}
+	if first[0] == binaryRequestMagic {
+		c.serveBinary()
+	} else {
+		c.serveText()
+	}
+}
+
+// authenticateCertificate 는 TLS client 인증서가 있으면 인증한다.
+// text protocol 에는 인증 명령이 없으므로 인증이 켜져 있으면 client 인증서 또는 binary protocol 의 SASL 이 필요하다.
+func (c *conn) authenticateCertificate() {
+	tlsConn, ok := c.netConn.(*tls.Conn)
+	if !ok {
+		return
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return
+	}
+	certificates := tlsConn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return
+	}
+	principal, err := c.s.auth.Authenticate(&auth.Request{PeerCertificates: certificates})
+	if err != nil {
+		return
+	}
+	c.setPrincipal(principal)
+}
+
+// authenticateToken 은 SASL PLAIN 의 password 를 Bearer token 으로 인증한다.
+func (c *conn) authenticateToken(token string) error {
+	if !c.s.auth.Enabled() {
+		return nil
+	}
+	principal, err := c.s.auth.Authenticate(&auth.Request{BearerToken: token})
+	if err != nil {
+		c.s.auth.Unauthenticated("memcached SASL", err)
+		return err
+	}
+	c.setPrincipal(principal)
+	return nil
+}
+
+func (c *conn) setPrincipal(principal *auth.Principal) {
+	c.authenticated = true
+	c.principal = principal
+	c.tenantID = c.s.cfg.Tenant
+	if principal != nil && principal.Tenant != "" {
+		c.tenantID = principal.Tenant
+	}
+}
+
+var (
+	errNotFound     = errors.New("not found")
+	errExists       = errors.New("exists")
+	errNotStored    = errors.New("not stored")
+	errNotNumeric   = errors.New("cannot increment or decrement non-numeric value")
+	errTooLarge     = errors.New("object too large for cache")
+	errUnauthorized = errors.New("authentication required")
+	errInvalidKey   = errors.New("invalid key")
+)
+
+// bucketName 은 인증, decommission, 권한, tenant 의 요청 속도 제한을 확인하고 저장소의 bucket 이름을 반환한다.
+func (c *conn) bucketName(command string, permission auth.Permission) ([]byte, error) {
+	if !c.authenticated {
+		return nil, errUnauthorized
+	}
+	if !c.s.decommission.Serving() {
+		return nil, decommission.ErrNotServing
+	}
+	bucketName := c.s.cfg.bucket()
+	if c.s.tenants.Enabled() {
+		if c.tenantID == "" {
+			return nil, errors.New("tenant required")
+		}
+		bucketName = tenant.Bucket(c.tenantID, bucketName)
+	}
+	// tenancy 가 켜져 있으면 tenant 를 붙인 이름으로 확인하므로 ACL 은 tenant 마다 따로 준다.
+	if c.s.auth.Enabled() {
+		if err := c.s.auth.Authorize(c.principal, string(bucketName), permission, "memcached "+command); err != nil {
+			return nil, err
+		}
+	}
+	if c.s.tenants.Enabled() && !c.s.tenants.Allow(c.tenantID) {
+		return nil, errors.New("request rate limit exceeded : tenant=" + c.tenantID)
+	}
+	return bucketName, nil
+}
+
+func validKey(key []byte) bool {
+	if len(key) == 0 || len(key) > maxKeyLength {
+		return false
+	}
+	for _, b := range key {
+		if b <= ' ' || b == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// expiresAt 은 memcached 의 exptime 을 만료 시각으로 바꾼다.
+// 0 이면 만료되지 않고, 30일 이하이면 지금부터의 초, 그보다 크면 Unix 시각이며, 음수이면 바로 만료된다.
+func expiresAt(exptime int64) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime < 0:
+		return time.Now()
+	case exptime <= maxRelativeExptime:
+		return time.Now().Add(time.Duration(exptime) * time.Second)
+	}
+	return time.Unix(exptime, 0)
+}
+
+// item 은 get 의 결과이다. cas 는 VersionedValue 의 version 이다.
+type item struct {
+	flags uint32
+	value []byte
+	cas   uint64
+}
+
+func (c *conn) get(ctx context.Context, command string, key []byte) (*item, error) {
+	bucketName, err := c.bucketName(command, auth.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+	versionedValue, err := c.s.dist.GetVersioned(ctx, bucketName, key)
+	if errors.Is(err, distributor.ErrKeyValueNotFound) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &item{flags: versionedValue.Flags, value: versionedValue.Value, cas: versionedValue.Version()}, nil
+}
+
+type storeMode int
+
+const (
+	modeSet storeMode = iota
+	modeAdd
+	modeReplace
+	modeCAS
+)
+
+// store 는 set, add, replace, cas 를 Precondition 으로 바꿔서 쓰고 새 cas 를 반환한다.
+// binary protocol 은 set, replace 에도 cas 를 줄 수 있으므로 cas 가 0 이 아니면 version 을 확인한다.
+func (c *conn) store(ctx context.Context, command string, mode storeMode, key []byte, flags uint32, exptime int64, value []byte, cas uint64) (uint64, error) {
+	if len(value) > c.s.cfg.maxItemSize() {
+		return 0, errTooLarge
+	}
+	bucketName, err := c.bucketName(command, auth.PermissionWrite)
+	if err != nil {
+		return 0, err
+	}
+	cond := &distributor.Precondition{IfVersion: cas}
+	switch mode {
+	case modeAdd:
+		cond.IfAbsent = true
+	case modeReplace:
+		cond.IfExists = true
+	}
+	version, err := c.s.dist.PutWithOptions(ctx, bucketName, key, value, &distributor.PutOptions{Cond: cond, ExpiresAt: expiresAt(exptime), Flags: flags})
+	if errors.Is(err, distributor.ErrPreconditionFailed) {
+		return 0, c.preconditionError(ctx, bucketName, key, mode, cas)
+	}
+	if errors.Is(err, distributor.ErrValueTooLarge) {
+		return 0, errTooLarge
+	}
+	if errors.Is(err, distributor.ErrKeyTooLarge) {
+		return 0, errInvalidKey
+	}
+	return version, err
+}
+
+// preconditionError 는 조건이 맞지 않은 이유를 memcached 의 결과로 바꾼다. cas 이면 key 가 없는지 version 이 다른지 구분한다.
+func (c *conn) preconditionError(ctx context.Context, bucketName, key []byte, mode storeMode, cas uint64) error {
+	if cas == 0 {
+		if mode == modeAdd {
+			return errNotStored
+		}
+		return errNotFound
+	}
+	_, err := c.s.dist.GetVersioned(ctx, bucketName, key)
+	if errors.Is(err, distributor.ErrKeyValueNotFound) {
+		return errNotFound
+	}
+	return errExists
+}
+
+func (c *conn) delete(ctx context.Context, command string, key []byte, cas uint64) error {
+	bucketName, err := c.bucketName(command, auth.PermissionWrite)
+	if err != nil {
+		return err
+	}
+	err = c.s.dist.DeleteIf(ctx, bucketName, key, &distributor.Precondition{IfExists: cas == 0, IfVersion: cas})
+	if errors.Is(err, distributor.ErrPreconditionFailed) {
+		return c.preconditionError(ctx, bucketName, key, modeCAS, cas)
+	}
+	return err
+}
+
+// incr 는 10진수 값에 delta 를 더하거나 뺀 값과 새 cas 를 반환한다. 더하면 2^64 에서 넘치고 빼면 0 아래로 내려가지 않는다.
+// 읽은 version 을 조건으로 쓰고 조건이 맞지 않으면 다시 읽는다. 조건 확인과 쓰기는 같은 coordinator 안에서만 직렬화되므로
+// 다른 coordinator 를 거친 incr, cas 와 동시에 실행되면 한쪽의 결과를 덮어쓸 수 있다.
+// initial 이 nil 이 아니면 key 가 없을 때 initial 을 쓴다. (binary protocol)
+func (c *conn) incr(ctx context.Context, command string, key []byte, delta uint64, decr bool, initial *uint64, exptime int64) (uint64, uint64, error) {
+	bucketName, err := c.bucketName(command, auth.PermissionWrite)
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := 0; i < casRetries; i++ {
+		current, err := c.s.dist.GetVersioned(ctx, bucketName, key)
+		if errors.Is(err, distributor.ErrKeyValueNotFound) {
+			if initial == nil {
+				return 0, 0, errNotFound
+			}
+			opts := &distributor.PutOptions{Cond: &distributor.Precondition{IfAbsent: true}, ExpiresAt: expiresAt(exptime)}
+			version, err := c.s.dist.PutWithOptions(ctx, bucketName, key, formatUint(*initial), opts)
+			if errors.Is(err, distributor.ErrPreconditionFailed) {
+				continue
+			}
+			return *initial, version, err
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		n, ok := parseUint(current.Value)
+		if !ok {
+			return 0, 0, errNotNumeric
+		}
+		switch {
+		case !decr:
+			n += delta
+		case delta > n:
+			n = 0
+		default:
+			n -= delta
+		}
+		opts := &distributor.PutOptions{Cond: &distributor.Precondition{IfVersion: current.Version()}, ExpiresAt: current.ExpiresAt, Flags: current.Flags}
+		version, err := c.s.dist.PutWithOptions(ctx, bucketName, key, formatUint(n), opts)
+		if errors.Is(err, distributor.ErrPreconditionFailed) {
+			continue
+		}
+		return n, version, err
+	}
+	return 0, 0, errors.New("too many concurrent updates")
+}
+
+func (c *conn) touch(ctx context.Context, command string, key []byte, exptime int64) error {
+	bucketName, err := c.bucketName(command, auth.PermissionWrite)
+	if err != nil {
+		return err
+	}
+	ok, err := c.s.dist.Expire(ctx, bucketName, key, expiresAt(exptime))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNotFound
+	}
+	return nil
+}
+
+func parseUint(value []byte) (uint64, bool) {
+	n, err := strconv.ParseUint(string(value), 10, 64)
+	return n, err == nil
+}
+
+func formatUint(n uint64) []byte {
+	return strconv.AppendUint(nil, n, 10)
+}
+
+type metrics struct {
+	commands    *prometheus.CounterVec
+	connections prometheus.Gauge
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	factory := promauto.With(reg)
+	return &metrics{
+		commands: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbolt_memcached_commands_total",
+			Help: "Total number of commands received by the memcached server.",
+		}, []string{"protocol", "command", "result"}),
+		connections: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "dbolt_memcached_connections",
+			Help: "Number of open memcached connections.",
+		}),
+	}
+}
+
+// result 는 metric 의 result label 이다. 찾지 못했거나 조건이 맞지 않은 것은 오류가 아니다.
+func result(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, errNotFound):
+		return "miss"
+	case errors.Is(err, errExists), errors.Is(err, errNotStored):
+		return "not_stored"
+	}
+	return "error"
+}
@@ -0,0 +1,440 @@
+package memcachedserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth/authtest"
+	"github.com/kwSeo/dbolt/pkg/dbolt/decommission"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor/distributortest"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestServer(t *testing.T, cfg *Config, authCfg *auth.Config, tenantCfg *tenant.Config) *Server {
+	t.Helper()
+	cluster := distributortest.New(t, &distributor.Config{})
+	reg := prometheus.NewRegistry()
+	authService, err := auth.New(authCfg, zap.NewNop())
+	require.NoError(t, err)
+	tenants := tenant.New(tenantCfg, tenant.NewQuotas(tenantCfg, tenant.NewOverrides(tenantCfg), reg), cluster.Ring, cluster.StorePool, cluster.LocalStore, reg, zap.NewNop())
+	decommissioner := decommission.New(&decommission.Config{}, nil, nil, nil, nil, reg, zap.NewNop())
+	return New(cfg, "", nil, cluster.Distributor, tenants, authService, decommissioner, reg, zap.NewNop())
+}
+
+// client 는 net.Pipe 로 Server 의 conn 하나와 연결된 client 이다.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (s *Server) dial(t *testing.T) *client {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	c := &conn{s: s, netConn: serverConn, br: bufio.NewReader(serverConn), bw: bufio.NewWriter(serverConn)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.serve()
+		_ = serverConn.Close()
+	}()
+	t.Cleanup(func() {
+		_ = clientConn.Close()
+		<-done
+	})
+	require.NoError(t, clientConn.SetDeadline(time.Now().Add(10*time.Second)))
+	return &client{t: t, conn: clientConn, r: bufio.NewReader(clientConn)}
+}
+
+func (c *client) send(request string) {
+	c.t.Helper()
+	_, err := c.conn.Write([]byte(request))
+	require.NoError(c.t, err)
+}
+
+func (c *client) readLine() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	require.NoError(c.t, err)
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+func (c *client) expect(lines ...string) {
+	c.t.Helper()
+	for _, line := range lines {
+		require.Equal(c.t, line, c.readLine())
+	}
+}
+
+// gets 는 key 의 cas 를 반환한다.
+func (c *client) gets(key string) uint64 {
+	c.t.Helper()
+	c.send("gets " + key + "\r\n")
+	fields := strings.Fields(c.readLine())
+	require.Len(c.t, fields, 5)
+	require.Equal(c.t, "VALUE", fields[0])
+	cas, err := strconv.ParseUint(fields[4], 10, 64)
+	require.NoError(c.t, err)
+	c.readLine()
+	c.expect("END")
+	return cas
+}
+
+func TestTextStorage(t *testing.T) {
+	c := newTestServer(t, &Config{Enabled: true}, &auth.Config{}, &tenant.Config{}).dial(t)
+
+	c.send("set k 5 0 5\r\nhello\r\n")
+	c.expect("STORED")
+	c.send("get k missing\r\n")
+	c.expect("VALUE k 5 5", "hello", "END")
+
+	c.send("add k 0 0 1\r\nx\r\n")
+	c.expect("NOT_STORED")
+	c.send("replace missing 0 0 1\r\nx\r\n")
+	c.expect("NOT_FOUND")
+	c.send("add other 0 0 1\r\nx\r\n")
+	c.expect("STORED")
+	c.send("replace other 0 0 1\r\ny\r\n")
+	c.expect("STORED")
+
+	cas := c.gets("k")
+	c.send("cas k 0 0 3 " + strconv.FormatUint(cas+1, 10) + "\r\nnew\r\n")
+	c.expect("EXISTS")
+	c.send("cas missing 0 0 3 1\r\nnew\r\n")
+	c.expect("NOT_FOUND")
+	c.send("cas k 0 0 3 " + strconv.FormatUint(cas, 10) + "\r\nnew\r\n")
+	c.expect("STORED")
+	c.send("get k\r\n")
+	c.expect("VALUE k 0 3", "new", "END")
+
+	// noreply 이면 결과를 보내지 않는다.
+	c.send("set k 0 0 3 noreply\r\nabc\r\ndelete other noreply\r\nget k other\r\n")
+	c.expect("VALUE k 0 3", "abc", "END")
+
+	c.send("delete k\r\n")
+	c.expect("DELETED")
+	c.send("delete k\r\n")
+	c.expect("NOT_FOUND")
+	c.send("touch k 10\r\n")
+	c.expect("NOT_FOUND")
+}
+
+func TestTextIncr(t *testing.T) {
+	c := newTestServer(t, &Config{Enabled: true}, &auth.Config{}, &tenant.Config{}).dial(t)
+
+	c.send("incr n 1\r\n")
+	c.expect("NOT_FOUND")
+	c.send("set n 3 100 2\r\n10\r\n")
+	c.expect("STORED")
+	c.send("incr n 5\r\n")
+	c.expect("15")
+	c.send("decr n 100\r\n")
+	c.expect("0")
+	c.send("incr n 18446744073709551615\r\n")
+	c.expect("18446744073709551615")
+	// 2^64 에서 넘친다.
+	c.send("incr n 2\r\n")
+	c.expect("1")
+	// flags 는 유지된다.
+	c.send("get n\r\n")
+	c.expect("VALUE n 3 1", "1", "END")
+	c.send("touch n 0\r\n")
+	c.expect("TOUCHED")
+
+	c.send("incr n -1\r\n")
+	c.expect("CLIENT_ERROR invalid numeric delta argument")
+	c.send("set s 0 0 5\r\nhello\r\n")
+	c.expect("STORED")
+	c.send("incr s 1\r\n")
+	c.expect("CLIENT_ERROR cannot increment or decrement non-numeric value")
+}
+
+func TestTextIncrConcurrent(t *testing.T) {
+	s := newTestServer(t, &Config{Enabled: true}, &auth.Config{}, &tenant.Config{})
+	c := s.dial(t)
+	c.send("set n 0 0 1\r\n0\r\n")
+	c.expect("STORED")
+
+	// 같은 coordinator 를 거친 incr 는 서로의 결과를 덮어쓰지 않는다.
+	// 다시 시도하는 횟수를 넘은 incr 는 SERVER_ERROR 로 실패하고 값을 바꾸지 않는다.
+	const clients, increments = 4, 20
+	var incremented atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		incrClient := s.dial(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				if _, err := incrClient.conn.Write([]byte("incr n 1\r\n")); err != nil {
+					t.Error(err)
+					return
+				}
+				line, err := incrClient.r.ReadString('\n')
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := strconv.ParseUint(strings.TrimSpace(line), 10, 64); err == nil {
+					incremented.Add(1)
+				} else if line != "SERVER_ERROR too many concurrent updates\r\n" {
+					t.Errorf("unexpected reply of incr: %s", line)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	require.Positive(t, incremented.Load())
+	value := strconv.FormatInt(incremented.Load(), 10)
+	c.send("get n\r\n")
+	c.expect("VALUE n 0 "+strconv.Itoa(len(value)), value, "END")
+}
+
+func TestTextErrors(t *testing.T) {
+	s := newTestServer(t, &Config{Enabled: true, MaxItemSize: 16}, &auth.Config{}, &tenant.Config{})
+	c := s.dial(t)
+
+	c.send("\r\n")
+	c.expect("ERROR")
+	c.send("unknown\r\n")
+	c.expect("ERROR")
+	c.send("get\r\n")
+	c.expect("CLIENT_ERROR bad command line format")
+	c.send("set k 0 0\r\n")
+	c.expect("CLIENT_ERROR bad command line format")
+	c.send("set k 0 0 3\r\nabcXX")
+	c.expect("CLIENT_ERROR bad data chunk")
+	// data block 은 읽고 버리므로 다음 명령을 읽을 수 있다.
+	c.send("set k x 0 3\r\nabc\r\nset " + strings.Repeat("k", maxKeyLength+1) + " 0 0 1\r\nx\r\n")
+	c.expect("CLIENT_ERROR bad command line format", "CLIENT_ERROR bad command line format")
+	c.send("set k 0 0 20\r\n" + strings.Repeat("x", 20) + "\r\nversion\r\n")
+	c.expect("SERVER_ERROR object too large for cache", "VERSION dbolt")
+
+	c.send(strings.Repeat("x", maxLineLength+1) + "\r\n")
+	c.expect("CLIENT_ERROR line too long")
+	_, err := c.r.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+// binaryResponse 는 binary protocol 의 응답이다.
+type binaryResponse struct {
+	opcode byte
+	status uint16
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    []byte
+	value  []byte
+}
+
+// binaryRequestBytes 는 binary protocol 의 요청이다.
+func binaryRequestBytes(opcode byte, opaque uint32, cas uint64, extras, key, value []byte) []byte {
+	request := make([]byte, binaryHeaderSize, binaryHeaderSize+len(extras)+len(key)+len(value))
+	request[0] = binaryRequestMagic
+	request[1] = opcode
+	binary.BigEndian.PutUint16(request[2:4], uint16(len(key)))
+	request[4] = byte(len(extras))
+	binary.BigEndian.PutUint32(request[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(request[12:16], opaque)
+	binary.BigEndian.PutUint64(request[16:24], cas)
+	request = append(request, extras...)
+	request = append(request, key...)
+	return append(request, value...)
+}
+
+func (c *client) sendBinary(opcode byte, opaque uint32, cas uint64, extras, key, value []byte) {
+	c.t.Helper()
+	c.send(string(binaryRequestBytes(opcode, opaque, cas, extras, key, value)))
+}
+
+func (c *client) readBinary() *binaryResponse {
+	c.t.Helper()
+	header := make([]byte, binaryHeaderSize)
+	_, err := io.ReadFull(c.r, header)
+	require.NoError(c.t, err)
+	require.Equal(c.t, byte(binaryResponseMagic), header[0])
+	keyLength := int(binary.BigEndian.Uint16(header[2:4]))
+	extrasLength := int(header[4])
+	body := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+	_, err = io.ReadFull(c.r, body)
+	require.NoError(c.t, err)
+	return &binaryResponse{
+		opcode: header[1],
+		status: binary.BigEndian.Uint16(header[6:8]),
+		opaque: binary.BigEndian.Uint32(header[12:16]),
+		cas:    binary.BigEndian.Uint64(header[16:24]),
+		extras: body[:extrasLength],
+		key:    body[extrasLength : extrasLength+keyLength],
+		value:  body[extrasLength+keyLength:],
+	}
+}
+
+// storeExtras 는 set, add, replace 의 flags 와 exptime 이다.
+func storeExtras(flags, exptime uint32) []byte {
+	return binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, flags), exptime)
+}
+
+// incrExtras 는 incr, decr 의 delta, initial, expiration 이다.
+func incrExtras(delta, initial uint64, expiration uint32) []byte {
+	extras := binary.BigEndian.AppendUint64(nil, delta)
+	extras = binary.BigEndian.AppendUint64(extras, initial)
+	return binary.BigEndian.AppendUint32(extras, expiration)
+}
+
+func TestBinaryStorage(t *testing.T) {
+	c := newTestServer(t, &Config{Enabled: true}, &auth.Config{}, &tenant.Config{}).dial(t)
+
+	c.sendBinary(opSet, 1, 0, storeExtras(7, 0), []byte("k"), []byte("hello"))
+	resp := c.readBinary()
+	require.Equal(t, statusOK, resp.status)
+	require.Equal(t, uint32(1), resp.opaque)
+	cas := resp.cas
+	require.NotZero(t, cas)
+
+	c.sendBinary(opGetK, 2, 0, nil, []byte("k"), nil)
+	resp = c.readBinary()
+	require.Equal(t, statusOK, resp.status)
+	require.Equal(t, []byte{0, 0, 0, 7}, resp.extras)
+	require.Equal(t, []byte("k"), resp.key)
+	require.Equal(t, []byte("hello"), resp.value)
+	require.Equal(t, cas, resp.cas)
+
+	c.sendBinary(opAdd, 3, 0, storeExtras(0, 0), []byte("k"), []byte("x"))
+	require.Equal(t, statusKeyExists, c.readBinary().status)
+	c.sendBinary(opReplace, 4, 0, storeExtras(0, 0), []byte("missing"), []byte("x"))
+	require.Equal(t, statusKeyNotFound, c.readBinary().status)
+	// set 에도 cas 를 줄 수 있다.
+	c.sendBinary(opSet, 5, cas+1, storeExtras(0, 0), []byte("k"), []byte("x"))
+	require.Equal(t, statusKeyExists, c.readBinary().status)
+	c.sendBinary(opSet, 6, cas, storeExtras(0, 0), []byte("k"), []byte("x"))
+	resp = c.readBinary()
+	require.Equal(t, statusOK, resp.status)
+	require.NotEqual(t, cas, resp.cas)
+
+	// quiet 명령은 성공하면 응답하지 않는다. 응답은 읽을 요청이 없을 때 모아서 보낸다.
+	var pipeline []byte
+	pipeline = append(pipeline, binaryRequestBytes(opSetQ, 7, 0, storeExtras(0, 0), []byte("q"), []byte("v"))...)
+	pipeline = append(pipeline, binaryRequestBytes(opGetQ, 8, 0, nil, []byte("missing"), nil)...)
+	pipeline = append(pipeline, binaryRequestBytes(opGetKQ, 9, 0, nil, []byte("q"), nil)...)
+	pipeline = append(pipeline, binaryRequestBytes(opNoop, 10, 0, nil, nil, nil)...)
+	c.send(string(pipeline))
+	resp = c.readBinary()
+	require.Equal(t, uint32(9), resp.opaque)
+	require.Equal(t, []byte("v"), resp.value)
+	require.Equal(t, uint32(10), c.readBinary().opaque)
+
+	c.sendBinary(opDelete, 11, resp.cas+1, nil, []byte("q"), nil)
+	require.Equal(t, statusKeyExists, c.readBinary().status)
+	c.sendBinary(opDelete, 12, resp.cas, nil, []byte("q"), nil)
+	require.Equal(t, statusOK, c.readBinary().status)
+	c.sendBinary(opGet, 13, 0, nil, []byte("q"), nil)
+	require.Equal(t, statusKeyNotFound, c.readBinary().status)
+
+	c.sendBinary(opGet, 14, 0, []byte{0}, []byte("k"), nil)
+	require.Equal(t, statusInvalidArgs, c.readBinary().status)
+	c.sendBinary(0x50, 15, 0, nil, nil, nil)
+	require.Equal(t, statusUnknownCommand, c.readBinary().status)
+	c.sendBinary(opQuit, 16, 0, nil, nil, nil)
+	require.Equal(t, statusOK, c.readBinary().status)
+}
+
+func TestBinaryIncr(t *testing.T) {
+	c := newTestServer(t, &Config{Enabled: true}, &auth.Config{}, &tenant.Config{}).dial(t)
+
+	c.sendBinary(opIncrement, 1, 0, incrExtras(1, 0, noInitial), []byte("n"), nil)
+	require.Equal(t, statusKeyNotFound, c.readBinary().status)
+
+	// key 가 없으면 initial 을 쓴다.
+	c.sendBinary(opIncrement, 2, 0, incrExtras(1, 5, 0), []byte("n"), nil)
+	resp := c.readBinary()
+	require.Equal(t, statusOK, resp.status)
+	require.Equal(t, uint64(5), binary.BigEndian.Uint64(resp.value))
+	c.sendBinary(opIncrement, 3, 0, incrExtras(3, 5, 0), []byte("n"), nil)
+	resp = c.readBinary()
+	require.Equal(t, uint64(8), binary.BigEndian.Uint64(resp.value))
+	c.sendBinary(opDecrement, 4, 0, incrExtras(10, 0, 0), []byte("n"), nil)
+	resp = c.readBinary()
+	require.Equal(t, uint64(0), binary.BigEndian.Uint64(resp.value))
+
+	// incr 가 돌려준 cas 로 다음 쓰기를 할 수 있다.
+	c.sendBinary(opSet, 5, resp.cas, storeExtras(0, 0), []byte("n"), []byte("41"))
+	require.Equal(t, statusOK, c.readBinary().status)
+	c.send(string(append(binaryRequestBytes(opIncrQ, 6, 0, incrExtras(1, 0, 0), []byte("n"), nil),
+		binaryRequestBytes(opGet, 7, 0, nil, []byte("n"), nil)...)))
+	require.Equal(t, []byte("42"), c.readBinary().value)
+
+	c.sendBinary(opSet, 8, 0, storeExtras(0, 0), []byte("s"), []byte("hello"))
+	require.Equal(t, statusOK, c.readBinary().status)
+	c.sendBinary(opIncrement, 9, 0, incrExtras(1, 0, 0), []byte("s"), nil)
+	require.Equal(t, statusNonNumeric, c.readBinary().status)
+}
+
+func TestBinaryTooLarge(t *testing.T) {
+	c := newTestServer(t, &Config{Enabled: true, MaxItemSize: 16}, &auth.Config{}, &tenant.Config{}).dial(t)
+
+	// body 가 너무 크면 읽고 버리므로 다음 요청을 처리할 수 있다.
+	c.sendBinary(opSet, 1, 0, storeExtras(0, 0), []byte("k"), make([]byte, 16+maxBodyOverhead))
+	require.Equal(t, statusTooLarge, c.readBinary().status)
+	c.sendBinary(opSet, 2, 0, storeExtras(0, 0), []byte("k"), make([]byte, 17))
+	require.Equal(t, statusTooLarge, c.readBinary().status)
+	c.sendBinary(opVersion, 3, 0, nil, nil, nil)
+	resp := c.readBinary()
+	require.Equal(t, statusOK, resp.status)
+	require.Equal(t, []byte("dbolt"), resp.value)
+}
+
+// saslAuth 는 binary protocol 의 SASL PLAIN 으로 token 을 보내고 결과를 반환한다.
+func (c *client) saslAuth(token string) uint16 {
+	c.t.Helper()
+	c.sendBinary(opSASLAuth, 0, 0, nil, []byte("PLAIN"), []byte("\x00user\x00"+token))
+	return c.readBinary().status
+}
+
+func TestTenantAuthorization(t *testing.T) {
+	// principal 에 tenant 가 없으면 설정의 tenant 를 사용하며, 그 tenant 의 bucket 에 권한이 있어야 한다.
+	s := newTestServer(t, &Config{Enabled: true, Tenant: authtest.OtherTenant}, authtest.Config(), &tenant.Config{Enabled: true})
+
+	c := s.dial(t)
+	c.sendBinary(opSet, 1, 0, storeExtras(0, 0), []byte("k"), []byte("v"))
+	require.Equal(t, statusAuthError, c.readBinary().status)
+	require.Equal(t, statusAuthError, c.saslAuth("invalid"))
+
+	require.Equal(t, statusOK, c.saslAuth(authtest.UnboundToken))
+	c.sendBinary(opSet, 2, 0, storeExtras(0, 0), []byte("k"), []byte("v"))
+	resp := c.readBinary()
+	require.Equal(t, statusAuthError, resp.status)
+	require.Contains(t, string(resp.value), auth.ErrForbidden.Error())
+
+	// tenant 에 묶인 principal 은 설정의 tenant 대신 자기 tenant 를 사용한다.
+	bound := s.dial(t)
+	require.Equal(t, statusOK, bound.saslAuth(authtest.BoundToken))
+	bound.sendBinary(opSet, 3, 0, storeExtras(0, 0), []byte("k"), []byte("bound"))
+	require.Equal(t, statusOK, bound.readBinary().status)
+
+	admin := s.dial(t)
+	require.Equal(t, statusOK, admin.saslAuth(authtest.AdminToken))
+	admin.sendBinary(opSet, 4, 0, storeExtras(0, 0), []byte("k"), []byte("admin"))
+	require.Equal(t, statusOK, admin.readBinary().status)
+
+	ctx := context.Background()
+	value, err := s.dist.Get(ctx, tenant.Bucket(authtest.Tenant, []byte("memcached")), []byte("k"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("bound"), value)
+	value, err = s.dist.Get(ctx, tenant.Bucket(authtest.OtherTenant, []byte("memcached")), []byte("k"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("admin"), value)
+}
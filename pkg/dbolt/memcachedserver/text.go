@@ -0,0 +1,267 @@
+package memcachedserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/pkg/errors"
+)
+
+// maxLineLength 는 text protocol 의 명령 한 줄의 최대 크기이다.
+const maxLineLength = 2048
+
+var (
+	errLineTooLong  = errors.New("line too long")
+	errBadFormat    = errors.New("bad command line format")
+	errBadDataChunk = errors.New("bad data chunk")
+	errBadDelta     = errors.New("invalid numeric delta argument")
+	errBadExptime   = errors.New("invalid exptime argument")
+)
+
+var textStoreModes = map[string]storeMode{"set": modeSet, "add": modeAdd, "replace": modeReplace, "cas": modeCAS}
+
+// serveText 는 text protocol 의 명령을 처리한다. pipeline 으로 보낸 명령이 남아 있으면 응답을 모아서 보낸다.
+func (c *conn) serveText() {
+	for {
+		line, err := c.readLine()
+		if errors.Is(err, errLineTooLong) {
+			c.bw.WriteString("CLIENT_ERROR line too long\r\n")
+			_ = c.bw.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		fields := bytes.Fields(line)
+		if len(fields) == 0 {
+			c.bw.WriteString("ERROR\r\n")
+		} else if quit := c.textCommand(fields); quit {
+			_ = c.bw.Flush()
+			return
+		}
+		if c.br.Buffered() == 0 {
+			if err := c.bw.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *conn) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := c.br.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxLineLength {
+			return nil, errLineTooLong
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// textCommand 는 명령 하나를 처리하고 연결을 끊어야 하면 true 를 반환한다.
+func (c *conn) textCommand(fields [][]byte) bool {
+	command := string(fields[0])
+	args := fields[1:]
+	noreply := len(args) > 0 && string(args[len(args)-1]) == "noreply"
+	if noreply {
+		args = args[:len(args)-1]
+	}
+	ctx, cancel := context.WithTimeout(c.s.ctx, time.Duration(c.s.timeout.Load()))
+	defer cancel()
+
+	var reply string
+	var err error
+	switch command {
+	case "get", "gets":
+		err = c.textGet(ctx, command, args)
+		noreply = false
+	case "set", "add", "replace", "cas":
+		reply, err = c.textStore(ctx, command, args)
+	case "delete":
+		reply, err = c.textDelete(ctx, command, args)
+	case "incr", "decr":
+		reply, err = c.textIncr(ctx, command, args)
+	case "touch":
+		reply, err = c.textTouch(ctx, command, args)
+	case "version":
+		reply = "VERSION dbolt"
+	case "verbosity":
+		reply = "OK"
+	case "quit":
+		return true
+	default:
+		c.s.metrics.commands.WithLabelValues("text", "unknown", "error").Inc()
+		c.bw.WriteString("ERROR\r\n")
+		return false
+	}
+	c.s.metrics.commands.WithLabelValues("text", command, result(err)).Inc()
+
+	if err != nil {
+		reply = textError(err)
+	}
+	if !noreply && reply != "" {
+		c.bw.WriteString(reply)
+		c.bw.WriteString("\r\n")
+	}
+	return false
+}
+
+// textError 는 get, store 등의 결과가 아닌 오류를 CLIENT_ERROR, SERVER_ERROR 로 바꾼다.
+func textError(err error) string {
+	switch {
+	case errors.Is(err, errNotFound):
+		return "NOT_FOUND"
+	case errors.Is(err, errExists):
+		return "EXISTS"
+	case errors.Is(err, errNotStored):
+		return "NOT_STORED"
+	case errors.Is(err, errTooLarge):
+		return "SERVER_ERROR object too large for cache"
+	case errors.Is(err, errBadFormat), errors.Is(err, errBadDataChunk), errors.Is(err, errBadDelta), errors.Is(err, errBadExptime),
+		errors.Is(err, errInvalidKey), errors.Is(err, errNotNumeric),
+		errors.Is(err, errUnauthorized), errors.Is(err, auth.ErrForbidden):
+		return "CLIENT_ERROR " + err.Error()
+	}
+	return "SERVER_ERROR " + err.Error()
+}
+
+// textGet 은 찾은 key 만 VALUE 로 보낸다. 오류가 나면 그때까지 찾은 값은 보내지 않는다.
+func (c *conn) textGet(ctx context.Context, command string, keys [][]byte) error {
+	if len(keys) == 0 {
+		return errBadFormat
+	}
+	var out bytes.Buffer
+	for _, key := range keys {
+		if !validKey(key) {
+			return errInvalidKey
+		}
+		it, err := c.get(ctx, command, key)
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		out.WriteString("VALUE ")
+		out.Write(key)
+		out.WriteByte(' ')
+		out.WriteString(strconv.FormatUint(uint64(it.flags), 10))
+		out.WriteByte(' ')
+		out.WriteString(strconv.Itoa(len(it.value)))
+		if command == "gets" {
+			out.WriteByte(' ')
+			out.WriteString(strconv.FormatUint(it.cas, 10))
+		}
+		out.WriteString("\r\n")
+		out.Write(it.value)
+		out.WriteString("\r\n")
+	}
+	out.WriteString("END")
+	c.bw.Write(out.Bytes())
+	c.bw.WriteString("\r\n")
+	return nil
+}
+
+// textStore 는 <command> <key> <flags> <exptime> <bytes> [<cas unique>] 뒤의 data block 을 읽어서 쓴다.
+// data block 의 크기를 알 수 있으면 다른 인자가 잘못되었어도 data block 을 읽고 버린다.
+func (c *conn) textStore(ctx context.Context, command string, args [][]byte) (string, error) {
+	argc := 4
+	if command == "cas" {
+		argc = 5
+	}
+	if len(args) < 4 {
+		return "", errBadFormat
+	}
+	size, err := strconv.Atoi(string(args[3]))
+	if err != nil || size < 0 {
+		return "", errBadFormat
+	}
+	if size > c.s.cfg.maxItemSize() {
+		if _, err := io.CopyN(io.Discard, c.br, int64(size)+2); err != nil {
+			return "", err
+		}
+		return "", errTooLarge
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.br, data); err != nil {
+		return "", err
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		return "", errBadDataChunk
+	}
+	if len(args) != argc || !validKey(args[0]) {
+		return "", errBadFormat
+	}
+	flags, err := strconv.ParseUint(string(args[1]), 10, 32)
+	if err != nil {
+		return "", errBadFormat
+	}
+	exptime, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return "", errBadFormat
+	}
+	var cas uint64
+	mode := textStoreModes[command]
+	if mode == modeCAS {
+		if cas, err = strconv.ParseUint(string(args[4]), 10, 64); err != nil || cas == 0 {
+			return "", errBadFormat
+		}
+	}
+	if _, err := c.store(ctx, command, mode, args[0], uint32(flags), exptime, data[:size], cas); err != nil {
+		return "", err
+	}
+	return "STORED", nil
+}
+
+func (c *conn) textDelete(ctx context.Context, command string, args [][]byte) (string, error) {
+	// 예전 client 는 delete <key> 0 처럼 hold time 을 보낸다.
+	if len(args) == 2 && string(args[1]) == "0" {
+		args = args[:1]
+	}
+	if len(args) != 1 || !validKey(args[0]) {
+		return "", errBadFormat
+	}
+	if err := c.delete(ctx, command, args[0], 0); err != nil {
+		return "", err
+	}
+	return "DELETED", nil
+}
+
+func (c *conn) textIncr(ctx context.Context, command string, args [][]byte) (string, error) {
+	if len(args) != 2 || !validKey(args[0]) {
+		return "", errBadFormat
+	}
+	delta, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return "", errBadDelta
+	}
+	n, _, err := c.incr(ctx, command, args[0], delta, command == "decr", nil, 0)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(n, 10), nil
+}
+
+func (c *conn) textTouch(ctx context.Context, command string, args [][]byte) (string, error) {
+	if len(args) != 2 || !validKey(args[0]) {
+		return "", errBadFormat
+	}
+	exptime, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return "", errBadExptime
+	}
+	if err := c.touch(ctx, command, args[0], exptime); err != nil {
+		return "", err
+	}
+	return "TOUCHED", nil
+}
@@ -3,12 +3,22 @@ package dbolt
 import (
 	"context"
 	"fmt"
-	"github.com/boltdb/bolt"
 	"github.com/grafana/dskit/dns"
+	"github.com/grafana/dskit/kv"
 	"github.com/grafana/dskit/kv/memberlist"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/backup"
+	"github.com/kwSeo/dbolt/pkg/dbolt/changes"
+	"github.com/kwSeo/dbolt/pkg/dbolt/decommission"
+	"github.com/kwSeo/dbolt/pkg/dbolt/etcdserver"
+	"github.com/kwSeo/dbolt/pkg/dbolt/grpcserver"
 	"github.com/kwSeo/dbolt/pkg/dbolt/httpserver"
+	"github.com/kwSeo/dbolt/pkg/dbolt/memcachedserver"
+	"github.com/kwSeo/dbolt/pkg/dbolt/ratelimit"
+	"github.com/kwSeo/dbolt/pkg/dbolt/respserver"
 	"github.com/kwSeo/dbolt/pkg/dbolt/store"
-	"gopkg.in/yaml.v2"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tlsconfig"
 	"os"
 	"time"
 
@@ -26,25 +36,45 @@ type App struct {
 	fxApp *fx.App
 }
 
-func NewApp(configPath string) *App {
+func NewApp(loader *ConfigLoader) *App {
 	fxApp := fx.New(
 		fx.Provide(
-			zap.NewDevelopment,
-			fx.Annotate(initGoKitLogger, fx.As(new(log.Logger))),
+			initConfigLoader(loader),
+			initConfig,
+			initLogger,
 			initPrometheusRegistry,
-			initConfigLoader(configPath),
+			initReloader,
+			initTenantOverrides,
 			initMemberlistService,
 			fx.Annotate(initRing, fx.As(new(ring.ReadRing))),
 			initLifecycler,
-			initBoltDB,
+			initStorageEngine,
+			initEncryptor,
+			initQuotas,
+			initLocalStore,
+			initCompactor,
+			initReencryptor,
+			initChangeLogTrimmer,
 			initStorePool,
 			initDistributor,
+			initRateLimiter,
+			initBackupService,
+			initChangesService,
+			initDecommissioner,
+			initTenantService,
+			initAuthService,
+			initServerTLS,
+			initInternalTLS,
 			initHTTPServer,
+			initGRPCServer,
+			initRESPServer,
+			initMemcachedServer,
+			initEtcdServer,
 		),
 		fx.WithLogger(func(logger *zap.Logger) fxevent.Logger {
 			return &fxevent.ZapLogger{Logger: logger}
 		}),
-		fx.Invoke(func(s *httpserver.Server) {
+		fx.Invoke(func(s *httpserver.Server, gs *grpcserver.Server, rs *respserver.Server, ms *memcachedserver.Server, es *etcdserver.Server, t *store.ChangeLogTrimmer) {
 			// 애플리케이션을 트리거하기 위한 빈 함수
 		}),
 	)
@@ -57,42 +87,56 @@ func (a *App) Run() {
 	a.fxApp.Run()
 }
 
-func initConfigLoader(path string) func() (*Config, error) {
-	return func() (*Config, error) {
-		file, err := os.ReadFile(path)
-		if err != nil {
-			return nil, err
-		}
-		config := new(Config)
-		err = yaml.Unmarshal(file, config)
-		if err != nil {
-			return nil, err
-		}
+func initConfigLoader(loader *ConfigLoader) func() *ConfigLoader {
+	return func() *ConfigLoader {
+		return loader
+	}
+}
 
-		err = config.Validate()
-		if err != nil {
-			return nil, err
-		}
-		return config, nil
+func initConfig(loader *ConfigLoader) (*Config, error) {
+	return loader.Load()
+}
+
+// initLogger 는 log.level 과 log.components 를 SIGHUP 으로 바꿀 수 있도록 Logging 을 함께 반환한다.
+func initLogger(cfg *Config) (*Logging, *zap.Logger, error) {
+	logging, err := NewLogging(&cfg.LogConfig)
+	if err != nil {
+		return nil, nil, err
 	}
+	return logging, logging.Logger(), nil
 }
 
-func initGoKitLogger(logger *zap.Logger) *ZapGoKitLogger {
-	return &ZapGoKitLogger{logger: logger}
+func initReloader(fxLc fx.Lifecycle, loader *ConfigLoader, cfg *Config, logging *Logging, reg prometheus.Registerer, logger *zap.Logger) *Reloader {
+	reloader := NewReloader(loader, cfg, reg, logger.Named("config"))
+	reloader.OnReload(func(cfg *Config) {
+		logging.ApplyConfig(&cfg.LogConfig)
+	})
+	fxLc.Append(fx.StartStopHook(reloader.Start, reloader.Stop))
+	return reloader
+}
+
+func initTenantOverrides(cfg *Config, reloader *Reloader) *tenant.Overrides {
+	overrides := tenant.NewOverrides(&cfg.TenancyConfig)
+	reloader.OnReload(func(cfg *Config) {
+		overrides.ApplyConfig(&cfg.TenancyConfig)
+	})
+	return overrides
 }
 
 func initPrometheusRegistry() prometheus.Registerer {
 	return prometheus.DefaultRegisterer
 }
 
-func initMemberlistService(cfg *Config, goKitLogger log.Logger, reg prometheus.Registerer) *memberlist.KVInitService {
+func initMemberlistService(cfg *Config, logging *Logging, reg prometheus.Registerer) *memberlist.KVInitService {
+	goKitLogger := logging.GoKit(ComponentMemberlist)
 	memberlistConfig := cfg.MemberlistConfig
+	memberlistConfig.Codecs = append(memberlistConfig.Codecs, ring.GetCodec())
 	dnsProvider := dns.NewProvider(log.With(goKitLogger, "component", "dnsProvider"), reg, dns.GolangResolverType)
 	return memberlist.NewKVInitService(&memberlistConfig, goKitLogger, dnsProvider, reg)
 }
 
-func initLifecycler(fxLc fx.Lifecycle, memberlistKVInitService *memberlist.KVInitService, cfg *Config, logger *zap.Logger, goKitLogger log.Logger, reg prometheus.Registerer) (*ring.Lifecycler, error) {
-	goKitLogger = log.With(goKitLogger, "service", "dskit-lifecycler")
+func initLifecycler(fxLc fx.Lifecycle, memberlistKVInitService *memberlist.KVInitService, cfg *Config, logger *zap.Logger, logging *Logging, reg prometheus.Registerer) (*ring.Lifecycler, error) {
+	goKitLogger := log.With(logging.GoKit(ComponentDSKit), "service", "dskit-lifecycler")
 
 	cfg.LifecyclerConfig.RingConfig.KVStore.MemberlistKV = memberlistKVInitService.GetMemberlistKV
 
@@ -105,7 +149,8 @@ func initLifecycler(fxLc fx.Lifecycle, memberlistKVInitService *memberlist.KVIni
 	fxLc.Append(fx.StartStopHook(
 		func(ctx context.Context) error {
 			logger.Info("Starting lifecycler.")
-			err := lifecycler.StartAsync(ctx)
+			// fx 의 시작 context 는 시작 제한 시간이 지나면 취소되므로 서비스가 계속 동작하도록 Background 로 시작한다.
+			err := lifecycler.StartAsync(context.Background())
 			if err != nil {
 				return err
 			}
@@ -123,10 +168,19 @@ func initLifecycler(fxLc fx.Lifecycle, memberlistKVInitService *memberlist.KVIni
 	return lifecycler, nil
 }
 
-func initRing(fl fx.Lifecycle, cfg *Config, reg prometheus.Registerer, logger *zap.Logger, goKitLogger log.Logger) (*ring.Ring, error) {
-	goKitLogger = log.With(goKitLogger, "service", "dskit-ring")
+func initRing(fl fx.Lifecycle, memberlistKVInitService *memberlist.KVInitService, cfg *Config, reg prometheus.Registerer, logger *zap.Logger, logging *Logging) (*ring.Ring, error) {
+	goKitLogger := log.With(logging.GoKit(ComponentDSKit), "service", "dskit-ring")
 
-	r, err := ring.New(cfg.LifecyclerConfig.RingConfig, distributor.RingName, distributor.RingKey, goKitLogger, reg)
+	// ring.New 와 같지만 zone 장애를 견디는 replication strategy 를 사용할 수 있도록 직접 만든다.
+	// Lifecycler 보다 먼저 만들어질 수 있으므로 memberlist KV 를 직접 연결한다.
+	ringConfig := cfg.LifecyclerConfig.RingConfig
+	ringConfig.KVStore.MemberlistKV = memberlistKVInitService.GetMemberlistKV
+	kvClient, err := kv.NewClient(ringConfig.KVStore, ring.GetCodec(), kv.RegistererWithKVName(reg, distributor.RingName+"-ring"), goKitLogger)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the KV client of Ring")
+	}
+	strategy := distributor.NewReplicationStrategy(&cfg.DistributorConfig)
+	r, err := ring.NewWithStoreClientAndStrategy(ringConfig, distributor.RingName, distributor.RingKey, kvClient, strategy, reg, goKitLogger)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create Ring")
 	}
@@ -134,7 +188,7 @@ func initRing(fl fx.Lifecycle, cfg *Config, reg prometheus.Registerer, logger *z
 	fl.Append(fx.StartStopHook(
 		func(ctx context.Context) error {
 			logger.Info("Starting ring.")
-			err := r.StartAsync(ctx)
+			err := r.StartAsync(context.Background())
 			if err != nil {
 				return err
 			}
@@ -152,18 +206,68 @@ func initRing(fl fx.Lifecycle, cfg *Config, reg prometheus.Registerer, logger *z
 	return r, nil
 }
 
-func initBoltDB(cfg *Config, logger *zap.Logger) (*bolt.DB, error) {
-	// TODO: bolt.DefaultOptions 뿐만이 아니라 다른 옵션들도 사용할 수 있도록 개선 필요.
-	db, err := bolt.Open(cfg.BoltConfig.DB.Path, os.ModePerm, bolt.DefaultOptions)
+func initStorageEngine(cfg *Config, logger *zap.Logger) (store.Engine, error) {
+	// TODO: 기본 옵션 뿐만이 아니라 다른 옵션들도 사용할 수 있도록 개선 필요.
+	db, err := store.OpenEngine(cfg.BoltConfig.DB.Engine, cfg.BoltConfig.DB.Path, os.ModePerm, nil)
 	if err != nil {
-		logger.Error("Failed to create bolt DB.", zap.Error(err))
+		logger.Error("Failed to open the storage engine.", zap.Error(err))
 		return nil, err
 	}
+	logger.Info("Opened the storage engine.", zap.String("engine", db.Name()), zap.String("path", db.Path()))
 	return db, nil
 }
 
-func initStorePool(fxLc fx.Lifecycle, cfg *Config, lc *ring.Lifecycler, r ring.ReadRing, boltdb *bolt.DB, logger *zap.Logger) *distributor.SimpleStorePool {
+func initEncryptor(cfg *Config, logger *zap.Logger) (*store.Encryptor, error) {
+	encryptionConfig := cfg.BoltConfig.Encryption
+	if !encryptionConfig.Enabled {
+		return store.NewEncryptor(nil), nil
+	}
+	provider, err := store.LoadFileKeyProvider(encryptionConfig.KeyFile)
+	if err != nil {
+		logger.Error("Failed to load the keyfile.", zap.Error(err))
+		return nil, err
+	}
+	logger.Info("Encrypting values at rest.", zap.String("activeKeyID", provider.ActiveKeyID()))
+	return store.NewEncryptor(provider), nil
+}
+
+func initQuotas(cfg *Config, overrides *tenant.Overrides, reg prometheus.Registerer) *tenant.Quotas {
+	return tenant.NewQuotas(&cfg.TenancyConfig, overrides, reg)
+}
+
+func initLocalStore(cfg *Config, db store.Engine, encryptor *store.Encryptor, quotas *tenant.Quotas, logger *zap.Logger) (*store.LocalStore, error) {
+	localStore, err := store.NewLocalStore(&cfg.BoltConfig.ChangeLog, db, encryptor, quotas, logger)
+	if err != nil {
+		logger.Error("Failed to open the local store.", zap.Error(err))
+		return nil, err
+	}
+	return localStore, nil
+}
+
+func initCompactor(fxLc fx.Lifecycle, cfg *Config, localStore *store.LocalStore, reg prometheus.Registerer, logger *zap.Logger) *store.Compactor {
+	compactor := store.NewCompactor(&cfg.BoltConfig.Compaction, localStore, reg, logger)
+	fxLc.Append(fx.StartStopHook(compactor.Start, compactor.Stop))
+	return compactor
+}
+
+func initReencryptor(fxLc fx.Lifecycle, cfg *Config, localStore *store.LocalStore, reg prometheus.Registerer, logger *zap.Logger) *store.Reencryptor {
+	reencryptor := store.NewReencryptor(&cfg.BoltConfig.Encryption, localStore, reg, logger)
+	fxLc.Append(fx.StartStopHook(reencryptor.Start, reencryptor.Stop))
+	return reencryptor
+}
+
+func initChangeLogTrimmer(fxLc fx.Lifecycle, cfg *Config, localStore *store.LocalStore, logger *zap.Logger) *store.ChangeLogTrimmer {
+	trimmer := store.NewChangeLogTrimmer(&cfg.BoltConfig.ChangeLog, localStore, logger)
+	fxLc.Append(fx.StartStopHook(trimmer.Start, trimmer.Stop))
+	return trimmer
+}
+
+func initStorePool(fxLc fx.Lifecycle, cfg *Config, lc *ring.Lifecycler, r ring.ReadRing, localStore *store.LocalStore, internalTLS *tlsconfig.Client, logger *zap.Logger) (*distributor.SimpleStorePool, error) {
 	storePool := distributor.NewSimpleStorePool()
+	internalToken, err := cfg.AuthConfig.LoadInternalToken()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the internal token")
+	}
 
 	fxLc.Append(fx.StartHook(func(ctx context.Context) error {
 		go func() {
@@ -180,13 +284,18 @@ func initStorePool(fxLc fx.Lifecycle, cfg *Config, lc *ring.Lifecycler, r ring.R
 				for _, addr := range replicationSet.GetAddresses() {
 					if addr == myAddr {
 						logger.Debug("Registering me.")
-						localStore := store.NewLocalStore(boltdb, logger)
 						storePool.Register(myAddr, localStore)
 
 					} else if !storePool.Contains(addr) {
 						logger.Debug("Registering store of member instance.", zap.String("addr", addr))
-						baseUrl := fmt.Sprintf("http://%s:%d", addr, cfg.ServerConfig.HTTPListenPort)
-						httpStore := store.NewHTTPStoreWithDefault(baseUrl)
+						httpStoreConfig := &store.HttpStoreConfig{Timeout: 3 * time.Second, Token: internalToken}
+						scheme := "http"
+						if internalTLS != nil {
+							httpStoreConfig.TLSConfig = internalTLS.Config(addr)
+							scheme = "https"
+						}
+						baseUrl := distributor.InstanceURL(scheme, addr, cfg.ServerConfig.HTTPListenPort)
+						httpStore := store.NewHTTPStore(httpStoreConfig, baseUrl)
 						storePool.Register(addr, httpStore)
 					}
 				}
@@ -195,15 +304,123 @@ func initStorePool(fxLc fx.Lifecycle, cfg *Config, lc *ring.Lifecycler, r ring.R
 		return nil
 	}))
 
-	return storePool
+	return storePool, nil
+}
+
+func initDistributor(cfg *Config, r ring.ReadRing, sp *distributor.SimpleStorePool, localStore *store.LocalStore, logging *Logging) *distributor.Distributor {
+	var limits distributor.ShardingLimits
+	if cfg.TenancyConfig.Enabled {
+		limits = &cfg.TenancyConfig
+	}
+	return distributor.New(&cfg.DistributorConfig, r, sp, limits, localStore, cfg.LifecyclerConfig.Zone, logging.Component(ComponentDistributor))
+}
+
+func initRateLimiter(cfg *Config, dist *distributor.Distributor, localStore *store.LocalStore, reloader *Reloader, reg prometheus.Registerer) *ratelimit.Limiter {
+	limiter := ratelimit.New(&cfg.RateLimitConfig, dist, localStore, reg)
+	reloader.OnReload(func(cfg *Config) {
+		limiter.ApplyConfig(&cfg.RateLimitConfig)
+	})
+	return limiter
 }
 
-func initDistributor(r ring.ReadRing, sp *distributor.SimpleStorePool, logger *zap.Logger) *distributor.Distributor {
-	return distributor.New(r, sp, logger)
+func initBackupService(cfg *Config, r ring.ReadRing, sp *distributor.SimpleStorePool, dist *distributor.Distributor, memberlistKVInitService *memberlist.KVInitService, encryptor *store.Encryptor, logger *zap.Logger) *backup.Service {
+	ringKey := cfg.LifecyclerConfig.RingConfig.KVStore.Prefix + distributor.RingKey
+	return backup.New(r, sp, dist, memberlistKVInitService, encryptor, ringKey, logger)
 }
 
-func initHTTPServer(fxLc fx.Lifecycle, cfg *Config, dist *distributor.Distributor, logger *zap.Logger) *httpserver.Server {
-	server := httpserver.New(&cfg.ServerConfig, dist, logger)
+func initChangesService(r ring.ReadRing, sp *distributor.SimpleStorePool, localStore *store.LocalStore, logger *zap.Logger) *changes.Service {
+	return changes.New(r, sp, localStore, logger)
+}
+
+func initDecommissioner(fxLc fx.Lifecycle, cfg *Config, lc *ring.Lifecycler, r ring.ReadRing, localStore *store.LocalStore, dist *distributor.Distributor, reg prometheus.Registerer, logger *zap.Logger) *decommission.Decommissioner {
+	decommissioner := decommission.New(&cfg.DecommissionConfig, lc, r, localStore, dist, reg, logger.Named("decommission"))
+	fxLc.Append(fx.StopHook(decommissioner.Stop))
+	return decommissioner
+}
+
+func initTenantService(cfg *Config, quotas *tenant.Quotas, r ring.ReadRing, sp *distributor.SimpleStorePool, localStore *store.LocalStore, reg prometheus.Registerer, logger *zap.Logger) *tenant.Service {
+	return tenant.New(&cfg.TenancyConfig, quotas, r, sp, localStore, reg, logger)
+}
+
+func initAuthService(cfg *Config, logger *zap.Logger) (*auth.Service, error) {
+	return auth.New(&cfg.AuthConfig, logger)
+}
+
+// initServerTLS 는 HTTP 와 gRPC listener 의 인증서를 읽는다. TLS 가 꺼져 있으면 nil 이다.
+func initServerTLS(fxLc fx.Lifecycle, cfg *Config, logger *zap.Logger) (*tlsconfig.Server, error) {
+	serverTLS, err := tlsconfig.NewServer(&cfg.ServerConfig.TLS, logger.Named("server-tls"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load the server certificates")
+	}
+	if serverTLS != nil {
+		fxLc.Append(fx.StartStopHook(serverTLS.Start, serverTLS.Stop))
+	}
+	return serverTLS, nil
+}
+
+// initInternalTLS 는 다른 인스턴스에 요청할 때의 인증서를 읽는다. TLS 가 꺼져 있으면 nil 이다.
+func initInternalTLS(fxLc fx.Lifecycle, cfg *Config, logger *zap.Logger) (*tlsconfig.Client, error) {
+	internalTLS, err := tlsconfig.NewClient(&cfg.ServerConfig.InternalTLS, logger.Named("internal-tls"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load the internal client certificates")
+	}
+	if internalTLS != nil {
+		fxLc.Append(fx.StartStopHook(internalTLS.Start, internalTLS.Stop))
+	}
+	return internalTLS, nil
+}
+
+func initHTTPServer(fxLc fx.Lifecycle, cfg *Config, serverTLS *tlsconfig.Server, dist *distributor.Distributor, localStore *store.LocalStore, compactor *store.Compactor, reencryptor *store.Reencryptor, changesService *changes.Service, tenants *tenant.Service, authService *auth.Service, backupService *backup.Service, r ring.ReadRing, lc *ring.Lifecycler, sp *distributor.SimpleStorePool, memberlistKVInitService *memberlist.KVInitService, decommissioner *decommission.Decommissioner, limiter *ratelimit.Limiter, reloader *Reloader, logging *Logging) *httpserver.Server {
+	server := httpserver.New(&cfg.ServerConfig, serverTLS, dist, localStore, compactor, reencryptor, changesService, tenants, authService, backupService, r, lc, sp, memberlistKVInitService, decommissioner, limiter, reloader, logging.Component(ComponentHTTP))
+	reloader.OnReload(func(cfg *Config) {
+		server.ApplyConfig(&cfg.ServerConfig)
+	})
+	fxLc.Append(fx.StartStopHook(server.Start, server.Stop))
+	return server
+}
+
+func initGRPCServer(fxLc fx.Lifecycle, cfg *Config, serverTLS *tlsconfig.Server, localStore *store.LocalStore, tenants *tenant.Service, authService *auth.Service, logger *zap.Logger) *grpcserver.Server {
+	addr := fmt.Sprintf("%v:%v", cfg.ServerConfig.BindIP, cfg.ServerConfig.GRPCListenPort)
+	server := grpcserver.New(addr, serverTLS, localStore, tenants, authService, logger)
 	fxLc.Append(fx.StartStopHook(server.Start, server.Stop))
 	return server
 }
+
+// initRESPServer 는 Redis protocol listener 를 만든다. 꺼져 있으면 시작하지 않는다.
+func initRESPServer(fxLc fx.Lifecycle, cfg *Config, serverTLS *tlsconfig.Server, dist *distributor.Distributor, tenants *tenant.Service, authService *auth.Service, decommissioner *decommission.Decommissioner, reloader *Reloader, reg prometheus.Registerer, logger *zap.Logger) *respserver.Server {
+	addr := fmt.Sprintf("%v:%v", cfg.ServerConfig.BindIP, cfg.RESPConfig.ListenPort)
+	server := respserver.New(&cfg.RESPConfig, addr, serverTLS, dist, tenants, authService, decommissioner, reg, logger.Named("resp"))
+	reloader.OnReload(func(cfg *Config) {
+		server.ApplyConfig(&cfg.RESPConfig)
+	})
+	if cfg.RESPConfig.Enabled {
+		fxLc.Append(fx.StartStopHook(server.Start, server.Stop))
+	}
+	return server
+}
+
+// initMemcachedServer 는 memcached protocol listener 를 만든다. 꺼져 있으면 시작하지 않는다.
+func initMemcachedServer(fxLc fx.Lifecycle, cfg *Config, serverTLS *tlsconfig.Server, dist *distributor.Distributor, tenants *tenant.Service, authService *auth.Service, decommissioner *decommission.Decommissioner, reloader *Reloader, reg prometheus.Registerer, logger *zap.Logger) *memcachedserver.Server {
+	addr := fmt.Sprintf("%v:%v", cfg.ServerConfig.BindIP, cfg.MemcachedConfig.ListenPort)
+	server := memcachedserver.New(&cfg.MemcachedConfig, addr, serverTLS, dist, tenants, authService, decommissioner, reg, logger.Named("memcached"))
+	reloader.OnReload(func(cfg *Config) {
+		server.ApplyConfig(&cfg.MemcachedConfig)
+	})
+	if cfg.MemcachedConfig.Enabled {
+		fxLc.Append(fx.StartStopHook(server.Start, server.Stop))
+	}
+	return server
+}
+
+// initEtcdServer 는 etcd v3 API listener 를 만든다. 꺼져 있으면 시작하지 않는다.
+func initEtcdServer(fxLc fx.Lifecycle, cfg *Config, serverTLS *tlsconfig.Server, dist *distributor.Distributor, changesService *changes.Service, tenants *tenant.Service, authService *auth.Service, decommissioner *decommission.Decommissioner, reloader *Reloader, reg prometheus.Registerer, logger *zap.Logger) *etcdserver.Server {
+	addr := fmt.Sprintf("%v:%v", cfg.ServerConfig.BindIP, cfg.EtcdConfig.ListenPort)
+	server := etcdserver.New(&cfg.EtcdConfig, addr, serverTLS, dist, changesService, tenants, authService, decommissioner, reg, logger.Named("etcd"))
+	reloader.OnReload(func(cfg *Config) {
+		server.ApplyConfig(&cfg.EtcdConfig)
+	})
+	if cfg.EtcdConfig.Enabled {
+		fxLc.Append(fx.StartStopHook(server.Start, server.Stop))
+	}
+	return server
+}
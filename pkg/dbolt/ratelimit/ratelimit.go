@@ -0,0 +1,327 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+// idleTimeout 동안 요청이 없는 client 와 bucket 의 token bucket 은 지운다.
+const idleTimeout = 10 * time.Minute
+
+// Limit 은 token bucket 의 설정이다.
+type Limit struct {
+	// Rate 는 초당 요청 수이다. 0 이면 제한하지 않는다.
+	Rate float64 `yaml:"rate" json:"rate"`
+	// Burst 는 한 번에 허용하는 요청 수이다. 0 이면 1초 동안의 요청 수만큼 허용한다.
+	Burst int `yaml:"burst" json:"burst"`
+}
+
+func (l *Limit) Validate() error {
+	if l.Rate < 0 || l.Burst < 0 {
+		return errors.New("rate and burst must not be negative")
+	}
+	return nil
+}
+
+func (l *Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return int(math.Max(1, math.Ceil(l.Rate)))
+}
+
+type SheddingConfig struct {
+	// MaxInFlight 개 이상의 Distributor 요청이 진행 중이면 새 요청을 거절한다. 0 이면 확인하지 않는다.
+	MaxInFlight int64 `yaml:"max_in_flight"`
+	// TargetWriteLatency 는 bolt 쓰기 트랜잭션의 목표 지연 시간이다. 넘은 비율만큼 쓰기 요청을 거절하고 두 배가 되면 모두 거절한다.
+	// 0 이면 확인하지 않는다.
+	TargetWriteLatency time.Duration `yaml:"target_write_latency"`
+	// RetryAfter 는 거절한 응답의 Retry-After 이다. 기본값은 1초이다.
+	RetryAfter time.Duration `yaml:"retry_after"`
+}
+
+func (sc *SheddingConfig) Validate() error {
+	if sc.MaxInFlight < 0 || sc.TargetWriteLatency < 0 || sc.RetryAfter < 0 {
+		return errors.New("load shedding settings must not be negative")
+	}
+	return nil
+}
+
+func (sc *SheddingConfig) retryAfter() time.Duration {
+	if sc.RetryAfter == 0 {
+		return time.Second
+	}
+	return sc.RetryAfter
+}
+
+// Config 는 /api/v1 요청의 제한이다. tenant 별 제한은 tenancy 의 request_rate 이다.
+type Config struct {
+	// Client 는 principal 마다, 인증이 꺼져 있으면 client IP 마다 적용한다.
+	Client Limit `yaml:"client"`
+	// Bucket 은 bucket 마다 적용한다. tenancy 가 켜져 있으면 tenant 의 bucket 마다 따로 적용한다.
+	Bucket Limit `yaml:"bucket"`
+	// BucketOverrides 는 bucket 이름(tenancy 가 켜져 있으면 <tenant>/<bucket>)별로 Bucket 대신 적용한다.
+	BucketOverrides map[string]Limit `yaml:"bucket_overrides"`
+	LoadShedding    SheddingConfig   `yaml:"load_shedding"`
+}
+
+func (c *Config) Validate() error {
+	if err := c.Client.Validate(); err != nil {
+		return errors.Wrap(err, "invalid rate_limit 'client'")
+	}
+	if err := c.Bucket.Validate(); err != nil {
+		return errors.Wrap(err, "invalid rate_limit 'bucket'")
+	}
+	for bucket, limit := range c.BucketOverrides {
+		if err := limit.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid rate_limit 'bucket_overrides' : bucket=%s", bucket)
+		}
+	}
+	if err := c.LoadShedding.Validate(); err != nil {
+		return errors.Wrap(err, "invalid rate_limit 'load_shedding'")
+	}
+	return nil
+}
+
+func (c *Config) bucketLimit(bucket string) Limit {
+	if limit, ok := c.BucketOverrides[bucket]; ok {
+		return limit
+	}
+	return c.Bucket
+}
+
+// Reason 은 요청을 거절한 이유이다. dbolt_rate_limit_rejected_total 의 reason label 이다.
+const (
+	ReasonClient       = "client"
+	ReasonBucket       = "bucket"
+	ReasonInFlight     = "in_flight"
+	ReasonWriteLatency = "write_latency"
+)
+
+// Rejection 은 제한을 넘어서 거절한 요청의 오류이다. RetryAfter 가 지나면 다시 요청할 수 있다.
+type Rejection struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (r *Rejection) Error() string {
+	switch r.Reason {
+	case ReasonInFlight, ReasonWriteLatency:
+		return fmt.Sprintf("server overloaded : reason=%s", r.Reason)
+	default:
+		return fmt.Sprintf("request rate limit exceeded : limit=%s", r.Reason)
+	}
+}
+
+// InFlight 는 진행 중인 Distributor 요청 수를 알려준다.
+type InFlight interface {
+	InFlight() int64
+}
+
+// WriteLatency 는 최근 bolt 쓰기 트랜잭션의 지연 시간을 알려준다.
+type WriteLatency interface {
+	WriteLatency() time.Duration
+}
+
+// Limiter 는 client 와 bucket 의 token bucket 과 load shedding 을 적용한다. 제한은 인스턴스마다 따로 적용된다.
+// 설정을 다시 읽으면 ApplyConfig 로 바뀌고 그때까지 쌓인 token 은 버린다.
+type Limiter struct {
+	inFlight     InFlight
+	writeLatency WriteLatency
+	metrics      *metrics
+
+	mu       sync.Mutex
+	cfg      *Config
+	clients  *keyedLimiter
+	buckets  *keyedLimiter
+	prunedAt time.Time
+}
+
+func New(cfg *Config, inFlight InFlight, writeLatency WriteLatency, reg prometheus.Registerer) *Limiter {
+	l := &Limiter{
+		inFlight:     inFlight,
+		writeLatency: writeLatency,
+		prunedAt:     time.Now(),
+	}
+	l.metrics = newMetrics(reg, l)
+	l.ApplyConfig(cfg)
+	return l
+}
+
+// ApplyConfig 는 제한을 cfg 의 것으로 바꾼다. cfg 는 이후에 수정하면 안 된다.
+func (l *Limiter) ApplyConfig(cfg *Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+	l.clients = newKeyedLimiter()
+	l.buckets = newKeyedLimiter()
+	l.metrics.setLimits(cfg)
+}
+
+// AllowClient 는 client 의 요청을 허용하는지 확인한다. 허용하지 않으면 *Rejection 을 반환한다.
+func (l *Limiter) AllowClient(client string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.allow(l.clients, ReasonClient, client, l.cfg.Client)
+}
+
+// AllowBucket 은 bucket 의 요청을 허용하는지 확인한다. 허용하지 않으면 *Rejection 을 반환한다.
+func (l *Limiter) AllowBucket(bucket string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.allow(l.buckets, ReasonBucket, bucket, l.cfg.bucketLimit(bucket))
+}
+
+func (l *Limiter) allow(limiters *keyedLimiter, reason, key string, limit Limit) error {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(l.prunedAt) > idleTimeout {
+		l.clients.prune(now)
+		l.buckets.prune(now)
+		l.prunedAt = now
+	}
+	if delay, ok := limiters.reserve(now, key, limit); !ok {
+		l.metrics.rejected.WithLabelValues(reason).Inc()
+		return &Rejection{Reason: reason, RetryAfter: delay}
+	}
+	return nil
+}
+
+// Shed 는 서버의 부하가 높아서 요청을 거절해야 하는지 확인한다. 거절해야 하면 *Rejection 을 반환한다.
+// 진행 중인 Distributor 요청이 너무 많으면 모든 요청을, bolt 의 쓰기가 느리면 쓰기 요청의 일부를 거절한다.
+func (l *Limiter) Shed(write bool) error {
+	l.mu.Lock()
+	cfg := l.cfg.LoadShedding
+	l.mu.Unlock()
+
+	if cfg.MaxInFlight > 0 && l.inFlight.InFlight() >= cfg.MaxInFlight {
+		l.metrics.rejected.WithLabelValues(ReasonInFlight).Inc()
+		return &Rejection{Reason: ReasonInFlight, RetryAfter: cfg.retryAfter()}
+	}
+	if write && cfg.TargetWriteLatency > 0 {
+		latency := l.writeLatency.WriteLatency()
+		overload := float64(latency-cfg.TargetWriteLatency) / float64(cfg.TargetWriteLatency)
+		if overload > 0 && rand.Float64() < overload {
+			l.metrics.rejected.WithLabelValues(ReasonWriteLatency).Inc()
+			return &Rejection{Reason: ReasonWriteLatency, RetryAfter: cfg.retryAfter()}
+		}
+	}
+	return nil
+}
+
+// keyedLimiter 는 key 마다 token bucket 을 가진다.
+type keyedLimiter struct {
+	limiters map[string]*keyedEntry
+}
+
+type keyedEntry struct {
+	limiter *rate.Limiter
+	usedAt  time.Time
+}
+
+func newKeyedLimiter() *keyedLimiter {
+	return &keyedLimiter{limiters: make(map[string]*keyedEntry)}
+}
+
+// reserve 는 token 하나를 꺼낸다. token 이 없으면 꺼내지 않고 token 이 생길 때까지의 시간을 반환한다.
+func (k *keyedLimiter) reserve(now time.Time, key string, limit Limit) (time.Duration, bool) {
+	entry, ok := k.limiters[key]
+	if !ok {
+		entry = &keyedEntry{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.burst())}
+		k.limiters[key] = entry
+	}
+	entry.usedAt = now
+	reservation := entry.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return delay, false
+	}
+	return 0, true
+}
+
+func (k *keyedLimiter) prune(now time.Time) {
+	for key, entry := range k.limiters {
+		if now.Sub(entry.usedAt) > idleTimeout {
+			delete(k.limiters, key)
+		}
+	}
+}
+
+type metrics struct {
+	rejected *prometheus.CounterVec
+	rate     *prometheus.GaugeVec
+	burst    *prometheus.GaugeVec
+}
+
+func newMetrics(reg prometheus.Registerer, l *Limiter) *metrics {
+	factory := promauto.With(reg)
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "dbolt_distributor_in_flight_requests",
+		Help: "The number of Distributor requests in flight to replicas.",
+	}, func() float64 {
+		return float64(l.inFlight.InFlight())
+	})
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "dbolt_bolt_write_latency_seconds",
+		Help: "The recent average latency of bolt write transactions including the wait for the write lock.",
+	}, func() float64 {
+		return l.writeLatency.WriteLatency().Seconds()
+	})
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "dbolt_load_shedding_max_in_flight",
+		Help: "The configured maximum number of Distributor requests in flight. 0 means no limit.",
+	}, func() float64 {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return float64(l.cfg.LoadShedding.MaxInFlight)
+	})
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "dbolt_load_shedding_target_write_latency_seconds",
+		Help: "The configured target latency of bolt write transactions. 0 means no target.",
+	}, func() float64 {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.cfg.LoadShedding.TargetWriteLatency.Seconds()
+	})
+	return &metrics{
+		rejected: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbolt_rate_limit_rejected_total",
+			Help: "Total number of API requests rejected by rate limits and load shedding.",
+		}, []string{"reason"}),
+		rate: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dbolt_rate_limit_rate",
+			Help: "The configured requests per second. 0 means no limit.",
+		}, []string{"limit", "bucket"}),
+		burst: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dbolt_rate_limit_burst",
+			Help: "The configured burst of requests.",
+		}, []string{"limit", "bucket"}),
+	}
+}
+
+// setLimits 는 설정된 제한을 gauge 로 내보낸다. bucket label 이 빈 bucket 제한은 기본값이다.
+func (m *metrics) setLimits(cfg *Config) {
+	m.rate.Reset()
+	m.burst.Reset()
+	set := func(limit, bucket string, l Limit) {
+		m.rate.WithLabelValues(limit, bucket).Set(l.Rate)
+		if l.Rate > 0 {
+			m.burst.WithLabelValues(limit, bucket).Set(float64(l.burst()))
+		}
+	}
+	set(ReasonClient, "", cfg.Client)
+	set(ReasonBucket, "", cfg.Bucket)
+	for bucket, l := range cfg.BucketOverrides {
+		set(ReasonBucket, bucket, l)
+	}
+}
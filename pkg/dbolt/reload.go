@@ -0,0 +1,244 @@
+package dbolt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+// redacted 는 /admin/config 에서 비밀 값 대신 보여주는 값이다. dskit 의 flagext.Secret 과 같다.
+const redacted = "********"
+
+// secretKeys 는 /admin/config 에서 값을 가리는 설정 이름이다.
+var secretKeys = map[string]bool{
+	"token":          true,
+	"secret":         true,
+	"internal_token": true,
+	"keys":           true,
+}
+
+// Reloader 는 SIGHUP 을 받으면 설정을 다시 읽어서 재시작 없이 바꿀 수 있는 설정만 적용한다.
+// log.level, log.components, tenancy 의 제한(shard_size 제외), rate_limit, server.health, resp, memcached, etcd 의 timeout 이 해당하고 나머지는 재시작해야 바뀐다.
+type Reloader struct {
+	loader  *ConfigLoader
+	metrics *reloadMetrics
+	logger  *zap.Logger
+
+	mu        sync.Mutex
+	effective *Config
+	appliers  []func(cfg *Config)
+
+	signals chan os.Signal
+	done    chan struct{}
+}
+
+type reloadMetrics struct {
+	reloads *prometheus.CounterVec
+}
+
+func NewReloader(loader *ConfigLoader, cfg *Config, reg prometheus.Registerer, logger *zap.Logger) *Reloader {
+	return &Reloader{
+		loader: loader,
+		metrics: &reloadMetrics{
+			reloads: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+				Name: "dbolt_config_reloads_total",
+				Help: "The number of config reloads by result.",
+			}, []string{"result"}),
+		},
+		logger:    logger,
+		effective: cfg,
+		signals:   make(chan os.Signal, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+// OnReload 는 설정을 다시 읽을 때마다 호출할 함수를 등록한다. fn 이 받는 설정은 수정하면 안 된다.
+func (r *Reloader) OnReload(fn func(cfg *Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.appliers = append(r.appliers, fn)
+}
+
+func (r *Reloader) Start(ctx context.Context) error {
+	signal.Notify(r.signals, syscall.SIGHUP)
+	go r.run()
+	return nil
+}
+
+func (r *Reloader) Stop(ctx context.Context) error {
+	signal.Stop(r.signals)
+	close(r.done)
+	return nil
+}
+
+func (r *Reloader) run() {
+	for {
+		select {
+		case <-r.signals:
+			r.logger.Info("Reloading the config.", zap.String("path", r.loader.Path()))
+			if err := r.Reload(); err != nil {
+				r.logger.Error("Failed to reload the config.", zap.Error(err))
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Reload 는 설정을 다시 읽어서 바꿀 수 있는 설정을 적용한다. 설정이 올바르지 않으면 아무것도 바꾸지 않는다.
+func (r *Reloader) Reload() error {
+	loaded, err := r.loader.Load()
+	if err != nil {
+		r.metrics.reloads.WithLabelValues("failure").Inc()
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	effective := *r.effective
+	applyReloadable(&effective, loaded)
+	changed, err := diffConfig(&effective, loaded)
+	if err != nil {
+		r.metrics.reloads.WithLabelValues("failure").Inc()
+		return err
+	}
+	if len(changed) > 0 {
+		r.logger.Warn("Some settings require a restart and were not applied.", zap.Strings("settings", changed))
+	}
+
+	for _, apply := range r.appliers {
+		apply(&effective)
+	}
+	r.effective = &effective
+	r.metrics.reloads.WithLabelValues("success").Inc()
+	r.logger.Info("Reloaded the config.")
+	return nil
+}
+
+// Config 는 지금 적용된 설정이다. 반환한 설정은 수정하면 안 된다.
+func (r *Reloader) Config() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.effective
+}
+
+// RedactedYAML 은 httpserver.ConfigSource 를 구현한다.
+func (r *Reloader) RedactedYAML() ([]byte, error) {
+	var tree yaml.MapSlice
+	if err := remarshal(r.Config(), &tree); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(redact(tree, false))
+}
+
+// applyReloadable 은 src 의 설정 중 재시작 없이 바꿀 수 있는 것만 dst 에 복사한다.
+// shard_size 는 tenant 의 bucket 배치를 바꾸므로 dst 의 것을 유지한다.
+func applyReloadable(dst, src *Config) {
+	dst.LogConfig.Level = src.LogConfig.Level
+	dst.LogConfig.Components = src.LogConfig.Components
+	dst.RateLimitConfig = src.RateLimitConfig
+	dst.ServerConfig.Health = src.ServerConfig.Health
+	dst.RESPConfig.Timeout = src.RESPConfig.Timeout
+	dst.MemcachedConfig.Timeout = src.MemcachedConfig.Timeout
+	dst.EtcdConfig.Timeout = src.EtcdConfig.Timeout
+
+	tenancy := dst.TenancyConfig
+	tenancy.DefaultLimits = src.TenancyConfig.DefaultLimits
+	tenancy.DefaultLimits.ShardSize = dst.TenancyConfig.DefaultLimits.ShardSize
+	tenancy.Overrides = make(map[string]tenant.Limits, len(src.TenancyConfig.Overrides))
+	for tenantID, limits := range src.TenancyConfig.Overrides {
+		limits.ShardSize = dst.TenancyConfig.ShardSize(tenantID)
+		tenancy.Overrides[tenantID] = limits
+	}
+	dst.TenancyConfig = tenancy
+}
+
+// diffConfig 는 a 와 b 에서 값이 다른 설정의 경로를 반환한다.
+func diffConfig(a, b *Config) ([]string, error) {
+	flatA, flatB := make(map[string]string), make(map[string]string)
+	for _, c := range []struct {
+		cfg  *Config
+		flat map[string]string
+	}{{a, flatA}, {b, flatB}} {
+		var tree interface{}
+		if err := remarshal(c.cfg, &tree); err != nil {
+			return nil, err
+		}
+		flatten("", tree, c.flat)
+	}
+	var changed []string
+	for path, value := range flatA {
+		if other, ok := flatB[path]; !ok || other != value {
+			changed = append(changed, path)
+		}
+	}
+	for path := range flatB {
+		if _, ok := flatA[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+func remarshal(cfg *Config, out interface{}) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the config")
+	}
+	return yaml.Unmarshal(data, out)
+}
+
+func flatten(prefix string, value interface{}, out map[string]string) {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		for _, item := range v {
+			flatten(fmt.Sprintf("%s%v.", prefix, item.Key), item.Value, out)
+		}
+	case map[interface{}]interface{}:
+		for key, item := range v {
+			flatten(fmt.Sprintf("%s%v.", prefix, key), item, out)
+		}
+	default:
+		out[prefix[:max(len(prefix)-1, 0)]] = fmt.Sprint(v)
+	}
+}
+
+// redact 는 secretKeys 에 있는 설정의 값을 가린다. secret 이면 value 아래의 모든 문자열을 가린다.
+func redact(value interface{}, secret bool) interface{} {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		for i, item := range v {
+			v[i].Value = redact(item.Value, secret || secretKeys[fmt.Sprint(item.Key)])
+		}
+		return v
+	case map[interface{}]interface{}:
+		for key, item := range v {
+			v[key] = redact(item, secret || secretKeys[fmt.Sprint(key)])
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redact(item, secret)
+		}
+		return v
+	case string:
+		if secret && v != "" {
+			return redacted
+		}
+		return v
+	default:
+		return v
+	}
+}
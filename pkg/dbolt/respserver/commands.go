@@ -0,0 +1,526 @@
+package respserver
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/pkg/errors"
+)
+
+type command struct {
+	handler func(c *conn, ctx context.Context, name string, args [][]byte) error
+	// arity 는 명령 이름을 포함한 인자 개수이다. 음수이면 최소 개수이다. (Redis 의 COMMAND 와 같다)
+	arity int
+	// noAuth 이면 인증하기 전에도 실행할 수 있다.
+	noAuth bool
+	// keys 이면 key 를 다루는 명령이므로 tenant 가 필요하고 tenant 의 요청 속도 제한을 적용한다.
+	keys bool
+}
+
+var commands = map[string]*command{
+	"PING":    {handler: (*conn).ping, arity: -1},
+	"ECHO":    {handler: (*conn).echo, arity: 2},
+	"QUIT":    {handler: (*conn).quit, arity: 1, noAuth: true},
+	"AUTH":    {handler: (*conn).auth, arity: -2, noAuth: true},
+	"SELECT":  {handler: (*conn).selectDB, arity: 2},
+	"CLIENT":  {handler: (*conn).client, arity: -2},
+	"COMMAND": {handler: (*conn).command, arity: -1},
+	"GET":     {handler: (*conn).get, arity: 2, keys: true},
+	"SET":     {handler: (*conn).set, arity: -3, keys: true},
+	"DEL":     {handler: (*conn).del, arity: -2, keys: true},
+	"EXISTS":  {handler: (*conn).exists, arity: -2, keys: true},
+	"MGET":    {handler: (*conn).mget, arity: -2, keys: true},
+	"MSET":    {handler: (*conn).mset, arity: -3, keys: true},
+	"SCAN":    {handler: (*conn).scan, arity: -2, keys: true},
+	"EXPIRE":  {handler: (*conn).expire, arity: 3, keys: true},
+	"PEXPIRE": {handler: (*conn).expire, arity: 3, keys: true},
+	"TTL":     {handler: (*conn).ttl, arity: 2, keys: true},
+	"PTTL":    {handler: (*conn).ttl, arity: 2, keys: true},
+	"PERSIST": {handler: (*conn).persist, arity: 2, keys: true},
+}
+
+func (c *conn) ping(_ context.Context, _ string, args [][]byte) error {
+	switch len(args) {
+	case 0:
+		c.w.simple("PONG")
+	case 1:
+		c.w.bulk(args[0])
+	default:
+		return replyError("ERR wrong number of arguments for 'ping' command")
+	}
+	return nil
+}
+
+func (c *conn) echo(_ context.Context, _ string, args [][]byte) error {
+	c.w.bulk(args[0])
+	return nil
+}
+
+func (c *conn) quit(_ context.Context, _ string, _ [][]byte) error {
+	c.closing = true
+	c.w.simple("OK")
+	return nil
+}
+
+// auth 는 AUTH <token> 또는 AUTH <tenant> <token> 이다. token 은 HTTP API 의 Bearer token 과 같다.
+// principal 에 tenant 가 없으면 username 을 tenant 로 사용한다. 인증이 꺼져 있으면 token 은 확인하지 않는다.
+func (c *conn) auth(_ context.Context, _ string, args [][]byte) error {
+	if len(args) > 2 {
+		return errSyntax
+	}
+	token := string(args[len(args)-1])
+	var tenantID string
+	if len(args) == 2 {
+		tenantID = string(args[0])
+		if _, err := tenant.ID(tenant.Inject(context.Background(), tenantID)); err != nil {
+			return replyError("ERR invalid tenant : " + err.Error())
+		}
+	}
+	if !c.s.auth.Enabled() {
+		c.setPrincipal(nil, tenantID)
+		c.w.simple("OK")
+		return nil
+	}
+	principal, err := c.s.auth.Authenticate(&auth.Request{BearerToken: token})
+	if err != nil {
+		c.s.auth.Unauthenticated("resp AUTH", err)
+		return errWrongPass
+	}
+	c.setPrincipal(principal, tenantID)
+	c.w.simple("OK")
+	return nil
+}
+
+func (c *conn) selectDB(_ context.Context, _ string, args [][]byte) error {
+	db, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return errNotInteger
+	}
+	if c.s.cfg.prefixMapping() && db != 0 {
+		return replyError("ERR SELECT is not allowed when keys are mapped by prefix")
+	}
+	if db < 0 || db >= databaseCount {
+		return replyError("ERR DB index is out of range")
+	}
+	c.db = db
+	c.cursors = make(map[uint64]*scanCursor)
+	c.w.simple("OK")
+	return nil
+}
+
+// client 는 client library 가 연결할 때 보내는 CLIENT 명령에 응답한다.
+func (c *conn) client(_ context.Context, _ string, args [][]byte) error {
+	switch strings.ToUpper(string(args[0])) {
+	case "SETNAME":
+		if len(args) != 2 {
+			return errSyntax
+		}
+		c.name = string(args[1])
+		c.w.simple("OK")
+	case "GETNAME":
+		if c.name == "" {
+			c.w.null()
+		} else {
+			c.w.bulk([]byte(c.name))
+		}
+	case "ID":
+		c.w.integer(c.id)
+	case "SETINFO":
+		c.w.simple("OK")
+	default:
+		return replyError("ERR unknown subcommand '" + string(args[0]) + "'")
+	}
+	return nil
+}
+
+// command 는 redis-cli 가 시작할 때 보내는 COMMAND DOCS 에 빈 목록으로 응답한다.
+func (c *conn) command(_ context.Context, _ string, _ [][]byte) error {
+	c.w.array(0)
+	return nil
+}
+
+func (c *conn) get(ctx context.Context, name string, args [][]byte) error {
+	bucketName, key, err := c.target(name, args[0], auth.PermissionRead)
+	if err != nil {
+		return err
+	}
+	versionedValue, err := c.s.dist.GetVersionedLocal(ctx, bucketName, key)
+	if errors.Is(err, distributor.ErrKeyValueNotFound) {
+		c.w.null()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.w.bulk(versionedValue.Value)
+	return nil
+}
+
+// set 은 SET key value [NX|XX] [EX seconds|PX milliseconds|EXAT timestamp|PXAT timestamp] 이다.
+func (c *conn) set(ctx context.Context, name string, args [][]byte) error {
+	cond := new(distributor.Precondition)
+	var expiresAt time.Time
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch option {
+		case "NX":
+			if cond.IfExists {
+				return errSyntax
+			}
+			cond.IfAbsent = true
+		case "XX":
+			if cond.IfAbsent {
+				return errSyntax
+			}
+			cond.IfExists = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if !expiresAt.IsZero() || i+1 == len(args) {
+				return errSyntax
+			}
+			i++
+			t, err := parseExpiration(args[i], option, "set")
+			if err != nil {
+				return err
+			}
+			expiresAt = t
+		case "KEEPTTL", "GET":
+			return replyError("ERR SET " + option + " is not supported")
+		default:
+			return errSyntax
+		}
+	}
+
+	bucketName, key, err := c.target(name, args[0], auth.PermissionWrite)
+	if err != nil {
+		return err
+	}
+	_, err = c.s.dist.PutWithOptions(ctx, bucketName, key, args[1], &distributor.PutOptions{Cond: cond, ExpiresAt: expiresAt})
+	if errors.Is(err, distributor.ErrPreconditionFailed) {
+		c.w.null()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.w.simple("OK")
+	return nil
+}
+
+// parseExpiration 은 EX, PX 이면 지금부터의 시간으로, EXAT, PXAT 이면 Unix 시각으로 만료 시각을 계산한다.
+func parseExpiration(arg []byte, unit, command string) (time.Time, error) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return time.Time{}, errNotInteger
+	}
+	invalid := replyError("ERR invalid expire time in '" + command + "' command")
+	// SET 은 0 이하의 만료 시간을 허용하지 않고, EXPIRE 는 0 이하이면 key 를 지운다.
+	if n <= 0 && command == "set" {
+		return time.Time{}, invalid
+	}
+	scale := int64(time.Second)
+	if unit == "PX" || unit == "PXAT" {
+		scale = int64(time.Millisecond)
+	}
+	if n > math.MaxInt64/scale || n < math.MinInt64/scale {
+		return time.Time{}, invalid
+	}
+	if unit == "EXAT" || unit == "PXAT" {
+		return time.Unix(0, n*scale), nil
+	}
+	now := time.Now()
+	if n > 0 && n*scale > math.MaxInt64-now.UnixNano() {
+		return time.Time{}, invalid
+	}
+	return now.Add(time.Duration(n * scale)), nil
+}
+
+func (c *conn) del(ctx context.Context, name string, args [][]byte) error {
+	deleted := int64(0)
+	for _, arg := range args {
+		bucketName, key, err := c.target(name, arg, auth.PermissionWrite)
+		if err != nil {
+			return err
+		}
+		err = c.s.dist.DeleteIf(ctx, bucketName, key, &distributor.Precondition{IfExists: true})
+		if errors.Is(err, distributor.ErrPreconditionFailed) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		deleted++
+	}
+	c.w.integer(deleted)
+	return nil
+}
+
+func (c *conn) exists(ctx context.Context, name string, args [][]byte) error {
+	found := int64(0)
+	for _, arg := range args {
+		bucketName, key, err := c.target(name, arg, auth.PermissionRead)
+		if err != nil {
+			return err
+		}
+		_, err = c.s.dist.GetVersioned(ctx, bucketName, key)
+		if errors.Is(err, distributor.ErrKeyValueNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		found++
+	}
+	c.w.integer(found)
+	return nil
+}
+
+func (c *conn) mget(ctx context.Context, name string, args [][]byte) error {
+	values := make([][]byte, len(args))
+	for i, arg := range args {
+		bucketName, key, err := c.target(name, arg, auth.PermissionRead)
+		if err != nil {
+			return err
+		}
+		versionedValue, err := c.s.dist.GetVersionedLocal(ctx, bucketName, key)
+		if errors.Is(err, distributor.ErrKeyValueNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		values[i] = versionedValue.Value
+	}
+	c.w.array(len(values))
+	for _, value := range values {
+		if value == nil {
+			c.w.null()
+		} else {
+			c.w.bulk(value)
+		}
+	}
+	return nil
+}
+
+// mset 은 권한을 모두 확인한 뒤 key 를 하나씩 쓴다. Redis 와 달리 중간에 실패하면 앞의 key 만 기록된다.
+func (c *conn) mset(ctx context.Context, name string, args [][]byte) error {
+	if len(args)%2 != 0 {
+		return replyError("ERR wrong number of arguments for 'mset' command")
+	}
+	type write struct {
+		bucketName, key, value []byte
+	}
+	writes := make([]write, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		bucketName, key, err := c.target(name, args[i], auth.PermissionWrite)
+		if err != nil {
+			return err
+		}
+		writes = append(writes, write{bucketName: bucketName, key: key, value: args[i+1]})
+	}
+	for _, w := range writes {
+		if err := c.s.dist.Put(ctx, w.bucketName, w.key, w.value); err != nil {
+			return err
+		}
+	}
+	c.w.simple("OK")
+	return nil
+}
+
+// expire 는 EXPIRE key seconds, PEXPIRE key milliseconds 이다. 0 이하이면 key 를 지운다.
+func (c *conn) expire(ctx context.Context, name string, args [][]byte) error {
+	unit := "EX"
+	if name == "PEXPIRE" {
+		unit = "PX"
+	}
+	expiresAt, err := parseExpiration(args[1], unit, strings.ToLower(name))
+	if err != nil {
+		return err
+	}
+	bucketName, key, err := c.target(name, args[0], auth.PermissionWrite)
+	if err != nil {
+		return err
+	}
+	ok, err := c.s.dist.Expire(ctx, bucketName, key, expiresAt)
+	if err != nil {
+		return err
+	}
+	c.w.integer(boolInt(ok))
+	return nil
+}
+
+// ttl 은 TTL, PTTL 이다. key 가 없으면 -2, 만료 시각이 없으면 -1 이다.
+func (c *conn) ttl(ctx context.Context, name string, args [][]byte) error {
+	bucketName, key, err := c.target(name, args[0], auth.PermissionRead)
+	if err != nil {
+		return err
+	}
+	versionedValue, err := c.s.dist.GetVersioned(ctx, bucketName, key)
+	if errors.Is(err, distributor.ErrKeyValueNotFound) {
+		c.w.integer(-2)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if versionedValue.ExpiresAt.IsZero() {
+		c.w.integer(-1)
+		return nil
+	}
+	remaining := time.Until(versionedValue.ExpiresAt)
+	if name == "PTTL" {
+		c.w.integer(remaining.Milliseconds())
+	} else {
+		c.w.integer(int64(remaining.Round(time.Second) / time.Second))
+	}
+	return nil
+}
+
+func (c *conn) persist(ctx context.Context, name string, args [][]byte) error {
+	bucketName, key, err := c.target(name, args[0], auth.PermissionWrite)
+	if err != nil {
+		return err
+	}
+	versionedValue, err := c.s.dist.GetVersioned(ctx, bucketName, key)
+	if errors.Is(err, distributor.ErrKeyValueNotFound) {
+		c.w.integer(0)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if versionedValue.ExpiresAt.IsZero() {
+		c.w.integer(0)
+		return nil
+	}
+	ok, err := c.s.dist.Expire(ctx, bucketName, key, time.Time{})
+	if err != nil {
+		return err
+	}
+	c.w.integer(boolInt(ok))
+	return nil
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+const (
+	defaultScanCount = 10
+	maxScanCount     = 1000
+	// maxCursors 는 연결마다 기억하는 SCAN cursor 의 최대 개수이다. 넘으면 모두 버린다.
+	maxCursors = 1024
+)
+
+// scanCursor 는 SCAN 이 반환한 cursor 에서 이어서 읽을 위치이다.
+type scanCursor struct {
+	after []byte
+}
+
+// scan 은 SCAN cursor [MATCH pattern] [COUNT count] [TYPE type] 이다.
+// Redis 의 cursor 는 숫자이므로 이어서 읽을 key 는 연결에 기억하고 그 번호를 cursor 로 반환한다.
+// key 를 prefix 로 bucket 에 대응시키면 MATCH 의 앞부분으로 bucket 을 정해야 한다.
+func (c *conn) scan(ctx context.Context, name string, args [][]byte) error {
+	id, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return replyError("ERR invalid cursor")
+	}
+	var after []byte
+	if id != 0 {
+		cursor, ok := c.cursors[id]
+		if !ok {
+			return replyError("ERR invalid cursor")
+		}
+		delete(c.cursors, id)
+		after = cursor.after
+	}
+	var pattern []byte
+	count := defaultScanCount
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return errSyntax
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			n, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return errNotInteger
+			}
+			if n < 1 {
+				return errSyntax
+			}
+			count = min(n, maxScanCount)
+		case "TYPE":
+			// 값은 모두 string 이다.
+			if !strings.EqualFold(string(args[i+1]), "string") {
+				c.writeScan(0, nil)
+				return nil
+			}
+		default:
+			return errSyntax
+		}
+	}
+
+	var bucket, keyPrefix, scanPrefix []byte
+	if c.s.cfg.prefixMapping() {
+		separator := c.s.cfg.separator()
+		literal := globPrefix(pattern)
+		i := bytes.Index(literal, separator)
+		if i <= 0 {
+			return replyError("ERR SCAN requires MATCH <bucket>" + string(separator) + "<pattern> when keys are mapped by prefix")
+		}
+		bucket = literal[:i]
+		keyPrefix = literal[:i+len(separator)]
+		scanPrefix = literal[i+len(separator):]
+	} else {
+		bucket = c.s.cfg.database(c.db)
+		scanPrefix = globPrefix(pattern)
+	}
+	bucketName, err := c.bucketName(name, bucket, auth.PermissionRead)
+	if err != nil {
+		return err
+	}
+
+	entries, more, err := c.s.dist.Scan(ctx, bucketName, scanPrefix, after, count)
+	if err != nil {
+		return err
+	}
+	keys := make([][]byte, 0, len(entries))
+	for _, entry := range entries {
+		key := append(append([]byte(nil), keyPrefix...), entry.Key...)
+		if pattern == nil || globMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	next := uint64(0)
+	if more && len(entries) > 0 {
+		if len(c.cursors) >= maxCursors {
+			c.cursors = make(map[uint64]*scanCursor)
+		}
+		c.nextCursor++
+		next = c.nextCursor
+		c.cursors[next] = &scanCursor{after: entries[len(entries)-1].Key}
+	}
+	c.writeScan(next, keys)
+	return nil
+}
+
+func (c *conn) writeScan(cursor uint64, keys [][]byte) {
+	c.w.array(2)
+	c.w.bulk([]byte(strconv.FormatUint(cursor, 10)))
+	c.w.array(len(keys))
+	for _, key := range keys {
+		c.w.bulk(key)
+	}
+}
@@ -0,0 +1,139 @@
+package respserver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxBulkLength 는 한 인자의 최대 크기이다.
+	maxBulkLength = 64 << 20
+	// maxArgs 는 한 명령의 최대 인자 개수이다.
+	maxArgs = 1 << 20
+	// maxCommandLength 는 한 명령의 모든 인자를 합한 최대 크기이다.
+	maxCommandLength = 128 << 20
+	// maxInlineLength 는 inline 명령 한 줄의 최대 크기이다.
+	maxInlineLength = 64 << 10
+)
+
+// errProtocol 이면 응답을 보낸 뒤 연결을 끊는다.
+var errProtocol = errors.New("protocol error")
+
+type reader struct {
+	br *bufio.Reader
+}
+
+// readCommand 는 RESP 배열로 된 명령을 읽는다. redis-cli 나 telnet 에서 보낸 inline 명령도 읽는다.
+func (r *reader) readCommand() ([][]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, errors.Wrap(errProtocol, "invalid multibulk length")
+	}
+	// Redis 처럼 *-1 과 *0 은 빈 명령으로 본다.
+	if n <= 0 {
+		return nil, nil
+	}
+	args := make([][]byte, 0, min(n, 1024))
+	total := 0
+	for i := 0; i < n; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errors.Wrapf(errProtocol, "expected '$', got '%s'", string(line))
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxBulkLength {
+			return nil, errors.Wrap(errProtocol, "invalid bulk length")
+		}
+		if total += size; total > maxCommandLength {
+			return nil, errors.Wrap(errProtocol, "too big command")
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r.br, arg); err != nil {
+			return nil, err
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, errors.Wrap(errProtocol, "bulk string not terminated by CRLF")
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+func (r *reader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.br.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxInlineLength {
+			return nil, errors.Wrap(errProtocol, "too big inline request")
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// writer 는 RESP2 응답을 기록한다. 버퍼에 모아 두었다가 읽을 명령이 없을 때 flush 한다.
+type writer struct {
+	bw *bufio.Writer
+}
+
+func (w *writer) simple(s string) {
+	w.bw.WriteByte('+')
+	w.bw.WriteString(s)
+	w.bw.WriteString("\r\n")
+}
+
+func (w *writer) error(msg string) {
+	w.bw.WriteByte('-')
+	w.bw.WriteString(msg)
+	w.bw.WriteString("\r\n")
+}
+
+func (w *writer) integer(n int64) {
+	w.bw.WriteByte(':')
+	w.bw.WriteString(strconv.FormatInt(n, 10))
+	w.bw.WriteString("\r\n")
+}
+
+func (w *writer) bulk(b []byte) {
+	w.bw.WriteByte('$')
+	w.bw.WriteString(strconv.Itoa(len(b)))
+	w.bw.WriteString("\r\n")
+	w.bw.Write(b)
+	w.bw.WriteString("\r\n")
+}
+
+func (w *writer) null() {
+	w.bw.WriteString("$-1\r\n")
+}
+
+func (w *writer) array(n int) {
+	w.bw.WriteByte('*')
+	w.bw.WriteString(strconv.Itoa(n))
+	w.bw.WriteString("\r\n")
+}
+
+func (w *writer) flush() error {
+	return w.bw.Flush()
+}
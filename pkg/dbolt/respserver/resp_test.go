@@ -0,0 +1,95 @@
+package respserver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newReader(r io.Reader) *reader {
+	return &reader{br: bufio.NewReader(r)}
+}
+
+func TestReadCommand(t *testing.T) {
+	r := newReader(strings.NewReader("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$0\r\n\r\nGET  key\r\n\r\n*0\r\n*-1\r\n"))
+
+	args, err := r.readCommand()
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("SET"), []byte("key"), {}}, args)
+
+	// inline 명령
+	args, err = r.readCommand()
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("GET"), []byte("key")}, args)
+
+	// 빈 줄, *0, *-1 은 빈 명령이다.
+	for i := 0; i < 3; i++ {
+		args, err = r.readCommand()
+		require.NoError(t, err)
+		require.Empty(t, args)
+	}
+	_, err = r.readCommand()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestReadCommandLimits(t *testing.T) {
+	for name, input := range map[string]string{
+		"invalid multibulk length": "*x\r\n",
+		"too many args":            "*" + strconv.Itoa(maxArgs+1) + "\r\n",
+		"missing bulk":             "*1\r\n+OK\r\n",
+		"invalid bulk length":      "*1\r\n$x\r\n",
+		"negative bulk length":     "*1\r\n$-1\r\n",
+		"too big bulk":             "*1\r\n$" + strconv.Itoa(maxBulkLength+1) + "\r\n",
+		"not terminated by CRLF":   "*1\r\n$3\r\nkeyXX",
+		"too big inline request":   strings.Repeat("a", maxInlineLength+1) + "\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newReader(strings.NewReader(input)).readCommand()
+			require.ErrorIs(t, err, errProtocol)
+		})
+	}
+
+	t.Run("too big command", func(t *testing.T) {
+		// 인자마다 maxBulkLength 이하여도 모두 합한 크기가 maxCommandLength 를 넘으면 거절한다.
+		n := maxCommandLength/maxBulkLength + 1
+		readers := []io.Reader{strings.NewReader("*" + strconv.Itoa(n) + "\r\n")}
+		for i := 0; i < n; i++ {
+			readers = append(readers,
+				strings.NewReader("$"+strconv.Itoa(maxBulkLength)+"\r\n"),
+				io.LimitReader(zeros{}, maxBulkLength),
+				strings.NewReader("\r\n"))
+		}
+		_, err := newReader(io.MultiReader(readers...)).readCommand()
+		require.ErrorIs(t, err, errProtocol)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := newReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$3\r\nke")).readCommand()
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &writer{bw: bufio.NewWriter(&buf)}
+	w.array(5)
+	w.simple("OK")
+	w.error("ERR wrong")
+	w.integer(-1)
+	w.bulk([]byte("value"))
+	w.null()
+	require.NoError(t, w.flush())
+	require.Equal(t, "*5\r\n+OK\r\n-ERR wrong\r\n:-1\r\n$5\r\nvalue\r\n$-1\r\n", buf.String())
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
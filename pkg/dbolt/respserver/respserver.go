@@ -0,0 +1,424 @@
+package respserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/decommission"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tlsconfig"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+const (
+	KeyMappingDatabase = "database"
+	KeyMappingPrefix   = "prefix"
+
+	// databaseCount 는 SELECT 할 수 있는 database 의 개수이다. Redis 의 기본값과 같다.
+	databaseCount = 16
+)
+
+type Config struct {
+	Enabled    bool   `yaml:"enabled"`
+	ListenPort uint16 `yaml:"listen_port"`
+	// KeyMapping 은 Redis 의 key 를 bucket 에 대응시키는 방법이다. (database, prefix) 기본값은 database 이다.
+	// database 이면 SELECT 한 database 의 bucket 을 사용하고, prefix 이면 key 를 KeySeparator 로 나눈 앞부분을 bucket 으로 사용한다.
+	KeyMapping string `yaml:"key_mapping"`
+	// Databases 는 database 번호의 bucket 이름이다. 없는 번호는 "db<번호>" bucket 을 사용한다.
+	Databases map[int]string `yaml:"databases"`
+	// KeySeparator 의 기본값은 ":" 이다.
+	KeySeparator string `yaml:"key_separator"`
+	// Timeout 은 한 명령의 제한 시간이다. 기본값은 10초이다.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c *Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.ListenPort == 0 {
+		return errors.New("resp 'listen_port' required")
+	}
+	switch c.KeyMapping {
+	case "", KeyMappingDatabase, KeyMappingPrefix:
+	default:
+		return errors.Errorf("unknown resp 'key_mapping' : %s", c.KeyMapping)
+	}
+	for db, bucket := range c.Databases {
+		if db < 0 || db >= databaseCount {
+			return errors.Errorf("resp 'databases' index must be between 0 and %d : %d", databaseCount-1, db)
+		}
+		if bucket == "" {
+			return errors.Errorf("resp 'databases.%d' must not be empty", db)
+		}
+	}
+	return nil
+}
+
+func (c *Config) prefixMapping() bool {
+	return c.KeyMapping == KeyMappingPrefix
+}
+
+func (c *Config) separator() []byte {
+	if c.KeySeparator == "" {
+		return []byte(":")
+	}
+	return []byte(c.KeySeparator)
+}
+
+func (c *Config) database(db int) []byte {
+	if bucket, ok := c.Databases[db]; ok {
+		return []byte(bucket)
+	}
+	return []byte("db" + strconv.Itoa(db))
+}
+
+func (c *Config) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return c.Timeout
+}
+
+// Server 는 Redis protocol(RESP2) 로 Distributor 의 key 를 읽고 쓰는 listener 이다.
+type Server struct {
+	cfg     *Config
+	addr    string
+	tls     *tlsconfig.Server
+	dist    *distributor.Distributor
+	tenants *tenant.Service
+	auth    *auth.Service
+	// decommission 을 시작하면 key 를 다루는 명령을 거절한다.
+	decommission *decommission.Decommissioner
+	metrics      *metrics
+	logger       *zap.Logger
+	// timeout 은 한 명령의 제한 시간이다. 설정을 다시 읽으면 ApplyConfig 로 바뀐다.
+	timeout atomic.Int64
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*conn]struct{}
+	wg       sync.WaitGroup
+	connID   atomic.Int64
+}
+
+func New(cfg *Config, addr string, serverTLS *tlsconfig.Server, dist *distributor.Distributor, tenants *tenant.Service, authService *auth.Service, decommissioner *decommission.Decommissioner, reg prometheus.Registerer, logger *zap.Logger) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		cfg:          cfg,
+		addr:         addr,
+		tls:          serverTLS,
+		dist:         dist,
+		tenants:      tenants,
+		auth:         authService,
+		decommission: decommissioner,
+		metrics:      newMetrics(reg),
+		logger:       logger,
+		ctx:          ctx,
+		cancel:       cancel,
+		conns:        make(map[*conn]struct{}),
+	}
+	s.timeout.Store(int64(cfg.timeout()))
+	return s
+}
+
+// ApplyConfig 는 다시 읽은 설정 중 timeout 만 적용한다. 나머지는 재시작해야 바뀐다.
+func (s *Server) ApplyConfig(cfg *Config) {
+	s.timeout.Store(int64(cfg.timeout()))
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen : addr=%s", s.addr)
+	}
+	if s.tls != nil {
+		ln = tls.NewListener(ln, s.tls.Config())
+	}
+	s.logger.Info("Starting RESP server.", zap.String("bindAddress", s.addr), zap.Bool("tls", s.tls != nil), zap.String("keyMapping", s.cfg.KeyMapping))
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+	go s.accept(ln)
+	return nil
+}
+
+func (s *Server) Stop(ctx context.Context) error {
+	s.logger.Info("Stopping RESP server.")
+	s.cancel()
+	s.mu.Lock()
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	for c := range s.conns {
+		_ = c.netConn.Close()
+	}
+	s.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) accept(ln net.Listener) {
+	for {
+		netConn, err := ln.Accept()
+		if err != nil {
+			if s.ctx.Err() == nil {
+				s.logger.Error("RESP server stopped.", zap.Error(err))
+			}
+			return
+		}
+		c := s.newConn(netConn)
+		s.mu.Lock()
+		if s.ctx.Err() != nil {
+			s.mu.Unlock()
+			_ = netConn.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.metrics.connections.Inc()
+			defer s.metrics.connections.Dec()
+			c.serve()
+			_ = netConn.Close()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// conn 은 client 연결 하나의 상태이다. 명령은 받은 순서대로 하나씩 처리한다.
+type conn struct {
+	s       *Server
+	id      int64
+	netConn net.Conn
+	r       *reader
+	w       *writer
+
+	db            int
+	authenticated bool
+	principal     *auth.Principal
+	tenantID      string
+	name          string
+	closing       bool
+
+	cursors    map[uint64]*scanCursor
+	nextCursor uint64
+}
+
+func (s *Server) newConn(netConn net.Conn) *conn {
+	return &conn{
+		s:       s,
+		id:      s.connID.Add(1),
+		netConn: netConn,
+		r:       &reader{br: bufio.NewReader(netConn)},
+		w:       &writer{bw: bufio.NewWriter(netConn)},
+		cursors: make(map[uint64]*scanCursor),
+	}
+}
+
+func (c *conn) serve() {
+	if c.s.auth.Enabled() {
+		c.authenticateCertificate()
+	}
+	for !c.closing {
+		args, err := c.r.readCommand()
+		if errors.Is(err, errProtocol) {
+			c.w.error("ERR Protocol error: " + strings.TrimSuffix(err.Error(), ": "+errProtocol.Error()))
+			_ = c.w.flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		if len(args) > 0 {
+			c.dispatch(args)
+		}
+		// pipeline 으로 보낸 명령이 남아 있으면 응답을 모아서 보낸다.
+		if c.r.br.Buffered() == 0 || c.closing {
+			if err := c.w.flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// authenticateCertificate 는 TLS client 인증서가 있으면 AUTH 없이 인증한다.
+func (c *conn) authenticateCertificate() {
+	tlsConn, ok := c.netConn.(*tls.Conn)
+	if !ok {
+		return
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return
+	}
+	certificates := tlsConn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return
+	}
+	principal, err := c.s.auth.Authenticate(&auth.Request{PeerCertificates: certificates})
+	if err != nil {
+		return
+	}
+	c.setPrincipal(principal, "")
+}
+
+func (c *conn) setPrincipal(principal *auth.Principal, tenantID string) {
+	c.authenticated = true
+	c.principal = principal
+	if principal != nil && principal.Tenant != "" {
+		tenantID = principal.Tenant
+	}
+	c.tenantID = tenantID
+}
+
+// replyError 는 그대로 client 에 보내는 오류이다. 그 외의 오류는 "ERR " 를 붙여 보낸다.
+type replyError string
+
+func (e replyError) Error() string {
+	return string(e)
+}
+
+var (
+	errSyntax        = replyError("ERR syntax error")
+	errNotInteger    = replyError("ERR value is not an integer or out of range")
+	errNoAuth        = replyError("NOAUTH Authentication required.")
+	errWrongPass     = replyError("WRONGPASS invalid username-password pair or user is disabled.")
+	errTenantMissing = replyError("ERR tenant required: AUTH <tenant> <token>")
+	// errNotServing 은 Redis Cluster 처럼 TRYAGAIN 으로 응답해서 client 가 다시 시도하게 한다.
+	errNotServing = replyError("TRYAGAIN " + decommission.ErrNotServing.Error())
+)
+
+func (c *conn) dispatch(args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		c.s.metrics.commands.WithLabelValues("unknown", "error").Inc()
+		c.w.error("ERR unknown command '" + string(args[0]) + "'")
+		return
+	}
+	err := c.run(name, cmd, args)
+	result := "ok"
+	if err != nil {
+		result = "error"
+		var reply replyError
+		if errors.As(err, &reply) {
+			c.w.error(string(reply))
+		} else {
+			c.w.error("ERR " + err.Error())
+		}
+	}
+	c.s.metrics.commands.WithLabelValues(strings.ToLower(name), result).Inc()
+}
+
+func (c *conn) run(name string, cmd *command, args [][]byte) error {
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		return replyError("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+	}
+	if c.s.auth.Enabled() && !c.authenticated && !cmd.noAuth {
+		return errNoAuth
+	}
+	if cmd.keys && !c.s.decommission.Serving() {
+		return errNotServing
+	}
+	if cmd.keys && c.s.tenants.Enabled() {
+		if c.tenantID == "" {
+			return errTenantMissing
+		}
+		if !c.s.tenants.Allow(c.tenantID) {
+			return replyError("ERR request rate limit exceeded : tenant=" + c.tenantID)
+		}
+	}
+	ctx, cancel := context.WithTimeout(c.s.ctx, time.Duration(c.s.timeout.Load()))
+	defer cancel()
+	return cmd.handler(c, ctx, name, args[1:])
+}
+
+// locate 는 Redis 의 key 를 bucket 과 bucket 안의 key 로 나눈다.
+func (c *conn) locate(key []byte) ([]byte, []byte, error) {
+	if !c.s.cfg.prefixMapping() {
+		return c.s.cfg.database(c.db), key, nil
+	}
+	separator := c.s.cfg.separator()
+	i := bytes.Index(key, separator)
+	if i <= 0 || i+len(separator) == len(key) {
+		return nil, nil, replyError("ERR key must be <bucket>" + string(separator) + "<key> : " + string(key))
+	}
+	return key[:i], key[i+len(separator):], nil
+}
+
+// bucketName 은 bucket 을 저장소의 bucket 이름으로 바꾸고 principal 이 그 이름에 권한을 가지고 있는지 확인한다.
+// tenancy 가 켜져 있으면 tenant 를 붙인 이름으로 확인하므로 ACL 은 tenant 마다 따로 준다.
+func (c *conn) bucketName(name string, bucket []byte, permission auth.Permission) ([]byte, error) {
+	bucketName := bucket
+	if c.tenantID != "" && c.s.tenants.Enabled() {
+		bucketName = tenant.Bucket(c.tenantID, bucket)
+	}
+	if c.s.auth.Enabled() {
+		if err := c.s.auth.Authorize(c.principal, string(bucketName), permission, "resp "+name); err != nil {
+			return nil, replyError("NOPERM " + err.Error())
+		}
+	}
+	return bucketName, nil
+}
+
+// target 은 locate 와 bucketName 을 함께 한다.
+func (c *conn) target(name string, key []byte, permission auth.Permission) ([]byte, []byte, error) {
+	bucket, k, err := c.locate(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	bucketName, err := c.bucketName(name, bucket, permission)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bucketName, k, nil
+}
+
+type metrics struct {
+	commands    *prometheus.CounterVec
+	connections prometheus.Gauge
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	factory := promauto.With(reg)
+	return &metrics{
+		commands: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbolt_resp_commands_total",
+			Help: "Total number of commands received by the RESP server.",
+		}, []string{"command", "result"}),
+		connections: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "dbolt_resp_connections",
+			Help: "Number of open RESP connections.",
+		}),
+	}
+}
@@ -0,0 +1,108 @@
+package respserver
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth"
+	"github.com/kwSeo/dbolt/pkg/dbolt/auth/authtest"
+	"github.com/kwSeo/dbolt/pkg/dbolt/decommission"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor/distributortest"
+	"github.com/kwSeo/dbolt/pkg/dbolt/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestServer(t *testing.T, cfg *Config, authCfg *auth.Config, tenantCfg *tenant.Config) *Server {
+	t.Helper()
+	cluster := distributortest.New(t, &distributor.Config{})
+	reg := prometheus.NewRegistry()
+	authService, err := auth.New(authCfg, zap.NewNop())
+	require.NoError(t, err)
+	tenants := tenant.New(tenantCfg, tenant.NewQuotas(tenantCfg, tenant.NewOverrides(tenantCfg), reg), cluster.Ring, cluster.StorePool, cluster.LocalStore, reg, zap.NewNop())
+	decommissioner := decommission.New(&decommission.Config{}, nil, nil, nil, nil, reg, zap.NewNop())
+	s := New(cfg, "", nil, cluster.Distributor, tenants, authService, decommissioner, reg, zap.NewNop())
+	t.Cleanup(s.cancel)
+	return s
+}
+
+// client 는 net.Pipe 로 Server 의 conn 하나와 연결된 client 이다. 명령은 inline 으로 보낸다.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (s *Server) dial(t *testing.T) *client {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	c := s.newConn(serverConn)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.serve()
+		_ = serverConn.Close()
+	}()
+	t.Cleanup(func() {
+		_ = clientConn.Close()
+		<-done
+	})
+	require.NoError(t, clientConn.SetDeadline(time.Now().Add(10*time.Second)))
+	return &client{t: t, conn: clientConn, r: bufio.NewReader(clientConn)}
+}
+
+// do 는 명령을 보내고 응답의 첫 줄을 반환한다. bulk string 이면 값을 반환한다.
+func (c *client) do(command string) string {
+	c.t.Helper()
+	_, err := c.conn.Write([]byte(command + "\r\n"))
+	require.NoError(c.t, err)
+	line, err := c.r.ReadString('\n')
+	require.NoError(c.t, err)
+	line = strings.TrimSuffix(line, "\r\n")
+	if strings.HasPrefix(line, "$") && line != "$-1" {
+		line, err = c.r.ReadString('\n')
+		require.NoError(c.t, err)
+		line = strings.TrimSuffix(line, "\r\n")
+	}
+	return line
+}
+
+func TestTenantAuthorization(t *testing.T) {
+	s := newTestServer(t, &Config{Enabled: true}, authtest.Config(), &tenant.Config{Enabled: true})
+
+	c := s.dial(t)
+	require.True(t, strings.HasPrefix(c.do("SET k v"), "-NOAUTH"))
+	require.True(t, strings.HasPrefix(c.do("AUTH "+authtest.Tenant+" invalid"), "-WRONGPASS"))
+
+	// tenant 가 없는 principal 은 AUTH 로 tenant 를 고르지만 그 tenant 의 bucket 에 권한이 있어야 한다.
+	require.Equal(t, "+OK", c.do("AUTH "+authtest.Tenant+" "+authtest.UnboundToken))
+	require.Equal(t, "+OK", c.do("SET k unbound"))
+	require.Equal(t, "+OK", c.do("AUTH "+authtest.OtherTenant+" "+authtest.UnboundToken))
+	require.True(t, strings.HasPrefix(c.do("SET k v"), "-NOPERM"))
+	require.True(t, strings.HasPrefix(c.do("GET k"), "-NOPERM"))
+
+	// tenant 에 묶인 principal 은 AUTH 의 tenant 대신 자기 tenant 를 사용한다.
+	bound := s.dial(t)
+	require.Equal(t, "+OK", bound.do("AUTH "+authtest.OtherTenant+" "+authtest.BoundToken))
+	require.Equal(t, "unbound", bound.do("GET k"))
+	require.Equal(t, "+OK", bound.do("SET k bound"))
+
+	admin := s.dial(t)
+	require.Equal(t, "+OK", admin.do("AUTH "+authtest.OtherTenant+" "+authtest.AdminToken))
+	require.Equal(t, "$-1", admin.do("GET k"))
+	require.Equal(t, "+OK", admin.do("SET k admin"))
+
+	ctx := context.Background()
+	value, err := s.dist.Get(ctx, tenant.Bucket(authtest.Tenant, []byte("db0")), []byte("k"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("bound"), value)
+	value, err = s.dist.Get(ctx, tenant.Bucket(authtest.OtherTenant, []byte("db0")), []byte("k"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("admin"), value)
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// Buckets 는 key 가 하나 이상 있는 bucket 의 이름을 반환한다. 내부 bucket 은 제외한다.
+// key 를 모두 지운 bucket 은 bolt 에 남아 있어도 반환하지 않는다.
+func (ls *LocalStore) Buckets(ctx context.Context) ([][]byte, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var bucketNames [][]byte
+	err := ls.view(func(tx Tx) error {
+		return tx.ForEach(func(bucketName []byte, bucket Bucket) error {
+			if IsInternalBucket(bucketName) {
+				return nil
+			}
+			if key, _ := bucket.Cursor().First(); key == nil {
+				return nil
+			}
+			bucketNames = append(bucketNames, append([]byte(nil), bucketName...))
+			return nil
+		})
+	})
+	return bucketNames, err
+}
+
+// Buckets 는 원격 인스턴스의 bucket 이름을 LocalStore.Buckets 와 같은 방식으로 읽는다.
+func (hs *HTTPStore) Buckets(ctx context.Context) ([][]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hs.baseUrl+"/v1/internal/buckets", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status of buckets : baseUrl=%s status=%d", hs.baseUrl, resp.StatusCode)
+	}
+	var bucketNames [][]byte
+	if err := json.NewDecoder(resp.Body).Decode(&bucketNames); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the bucket names")
+	}
+	return bucketNames, nil
+}
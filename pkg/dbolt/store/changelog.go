@@ -0,0 +1,446 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// internalBucketPrefix 로 시작하는 bucket 은 LocalStore 가 내부적으로 사용하며 사용자 데이터로 쓰거나 복원하지 않는다.
+const internalBucketPrefix = "__dbolt_"
+
+// ChangeLogBucket 은 이 노드에 적용된 변경을 revision 순서로 기록하는 append-only bucket 이다.
+// key 는 big-endian revision 이고 값은 아래의 형식이며 다른 값과 마찬가지로 암호화된다.
+//
+//	format(1) | committedAt(8) | type(1) | bucketLen(uvarint) | bucket | keyLen(uvarint) | key | value
+//
+// committedAt 은 big-endian Unix nano 이다. 처음 버전은 format 과 committedAt 없이 type 부터 기록했으므로
+// 첫 byte 가 changeFormatV2 가 아니면 처음 버전으로 읽는다.
+var ChangeLogBucket = []byte(internalBucketPrefix + "changelog")
+
+const changeFormatV2 byte = 0xC2
+
+const (
+	// changeLogReadSize 는 한 번의 읽기 트랜잭션에서 가져오는 변경의 최대 개수이다.
+	// 느린 구독자 때문에 읽기 트랜잭션이 오래 열려 있지 않도록 트랜잭션 밖에서 전달한다.
+	changeLogReadSize = 1000
+	// changeLogTrimInterval 마다 MaxAge 보다 오래된 변경을 지운다.
+	changeLogTrimInterval = time.Minute
+)
+
+var (
+	ErrInternalBucket    = errors.New("bucket name is reserved")
+	ErrRevisionCompacted = errors.New("requested revision has been compacted")
+)
+
+func IsInternalBucket(bucketName []byte) bool {
+	return bytes.HasPrefix(bucketName, []byte(internalBucketPrefix))
+}
+
+type ChangeType byte
+
+const (
+	ChangePut ChangeType = iota + 1
+	ChangeDelete
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangePut:
+		return "put"
+	case ChangeDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+type ChangeEvent struct {
+	Revision   uint64     `json:"revision"`
+	Type       ChangeType `json:"type"`
+	BucketName []byte     `json:"bucketName"`
+	Key        []byte     `json:"key"`
+	// Value 는 저장된 값이며 ChangeDelete 이면 nil 이다.
+	Value []byte `json:"value,omitempty"`
+	// CommittedAt 은 이 노드에서 변경이 기록된 시각이다.
+	CommittedAt time.Time `json:"committedAt"`
+}
+
+// WatchRequest 는 bucket 안의 key 하나 또는 prefix 에 대한 변경을 StartRevision 부터 받기 위한 조건이다.
+// Prefix 가 true 이고 Key 가 비어 있으면 bucket 의 모든 변경을 받는다.
+// StartRevision 이 0 이면 현재 이후의 변경부터 받는다.
+type WatchRequest struct {
+	BucketName    []byte
+	Key           []byte
+	Prefix        bool
+	StartRevision uint64
+}
+
+func (r *WatchRequest) matches(event *ChangeEvent) bool {
+	if !bytes.Equal(r.BucketName, event.BucketName) {
+		return false
+	}
+	if r.Prefix {
+		return bytes.HasPrefix(event.Key, r.Key)
+	}
+	return bytes.Equal(r.Key, event.Key)
+}
+
+type ChangeLogConfig struct {
+	// MaxEntries 는 보관하는 변경의 최대 개수이며 0 이면 개수로 지우지 않는다.
+	MaxEntries uint64 `yaml:"max_entries"`
+	// MaxAge 보다 오래된 변경은 주기적으로 지우며 0 이면 기간으로 지우지 않는다.
+	// revision 이 이어지도록 마지막 변경은 지우지 않는다.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// changeNotifier 는 새 변경이 commit 될 때마다 기다리는 watcher 들을 깨운다.
+type changeNotifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newChangeNotifier() *changeNotifier {
+	return &changeNotifier{ch: make(chan struct{})}
+}
+
+func (n *changeNotifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.ch
+}
+
+func (n *changeNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
+
+func revisionKey(revision uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, revision)
+}
+
+func marshalChange(event *ChangeEvent) []byte {
+	marshaled := make([]byte, 0, 10+2*binary.MaxVarintLen64+len(event.BucketName)+len(event.Key)+len(event.Value))
+	marshaled = append(marshaled, changeFormatV2)
+	marshaled = binary.BigEndian.AppendUint64(marshaled, uint64(event.CommittedAt.UnixNano()))
+	marshaled = append(marshaled, byte(event.Type))
+	marshaled = binary.AppendUvarint(marshaled, uint64(len(event.BucketName)))
+	marshaled = append(marshaled, event.BucketName...)
+	marshaled = binary.AppendUvarint(marshaled, uint64(len(event.Key)))
+	marshaled = append(marshaled, event.Key...)
+	return append(marshaled, event.Value...)
+}
+
+func unmarshalChange(revision uint64, marshaled []byte) (*ChangeEvent, error) {
+	event := &ChangeEvent{Revision: revision}
+	if len(marshaled) > 0 && marshaled[0] == changeFormatV2 {
+		if len(marshaled) < 9 {
+			return nil, errors.New("failed to unmarshal the change: too short")
+		}
+		event.CommittedAt = time.Unix(0, int64(binary.BigEndian.Uint64(marshaled[1:9])))
+		marshaled = marshaled[9:]
+	}
+	if len(marshaled) < 1 {
+		return nil, errors.New("failed to unmarshal the change: too short")
+	}
+	event.Type = ChangeType(marshaled[0])
+	rest := marshaled[1:]
+	var fields [2][]byte
+	for i := range fields {
+		n, read := binary.Uvarint(rest)
+		if read <= 0 || uint64(len(rest)-read) < n {
+			return nil, errors.New("failed to unmarshal the change: too short")
+		}
+		fields[i] = rest[read : read+int(n)]
+		rest = rest[read+int(n):]
+	}
+	event.BucketName, event.Key = fields[0], fields[1]
+	if event.Type == ChangePut {
+		event.Value = rest
+	}
+	return event, nil
+}
+
+// appendChange 는 쓰기 트랜잭션 안에서 변경을 기록하고 보관 개수를 넘은 오래된 변경을 지운다.
+// 반드시 데이터를 수정한 트랜잭션과 같은 트랜잭션에서 호출해야 한다.
+func (ls *LocalStore) appendChange(ctx context.Context, tx Tx, event *ChangeEvent) error {
+	bucket, err := tx.CreateBucketIfNotExists(ChangeLogBucket)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the change log bucket")
+	}
+	cursor := bucket.Cursor()
+	event.Revision = 1
+	event.CommittedAt = time.Now()
+	if last, _ := cursor.Last(); last != nil {
+		event.Revision = binary.BigEndian.Uint64(last) + 1
+	}
+
+	key := revisionKey(event.Revision)
+	value, err := ls.encryptor.Encrypt(ctx, ChangeLogBucket, key, marshalChange(event))
+	if err != nil {
+		return errors.Wrap(err, "failed to encrypt the change")
+	}
+	if err := bucket.Put(key, value); err != nil {
+		return errors.Wrapf(err, "failed to append the change : revision=%d", event.Revision)
+	}
+	ls.bufferIfCompacting(ChangeLogBucket, key, value)
+
+	if ls.changeLogCfg.MaxEntries == 0 || event.Revision <= ls.changeLogCfg.MaxEntries {
+		return nil
+	}
+	oldest := event.Revision - ls.changeLogCfg.MaxEntries
+	for first, _ := bucket.Cursor().First(); first != nil && binary.BigEndian.Uint64(first) <= oldest; first, _ = bucket.Cursor().First() {
+		first = append([]byte(nil), first...)
+		if err := bucket.Delete(first); err != nil {
+			return errors.Wrap(err, "failed to trim the change log")
+		}
+		ls.bufferDeleteIfCompacting(ChangeLogBucket, first)
+	}
+	return nil
+}
+
+// Revision 은 이 노드에 마지막으로 기록된 변경의 revision 이다.
+func (ls *LocalStore) Revision() (uint64, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var revision uint64
+	err := ls.view(func(tx Tx) error {
+		bucket := tx.Bucket(ChangeLogBucket)
+		if bucket == nil {
+			return nil
+		}
+		if last, _ := bucket.Cursor().Last(); last != nil {
+			revision = binary.BigEndian.Uint64(last)
+		}
+		return nil
+	})
+	return revision, err
+}
+
+// Watch 는 조건에 맞는 변경을 revision 순서로 전달하는 channel 을 반환한다.
+// ctx 가 끝나거나 에러가 나면 channel 이 닫히며, 에러는 errc 로 전달된다.
+// 구독자가 느려서 요청한 revision 이 이미 지워졌다면 ErrRevisionCompacted 로 끝난다.
+func (ls *LocalStore) Watch(ctx context.Context, req WatchRequest) (<-chan *ChangeEvent, <-chan error) {
+	events := make(chan *ChangeEvent)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(events)
+		if err := ls.watch(ctx, req, events); err != nil && ctx.Err() == nil {
+			errc <- err
+		}
+		close(errc)
+	}()
+	return events, errc
+}
+
+func (ls *LocalStore) watch(ctx context.Context, req WatchRequest, events chan<- *ChangeEvent) error {
+	next := req.StartRevision
+	if next == 0 {
+		revision, err := ls.Revision()
+		if err != nil {
+			return err
+		}
+		next = revision + 1
+	}
+
+	for {
+		// 읽기 전에 기다릴 channel 을 받아 두어야 읽는 도중에 commit 된 변경을 놓치지 않는다.
+		changed := ls.changes.wait()
+		for {
+			changes, more, err := ls.readChanges(ctx, next, changeLogReadSize)
+			if err != nil {
+				return err
+			}
+			for _, event := range changes {
+				next = event.Revision + 1
+				if !req.matches(event) {
+					continue
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if !more {
+				break
+			}
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Changes 는 since 다음 revision 부터 최대 limit 개의 변경을 반환한다.
+// since 가 0 이면 남아 있는 가장 오래된 변경부터 반환하고, since 다음 revision 이 이미 지워졌다면 ErrRevisionCompacted 를 반환한다.
+func (ls *LocalStore) Changes(ctx context.Context, since uint64, limit int) ([]*ChangeEvent, error) {
+	if limit <= 0 || limit > changeLogReadSize {
+		limit = changeLogReadSize
+	}
+	from := since + 1
+	if since == 0 {
+		from = 0
+	}
+	changes, _, err := ls.readChanges(ctx, from, limit)
+	return changes, err
+}
+
+// readChanges 는 from 부터 최대 limit 개의 변경을 읽고, 더 읽을 변경이 남았는지 함께 반환한다.
+// from 이 0 이면 남아 있는 가장 오래된 변경부터 읽는다.
+func (ls *LocalStore) readChanges(ctx context.Context, from uint64, limit int) ([]*ChangeEvent, bool, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var changes []*ChangeEvent
+	more := false
+	err := ls.view(func(tx Tx) error {
+		bucket := tx.Bucket(ChangeLogBucket)
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		if first, _ := cursor.First(); first != nil && from > 0 && from < binary.BigEndian.Uint64(first) {
+			return errors.Wrapf(ErrRevisionCompacted, "revision=%d oldest=%d", from, binary.BigEndian.Uint64(first))
+		}
+		for key, value := cursor.Seek(revisionKey(from)); key != nil; key, value = cursor.Next() {
+			if len(changes) == limit {
+				more = true
+				break
+			}
+			decrypted, err := ls.encryptor.Decrypt(ctx, ChangeLogBucket, key, value)
+			if err != nil {
+				return err
+			}
+			// bolt 의 값은 트랜잭션 안에서만 유효하므로 복사한다.
+			event, err := unmarshalChange(binary.BigEndian.Uint64(key), append([]byte(nil), decrypted...))
+			if err != nil {
+				return err
+			}
+			changes = append(changes, event)
+		}
+		return nil
+	})
+	return changes, more, err
+}
+
+// TrimChangeLog 는 before 보다 먼저 기록된 변경을 지우고 지운 개수를 반환한다. 마지막 변경은 남긴다.
+func (ls *LocalStore) TrimChangeLog(ctx context.Context, before time.Time) (int, error) {
+	trimmed := 0
+	for {
+		n, more, err := ls.trimChangeLogBatch(ctx, before)
+		trimmed += n
+		if err != nil || !more {
+			return trimmed, err
+		}
+		if err := ctx.Err(); err != nil {
+			return trimmed, err
+		}
+	}
+}
+
+func (ls *LocalStore) trimChangeLogBatch(ctx context.Context, before time.Time) (int, bool, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	trimmed, more := 0, false
+	err := ls.update(func(tx Tx) error {
+		bucket := tx.Bucket(ChangeLogBucket)
+		if bucket == nil {
+			return nil
+		}
+		last, _ := bucket.Cursor().Last()
+		var expired [][]byte
+		cursor := bucket.Cursor()
+		for key, value := cursor.First(); key != nil && !bytes.Equal(key, last); key, value = cursor.Next() {
+			if len(expired) == changeLogReadSize {
+				more = true
+				break
+			}
+			decrypted, err := ls.encryptor.Decrypt(ctx, ChangeLogBucket, key, value)
+			if err != nil {
+				return err
+			}
+			event, err := unmarshalChange(binary.BigEndian.Uint64(key), decrypted)
+			if err != nil {
+				return err
+			}
+			// 처음 버전의 변경은 기록된 시각이 없으므로 오래된 것으로 본다.
+			if !event.CommittedAt.IsZero() && !event.CommittedAt.Before(before) {
+				break
+			}
+			expired = append(expired, append([]byte(nil), key...))
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return errors.Wrap(err, "failed to trim the change log")
+			}
+			ls.bufferDeleteIfCompacting(ChangeLogBucket, key)
+		}
+		trimmed = len(expired)
+		return nil
+	})
+	if err != nil {
+		ls.invalidateBuffer()
+		return 0, false, err
+	}
+	return trimmed, more, nil
+}
+
+// ChangeLogTrimmer 는 ChangeLogConfig.MaxAge 보다 오래된 변경을 주기적으로 지운다.
+type ChangeLogTrimmer struct {
+	cfg      *ChangeLogConfig
+	store    *LocalStore
+	logger   *zap.Logger
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewChangeLogTrimmer(cfg *ChangeLogConfig, store *LocalStore, logger *zap.Logger) *ChangeLogTrimmer {
+	return &ChangeLogTrimmer{
+		cfg:    cfg,
+		store:  store,
+		logger: logger,
+		stop:   make(chan struct{}),
+	}
+}
+
+func (t *ChangeLogTrimmer) Start(ctx context.Context) error {
+	if t.cfg.MaxAge <= 0 {
+		return nil
+	}
+	t.logger.Info("Starting change log retention.", zap.Duration("maxAge", t.cfg.MaxAge))
+	go func() {
+		ticker := time.NewTicker(changeLogTrimInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				trimmed, err := t.store.TrimChangeLog(context.Background(), time.Now().Add(-t.cfg.MaxAge))
+				if err != nil {
+					t.logger.Error("Failed to trim the change log.", zap.Error(err))
+				} else if trimmed > 0 {
+					t.logger.Debug("Trimmed the change log.", zap.Int("trimmed", trimmed))
+				}
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop 은 여러 번 호출해도 된다.
+func (t *ChangeLogTrimmer) Stop(ctx context.Context) error {
+	t.stopOnce.Do(func() { close(t.stop) })
+	return nil
+}
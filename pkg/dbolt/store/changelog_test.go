@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestChangeLogTrimmerStopTwice(t *testing.T) {
+	trimmer := NewChangeLogTrimmer(&ChangeLogConfig{MaxAge: time.Hour}, nil, zap.NewNop())
+	require.NoError(t, trimmer.Start(context.Background()))
+	require.NoError(t, trimmer.Stop(context.Background()))
+	require.NoError(t, trimmer.Stop(context.Background()))
+}
+
+func TestUnmarshalChange(t *testing.T) {
+	committedAt := time.Unix(0, time.Now().UnixNano())
+	put := &ChangeEvent{Type: ChangePut, BucketName: []byte("bucket"), Key: []byte("key"), Value: []byte("value"), CommittedAt: committedAt}
+	event, err := unmarshalChange(7, marshalChange(put))
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), event.Revision)
+	require.Equal(t, ChangePut, event.Type)
+	require.Equal(t, []byte("bucket"), event.BucketName)
+	require.Equal(t, []byte("key"), event.Key)
+	require.Equal(t, []byte("value"), event.Value)
+	require.True(t, committedAt.Equal(event.CommittedAt))
+
+	del := &ChangeEvent{Type: ChangeDelete, BucketName: []byte("bucket"), Key: []byte("key"), CommittedAt: committedAt}
+	event, err = unmarshalChange(8, marshalChange(del))
+	require.NoError(t, err)
+	require.Equal(t, ChangeDelete, event.Type)
+	require.Equal(t, []byte("key"), event.Key)
+	require.Nil(t, event.Value)
+}
+
+func TestUnmarshalLegacyChange(t *testing.T) {
+	// 처음 버전은 format 과 committedAt 없이 type 부터 기록했다.
+	legacy := []byte{byte(ChangePut), 6}
+	legacy = append(legacy, "bucket"...)
+	legacy = append(legacy, 3)
+	legacy = append(legacy, "key"...)
+	legacy = append(legacy, "value"...)
+
+	event, err := unmarshalChange(1, legacy)
+	require.NoError(t, err)
+	require.Equal(t, ChangePut, event.Type)
+	require.Equal(t, []byte("bucket"), event.BucketName)
+	require.Equal(t, []byte("key"), event.Key)
+	require.Equal(t, []byte("value"), event.Value)
+	require.True(t, event.CommittedAt.IsZero())
+
+	legacy = append([]byte{byte(ChangeDelete), 6}, "bucket\x03key"...)
+	event, err = unmarshalChange(2, legacy)
+	require.NoError(t, err)
+	require.Equal(t, ChangeDelete, event.Type)
+	require.Equal(t, []byte("key"), event.Key)
+	require.Nil(t, event.Value)
+}
+
+func TestUnmarshalTruncatedChange(t *testing.T) {
+	marshaled := marshalChange(&ChangeEvent{Type: ChangePut, BucketName: []byte("bucket"), Key: []byte("key"), CommittedAt: time.Now()})
+	for _, truncated := range [][]byte{nil, marshaled[:1], marshaled[:9], marshaled[:12], {byte(ChangePut), 6, 'b'}} {
+		_, err := unmarshalChange(1, truncated)
+		require.Error(t, err, "marshaled=%x", truncated)
+	}
+}
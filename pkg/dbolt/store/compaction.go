@@ -0,0 +1,378 @@
+package store
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrCompactionInProgress  = errors.New("compaction already in progress")
+	ErrCompactionUnsupported = errors.New("compaction is not supported by the storage engine")
+)
+
+type pendingWrite struct {
+	bucketName []byte
+	key        []byte
+	value      []byte
+	delete     bool
+}
+
+type CompactionResult struct {
+	StartedAt  time.Time     `json:"startedAt"`
+	Duration   time.Duration `json:"duration"`
+	SizeBefore int64         `json:"sizeBefore"`
+	SizeAfter  int64         `json:"sizeAfter"`
+	Keys       int           `json:"keys"`
+	Buffered   int           `json:"buffered"`
+	Error      string        `json:"error,omitempty"`
+}
+
+func (ls *LocalStore) bufferIfCompacting(bucketName, key, value []byte) {
+	ls.bufferPendingWrite(pendingWrite{bucketName: bucketName, key: key, value: value})
+}
+
+func (ls *LocalStore) bufferDeleteIfCompacting(bucketName, key []byte) {
+	ls.bufferPendingWrite(pendingWrite{bucketName: bucketName, key: key, delete: true})
+}
+
+func (ls *LocalStore) bufferPendingWrite(op pendingWrite) {
+	ls.pendingMu.Lock()
+	defer ls.pendingMu.Unlock()
+	if !ls.buffering {
+		return
+	}
+	ls.pendingOps = append(ls.pendingOps, pendingWrite{
+		bucketName: append([]byte(nil), op.bucketName...),
+		key:        append([]byte(nil), op.key...),
+		value:      append([]byte(nil), op.value...),
+		delete:     op.delete,
+	})
+}
+
+// invalidateBuffer 는 commit 에 실패한 쓰기가 버퍼에 남았을 수 있으므로 진행 중인 compaction 을 무효화한다.
+func (ls *LocalStore) invalidateBuffer() {
+	ls.pendingMu.Lock()
+	defer ls.pendingMu.Unlock()
+	if ls.buffering {
+		ls.pendingBroken = true
+	}
+}
+
+func (ls *LocalStore) setBuffering(buffering bool) {
+	ls.pendingMu.Lock()
+	defer ls.pendingMu.Unlock()
+	ls.buffering = buffering
+	ls.pendingBroken = false
+	ls.pendingOps = nil
+}
+
+// Compact 는 살아있는 데이터만 새 bolt 파일로 복사한 뒤 기존 파일과 원자적으로 교체한다.
+// 복사하는 동안 들어온 쓰기는 기존 파일에 기록되면서 메모리에 버퍼링되고, 교체 직전에 새 파일에 다시 적용된다.
+// 교체하는 짧은 순간에만 읽기와 쓰기가 대기한다.
+func (ls *LocalStore) Compact(ctx context.Context) (*CompactionResult, error) {
+	if !ls.compactMu.TryLock() {
+		return nil, ErrCompactionInProgress
+	}
+	defer ls.compactMu.Unlock()
+
+	result := &CompactionResult{StartedAt: time.Now()}
+
+	ls.mu.RLock()
+	src := ls.db
+	ls.mu.RUnlock()
+	path := src.Path()
+	if path == "" {
+		return nil, ErrCompactionUnsupported
+	}
+	tmpPath := path + ".compact"
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat the bolt file")
+	}
+	result.SizeBefore = info.Size()
+
+	_ = os.Remove(tmpPath)
+	dst, err := OpenEngine(src.Name(), tmpPath, info.Mode(), ls.engineOptions())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the compacted bolt file")
+	}
+
+	// 쓰기는 트랜잭션 안에서 버퍼링 여부를 정하고 commit 할 때까지 mu 를 읽기로 잡고 있다.
+	// mu 를 잡은 채로 버퍼링을 켜고 복사할 읽기 트랜잭션을 열어야 모든 쓰기가 복사본이나 버퍼 중 하나에는 들어간다.
+	ls.mu.Lock()
+	ls.setBuffering(true)
+	srcTx, err := src.Begin(false)
+	ls.mu.Unlock()
+	defer ls.setBuffering(false)
+	if err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmpPath)
+		return nil, errors.Wrap(err, "failed to begin the read transaction to copy")
+	}
+
+	ls.logger.Info("Copying live data into a new bolt file.", zap.String("path", tmpPath))
+	keys, err := copyTx(ctx, dst, srcTx)
+	_ = srcTx.Rollback()
+	if err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+	result.Keys = keys
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.pendingMu.Lock()
+	pending, broken := ls.pendingOps, ls.pendingBroken
+	ls.pendingMu.Unlock()
+	if broken {
+		_ = dst.Close()
+		_ = os.Remove(tmpPath)
+		return nil, errors.New("a write failed during compaction, aborted")
+	}
+	if err := replayPendingWrites(dst, pending); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+	result.Buffered = len(pending)
+
+	if err := dst.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to close the compacted bolt file")
+	}
+	if err := src.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to close the bolt file")
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		// 교체에 실패하면 기존 파일을 다시 연다.
+		ls.reopen(src, path, info.Mode())
+		return nil, errors.Wrap(err, "failed to swap the bolt file")
+	}
+	if err := ls.reopen(src, path, info.Mode()); err != nil {
+		return nil, errors.Wrap(err, "failed to reopen the compacted bolt file")
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		result.SizeAfter = info.Size()
+	}
+	result.Duration = time.Since(result.StartedAt)
+	return result, nil
+}
+
+// reopen 은 닫은 src 대신 path 의 파일을 연다. 열지 못하면 모든 트랜잭션이 실패하는 엔진으로 바꿔서
+// 이후의 요청이 nil 엔진에서 panic 하지 않고 오류를 반환하며 readiness 가 실패하게 한다. 호출하는 쪽에서 mu 를 잡고 있어야 한다.
+func (ls *LocalStore) reopen(src Engine, path string, mode os.FileMode) error {
+	db, err := OpenEngine(src.Name(), path, mode, ls.engineOptions())
+	if err != nil {
+		ls.logger.Error("Failed to reopen the bolt file. The store is unavailable until restarted.", zap.String("path", path), zap.Error(err))
+		ls.db = &failedEngine{name: src.Name(), path: path, err: errors.Wrap(err, "failed to reopen the bolt file")}
+		return err
+	}
+	ls.db = db
+	return nil
+}
+
+// failedEngine 은 compaction 뒤에 파일을 다시 열지 못한 엔진이다.
+type failedEngine struct {
+	name string
+	path string
+	err  error
+}
+
+func (e *failedEngine) Name() string                      { return e.name }
+func (e *failedEngine) Path() string                      { return e.path }
+func (e *failedEngine) Begin(writable bool) (Tx, error)   { return nil, e.err }
+func (e *failedEngine) View(fn func(tx Tx) error) error   { return e.err }
+func (e *failedEngine) Update(fn func(tx Tx) error) error { return e.err }
+func (e *failedEngine) Stats() EngineStats                { return EngineStats{} }
+func (e *failedEngine) Close() error                      { return nil }
+
+func (ls *LocalStore) engineOptions() *EngineOptions {
+	if ls.options == nil {
+		return &EngineOptions{}
+	}
+	return ls.options
+}
+
+func replayPendingWrites(dst Engine, pending []pendingWrite) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	return dst.Update(func(tx Tx) error {
+		for _, op := range pending {
+			bucket, err := tx.CreateBucketIfNotExists(op.bucketName)
+			if err != nil {
+				return err
+			}
+			if op.delete {
+				if err := bucket.Delete(op.key); err != nil {
+					return errors.Wrapf(err, "failed to replay buffered delete : key=%s", string(op.key))
+				}
+				continue
+			}
+			if err := bucket.Put(op.key, op.value); err != nil {
+				return errors.Wrapf(err, "failed to replay buffered write : key=%s", string(op.key))
+			}
+		}
+		return nil
+	})
+}
+
+type CompactionConfig struct {
+	// Interval 이 0 이면 주기적인 compaction 을 하지 않고 admin API 로만 수행한다.
+	Interval time.Duration `yaml:"interval"`
+}
+
+type compactionMetrics struct {
+	compactions   *prometheus.CounterVec
+	duration      prometheus.Histogram
+	fileSize      prometheus.Gauge
+	reclaimed     prometheus.Gauge
+	lastSucceeded prometheus.Gauge
+}
+
+func newCompactionMetrics(reg prometheus.Registerer) *compactionMetrics {
+	factory := promauto.With(reg)
+	return &compactionMetrics{
+		compactions: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbolt_compactions_total",
+			Help: "Total number of bolt file compactions.",
+		}, []string{"result"}),
+		duration: factory.NewHistogram(prometheus.HistogramOpts{
+			Name:    "dbolt_compaction_duration_seconds",
+			Help:    "Time taken to compact the bolt file.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 4, 8),
+		}),
+		fileSize: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "dbolt_bolt_file_size_bytes",
+			Help: "Size of the bolt file after the last compaction.",
+		}),
+		reclaimed: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "dbolt_compaction_reclaimed_bytes",
+			Help: "Bytes given back to the filesystem by the last compaction.",
+		}),
+		lastSucceeded: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "dbolt_compaction_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful compaction.",
+		}),
+	}
+}
+
+// Compactor 는 LocalStore 의 compaction 을 주기적으로 또는 요청에 따라 수행하고 결과를 기록한다.
+type Compactor struct {
+	cfg     *CompactionConfig
+	store   *LocalStore
+	metrics *compactionMetrics
+	logger  *zap.Logger
+
+	mu         sync.Mutex
+	running    bool
+	lastResult *CompactionResult
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+func NewCompactor(cfg *CompactionConfig, store *LocalStore, reg prometheus.Registerer, logger *zap.Logger) *Compactor {
+	return &Compactor{
+		cfg:     cfg,
+		store:   store,
+		metrics: newCompactionMetrics(reg),
+		logger:  logger,
+		stop:    make(chan struct{}),
+	}
+}
+
+func (c *Compactor) Start(ctx context.Context) error {
+	if c.cfg.Interval <= 0 {
+		return nil
+	}
+	c.logger.Info("Starting scheduled compaction.", zap.Duration("interval", c.cfg.Interval))
+	go func() {
+		ticker := time.NewTicker(c.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := c.Compact(context.Background()); err != nil {
+					c.logger.Error("Scheduled compaction failed.", zap.Error(err))
+				}
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop 은 여러 번 호출해도 된다.
+func (c *Compactor) Stop(ctx context.Context) error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	return nil
+}
+
+func (c *Compactor) Compact(ctx context.Context) (*CompactionResult, error) {
+	c.setRunning(true)
+	defer c.setRunning(false)
+
+	c.logger.Info("Compacting the bolt file.")
+	result, err := c.store.Compact(ctx)
+	if errors.Is(err, ErrCompactionInProgress) || errors.Is(err, ErrCompactionUnsupported) {
+		return nil, err
+	}
+	if err != nil {
+		c.metrics.compactions.WithLabelValues("failure").Inc()
+		c.setLastResult(&CompactionResult{StartedAt: time.Now(), Error: err.Error()})
+		return nil, err
+	}
+
+	c.metrics.compactions.WithLabelValues("success").Inc()
+	c.metrics.duration.Observe(result.Duration.Seconds())
+	c.metrics.fileSize.Set(float64(result.SizeAfter))
+	c.metrics.reclaimed.Set(float64(result.SizeBefore - result.SizeAfter))
+	c.metrics.lastSucceeded.SetToCurrentTime()
+	c.setLastResult(result)
+	c.logger.Info("Compacted the bolt file.",
+		zap.Int64("sizeBefore", result.SizeBefore),
+		zap.Int64("sizeAfter", result.SizeAfter),
+		zap.Int("keys", result.Keys),
+		zap.Int("buffered", result.Buffered),
+		zap.Duration("duration", result.Duration))
+	return result, nil
+}
+
+type CompactionStatus struct {
+	Running    bool              `json:"running"`
+	Interval   time.Duration     `json:"interval"`
+	LastResult *CompactionResult `json:"lastResult,omitempty"`
+}
+
+func (c *Compactor) Status() CompactionStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CompactionStatus{
+		Running:    c.running,
+		Interval:   c.cfg.Interval,
+		LastResult: c.lastResult,
+	}
+}
+
+func (c *Compactor) setRunning(running bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = running
+}
+
+func (c *Compactor) setLastResult(result *CompactionResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastResult = result
+}
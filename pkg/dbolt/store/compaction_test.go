@@ -0,0 +1,172 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCompactorStopTwice(t *testing.T) {
+	c := NewCompactor(&CompactionConfig{Interval: time.Hour}, nil, prometheus.NewRegistry(), zap.NewNop())
+	require.NoError(t, c.Start(context.Background()))
+	require.NoError(t, c.Stop(context.Background()))
+	require.NoError(t, c.Stop(context.Background()))
+}
+
+func openTestStore(t *testing.T, engine, path string) *LocalStore {
+	t.Helper()
+	ls, err := Open(&ChangeLogConfig{}, engine, path, 0o600, nil, NewEncryptor(nil), nil, zap.NewNop())
+	require.NoError(t, err)
+	return ls
+}
+
+func TestCompactUnsupported(t *testing.T) {
+	ls := openTestStore(t, EngineMemory, "")
+	_, err := ls.Compact(context.Background())
+	require.ErrorIs(t, err, ErrCompactionUnsupported)
+}
+
+func TestBufferPendingWrites(t *testing.T) {
+	ls := openTestStore(t, EngineMemory, "")
+	ctx := context.Background()
+	bucketName := []byte("bucket")
+	require.NoError(t, ls.Put(ctx, bucketName, []byte("before"), []byte("v")))
+
+	ls.setBuffering(true)
+	require.NoError(t, ls.Put(ctx, bucketName, []byte("a"), []byte("1")))
+	require.NoError(t, ls.Put(ctx, bucketName, []byte("a"), []byte("2")))
+	require.NoError(t, ls.Put(ctx, bucketName, []byte("b"), []byte("1")))
+	require.NoError(t, ls.Delete(ctx, bucketName, []byte("b")))
+	require.NoError(t, ls.Delete(ctx, bucketName, []byte("before")))
+	// 없는 key 를 지우는 것은 기록하지 않는다.
+	require.NoError(t, ls.Delete(ctx, bucketName, []byte("missing")))
+	ls.pendingMu.Lock()
+	pending, broken := ls.pendingOps, ls.pendingBroken
+	ls.pendingMu.Unlock()
+	require.False(t, broken)
+
+	// 버퍼에는 change log 의 기록도 들어 있다.
+	var writes []pendingWrite
+	for _, op := range pending {
+		if !IsInternalBucket(op.bucketName) {
+			writes = append(writes, op)
+		}
+	}
+	require.Equal(t, []pendingWrite{
+		{bucketName: bucketName, key: []byte("a"), value: []byte("1")},
+		{bucketName: bucketName, key: []byte("a"), value: []byte("2")},
+		{bucketName: bucketName, key: []byte("b"), value: []byte("1")},
+		{bucketName: bucketName, key: []byte("b"), delete: true},
+		{bucketName: bucketName, key: []byte("before"), delete: true},
+	}, writes)
+
+	// 복사본에는 버퍼링을 시작하기 전의 값만 있고, 다시 적용하면 원본과 같아진다.
+	dst := newMemoryEngine()
+	require.NoError(t, dst.Update(func(tx Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketName)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("before"), []byte("v"))
+	}))
+	require.NoError(t, replayPendingWrites(dst, pending))
+	require.NoError(t, dst.View(func(tx Tx) error {
+		bucket := tx.Bucket(bucketName)
+		require.Equal(t, []byte("2"), bucket.Get([]byte("a")))
+		require.Nil(t, bucket.Get([]byte("b")))
+		require.Nil(t, bucket.Get([]byte("before")))
+		require.NotNil(t, tx.Bucket(ChangeLogBucket))
+		return nil
+	}))
+
+	// 버퍼링을 끄면 버퍼를 비우고 더 이상 기록하지 않는다.
+	ls.setBuffering(false)
+	require.NoError(t, ls.Put(ctx, bucketName, []byte("c"), []byte("1")))
+	require.Empty(t, ls.pendingOps)
+}
+
+func TestInvalidateBuffer(t *testing.T) {
+	ls := openTestStore(t, EngineMemory, "")
+	ls.invalidateBuffer()
+	require.False(t, ls.pendingBroken)
+
+	ls.setBuffering(true)
+	ls.invalidateBuffer()
+	require.True(t, ls.pendingBroken)
+	ls.setBuffering(false)
+	require.False(t, ls.pendingBroken)
+}
+
+func TestCompactWithConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dbolt.db")
+	ls := openTestStore(t, EngineBBolt, path)
+	ctx := context.Background()
+	bucketName := []byte("bucket")
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, ls.Put(ctx, bucketName, []byte(fmt.Sprintf("key-%d", i)), bytes.Repeat([]byte{'v'}, 512)))
+	}
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, ls.Delete(ctx, bucketName, []byte(fmt.Sprintf("key-%d", i))))
+	}
+
+	// compaction 하는 동안 기록한 값과 지운 key 가 새 파일에도 적용되어야 한다.
+	expected := make(map[string]string)
+	buffered := 0
+	for round := 0; round < 10 && buffered == 0; round++ {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := fmt.Sprintf("live-%d-%d", round, i%50)
+				if i%3 == 2 {
+					if err := ls.Delete(ctx, bucketName, []byte(key)); err != nil {
+						t.Error(err)
+						return
+					}
+					delete(expected, key)
+					continue
+				}
+				value := fmt.Sprintf("value-%d", i)
+				if err := ls.Put(ctx, bucketName, []byte(key), []byte(value)); err != nil {
+					t.Error(err)
+					return
+				}
+				expected[key] = value
+			}
+		}()
+		result, err := ls.Compact(ctx)
+		close(stop)
+		<-done
+		require.NoError(t, err)
+		buffered = result.Buffered
+	}
+	require.Positive(t, buffered)
+
+	for key, value := range expected {
+		stored, err := ls.Get(ctx, bucketName, []byte(key))
+		require.NoError(t, err)
+		require.Equal(t, value, string(stored), "key=%s", key)
+	}
+	kvs, err := ls.Scan(ctx, bucketName, []byte("live-"), nil, 10000)
+	require.NoError(t, err)
+	require.Len(t, kvs, len(expected))
+	stored, err := ls.Get(ctx, bucketName, []byte("key-0"))
+	require.NoError(t, err)
+	require.Nil(t, stored)
+	stored, err = ls.Get(ctx, bucketName, []byte("key-1999"))
+	require.NoError(t, err)
+	require.Len(t, stored, 512)
+}
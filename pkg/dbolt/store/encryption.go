@@ -0,0 +1,288 @@
+package store
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// 암호화된 값은 아래의 envelope 로 저장된다.
+//
+//	magic(1) | format(1) | keyIDLen(1) | keyID | wrappedLen(2) | wrappedDEK | nonce(12) | ciphertext
+//
+// 값마다 새로 만든 data key(DEK) 로 AES-GCM 암호화하고, DEK 는 KeyProvider 의 key 로 감싸서 함께 저장한다.
+// bucket 이름과 key 를 AAD 로 사용하므로 다른 위치로 옮겨진 값은 복호화되지 않는다.
+// 첫 byte 가 magic 이 아니면 암호화되지 않은 값으로 읽는다.
+const (
+	encryptedMagic    byte = 0xEC
+	encryptedFormatV1 byte = 1
+	dataKeySize            = 32
+)
+
+var (
+	ErrEncryptionDisabled = errors.New("value is encrypted but encryption is not configured")
+	ErrUnknownKeyID       = errors.New("unknown encryption key id")
+)
+
+// KeyProvider 는 data key 를 감싸는 key encryption key 를 관리한다. KMS 를 사용할 때 이 interface 를 구현한다.
+// 값을 읽고 쓸 때마다 호출되므로 원격 KMS 를 사용하는 구현은 결과를 캐시해야 한다.
+type KeyProvider interface {
+	// ActiveKeyID 는 새로 암호화할 때 사용할 key 의 ID 이다.
+	ActiveKeyID() string
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// KeyFile 은 FileKeyProvider 가 읽는 keyfile 의 형식이다. key 는 base64 로 인코딩된 32 byte 이다.
+//
+//	active: 2024-06
+//	keys:
+//	  2024-01: 3q2+7w...
+//	  2024-06: yv66vg...
+type KeyFile struct {
+	Active string            `yaml:"active"`
+	Keys   map[string]string `yaml:"keys"`
+}
+
+// FileKeyProvider 는 로컬 keyfile 의 key 로 data key 를 AES-GCM 으로 감싼다.
+type FileKeyProvider struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+func LoadFileKeyProvider(path string) (*FileKeyProvider, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the keyfile : path=%s", path)
+	}
+	keyFile := new(KeyFile)
+	if err := yaml.Unmarshal(file, keyFile); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the keyfile : path=%s", path)
+	}
+	return NewFileKeyProvider(keyFile)
+}
+
+func NewFileKeyProvider(keyFile *KeyFile) (*FileKeyProvider, error) {
+	if _, ok := keyFile.Keys[keyFile.Active]; !ok {
+		return nil, errors.Errorf("active key not found in the keyfile : active=%s", keyFile.Active)
+	}
+	keys := make(map[string]cipher.AEAD, len(keyFile.Keys))
+	for id, encoded := range keyFile.Keys {
+		if len(id) == 0 || len(id) > 255 {
+			return nil, errors.Errorf("key id must be 1 to 255 bytes : id=%s", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode the key : id=%s", id)
+		}
+		if len(key) != dataKeySize {
+			return nil, errors.Errorf("key must be %d bytes : id=%s", dataKeySize, id)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		keys[id] = aead
+	}
+	return &FileKeyProvider{
+		active: keyFile.Active,
+		keys:   keys,
+	}, nil
+}
+
+func (p *FileKeyProvider) ActiveKeyID() string {
+	return p.active
+}
+
+func (p *FileKeyProvider) WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownKeyID, "id=%s", keyID)
+	}
+	return seal(aead, dataKey, nil)
+}
+
+func (p *FileKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownKeyID, "id=%s", keyID)
+	}
+	return unseal(aead, wrapped, nil)
+}
+
+// Encryptor 는 LocalStore 에 기록되는 값을 암호화한다. provider 가 nil 이면 값을 그대로 저장한다.
+type Encryptor struct {
+	provider KeyProvider
+}
+
+func NewEncryptor(provider KeyProvider) *Encryptor {
+	return &Encryptor{provider: provider}
+}
+
+func (e *Encryptor) Enabled() bool {
+	return e.provider != nil
+}
+
+func (e *Encryptor) ActiveKeyID() string {
+	if e.provider == nil {
+		return ""
+	}
+	return e.provider.ActiveKeyID()
+}
+
+func (e *Encryptor) Encrypt(ctx context.Context, bucketName, key, value []byte) ([]byte, error) {
+	if e.provider == nil {
+		return value, nil
+	}
+	keyID := e.provider.ActiveKeyID()
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, errors.Wrap(err, "failed to generate a data key")
+	}
+	wrapped, err := e.provider.WrapKey(ctx, keyID, dataKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to wrap the data key")
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(aead, value, additionalData(bucketName, key))
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := make([]byte, 0, 3+len(keyID)+2+len(wrapped)+len(ciphertext))
+	encrypted = append(encrypted, encryptedMagic, encryptedFormatV1, byte(len(keyID)))
+	encrypted = append(encrypted, keyID...)
+	encrypted = binary.BigEndian.AppendUint16(encrypted, uint16(len(wrapped)))
+	encrypted = append(encrypted, wrapped...)
+	return append(encrypted, ciphertext...), nil
+}
+
+// Decrypt 는 암호화된 값을 복호화한다. 암호화되지 않은 값은 그대로 반환한다.
+func (e *Encryptor) Decrypt(ctx context.Context, bucketName, key, value []byte) ([]byte, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if e.provider == nil {
+		return nil, ErrEncryptionDisabled
+	}
+	keyID, wrapped, ciphertext, err := parseEncrypted(value)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := e.provider.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unwrap the data key : keyID=%s", keyID)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := unseal(aead, ciphertext, additionalData(bucketName, key))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt the value : key=%s", string(key))
+	}
+	return plaintext, nil
+}
+
+// needsReencryption 은 값이 active key 로 암호화되어 있지 않으면 true 를 반환한다.
+func (e *Encryptor) needsReencryption(value []byte) bool {
+	if e.provider == nil {
+		return false
+	}
+	keyID, ok := KeyIDOf(value)
+	return !ok || keyID != e.provider.ActiveKeyID()
+}
+
+func IsEncrypted(value []byte) bool {
+	return len(value) > 0 && value[0] == encryptedMagic
+}
+
+// KeyIDOf 는 암호화된 값에 기록된 key ID 를 반환한다.
+func KeyIDOf(value []byte) (string, bool) {
+	if !IsEncrypted(value) || len(value) < 3 || len(value) < 3+int(value[2]) {
+		return "", false
+	}
+	return string(value[3 : 3+int(value[2])]), true
+}
+
+func parseEncrypted(value []byte) (keyID string, wrapped, ciphertext []byte, err error) {
+	if len(value) < 3 {
+		return "", nil, nil, errors.New("failed to decrypt the value: envelope too short")
+	}
+	if value[1] != encryptedFormatV1 {
+		return "", nil, nil, errors.Errorf("failed to decrypt the value: unknown envelope format %d", value[1])
+	}
+	offset := 3 + int(value[2])
+	if len(value) < offset+2 {
+		return "", nil, nil, errors.New("failed to decrypt the value: envelope too short")
+	}
+	keyID = string(value[3:offset])
+	wrappedLen := int(binary.BigEndian.Uint16(value[offset : offset+2]))
+	offset += 2
+	if len(value) < offset+wrappedLen {
+		return "", nil, nil, errors.New("failed to decrypt the value: envelope too short")
+	}
+	return keyID, value[offset : offset+wrappedLen], value[offset+wrappedLen:], nil
+}
+
+func additionalData(bucketName, key []byte) []byte {
+	aad := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(bucketName)+len(key)), uint64(len(bucketName)))
+	aad = append(aad, bucketName...)
+	return append(aad, key...)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create AES cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GCM")
+	}
+	return aead, nil
+}
+
+// seal 은 nonce 를 앞에 붙인 ciphertext 를 반환한다.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to generate a nonce")
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func unseal(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+type EncryptionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// KeyFile 은 KeyFile 형식의 yaml 파일 경로이다.
+	KeyFile string `yaml:"key_file"`
+	// ReencryptionInterval 이 0 이면 주기적인 재암호화를 하지 않고 admin API 로만 수행한다.
+	ReencryptionInterval time.Duration `yaml:"reencryption_interval"`
+}
+
+func (ec *EncryptionConfig) Validate() error {
+	if ec.Enabled && ec.KeyFile == "" {
+		return errors.New("bolt 'encryption.key_file' required when encryption is enabled")
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LocalStore 가 사용할 수 있는 저장소 엔진이다.
+const (
+	EngineBBolt  = "bbolt"
+	EngineBoltDB = "boltdb"
+	EngineMemory = "memory"
+)
+
+// engineTxSize 는 엔진 사이에 데이터를 복사할 때 하나의 쓰기 트랜잭션에 넣는 key 의 최대 개수이다.
+const engineTxSize = 10000
+
+// Engine 은 bucket 과 트랜잭션을 제공하는 key-value 저장소이다. 의미는 bolt 를 따른다.
+// 쓰기 트랜잭션은 한 번에 하나만 열리고, 읽기 트랜잭션은 시작한 시점의 일관된 상태를 본다.
+type Engine interface {
+	// Name 은 엔진의 이름이다. OpenEngine 에 다시 넘기면 같은 종류의 엔진이 열린다.
+	Name() string
+	// Path 는 엔진이 사용하는 파일 경로이며 메모리 엔진은 빈 문자열이다.
+	Path() string
+	Begin(writable bool) (Tx, error)
+	View(fn func(tx Tx) error) error
+	Update(fn func(tx Tx) error) error
+	// Stats 는 엔진의 page 와 트랜잭션 통계이다.
+	Stats() EngineStats
+	Close() error
+}
+
+// EngineStats 는 엔진의 상태이다. 메모리 엔진은 page 통계가 0 이다.
+type EngineStats struct {
+	Engine   string `json:"engine"`
+	Path     string `json:"path,omitempty"`
+	FileSize int64  `json:"fileSize"`
+	// FreePageN, PendingPageN 은 freelist 의 free page 와 아직 해제되지 않은 page 의 수이다.
+	FreePageN     int `json:"freePageN"`
+	PendingPageN  int `json:"pendingPageN"`
+	FreeAlloc     int `json:"freeAlloc"`
+	FreelistInuse int `json:"freelistInuse"`
+	// TxN 은 시작한 읽기 트랜잭션의 수, OpenTxN 은 열려 있는 읽기 트랜잭션의 수이다.
+	TxN     int `json:"txN"`
+	OpenTxN int `json:"openTxN"`
+}
+
+type Tx interface {
+	// Bucket 은 bucket 이 없으면 nil 을 반환한다.
+	Bucket(name []byte) Bucket
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+	ForEach(fn func(name []byte, bucket Bucket) error) error
+	// WriteTo 는 트랜잭션 시점의 스냅샷을 bolt 파일 형식으로 기록한다.
+	WriteTo(w io.Writer) (int64, error)
+	Commit() error
+	Rollback() error
+}
+
+// Bucket 이 반환하는 key 와 value 는 트랜잭션 안에서만 유효하다.
+type Bucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	ForEach(fn func(key, value []byte) error) error
+	Cursor() Cursor
+	// SetFillPercent 는 page 를 분할하는 기준이다. 정렬된 순서로 넣을 때 1.0 으로 설정한다.
+	SetFillPercent(fillPercent float64)
+}
+
+// Cursor 는 key 가 없으면 nil 을 반환한다.
+type Cursor interface {
+	First() (key, value []byte)
+	Last() (key, value []byte)
+	Next() (key, value []byte)
+	Prev() (key, value []byte)
+	Seek(seek []byte) (key, value []byte)
+}
+
+type EngineOptions struct {
+	// Timeout 은 파일 잠금을 기다리는 시간이며 0 이면 무한히 기다린다.
+	Timeout  time.Duration
+	ReadOnly bool
+}
+
+// OpenEngine 은 name 에 해당하는 엔진을 path 에 연다. name 이 비어 있으면 bbolt 를 사용한다.
+func OpenEngine(name, path string, mode os.FileMode, options *EngineOptions) (Engine, error) {
+	if options == nil {
+		options = &EngineOptions{}
+	}
+	switch name {
+	case "", EngineBBolt:
+		return openBBolt(path, mode, options)
+	case EngineBoltDB:
+		return openBoltDB(path, mode, options)
+	case EngineMemory:
+		return newMemoryEngine(), nil
+	}
+	return nil, errors.Errorf("unknown storage engine : %s", name)
+}
+
+func ValidateEngine(name string) error {
+	switch name {
+	case "", EngineBBolt, EngineBoltDB, EngineMemory:
+		return nil
+	}
+	return errors.Errorf("unknown storage engine : %s", name)
+}
+
+// CopyEngine 은 src 의 모든 bucket 과 key-value 를 dst 로 복사하고 복사한 key 의 개수를 반환한다.
+func CopyEngine(ctx context.Context, dst, src Engine) (int, error) {
+	keys := 0
+	err := src.View(func(srcTx Tx) error {
+		n, err := copyTx(ctx, dst, srcTx)
+		keys = n
+		return err
+	})
+	return keys, err
+}
+
+func copyTx(ctx context.Context, dst Engine, srcTx Tx) (int, error) {
+	keys := 0
+	err := srcTx.ForEach(func(bucketName []byte, srcBucket Bucket) error {
+		dstTx, err := dst.Begin(true)
+		if err != nil {
+			return err
+		}
+		// 중간에 다시 시작하지 못하면 dstTx 는 nil 이다.
+		defer func() {
+			if dstTx != nil {
+				_ = dstTx.Rollback()
+			}
+		}()
+
+		dstBucket, err := dstTx.CreateBucketIfNotExists(bucketName)
+		if err != nil {
+			return err
+		}
+		// 정렬된 순서로 넣으므로 page 를 가득 채워도 분할이 일어나지 않는다.
+		dstBucket.SetFillPercent(1.0)
+
+		count := 0
+		err = srcBucket.ForEach(func(key, value []byte) error {
+			if value == nil {
+				// 중첩 bucket 은 사용하지 않는다.
+				return nil
+			}
+			if err := dstBucket.Put(key, value); err != nil {
+				return err
+			}
+			keys++
+			count++
+			if count%engineTxSize != 0 {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := dstTx.Commit(); err != nil {
+				return err
+			}
+			next, err := dst.Begin(true)
+			if err != nil {
+				dstTx = nil
+				return err
+			}
+			dstTx = next
+			dstBucket = dstTx.Bucket(bucketName)
+			dstBucket.SetFillPercent(1.0)
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to copy bucket : bucketName=%s", string(bucketName))
+		}
+		return dstTx.Commit()
+	})
+	return keys, err
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+// bboltEngine 은 유지보수되고 있는 bolt 의 fork 인 bbolt 를 사용한다. 파일 형식은 boltdb 와 같다.
+type bboltEngine struct {
+	db *bbolt.DB
+}
+
+func openBBolt(path string, mode os.FileMode, options *EngineOptions) (Engine, error) {
+	db, err := bbolt.Open(path, mode, &bbolt.Options{
+		Timeout:  options.Timeout,
+		ReadOnly: options.ReadOnly,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open bbolt : path=%s", path)
+	}
+	return &bboltEngine{db: db}, nil
+}
+
+func (e *bboltEngine) Name() string {
+	return EngineBBolt
+}
+
+func (e *bboltEngine) Path() string {
+	return e.db.Path()
+}
+
+func (e *bboltEngine) Begin(writable bool) (Tx, error) {
+	tx, err := e.db.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return &bboltTx{tx: tx}, nil
+}
+
+func (e *bboltEngine) View(fn func(tx Tx) error) error {
+	return e.db.View(func(tx *bbolt.Tx) error {
+		return fn(&bboltTx{tx: tx})
+	})
+}
+
+func (e *bboltEngine) Update(fn func(tx Tx) error) error {
+	return e.db.Update(func(tx *bbolt.Tx) error {
+		return fn(&bboltTx{tx: tx})
+	})
+}
+
+func (e *bboltEngine) Stats() EngineStats {
+	stats := e.db.Stats()
+	return EngineStats{
+		FreePageN:     stats.FreePageN,
+		PendingPageN:  stats.PendingPageN,
+		FreeAlloc:     stats.FreeAlloc,
+		FreelistInuse: stats.FreelistInuse,
+		TxN:           stats.TxN,
+		OpenTxN:       stats.OpenTxN,
+	}
+}
+
+func (e *bboltEngine) Close() error {
+	return e.db.Close()
+}
+
+type bboltTx struct {
+	tx *bbolt.Tx
+}
+
+func (t *bboltTx) Bucket(name []byte) Bucket {
+	bucket := t.tx.Bucket(name)
+	if bucket == nil {
+		return nil
+	}
+	return &bboltBucket{bucket: bucket}
+}
+
+func (t *bboltTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	bucket, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return &bboltBucket{bucket: bucket}, nil
+}
+
+func (t *bboltTx) ForEach(fn func(name []byte, bucket Bucket) error) error {
+	return t.tx.ForEach(func(name []byte, bucket *bbolt.Bucket) error {
+		return fn(name, &bboltBucket{bucket: bucket})
+	})
+}
+
+func (t *bboltTx) WriteTo(w io.Writer) (int64, error) {
+	return t.tx.WriteTo(w)
+}
+
+func (t *bboltTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *bboltTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+type bboltBucket struct {
+	bucket *bbolt.Bucket
+}
+
+func (b *bboltBucket) Get(key []byte) []byte {
+	return b.bucket.Get(key)
+}
+
+func (b *bboltBucket) Put(key, value []byte) error {
+	return b.bucket.Put(key, value)
+}
+
+func (b *bboltBucket) Delete(key []byte) error {
+	return b.bucket.Delete(key)
+}
+
+func (b *bboltBucket) ForEach(fn func(key, value []byte) error) error {
+	return b.bucket.ForEach(fn)
+}
+
+func (b *bboltBucket) Cursor() Cursor {
+	return b.bucket.Cursor()
+}
+
+func (b *bboltBucket) SetFillPercent(fillPercent float64) {
+	b.bucket.FillPercent = fillPercent
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"io"
+	"os"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// boltDBEngine 은 더 이상 유지보수되지 않는 github.com/boltdb/bolt 를 사용한다. 기존 설치와의 호환을 위해 남겨둔다.
+type boltDBEngine struct {
+	db *bolt.DB
+}
+
+func openBoltDB(path string, mode os.FileMode, options *EngineOptions) (Engine, error) {
+	db, err := bolt.Open(path, mode, &bolt.Options{
+		Timeout:  options.Timeout,
+		ReadOnly: options.ReadOnly,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open boltdb : path=%s", path)
+	}
+	return &boltDBEngine{db: db}, nil
+}
+
+func (e *boltDBEngine) Name() string {
+	return EngineBoltDB
+}
+
+func (e *boltDBEngine) Path() string {
+	return e.db.Path()
+}
+
+func (e *boltDBEngine) Begin(writable bool) (Tx, error) {
+	tx, err := e.db.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return &boltDBTx{tx: tx}, nil
+}
+
+func (e *boltDBEngine) View(fn func(tx Tx) error) error {
+	return e.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltDBTx{tx: tx})
+	})
+}
+
+func (e *boltDBEngine) Update(fn func(tx Tx) error) error {
+	return e.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltDBTx{tx: tx})
+	})
+}
+
+func (e *boltDBEngine) Stats() EngineStats {
+	stats := e.db.Stats()
+	return EngineStats{
+		FreePageN:     stats.FreePageN,
+		PendingPageN:  stats.PendingPageN,
+		FreeAlloc:     stats.FreeAlloc,
+		FreelistInuse: stats.FreelistInuse,
+		TxN:           stats.TxN,
+		OpenTxN:       stats.OpenTxN,
+	}
+}
+
+func (e *boltDBEngine) Close() error {
+	return e.db.Close()
+}
+
+type boltDBTx struct {
+	tx *bolt.Tx
+}
+
+func (t *boltDBTx) Bucket(name []byte) Bucket {
+	bucket := t.tx.Bucket(name)
+	if bucket == nil {
+		return nil
+	}
+	return &boltDBBucket{bucket: bucket}
+}
+
+func (t *boltDBTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	bucket, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return &boltDBBucket{bucket: bucket}, nil
+}
+
+func (t *boltDBTx) ForEach(fn func(name []byte, bucket Bucket) error) error {
+	return t.tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+		return fn(name, &boltDBBucket{bucket: bucket})
+	})
+}
+
+func (t *boltDBTx) WriteTo(w io.Writer) (int64, error) {
+	return t.tx.WriteTo(w)
+}
+
+func (t *boltDBTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *boltDBTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+type boltDBBucket struct {
+	bucket *bolt.Bucket
+}
+
+func (b *boltDBBucket) Get(key []byte) []byte {
+	return b.bucket.Get(key)
+}
+
+func (b *boltDBBucket) Put(key, value []byte) error {
+	return b.bucket.Put(key, value)
+}
+
+func (b *boltDBBucket) Delete(key []byte) error {
+	return b.bucket.Delete(key)
+}
+
+func (b *boltDBBucket) ForEach(fn func(key, value []byte) error) error {
+	return b.bucket.ForEach(fn)
+}
+
+func (b *boltDBBucket) Cursor() Cursor {
+	return b.bucket.Cursor()
+}
+
+func (b *boltDBBucket) SetFillPercent(fillPercent float64) {
+	b.bucket.FillPercent = fillPercent
+}
@@ -0,0 +1,346 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/google/btree"
+	"github.com/pkg/errors"
+)
+
+const memoryBTreeDegree = 32
+
+var (
+	ErrTxNotWritable = errors.New("tx not writable")
+	ErrTxClosed      = errors.New("tx closed")
+	ErrKeyRequired   = errors.New("key required")
+)
+
+type memoryItem struct {
+	key   []byte
+	value []byte
+}
+
+func lessMemoryItem(a, b memoryItem) bool {
+	return bytes.Compare(a.key, b.key) < 0
+}
+
+type memoryTree = btree.BTreeG[memoryItem]
+
+// memoryEngine 은 bucket 마다 B-tree 를 두는 메모리 엔진이며 테스트나 캐시 계층에 사용한다.
+// 쓰기 트랜잭션은 수정하는 bucket 의 B-tree 를 copy-on-write 로 복제하고 commit 할 때 교체하므로,
+// 읽기 트랜잭션은 잠금 없이 시작 시점의 상태를 본다.
+type memoryEngine struct {
+	// writer 는 쓰기 트랜잭션을 하나로 제한한다.
+	writer  sync.Mutex
+	mu      sync.RWMutex
+	buckets map[string]*memoryTree
+}
+
+func newMemoryEngine() *memoryEngine {
+	return &memoryEngine{buckets: make(map[string]*memoryTree)}
+}
+
+func (e *memoryEngine) Name() string {
+	return EngineMemory
+}
+
+func (e *memoryEngine) Path() string {
+	return ""
+}
+
+func (e *memoryEngine) Begin(writable bool) (Tx, error) {
+	if writable {
+		e.writer.Lock()
+	}
+	e.mu.RLock()
+	buckets := e.buckets
+	e.mu.RUnlock()
+
+	tx := &memoryTx{engine: e, writable: writable, buckets: buckets}
+	if writable {
+		tx.buckets = make(map[string]*memoryTree, len(buckets))
+		for name, tree := range buckets {
+			tx.buckets[name] = tree
+		}
+		tx.cloned = make(map[string]bool)
+	}
+	return tx, nil
+}
+
+func (e *memoryEngine) View(fn func(tx Tx) error) error {
+	tx, err := e.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	return fn(tx)
+}
+
+func (e *memoryEngine) Update(fn func(tx Tx) error) error {
+	tx, err := e.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (e *memoryEngine) Stats() EngineStats {
+	return EngineStats{}
+}
+
+func (e *memoryEngine) Close() error {
+	return nil
+}
+
+type memoryTx struct {
+	engine   *memoryEngine
+	writable bool
+	closed   bool
+	buckets  map[string]*memoryTree
+	// cloned 는 이 트랜잭션에서 이미 복제한 bucket 이다.
+	cloned map[string]bool
+}
+
+func (t *memoryTx) Bucket(name []byte) Bucket {
+	if _, ok := t.buckets[string(name)]; !ok {
+		return nil
+	}
+	return &memoryBucket{tx: t, name: string(name)}
+}
+
+func (t *memoryTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if t.closed {
+		return nil, ErrTxClosed
+	}
+	if !t.writable {
+		return nil, ErrTxNotWritable
+	}
+	if len(name) == 0 {
+		return nil, errors.New("bucket name required")
+	}
+	if _, ok := t.buckets[string(name)]; !ok {
+		t.buckets[string(name)] = btree.NewG(memoryBTreeDegree, lessMemoryItem)
+		t.cloned[string(name)] = true
+	}
+	return &memoryBucket{tx: t, name: string(name)}, nil
+}
+
+func (t *memoryTx) ForEach(fn func(name []byte, bucket Bucket) error) error {
+	names := make([]string, 0, len(t.buckets))
+	for name := range t.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := fn([]byte(name), &memoryBucket{tx: t, name: name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTo 는 다른 엔진의 스냅샷과 같은 형식이 되도록 임시 bbolt 파일에 복사한 뒤 기록한다.
+func (t *memoryTx) WriteTo(w io.Writer) (int64, error) {
+	tmp, err := os.CreateTemp("", "dbolt-memory-*.db")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create a temporary file")
+	}
+	path := tmp.Name()
+	_ = tmp.Close()
+	defer os.Remove(path)
+
+	dst, err := openBBolt(path, 0600, &EngineOptions{})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := copyTx(context.Background(), dst, t); err != nil {
+		_ = dst.Close()
+		return 0, err
+	}
+	if err := dst.Close(); err != nil {
+		return 0, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return io.Copy(w, file)
+}
+
+func (t *memoryTx) Commit() error {
+	if t.closed {
+		return ErrTxClosed
+	}
+	if !t.writable {
+		return ErrTxNotWritable
+	}
+	t.engine.mu.Lock()
+	t.engine.buckets = t.buckets
+	t.engine.mu.Unlock()
+	t.close()
+	return nil
+}
+
+func (t *memoryTx) Rollback() error {
+	if t.closed {
+		return ErrTxClosed
+	}
+	t.close()
+	return nil
+}
+
+func (t *memoryTx) close() {
+	t.closed = true
+	if t.writable {
+		t.engine.writer.Unlock()
+	}
+}
+
+// writableTree 는 처음 수정하는 bucket 을 복제해서 commit 된 B-tree 를 읽고 있는 트랜잭션에 영향이 없게 한다.
+func (t *memoryTx) writableTree(name string) (*memoryTree, error) {
+	if t.closed {
+		return nil, ErrTxClosed
+	}
+	if !t.writable {
+		return nil, ErrTxNotWritable
+	}
+	if !t.cloned[name] {
+		t.buckets[name] = t.buckets[name].Clone()
+		t.cloned[name] = true
+	}
+	return t.buckets[name], nil
+}
+
+type memoryBucket struct {
+	tx   *memoryTx
+	name string
+}
+
+func (b *memoryBucket) tree() *memoryTree {
+	return b.tx.buckets[b.name]
+}
+
+func (b *memoryBucket) Get(key []byte) []byte {
+	item, ok := b.tree().Get(memoryItem{key: key})
+	if !ok {
+		return nil
+	}
+	return item.value
+}
+
+func (b *memoryBucket) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyRequired
+	}
+	tree, err := b.tx.writableTree(b.name)
+	if err != nil {
+		return err
+	}
+	tree.ReplaceOrInsert(memoryItem{
+		key:   append([]byte(nil), key...),
+		value: append([]byte{}, value...),
+	})
+	return nil
+}
+
+func (b *memoryBucket) Delete(key []byte) error {
+	tree, err := b.tx.writableTree(b.name)
+	if err != nil {
+		return err
+	}
+	tree.Delete(memoryItem{key: key})
+	return nil
+}
+
+func (b *memoryBucket) ForEach(fn func(key, value []byte) error) error {
+	var err error
+	b.tree().Ascend(func(item memoryItem) bool {
+		err = fn(item.key, item.value)
+		return err == nil
+	})
+	return err
+}
+
+func (b *memoryBucket) Cursor() Cursor {
+	return &memoryCursor{tree: b.tree()}
+}
+
+func (b *memoryBucket) SetFillPercent(fillPercent float64) {}
+
+// memoryCursor 는 현재 key 를 기억하고 매번 B-tree 를 다시 탐색한다.
+type memoryCursor struct {
+	tree    *memoryTree
+	current []byte
+}
+
+func (c *memoryCursor) First() ([]byte, []byte) {
+	item, ok := c.tree.Min()
+	return c.moveTo(item, ok)
+}
+
+func (c *memoryCursor) Last() ([]byte, []byte) {
+	item, ok := c.tree.Max()
+	return c.moveTo(item, ok)
+}
+
+func (c *memoryCursor) Next() ([]byte, []byte) {
+	if c.current == nil {
+		return nil, nil
+	}
+	var next memoryItem
+	found := false
+	c.tree.AscendGreaterOrEqual(memoryItem{key: c.current}, func(item memoryItem) bool {
+		if bytes.Equal(item.key, c.current) {
+			return true
+		}
+		next, found = item, true
+		return false
+	})
+	return c.moveTo(next, found)
+}
+
+func (c *memoryCursor) Prev() ([]byte, []byte) {
+	if c.current == nil {
+		return nil, nil
+	}
+	var prev memoryItem
+	found := false
+	c.tree.DescendLessOrEqual(memoryItem{key: c.current}, func(item memoryItem) bool {
+		if bytes.Equal(item.key, c.current) {
+			return true
+		}
+		prev, found = item, true
+		return false
+	})
+	return c.moveTo(prev, found)
+}
+
+func (c *memoryCursor) Seek(seek []byte) ([]byte, []byte) {
+	var next memoryItem
+	found := false
+	c.tree.AscendGreaterOrEqual(memoryItem{key: seek}, func(item memoryItem) bool {
+		next, found = item, true
+		return false
+	})
+	return c.moveTo(next, found)
+}
+
+func (c *memoryCursor) moveTo(item memoryItem, ok bool) ([]byte, []byte) {
+	if !ok {
+		c.current = nil
+		return nil, nil
+	}
+	c.current = item.key
+	return item.key, item.value
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// fillEngine 은 bucket 마다 n 개의 key 를 기록한다.
+func fillEngine(t *testing.T, e Engine, buckets []string, n int) {
+	t.Helper()
+	require.NoError(t, e.Update(func(tx Tx) error {
+		for _, name := range buckets {
+			bucket, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < n; i++ {
+				if err := bucket.Put([]byte(fmt.Sprintf("key-%06d", i)), []byte(fmt.Sprintf("%s-%d", name, i))); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+}
+
+func TestCopyEngine(t *testing.T) {
+	for _, name := range []string{EngineBBolt, EngineMemory} {
+		t.Run(name, func(t *testing.T) {
+			src, err := OpenEngine(EngineMemory, "", 0o600, nil)
+			require.NoError(t, err)
+			// 여러 쓰기 트랜잭션으로 나눠서 복사한다.
+			n := engineTxSize + engineTxSize/2
+			fillEngine(t, src, []string{"a", "b"}, n)
+
+			dst, err := OpenEngine(name, filepath.Join(t.TempDir(), "dst.db"), 0o600, nil)
+			require.NoError(t, err)
+			defer dst.Close()
+			keys, err := CopyEngine(context.Background(), dst, src)
+			require.NoError(t, err)
+			require.Equal(t, 2*n, keys)
+
+			require.NoError(t, dst.View(func(tx Tx) error {
+				for _, bucketName := range []string{"a", "b"} {
+					bucket := tx.Bucket([]byte(bucketName))
+					require.NotNil(t, bucket)
+					count := 0
+					require.NoError(t, bucket.ForEach(func(key, value []byte) error {
+						count++
+						return nil
+					}))
+					require.Equal(t, n, count)
+					require.Equal(t, []byte(bucketName+"-0"), bucket.Get([]byte("key-000000")))
+					require.Equal(t, []byte(fmt.Sprintf("%s-%d", bucketName, n-1)), bucket.Get([]byte(fmt.Sprintf("key-%06d", n-1))))
+				}
+				return nil
+			}))
+		})
+	}
+}
+
+// failingEngine 은 begins 번째 이후의 쓰기 트랜잭션을 시작하지 못하는 엔진이다.
+type failingEngine struct {
+	Engine
+	begins int
+}
+
+var errBeginFailed = errors.New("begin failed")
+
+func (e *failingEngine) Begin(writable bool) (Tx, error) {
+	if writable {
+		if e.begins == 0 {
+			return nil, errBeginFailed
+		}
+		e.begins--
+	}
+	return e.Engine.Begin(writable)
+}
+
+func TestCopyEngineFailure(t *testing.T) {
+	src, err := OpenEngine(EngineMemory, "", 0o600, nil)
+	require.NoError(t, err)
+	fillEngine(t, src, []string{"a", "b"}, engineTxSize+1)
+
+	// 첫 번째 트랜잭션도 시작하지 못하는 경우와, 중간에 다음 트랜잭션을 시작하지 못하는 경우 모두 panic 없이 실패한다.
+	for begins := 0; begins < 4; begins++ {
+		dst := &failingEngine{Engine: newMemoryEngine(), begins: begins}
+		_, err := CopyEngine(context.Background(), dst, src)
+		require.ErrorIs(t, err, errBeginFailed, "begins=%d", begins)
+
+		// 실패한 뒤에 쓰기 트랜잭션이 남아 있으면 다음 쓰기가 끝나지 않는다.
+		require.NoError(t, dst.Engine.Update(func(tx Tx) error { return nil }))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = CopyEngine(ctx, newMemoryEngine(), src)
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ErrProbeInProgress = errors.New("the previous probe has not finished yet")
+
+// writeLatencyWindow 동안 끝난 쓰기 트랜잭션이 없으면 이전의 쓰기 지연 시간은 더 이상 반영하지 않는다.
+const writeLatencyWindow = 10 * time.Second
+
+// TxHealth 는 열려 있는 트랜잭션 중 가장 오래된 것의 경과 시간이다.
+// Waiting 은 bolt 의 쓰기 lock 을 기다리는 시간이고 Running 은 트랜잭션 함수가 실행된 시간이다.
+type TxHealth struct {
+	OpenTx  int           `json:"openTx"`
+	Waiting time.Duration `json:"waiting"`
+	Running time.Duration `json:"running"`
+}
+
+// txTracker 는 LocalStore 가 연 트랜잭션의 시작 시각을 기록한다. liveness probe 가 멈춘 트랜잭션을 찾고
+// load shedding 이 쓰기 지연 시간을 확인하는 데 쓴다.
+type txTracker struct {
+	mu   sync.Mutex
+	next uint64
+	txs  map[uint64]*trackedTx
+	// writeLatency 는 끝난 쓰기 트랜잭션의 지연 시간(lock 을 기다린 시간 포함)의 지수 이동 평균이다.
+	writeLatency time.Duration
+	lastWriteAt  time.Time
+}
+
+type trackedTx struct {
+	write     bool
+	queuedAt  time.Time
+	startedAt time.Time
+}
+
+func newTxTracker() *txTracker {
+	return &txTracker{txs: make(map[uint64]*trackedTx)}
+}
+
+func (t *txTracker) queue(write bool) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	t.txs[t.next] = &trackedTx{write: write, queuedAt: time.Now()}
+	return t.next
+}
+
+func (t *txTracker) start(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tx, ok := t.txs[id]; ok && tx.startedAt.IsZero() {
+		tx.startedAt = time.Now()
+	}
+}
+
+func (t *txTracker) done(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tx, ok := t.txs[id]; ok && tx.write {
+		now := time.Now()
+		latency := now.Sub(tx.queuedAt)
+		if now.Sub(t.lastWriteAt) > writeLatencyWindow {
+			t.writeLatency = latency
+		} else {
+			t.writeLatency += (latency - t.writeLatency) / 10
+		}
+		t.lastWriteAt = now
+	}
+	delete(t.txs, id)
+}
+
+// recentWriteLatency 는 최근 쓰기 트랜잭션의 평균 지연 시간과 아직 끝나지 않은 쓰기 트랜잭션의 경과 시간 중 큰 값이다.
+func (t *txTracker) recentWriteLatency(now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var latency time.Duration
+	if now.Sub(t.lastWriteAt) <= writeLatencyWindow {
+		latency = t.writeLatency
+	}
+	for _, tx := range t.txs {
+		if tx.write {
+			latency = max(latency, now.Sub(tx.queuedAt))
+		}
+	}
+	return latency
+}
+
+func (t *txTracker) health(now time.Time) TxHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	health := TxHealth{OpenTx: len(t.txs)}
+	for _, tx := range t.txs {
+		if tx.startedAt.IsZero() {
+			health.Waiting = max(health.Waiting, now.Sub(tx.queuedAt))
+		} else {
+			health.Running = max(health.Running, now.Sub(tx.startedAt))
+		}
+	}
+	return health
+}
+
+// view 와 update 는 트랜잭션을 txTracker 에 기록하면서 db 의 View, Update 를 호출한다. 호출하는 쪽에서 mu 를 잡고 있어야 한다.
+func (ls *LocalStore) view(fn func(tx Tx) error) error {
+	id := ls.txs.queue(false)
+	defer ls.txs.done(id)
+	return ls.db.View(func(tx Tx) error {
+		ls.txs.start(id)
+		return fn(tx)
+	})
+}
+
+func (ls *LocalStore) update(fn func(tx Tx) error) error {
+	id := ls.txs.queue(true)
+	defer ls.txs.done(id)
+	return ls.db.Update(func(tx Tx) error {
+		ls.txs.start(id)
+		return fn(tx)
+	})
+}
+
+// TxHealth 는 지금 열려 있는 트랜잭션의 상태를 반환한다. 스냅샷을 스트림으로 쓰는 Backup 과 compaction 의 복사는 오래 걸리는 것이 정상이므로 포함하지 않는다.
+func (ls *LocalStore) TxHealth() TxHealth {
+	return ls.txs.health(time.Now())
+}
+
+// WriteLatency 는 최근 쓰기 트랜잭션이 lock 을 기다린 시간을 포함해서 끝날 때까지 걸린 시간이다.
+// 쓰기가 없으면 0 이고, 끝나지 않은 쓰기가 있으면 그 경과 시간도 반영한다.
+func (ls *LocalStore) WriteLatency() time.Duration {
+	return ls.txs.recentWriteLatency(time.Now())
+}
+
+// CheckRead 는 ctx 가 끝나기 전에 읽기 트랜잭션을 열 수 있는지 확인한다.
+// 멈춘 db 를 기다리는 goroutine 이 쌓이지 않도록 이전 확인이 끝나지 않았으면 바로 ErrProbeInProgress 를 반환한다.
+func (ls *LocalStore) CheckRead(ctx context.Context) error {
+	return probe(ctx, &ls.readProbe, func() error {
+		ls.mu.RLock()
+		defer ls.mu.RUnlock()
+		return ls.view(func(tx Tx) error {
+			tx.Bucket(MetadataBucket)
+			return nil
+		})
+	})
+}
+
+// CheckLock 은 ctx 가 끝나기 전에 compaction 이 bolt 파일을 교체할 때 잡는 lock 을 얻을 수 있는지 확인한다.
+func (ls *LocalStore) CheckLock(ctx context.Context) error {
+	return probe(ctx, &ls.lockProbe, func() error {
+		ls.mu.RLock()
+		ls.mu.RUnlock()
+		return nil
+	})
+}
+
+// probe 는 fn 을 별도의 goroutine 에서 실행하고 ctx 가 끝날 때까지만 기다린다. running 은 fn 이 실행 중인지를 나타낸다.
+func probe(ctx context.Context, running *atomic.Bool, fn func() error) error {
+	if !running.CompareAndSwap(false, true) {
+		return ErrProbeInProgress
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer running.Store(false)
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "timed out")
+	}
+}
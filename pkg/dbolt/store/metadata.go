@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// MetadataBucket 은 노드가 재시작해도 유지해야 하는 내부 상태를 저장한다.
+// 노드마다 다른 값이므로 스냅샷에서 복원하지 않으며 다른 값과 마찬가지로 암호화된다.
+var MetadataBucket = []byte(internalBucketPrefix + "metadata")
+
+func (ls *LocalStore) GetMetadata(ctx context.Context, key []byte) ([]byte, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var value []byte
+	if err := ls.view(func(tx Tx) error {
+		bucket := tx.Bucket(MetadataBucket)
+		if bucket == nil {
+			return nil
+		}
+		if v := bucket.Get(key); v != nil {
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return ls.encryptor.Decrypt(ctx, MetadataBucket, key, value)
+}
+
+func (ls *LocalStore) PutMetadata(ctx context.Context, key, value []byte) error {
+	encrypted, err := ls.encryptor.Encrypt(ctx, MetadataBucket, key, value)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encrypt the metadata : key=%s", string(key))
+	}
+
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	err = ls.update(func(tx Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(MetadataBucket)
+		if err != nil {
+			return errors.Wrap(err, "failed to create the metadata bucket")
+		}
+		if err := bucket.Put(key, encrypted); err != nil {
+			return errors.Wrapf(err, "failed to put the metadata : key=%s", string(key))
+		}
+		ls.bufferIfCompacting(MetadataBucket, key, encrypted)
+		return nil
+	})
+	if err != nil {
+		ls.invalidateBuffer()
+	}
+	return err
+}
@@ -0,0 +1,293 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// reencryptionTxSize 는 재암호화 중 하나의 쓰기 트랜잭션에서 확인하는 key 의 최대 개수이다.
+const reencryptionTxSize = 1000
+
+var ErrReencryptionInProgress = errors.New("re-encryption already in progress")
+
+type ReencryptionResult struct {
+	StartedAt   time.Time     `json:"startedAt"`
+	Duration    time.Duration `json:"duration"`
+	ActiveKeyID string        `json:"activeKeyId"`
+	Keys        int           `json:"keys"`
+	Reencrypted int           `json:"reencrypted"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// Reencrypt 는 active key 로 암호화되어 있지 않은 값을 active key 로 다시 암호화한다.
+// 암호화를 켜기 전에 기록된 평문 값도 함께 암호화된다.
+// 작은 쓰기 트랜잭션으로 나누어 수행하므로 그 사이의 읽기와 쓰기를 오래 막지 않는다.
+func (ls *LocalStore) Reencrypt(ctx context.Context) (*ReencryptionResult, error) {
+	if !ls.encryptor.Enabled() {
+		return nil, errors.New("encryption is not enabled")
+	}
+	if !ls.reencryptMu.TryLock() {
+		return nil, ErrReencryptionInProgress
+	}
+	defer ls.reencryptMu.Unlock()
+
+	result := &ReencryptionResult{
+		StartedAt:   time.Now(),
+		ActiveKeyID: ls.encryptor.ActiveKeyID(),
+	}
+
+	bucketNames, err := ls.bucketNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, bucketName := range bucketNames {
+		var after []byte
+		for {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			next, keys, reencrypted, err := ls.reencryptBatch(ctx, bucketName, after)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to re-encrypt bucket : bucketName=%s", string(bucketName))
+			}
+			result.Keys += keys
+			result.Reencrypted += reencrypted
+			if next == nil {
+				break
+			}
+			after = next
+		}
+	}
+	result.Duration = time.Since(result.StartedAt)
+	return result, nil
+}
+
+func (ls *LocalStore) bucketNames() ([][]byte, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var bucketNames [][]byte
+	err := ls.view(func(tx Tx) error {
+		return tx.ForEach(func(bucketName []byte, _ Bucket) error {
+			bucketNames = append(bucketNames, append([]byte(nil), bucketName...))
+			return nil
+		})
+	})
+	return bucketNames, err
+}
+
+// reencryptBatch 는 after 다음 key 부터 최대 reencryptionTxSize 개를 확인하고 마지막으로 확인한 key 를 반환한다.
+// bucket 의 끝에 도달하면 nil 을 반환한다.
+func (ls *LocalStore) reencryptBatch(ctx context.Context, bucketName, after []byte) ([]byte, int, int, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var next []byte
+	keys, reencrypted := 0, 0
+	var delta Usage
+	err := ls.update(func(tx Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+
+		// 커서로 순회하는 동안 bucket 을 수정하면 안 되므로 모아 두었다가 기록한다.
+		var rewrites []pendingWrite
+		var last []byte
+		cursor := bucket.Cursor()
+		key, value := cursor.First()
+		if after != nil {
+			key, value = cursor.Seek(after)
+			if bytes.Equal(key, after) {
+				key, value = cursor.Next()
+			}
+		}
+		for ; key != nil; key, value = cursor.Next() {
+			if keys == reencryptionTxSize {
+				next = append([]byte(nil), last...)
+				break
+			}
+			keys++
+			last = key
+			if value == nil || !ls.encryptor.needsReencryption(value) {
+				continue
+			}
+			plaintext, err := ls.encryptor.Decrypt(ctx, bucketName, key, value)
+			if err != nil {
+				return err
+			}
+			encrypted, err := ls.encryptor.Encrypt(ctx, bucketName, key, plaintext)
+			if err != nil {
+				return err
+			}
+			rewrites = append(rewrites, pendingWrite{
+				bucketName: bucketName,
+				key:        append([]byte(nil), key...),
+				value:      encrypted,
+			})
+			delta = delta.add(putDelta(key, value, encrypted))
+		}
+
+		for _, op := range rewrites {
+			if err := bucket.Put(op.key, op.value); err != nil {
+				return errors.Wrapf(err, "failed to put re-encrypted value : key=%s", string(op.key))
+			}
+			ls.bufferIfCompacting(op.bucketName, op.key, op.value)
+		}
+		reencrypted = len(rewrites)
+		return nil
+	})
+	if err != nil {
+		ls.invalidateBuffer()
+		return nil, 0, 0, err
+	}
+	ls.usage.add(bucketName, delta)
+	return next, keys, reencrypted, nil
+}
+
+type reencryptionMetrics struct {
+	runs          *prometheus.CounterVec
+	reencrypted   prometheus.Counter
+	duration      prometheus.Histogram
+	lastSucceeded prometheus.Gauge
+}
+
+func newReencryptionMetrics(reg prometheus.Registerer) *reencryptionMetrics {
+	factory := promauto.With(reg)
+	return &reencryptionMetrics{
+		runs: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbolt_reencryptions_total",
+			Help: "Total number of re-encryption runs.",
+		}, []string{"result"}),
+		reencrypted: factory.NewCounter(prometheus.CounterOpts{
+			Name: "dbolt_reencrypted_values_total",
+			Help: "Total number of values re-encrypted with the active key.",
+		}),
+		duration: factory.NewHistogram(prometheus.HistogramOpts{
+			Name:    "dbolt_reencryption_duration_seconds",
+			Help:    "Time taken to re-encrypt the bolt file.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 4, 8),
+		}),
+		lastSucceeded: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "dbolt_reencryption_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful re-encryption.",
+		}),
+	}
+}
+
+// Reencryptor 는 key 를 교체한 뒤 이전 key 로 암호화된 값을 주기적으로 또는 요청에 따라 다시 암호화한다.
+type Reencryptor struct {
+	cfg     *EncryptionConfig
+	store   *LocalStore
+	metrics *reencryptionMetrics
+	logger  *zap.Logger
+
+	mu         sync.Mutex
+	running    bool
+	lastResult *ReencryptionResult
+	stop       chan struct{}
+}
+
+func NewReencryptor(cfg *EncryptionConfig, store *LocalStore, reg prometheus.Registerer, logger *zap.Logger) *Reencryptor {
+	return &Reencryptor{
+		cfg:     cfg,
+		store:   store,
+		metrics: newReencryptionMetrics(reg),
+		logger:  logger,
+		stop:    make(chan struct{}),
+	}
+}
+
+func (r *Reencryptor) Start(ctx context.Context) error {
+	if !r.cfg.Enabled || r.cfg.ReencryptionInterval <= 0 {
+		return nil
+	}
+	r.logger.Info("Starting scheduled re-encryption.", zap.Duration("interval", r.cfg.ReencryptionInterval))
+	go func() {
+		ticker := time.NewTicker(r.cfg.ReencryptionInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := r.Reencrypt(context.Background()); err != nil {
+					r.logger.Error("Scheduled re-encryption failed.", zap.Error(err))
+				}
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *Reencryptor) Stop(ctx context.Context) error {
+	close(r.stop)
+	return nil
+}
+
+func (r *Reencryptor) Reencrypt(ctx context.Context) (*ReencryptionResult, error) {
+	r.setRunning(true)
+	defer r.setRunning(false)
+
+	r.logger.Info("Re-encrypting values with the active key.")
+	result, err := r.store.Reencrypt(ctx)
+	if errors.Is(err, ErrReencryptionInProgress) {
+		return nil, err
+	}
+	if err != nil {
+		r.metrics.runs.WithLabelValues("failure").Inc()
+		r.setLastResult(&ReencryptionResult{StartedAt: time.Now(), Error: err.Error()})
+		return nil, err
+	}
+
+	r.metrics.runs.WithLabelValues("success").Inc()
+	r.metrics.reencrypted.Add(float64(result.Reencrypted))
+	r.metrics.duration.Observe(result.Duration.Seconds())
+	r.metrics.lastSucceeded.SetToCurrentTime()
+	r.setLastResult(result)
+	r.logger.Info("Re-encrypted values with the active key.",
+		zap.String("activeKeyID", result.ActiveKeyID),
+		zap.Int("keys", result.Keys),
+		zap.Int("reencrypted", result.Reencrypted),
+		zap.Duration("duration", result.Duration))
+	return result, nil
+}
+
+type ReencryptionStatus struct {
+	Enabled     bool                `json:"enabled"`
+	Running     bool                `json:"running"`
+	ActiveKeyID string              `json:"activeKeyId,omitempty"`
+	Interval    time.Duration       `json:"interval"`
+	LastResult  *ReencryptionResult `json:"lastResult,omitempty"`
+}
+
+func (r *Reencryptor) Status() ReencryptionStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return ReencryptionStatus{
+		Enabled:     r.store.encryptor.Enabled(),
+		Running:     r.running,
+		ActiveKeyID: r.store.encryptor.ActiveKeyID(),
+		Interval:    r.cfg.ReencryptionInterval,
+		LastResult:  r.lastResult,
+	}
+}
+
+func (r *Reencryptor) setRunning(running bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running = running
+}
+
+func (r *Reencryptor) setLastResult(result *ReencryptionResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastResult = result
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// KeyValue 는 Scan 의 결과이다. Value 는 복호화된 저장 값이다.
+type KeyValue struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type ScanReq struct {
+	BucketName []byte `json:"bucketName"`
+	Prefix     []byte `json:"prefix,omitempty"`
+	After      []byte `json:"after,omitempty"`
+	Limit      int    `json:"limit"`
+}
+
+// Scan 은 bucket 에서 prefix 로 시작하고 after 보다 큰 key 를 정렬된 순서로 최대 limit 개 반환한다.
+func (ls *LocalStore) Scan(ctx context.Context, bucketName, prefix, after []byte, limit int) ([]*KeyValue, error) {
+	if IsInternalBucket(bucketName) {
+		return nil, errors.Wrapf(ErrInternalBucket, "bucketName=%s", string(bucketName))
+	}
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var kvs []*KeyValue
+	err := ls.view(func(tx Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		seek := prefix
+		if bytes.Compare(after, prefix) >= 0 {
+			seek = after
+		}
+		key, value := cursor.First()
+		if len(seek) > 0 {
+			key, value = cursor.Seek(seek)
+		}
+		if after != nil && bytes.Equal(key, after) {
+			key, value = cursor.Next()
+		}
+		for ; key != nil && bytes.HasPrefix(key, prefix) && len(kvs) < limit; key, value = cursor.Next() {
+			if value == nil {
+				continue
+			}
+			// bolt 의 값은 트랜잭션 안에서만 유효하므로 복사해서 반환한다.
+			kvs = append(kvs, &KeyValue{
+				Key:   append([]byte(nil), key...),
+				Value: append([]byte(nil), value...),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range kvs {
+		decrypted, err := ls.encryptor.Decrypt(ctx, bucketName, kv.Key, kv.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decrypt the value : key=%s", string(kv.Key))
+		}
+		kv.Value = decrypted
+	}
+	return kvs, nil
+}
+
+// Scan 은 원격 인스턴스의 bucket 을 LocalStore.Scan 과 같은 방식으로 읽는다.
+func (hs *HTTPStore) Scan(ctx context.Context, bucketName, prefix, after []byte, limit int) ([]*KeyValue, error) {
+	marshaled, err := json.Marshal(&ScanReq{BucketName: bucketName, Prefix: prefix, After: after, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hs.baseUrl+"/v1/internal/scan", bytes.NewReader(marshaled))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status of scan : baseUrl=%s status=%d", hs.baseUrl, resp.StatusCode)
+	}
+	var kvs []*KeyValue
+	if err := json.NewDecoder(resp.Body).Decode(&kvs); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the scan result")
+	}
+	return kvs, nil
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ReadSnapshot 은 Backup 으로 만들어진 bolt 스냅샷 파일을 읽기 전용으로 열어 모든 key-value 를 순회한다.
+// 변경 기록처럼 노드에 속한 내부 bucket 은 건너뛴다.
+func ReadSnapshot(path string, fn func(bucketName, key, value []byte) error) error {
+	db, err := openBBolt(path, 0600, &EngineOptions{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return errors.Wrapf(err, "failed to open the snapshot : path=%s", path)
+	}
+	defer db.Close()
+
+	return db.View(func(tx Tx) error {
+		return tx.ForEach(func(bucketName []byte, bucket Bucket) error {
+			if IsInternalBucket(bucketName) {
+				return nil
+			}
+			return bucket.ForEach(func(key, value []byte) error {
+				if value == nil {
+					// 중첩 bucket 은 사용하지 않는다.
+					return nil
+				}
+				return fn(bucketName, key, value)
+			})
+		})
+	})
+}
@@ -3,71 +3,228 @@ package store
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"go.uber.org/zap"
 	"io"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/pkg/errors"
 )
 
 type LocalStore struct {
-	db     *bolt.DB
-	logger *zap.Logger
+	// mu 는 compaction 이 bolt 파일을 교체하는 동안 db 에 대한 접근을 막는다.
+	mu        sync.RWMutex
+	db        Engine
+	options   *EngineOptions
+	encryptor *Encryptor
+	quota     QuotaChecker
+	usage     *usageTracker
+	txs       *txTracker
+	logger    *zap.Logger
+
+	changeLogCfg *ChangeLogConfig
+	changes      *changeNotifier
+
+	reencryptMu   sync.Mutex
+	compactMu     sync.Mutex
+	pendingMu     sync.Mutex
+	buffering     bool
+	pendingBroken bool
+	pendingOps    []pendingWrite
+
+	readProbe atomic.Bool
+	lockProbe atomic.Bool
 }
 
-func NewLocalStore(db *bolt.DB, logger *zap.Logger) *LocalStore {
-	return &LocalStore{
-		db:     db,
-		logger: logger,
+// NewLocalStore 는 db 의 사용량을 센 뒤 LocalStore 를 만든다. quota 가 nil 이면 사용량을 제한하지 않는다.
+func NewLocalStore(cfg *ChangeLogConfig, db Engine, encryptor *Encryptor, quota QuotaChecker, logger *zap.Logger) (*LocalStore, error) {
+	ls := &LocalStore{
+		db:           db,
+		encryptor:    encryptor,
+		quota:        quota,
+		usage:        newUsageTracker(),
+		txs:          newTxTracker(),
+		logger:       logger,
+		changeLogCfg: cfg,
+		changes:      newChangeNotifier(),
 	}
+	if err := ls.loadUsage(); err != nil {
+		return nil, err
+	}
+	return ls, nil
 }
 
-func Open(path string, mode os.FileMode, options *bolt.Options, logger *zap.Logger) (*LocalStore, error) {
-	boltdb, err := bolt.Open(path, mode, options)
+func Open(cfg *ChangeLogConfig, engine, path string, mode os.FileMode, options *EngineOptions, encryptor *Encryptor, quota QuotaChecker, logger *zap.Logger) (*LocalStore, error) {
+	db, err := OpenEngine(engine, path, mode, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the storage engine")
+	}
+	ls, err := NewLocalStore(cfg, db, encryptor, quota, logger)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create BoltDB")
+		_ = db.Close()
+		return nil, err
 	}
-	return NewLocalStore(boltdb, logger), nil
+	ls.options = options
+	return ls, nil
 }
 
 func (ls *LocalStore) Get(ctx context.Context, bucketName, key []byte) ([]byte, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
 	var value []byte
-	if err := ls.db.View(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists(bucketName)
-		if err != nil {
-			return errors.Wrapf(err, "failed to create or get bucket in view : bucketName=%s", string(bucketName))
+	if err := ls.view(func(tx Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+		// bolt 의 값은 트랜잭션 안에서만 유효하므로 복사해서 반환한다.
+		if v := bucket.Get(key); v != nil {
+			value = append([]byte(nil), v...)
 		}
-		value = bucket.Get(key)
 		return nil
 
 	}); err != nil {
 		return nil, err
 	}
-	return value, nil
+	if value == nil {
+		return nil, nil
+	}
+	return ls.encryptor.Decrypt(ctx, bucketName, key, value)
 }
 
 func (ls *LocalStore) Put(ctx context.Context, bucketName, key, value []byte) error {
-	return ls.db.Update(func(tx *bolt.Tx) error {
+	if IsInternalBucket(bucketName) {
+		return errors.Wrapf(ErrInternalBucket, "bucketName=%s", string(bucketName))
+	}
+	encrypted, err := ls.encryptor.Encrypt(ctx, bucketName, key, value)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encrypt the value : key=%s", string(key))
+	}
+
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var delta Usage
+	err = ls.update(func(tx Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists(bucketName)
 		if err != nil {
 			return errors.Wrapf(err, "failed to create or get bucket in update : bucketName=%s", string(bucketName))
 		}
-		if err := bucket.Put(key, value); err != nil {
-			return errors.Wrapf(err, "failed to put key-value : key=%s value=%s", string(key), string(value))
+		delta = putDelta(key, bucket.Get(key), encrypted)
+		if ls.quota != nil && (delta.Keys > 0 || delta.Bytes > 0) {
+			namespace := Namespace(bucketName)
+			if err := ls.quota.CheckQuota(namespace, ls.usage.get(namespace), delta); err != nil {
+				return err
+			}
 		}
-		return nil
+		if err := bucket.Put(key, encrypted); err != nil {
+			return errors.Wrapf(err, "failed to put key-value : key=%s", string(key))
+		}
+		// bolt 의 쓰기 트랜잭션은 직렬화되므로 여기서 기록하면 commit 순서가 유지된다.
+		ls.bufferIfCompacting(bucketName, key, encrypted)
+		return ls.appendChange(ctx, tx, &ChangeEvent{Type: ChangePut, BucketName: bucketName, Key: key, Value: value})
+	})
+	if err != nil {
+		ls.invalidateBuffer()
+		return err
+	}
+	ls.usage.add(bucketName, delta)
+	ls.changes.notify()
+	return nil
+}
+
+// Delete 는 key 를 지우고 변경을 기록한다. key 가 없으면 아무것도 하지 않는다.
+func (ls *LocalStore) Delete(ctx context.Context, bucketName, key []byte) error {
+	if IsInternalBucket(bucketName) {
+		return errors.Wrapf(ErrInternalBucket, "bucketName=%s", string(bucketName))
+	}
+
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	deleted := false
+	var delta Usage
+	err := ls.update(func(tx Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+		old := bucket.Get(key)
+		if old == nil {
+			return nil
+		}
+		delta = Usage{Keys: -1, Bytes: -int64(len(key) + len(old))}
+		if err := bucket.Delete(key); err != nil {
+			return errors.Wrapf(err, "failed to delete key : key=%s", string(key))
+		}
+		ls.bufferDeleteIfCompacting(bucketName, key)
+		deleted = true
+		return ls.appendChange(ctx, tx, &ChangeEvent{Type: ChangeDelete, BucketName: bucketName, Key: key})
+	})
+	if err != nil {
+		ls.invalidateBuffer()
+		return err
+	}
+	if deleted {
+		ls.usage.add(bucketName, delta)
+		ls.changes.notify()
+	}
+	return nil
+}
+
+// Backup 은 현재 시점의 일관된 bolt 파일 스냅샷을 w 에 기록한다.
+// 읽기 트랜잭션 안에서 수행되므로 쓰기를 막지 않는다. 암호화된 값은 암호화된 채로 기록된다.
+func (ls *LocalStore) Backup(ctx context.Context, w io.Writer) (int64, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var written int64
+	err := ls.db.View(func(tx Tx) error {
+		n, err := tx.WriteTo(w)
+		written = n
+		return err
 	})
+	if err != nil {
+		return written, errors.Wrap(err, "failed to write the snapshot")
+	}
+	return written, nil
+}
+
+// Stats 는 엔진의 종류, 파일 크기와 page, 트랜잭션 통계를 반환한다.
+func (ls *LocalStore) Stats() EngineStats {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	stats := ls.db.Stats()
+	stats.Engine = ls.db.Name()
+	stats.Path = ls.db.Path()
+	if stats.Path != "" {
+		if info, err := os.Stat(stats.Path); err == nil {
+			stats.FileSize = info.Size()
+		}
+	}
+	return stats
 }
 
 const contentType = "application/json"
 
 type HTTPStore struct {
-	client  *http.Client
-	baseUrl string
+	client *http.Client
+	// streamClient 는 스냅샷처럼 큰 응답을 받을 때 사용하며 timeout 이 없다.
+	streamClient *http.Client
+	baseUrl      string
+}
+
+// BaseURL 은 원격 인스턴스의 API 주소이다.
+func (hs *HTTPStore) BaseURL() string {
+	return hs.baseUrl
 }
 
 func NewHTTPStoreWithDefault(baseUrl string) *HTTPStore {
@@ -75,15 +232,38 @@ func NewHTTPStoreWithDefault(baseUrl string) *HTTPStore {
 }
 
 func NewHTTPStore(cfg *HttpStoreConfig, baseUrl string) *HTTPStore {
+	var transport http.RoundTripper = http.DefaultTransport
+	if cfg.TLSConfig != nil {
+		tlsTransport := http.DefaultTransport.(*http.Transport).Clone()
+		tlsTransport.TLSClientConfig = cfg.TLSConfig
+		transport = tlsTransport
+	}
+	if cfg.Token != "" {
+		transport = &bearerTransport{token: cfg.Token, next: transport}
+	}
 	client := &http.Client{
-		Timeout: cfg.Timeout,
+		Timeout:   cfg.Timeout,
+		Transport: transport,
 	}
 	return &HTTPStore{
-		client:  client,
-		baseUrl: baseUrl,
+		client:       client,
+		streamClient: &http.Client{Transport: transport},
+		baseUrl:      baseUrl,
 	}
 }
 
+// bearerTransport 는 모든 요청에 Authorization 헤더로 token 을 붙인다.
+type bearerTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(req)
+}
+
 func (hs *HTTPStore) Get(ctx context.Context, bucketName, key []byte) ([]byte, error) {
 	reqBody := &GetReq{
 		BucketName: bucketName,
@@ -114,16 +294,111 @@ func (hs *HTTPStore) Put(ctx context.Context, bucketName, key, value []byte) err
 		return err
 	}
 	content := bytes.NewBuffer(marshaled)
-	resp, err := http.Post(hs.baseUrl+"/v1/internal/put", contentType, content)
+	resp, err := hs.client.Post(hs.baseUrl+"/v1/internal/put", contentType, content)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusInsufficientStorage {
+		return errors.Wrapf(ErrQuotaExceeded, "baseUrl=%s bucketName=%s", hs.baseUrl, string(bucketName))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status of put : baseUrl=%s status=%d", hs.baseUrl, resp.StatusCode)
+	}
+	return nil
+}
+
+func (hs *HTTPStore) Delete(ctx context.Context, bucketName, key []byte) error {
+	reqBody := &DeleteReq{
+		BucketName: bucketName,
+		Key:        key,
+	}
+	marshaled, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+	content := bytes.NewBuffer(marshaled)
+	resp, err := hs.client.Post(hs.baseUrl+"/v1/internal/delete", contentType, content)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status of delete : baseUrl=%s status=%d", hs.baseUrl, resp.StatusCode)
+	}
 	return nil
 }
 
+// Changes 는 원격 인스턴스의 변경 기록을 since 다음 revision 부터 최대 limit 개 가져온다.
+func (hs *HTTPStore) Changes(ctx context.Context, since uint64, limit int) ([]*ChangeEvent, error) {
+	url := fmt.Sprintf("%s/v1/internal/changes?since=%d&limit=%d", hs.baseUrl, since, limit)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return nil, errors.Wrapf(ErrRevisionCompacted, "baseUrl=%s since=%d", hs.baseUrl, since)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status of changes : baseUrl=%s status=%d", hs.baseUrl, resp.StatusCode)
+	}
+	var changes []*ChangeEvent
+	if err := json.NewDecoder(resp.Body).Decode(&changes); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the changes")
+	}
+	return changes, nil
+}
+
+// Usage 는 원격 인스턴스의 namespace 별 사용량을 가져온다.
+func (hs *HTTPStore) Usage(ctx context.Context) (map[string]Usage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hs.baseUrl+"/v1/internal/usage", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status of usage : baseUrl=%s status=%d", hs.baseUrl, resp.StatusCode)
+	}
+	var usage map[string]Usage
+	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the usage")
+	}
+	return usage, nil
+}
+
+// Backup 은 원격 인스턴스의 bolt 스냅샷을 내려받아 w 에 기록한다.
+func (hs *HTTPStore) Backup(ctx context.Context, w io.Writer) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hs.baseUrl+"/admin/backup", nil)
+	if err != nil {
+		return 0, err
+	}
+	// 스냅샷은 크기가 클 수 있으므로 timeout 이 없는 client 를 사용한다.
+	resp, err := hs.streamClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Errorf("unexpected status of backup : baseUrl=%s status=%d", hs.baseUrl, resp.StatusCode)
+	}
+	return io.Copy(w, resp.Body)
+}
+
 type HttpStoreConfig struct {
 	Timeout time.Duration `yaml:"timeout"`
+	// Token 이 있으면 다른 인스턴스에 보내는 요청에 Bearer token 으로 붙인다.
+	Token string `yaml:"-"`
+	// TLSConfig 가 있으면 https 요청에 사용한다.
+	TLSConfig *tls.Config `yaml:"-"`
 }
 
 type GetReq struct {
@@ -136,3 +411,8 @@ type PutReq struct {
 	Key        []byte `json:"key"`
 	Value      []byte `json:"value"`
 }
+
+type DeleteReq struct {
+	BucketName []byte `json:"bucketName"`
+	Key        []byte `json:"key"`
+}
@@ -0,0 +1,139 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// NamespaceSeparator 앞부분이 bucket 의 namespace 이다. 구분자가 없는 bucket 의 namespace 는 "" 이다.
+// multi-tenancy 에서는 tenant 가 namespace 가 된다.
+const NamespaceSeparator = '/'
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Usage 는 저장된 key 의 수와 key 와 값이 차지하는 byte 수이다. 값은 저장된 그대로(압축, 암호화 후)의 크기이다.
+type Usage struct {
+	Keys  int64 `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (u Usage) add(other Usage) Usage {
+	return Usage{Keys: u.Keys + other.Keys, Bytes: u.Bytes + other.Bytes}
+}
+
+// QuotaChecker 는 namespace 의 사용량을 늘리는 쓰기를 허용할지 결정한다.
+// current 는 쓰기 전 이 노드에서의 namespace 사용량이고 delta 는 쓰기로 늘어나는 양이다.
+type QuotaChecker interface {
+	CheckQuota(namespace string, current, delta Usage) error
+}
+
+// Namespace 는 bucket 이름에서 namespace 를 꺼낸다.
+func Namespace(bucketName []byte) string {
+	i := bytes.IndexByte(bucketName, NamespaceSeparator)
+	if i < 0 {
+		return ""
+	}
+	return string(bucketName[:i])
+}
+
+// usageTracker 는 namespace 별 사용량을 메모리에 유지한다. 시작할 때 한 번 전체를 세고 이후에는 쓰기마다 갱신한다.
+type usageTracker struct {
+	mu         sync.RWMutex
+	namespaces map[string]Usage
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{namespaces: make(map[string]Usage)}
+}
+
+func (t *usageTracker) get(namespace string) Usage {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.namespaces[namespace]
+}
+
+func (t *usageTracker) add(bucketName []byte, delta Usage) {
+	if delta == (Usage{}) || IsInternalBucket(bucketName) {
+		return
+	}
+	namespace := Namespace(bucketName)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	usage := t.namespaces[namespace].add(delta)
+	if usage.Keys <= 0 {
+		delete(t.namespaces, namespace)
+		return
+	}
+	t.namespaces[namespace] = usage
+}
+
+func (t *usageTracker) snapshot() map[string]Usage {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	snapshot := make(map[string]Usage, len(t.namespaces))
+	for namespace, usage := range t.namespaces {
+		snapshot[namespace] = usage
+	}
+	return snapshot
+}
+
+func (t *usageTracker) reset(namespaces map[string]Usage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.namespaces = namespaces
+}
+
+// putDelta 는 old 를 value 로 덮어쓸 때 늘어나는 사용량이다. old 가 nil 이면 새 key 이다.
+func putDelta(key, old, value []byte) Usage {
+	if old == nil {
+		return Usage{Keys: 1, Bytes: int64(len(key) + len(value))}
+	}
+	return Usage{Bytes: int64(len(value) - len(old))}
+}
+
+// Usage 는 이 노드의 namespace 별 사용량을 반환한다.
+func (ls *LocalStore) Usage(ctx context.Context) (map[string]Usage, error) {
+	return ls.usage.snapshot(), nil
+}
+
+// NamespaceUsage 는 이 노드에서 namespace 의 사용량을 반환한다.
+func (ls *LocalStore) NamespaceUsage(namespace string) Usage {
+	return ls.usage.get(namespace)
+}
+
+// loadUsage 는 모든 bucket 을 읽어서 namespace 별 사용량을 다시 센다.
+func (ls *LocalStore) loadUsage() error {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	namespaces := make(map[string]Usage)
+	err := ls.view(func(tx Tx) error {
+		return tx.ForEach(func(bucketName []byte, bucket Bucket) error {
+			if IsInternalBucket(bucketName) {
+				return nil
+			}
+			var usage Usage
+			if err := bucket.ForEach(func(key, value []byte) error {
+				if value != nil {
+					usage = usage.add(putDelta(key, nil, value))
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			if usage.Keys > 0 {
+				namespace := Namespace(bucketName)
+				namespaces[namespace] = namespaces[namespace].add(usage)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to count the usage")
+	}
+	ls.usage.reset(namespaces)
+	return nil
+}
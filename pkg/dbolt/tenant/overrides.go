@@ -0,0 +1,31 @@
+package tenant
+
+import "sync"
+
+// Overrides 는 quota 와 요청 속도 제한에 적용하는 tenant 별 제한이다. 설정을 다시 읽으면 ApplyConfig 로 교체된다.
+// shard_size 를 바꾸면 bucket 의 배치가 바뀌므로 교체하지 않고 처음 설정을 그대로 쓴다.
+type Overrides struct {
+	mu      sync.RWMutex
+	initial *Config
+	current *Config
+}
+
+func NewOverrides(cfg *Config) *Overrides {
+	return &Overrides{initial: cfg, current: cfg}
+}
+
+// LimitsFor 는 tenant 에 지금 적용되는 제한을 반환한다.
+func (o *Overrides) LimitsFor(tenantID string) Limits {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	limits := o.current.LimitsFor(tenantID)
+	limits.ShardSize = o.initial.ShardSize(tenantID)
+	return limits
+}
+
+// ApplyConfig 는 default_limits 와 overrides 를 cfg 의 것으로 바꾼다. cfg 는 이후에 수정하면 안 된다.
+func (o *Overrides) ApplyConfig(cfg *Config) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.current = cfg
+}
@@ -0,0 +1,315 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/grafana/dskit/limiter"
+	"github.com/grafana/dskit/ring"
+	dskittenant "github.com/grafana/dskit/tenant"
+	"github.com/grafana/dskit/user"
+	"github.com/kwSeo/dbolt/pkg/dbolt/distributor"
+	"github.com/kwSeo/dbolt/pkg/dbolt/store"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// HeaderName 은 인증에서 tenant 가 정해지지 않았을 때 tenant ID 를 읽는 헤더이다.
+const HeaderName = user.OrgIDHeaderName
+
+// limiterRecheckPeriod 마다 tenant 의 요청 속도 제한 설정을 다시 읽는다.
+const limiterRecheckPeriod = 10 * time.Second
+
+var ErrNoTenant = errors.New("no tenant ID")
+
+// Limits 는 tenant 하나에 대한 제한이다. 0 이면 제한하지 않는다.
+// key 와 byte 수는 각 인스턴스가 가진 replica 에 대해 인스턴스마다 따로 적용된다.
+type Limits struct {
+	MaxKeys      int64   `yaml:"max_keys" json:"maxKeys"`
+	MaxBytes     int64   `yaml:"max_bytes" json:"maxBytes"`
+	RequestRate  float64 `yaml:"request_rate" json:"requestRate"`
+	RequestBurst int     `yaml:"request_burst" json:"requestBurst"`
+	// ShardSize 는 tenant 의 bucket 을 배치할 인스턴스 수이다. 0 이면 모든 인스턴스를 사용한다.
+	ShardSize int `yaml:"shard_size" json:"shardSize"`
+}
+
+func (l *Limits) Validate() error {
+	if l.MaxKeys < 0 || l.MaxBytes < 0 || l.RequestRate < 0 || l.RequestBurst < 0 || l.ShardSize < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+type Config struct {
+	Enabled       bool              `yaml:"enabled"`
+	DefaultLimits Limits            `yaml:"default_limits"`
+	Overrides     map[string]Limits `yaml:"overrides"`
+}
+
+func (c *Config) Validate() error {
+	if err := c.DefaultLimits.Validate(); err != nil {
+		return errors.Wrap(err, "invalid tenancy 'default_limits'")
+	}
+	for tenantID, limits := range c.Overrides {
+		if err := dskittenant.ValidTenantID(tenantID); err != nil {
+			return errors.Wrapf(err, "invalid tenancy 'overrides' : tenant=%s", tenantID)
+		}
+		if err := limits.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid tenancy 'overrides' : tenant=%s", tenantID)
+		}
+	}
+	return nil
+}
+
+// LimitsFor 는 tenant 에 적용되는 제한을 반환한다.
+func (c *Config) LimitsFor(tenantID string) Limits {
+	if limits, ok := c.Overrides[tenantID]; ok {
+		return limits
+	}
+	return c.DefaultLimits
+}
+
+// ShardSize 는 distributor.ShardingLimits 를 구현한다.
+func (c *Config) ShardSize(tenantID string) int {
+	return c.LimitsFor(tenantID).ShardSize
+}
+
+// Inject 는 인증 등으로 정해진 tenant 를 ctx 에 넣는다.
+func Inject(ctx context.Context, tenantID string) context.Context {
+	return user.InjectOrgID(ctx, tenantID)
+}
+
+// ID 는 ctx 의 tenant ID 를 검증해서 반환한다.
+func ID(ctx context.Context) (string, error) {
+	if _, err := user.ExtractOrgID(ctx); err != nil {
+		return "", ErrNoTenant
+	}
+	return dskittenant.TenantID(ctx)
+}
+
+// Bucket 은 tenant 의 bucket 을 저장소의 bucket 이름으로 바꾼다.
+// tenant ID 에는 store.NamespaceSeparator 가 들어갈 수 없으므로 tenant 는 bucket 의 namespace 가 된다.
+func Bucket(tenantID string, bucket []byte) []byte {
+	bucketName := make([]byte, 0, len(tenantID)+1+len(bucket))
+	bucketName = append(bucketName, tenantID...)
+	bucketName = append(bucketName, store.NamespaceSeparator)
+	return append(bucketName, bucket...)
+}
+
+// SplitBucket 은 저장소의 bucket 이름을 tenant 와 tenant 의 bucket 으로 나눈다.
+func SplitBucket(bucketName []byte) (string, []byte, bool) {
+	i := bytes.IndexByte(bucketName, store.NamespaceSeparator)
+	if i < 0 {
+		return "", nil, false
+	}
+	return string(bucketName[:i]), bucketName[i+1:], true
+}
+
+// UsageReader 는 인스턴스의 namespace 별 사용량을 읽을 수 있는 Store 이다.
+type UsageReader interface {
+	Usage(ctx context.Context) (map[string]store.Usage, error)
+}
+
+type TenantUsage struct {
+	Tenant string      `json:"tenant"`
+	Usage  store.Usage `json:"usage"`
+	Limits Limits      `json:"limits"`
+}
+
+// Quotas 는 tenant 의 key 와 byte 제한을 LocalStore 의 쓰기에 적용한다.
+type Quotas struct {
+	cfg     *Config
+	limits  *Overrides
+	metrics *metrics
+}
+
+func NewQuotas(cfg *Config, overrides *Overrides, reg prometheus.Registerer) *Quotas {
+	return &Quotas{cfg: cfg, limits: overrides, metrics: newMetrics(reg)}
+}
+
+func (q *Quotas) CheckQuota(namespace string, current, delta store.Usage) error {
+	if !q.cfg.Enabled || namespace == "" {
+		return nil
+	}
+	limits := q.limits.LimitsFor(namespace)
+	if limits.MaxKeys > 0 && delta.Keys > 0 && current.Keys+delta.Keys > limits.MaxKeys {
+		q.metrics.rejected.WithLabelValues(namespace, "max_keys").Inc()
+		return errors.Wrapf(store.ErrQuotaExceeded, "tenant=%s keys=%d maxKeys=%d", namespace, current.Keys, limits.MaxKeys)
+	}
+	if limits.MaxBytes > 0 && delta.Bytes > 0 && current.Bytes+delta.Bytes > limits.MaxBytes {
+		q.metrics.rejected.WithLabelValues(namespace, "max_bytes").Inc()
+		return errors.Wrapf(store.ErrQuotaExceeded, "tenant=%s bytes=%d maxBytes=%d", namespace, current.Bytes, limits.MaxBytes)
+	}
+	return nil
+}
+
+type Service struct {
+	cfg        *Config
+	limits     *Overrides
+	limiter    *limiter.RateLimiter
+	metrics    *metrics
+	readRing   ring.ReadRing
+	storePool  *distributor.SimpleStorePool
+	localStore *store.LocalStore
+	logger     *zap.Logger
+}
+
+func New(cfg *Config, quotas *Quotas, r ring.ReadRing, storePool *distributor.SimpleStorePool, localStore *store.LocalStore, reg prometheus.Registerer, logger *zap.Logger) *Service {
+	if cfg.Enabled {
+		reg.MustRegister(&usageCollector{localStore: localStore})
+	}
+	return &Service{
+		cfg:        cfg,
+		limits:     quotas.limits,
+		limiter:    limiter.NewRateLimiter(&rateLimits{limits: quotas.limits}, limiterRecheckPeriod),
+		metrics:    quotas.metrics,
+		readRing:   r,
+		storePool:  storePool,
+		localStore: localStore,
+		logger:     logger,
+	}
+}
+
+func (s *Service) Enabled() bool {
+	return s.cfg.Enabled
+}
+
+// Allow 는 tenant 의 요청을 기록하고 요청 속도 제한 안이면 true 를 반환한다. 속도 제한은 인스턴스마다 따로 적용된다.
+func (s *Service) Allow(tenantID string) bool {
+	s.metrics.requests.WithLabelValues(tenantID).Inc()
+	if s.limiter.AllowN(time.Now(), tenantID, 1) {
+		return true
+	}
+	s.metrics.rejected.WithLabelValues(tenantID, "rate_limited").Inc()
+	return false
+}
+
+// RetryAfter 는 요청 속도 제한을 넘은 tenant 가 다시 요청할 수 있을 때까지의 시간이다.
+func (s *Service) RetryAfter(tenantID string) time.Duration {
+	limit := s.limiter.Limit(time.Now(), tenantID)
+	if limit <= 0 || math.IsInf(limit, 1) {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / limit)
+}
+
+// Usage 는 tenant 별 사용량을 반환한다.
+// cluster 이면 모든 healthy 인스턴스의 사용량을 더하므로 replica 가 모두 포함된다.
+func (s *Service) Usage(ctx context.Context, cluster bool) ([]*TenantUsage, error) {
+	var namespaces map[string]store.Usage
+	var err error
+	if cluster {
+		namespaces, err = s.clusterUsage(ctx)
+	} else {
+		namespaces, err = s.localStore.Usage(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	usages := make([]*TenantUsage, 0, len(namespaces))
+	for namespace, usage := range namespaces {
+		if namespace == "" {
+			continue
+		}
+		usages = append(usages, &TenantUsage{Tenant: namespace, Usage: usage, Limits: s.limits.LimitsFor(namespace)})
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Tenant < usages[j].Tenant
+	})
+	return usages, nil
+}
+
+func (s *Service) clusterUsage(ctx context.Context) (map[string]store.Usage, error) {
+	replicationSet, err := s.readRing.GetAllHealthy(ring.Reporting)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read all healthy instances")
+	}
+	total := make(map[string]store.Usage)
+	for _, addr := range replicationSet.GetAddresses() {
+		reader, ok := s.storePool.Get(addr).(UsageReader)
+		if !ok {
+			s.logger.Warn("Skipping instance without usage.", zap.String("addr", addr))
+			continue
+		}
+		namespaces, err := reader.Usage(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read usage : addr=%s", addr)
+		}
+		for namespace, usage := range namespaces {
+			current := total[namespace]
+			total[namespace] = store.Usage{Keys: current.Keys + usage.Keys, Bytes: current.Bytes + usage.Bytes}
+		}
+	}
+	return total, nil
+}
+
+type rateLimits struct {
+	limits *Overrides
+}
+
+func (r *rateLimits) Limit(tenantID string) float64 {
+	if limit := r.limits.LimitsFor(tenantID).RequestRate; limit > 0 {
+		return limit
+	}
+	return math.Inf(1)
+}
+
+func (r *rateLimits) Burst(tenantID string) int {
+	limits := r.limits.LimitsFor(tenantID)
+	if limits.RequestBurst > 0 {
+		return limits.RequestBurst
+	}
+	// burst 를 정하지 않으면 1초 동안의 요청 수만큼 허용한다.
+	return int(math.Max(1, math.Ceil(limits.RequestRate)))
+}
+
+type metrics struct {
+	requests *prometheus.CounterVec
+	rejected *prometheus.CounterVec
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	factory := promauto.With(reg)
+	return &metrics{
+		requests: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbolt_tenant_requests_total",
+			Help: "Total number of API requests per tenant.",
+		}, []string{"tenant"}),
+		rejected: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbolt_tenant_rejected_total",
+			Help: "Total number of requests and writes rejected by tenant limits.",
+		}, []string{"tenant", "reason"}),
+	}
+}
+
+var (
+	keysDesc  = prometheus.NewDesc("dbolt_tenant_keys", "Number of keys stored for the tenant on this instance.", []string{"tenant"}, nil)
+	bytesDesc = prometheus.NewDesc("dbolt_tenant_bytes", "Bytes of keys and stored values for the tenant on this instance.", []string{"tenant"}, nil)
+)
+
+// usageCollector 는 scrape 할 때마다 LocalStore 의 사용량을 tenant 별 gauge 로 내보낸다.
+type usageCollector struct {
+	localStore *store.LocalStore
+}
+
+func (c *usageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- keysDesc
+	ch <- bytesDesc
+}
+
+func (c *usageCollector) Collect(ch chan<- prometheus.Metric) {
+	namespaces, _ := c.localStore.Usage(context.Background())
+	for namespace, usage := range namespaces {
+		if namespace == "" {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(keysDesc, prometheus.GaugeValue, float64(usage.Keys), namespace)
+		ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.GaugeValue, float64(usage.Bytes), namespace)
+	}
+}
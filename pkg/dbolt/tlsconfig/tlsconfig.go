@@ -0,0 +1,317 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// reloadInterval 마다 인증서 파일의 수정 시각을 확인해서 바뀌었으면 다시 읽는다.
+const reloadInterval = 10 * time.Second
+
+var (
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		"":        tls.NoClientCert,
+		"none":    tls.NoClientCert,
+		"request": tls.VerifyClientCertIfGiven,
+		"require": tls.RequireAndVerifyClientCert,
+	}
+	tlsVersions = map[string]uint16{
+		"":    tls.VersionTLS12,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+// ServerConfig 는 client 요청을 받는 listener 의 TLS 설정이다.
+type ServerConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertPath string `yaml:"cert_path"`
+	KeyPath  string `yaml:"key_path"`
+	// ClientCAPath 는 client 인증서를 검증할 CA 이다. ClientAuth 가 request 또는 require 이면 필요하다.
+	ClientCAPath string `yaml:"client_ca_path"`
+	// ClientAuth 는 none, request(인증서가 있으면 검증), require 중 하나이다.
+	ClientAuth string `yaml:"client_auth"`
+	// MinVersion 은 1.2 또는 1.3 이다.
+	MinVersion string `yaml:"min_version"`
+}
+
+func (c *ServerConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.CertPath == "" || c.KeyPath == "" {
+		return errors.New("'cert_path' and 'key_path' required")
+	}
+	clientAuth, ok := clientAuthTypes[c.ClientAuth]
+	if !ok {
+		return errors.Errorf("unknown 'client_auth' : %s", c.ClientAuth)
+	}
+	if clientAuth != tls.NoClientCert && c.ClientCAPath == "" {
+		return errors.New("'client_ca_path' required when 'client_auth' is set")
+	}
+	if _, ok := tlsVersions[c.MinVersion]; !ok {
+		return errors.Errorf("unknown 'min_version' : %s", c.MinVersion)
+	}
+	return nil
+}
+
+// ClientConfig 는 다른 인스턴스에 요청할 때의 TLS 설정이다.
+type ClientConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CertPath, KeyPath 는 서버가 client 인증서를 요구할 때 사용한다.
+	CertPath string `yaml:"cert_path"`
+	KeyPath  string `yaml:"key_path"`
+	// CAPath 가 없으면 시스템의 CA 로 서버 인증서를 검증한다.
+	CAPath             string `yaml:"ca_path"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	MinVersion         string `yaml:"min_version"`
+}
+
+func (c *ClientConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if (c.CertPath == "") != (c.KeyPath == "") {
+		return errors.New("'cert_path' and 'key_path' must be set together")
+	}
+	if _, ok := tlsVersions[c.MinVersion]; !ok {
+		return errors.Errorf("unknown 'min_version' : %s", c.MinVersion)
+	}
+	return nil
+}
+
+// Reloader 는 인증서와 CA 를 파일에서 읽고, 파일이 바뀌면 다시 읽어서 새 연결부터 적용한다.
+type Reloader struct {
+	certPath string
+	keyPath  string
+	caPath   string
+	logger   *zap.Logger
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes map[string]time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newReloader(certPath, keyPath, caPath string, logger *zap.Logger) (*Reloader, error) {
+	r := &Reloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+		caPath:   caPath,
+		logger:   logger,
+		stop:     make(chan struct{}),
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) Start(ctx context.Context) error {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(reloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				if !r.changed() {
+					continue
+				}
+				if err := r.reload(); err != nil {
+					// 파일을 교체하는 도중일 수 있으므로 이전 인증서를 계속 사용하고 다음에 다시 시도한다.
+					r.logger.Warn("Failed to reload the certificates.", zap.Error(err))
+					continue
+				}
+				r.logger.Info("Reloaded the certificates.", zap.String("certPath", r.certPath), zap.String("caPath", r.caPath))
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *Reloader) Stop(ctx context.Context) error {
+	close(r.stop)
+	r.wg.Wait()
+	return nil
+}
+
+func (r *Reloader) paths() []string {
+	var paths []string
+	for _, path := range []string{r.certPath, r.keyPath, r.caPath} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, path := range r.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, path := range r.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to stat : path=%s", path)
+		}
+		modTimes[path] = info.ModTime()
+	}
+
+	var cert *tls.Certificate
+	if r.certPath != "" {
+		loaded, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load the key pair : cert=%s key=%s", r.certPath, r.keyPath)
+		}
+		cert = &loaded
+	}
+	var pool *x509.CertPool
+	if r.caPath != "" {
+		pem, err := os.ReadFile(r.caPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read the CA : path=%s", r.caPath)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.Errorf("no certificate in the CA : path=%s", r.caPath)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.pool, r.modTimes = cert, pool, modTimes
+	return nil
+}
+
+func (r *Reloader) certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+func (r *Reloader) certPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// Server 는 파일에서 다시 읽은 인증서를 새 연결부터 사용하는 서버의 TLS 설정이다.
+type Server struct {
+	*Reloader
+	cfg *ServerConfig
+}
+
+// NewServer 는 cfg 의 인증서를 읽는다. cfg 가 꺼져 있으면 nil 이다.
+func NewServer(cfg *ServerConfig, logger *zap.Logger) (*Server, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	r, err := newReloader(cfg.CertPath, cfg.KeyPath, cfg.ClientCAPath, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{Reloader: r, cfg: cfg}, nil
+}
+
+func (s *Server) Config() *tls.Config {
+	base := &tls.Config{
+		MinVersion: tlsVersions[s.cfg.MinVersion],
+		ClientAuth: clientAuthTypes[s.cfg.ClientAuth],
+	}
+	tlsConfig := base.Clone()
+	// client CA 도 바뀔 수 있으므로 연결마다 현재 인증서와 CA 로 설정을 만든다.
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		config := base.Clone()
+		config.Certificates = []tls.Certificate{*s.certificate()}
+		config.ClientCAs = s.certPool()
+		return config, nil
+	}
+	return tlsConfig
+}
+
+// Client 는 파일에서 다시 읽은 인증서와 CA 를 새 연결부터 사용하는 client 의 TLS 설정이다.
+type Client struct {
+	*Reloader
+	cfg *ClientConfig
+}
+
+// NewClient 는 cfg 의 인증서를 읽는다. cfg 가 꺼져 있으면 nil 이다.
+func NewClient(cfg *ClientConfig, logger *zap.Logger) (*Client, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	r, err := newReloader(cfg.CertPath, cfg.KeyPath, cfg.CAPath, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Reloader: r, cfg: cfg}, nil
+}
+
+// Config 는 host 에 연결할 때의 설정을 반환한다. ServerName 이 없으면 host 로 서버 인증서를 검증한다.
+func (c *Client) Config(host string) *tls.Config {
+	serverName := c.cfg.ServerName
+	if serverName == "" {
+		serverName = host
+		// Ring 의 인스턴스 주소에는 port 가 붙어 있다.
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			serverName = h
+		}
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tlsVersions[c.cfg.MinVersion],
+		ServerName:         serverName,
+		InsecureSkipVerify: c.cfg.InsecureSkipVerify,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert := c.certificate(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+	}
+	if c.cfg.CAPath != "" && !c.cfg.InsecureSkipVerify {
+		// RootCAs 는 바꿀 수 없으므로 기본 검증을 끄고 연결마다 현재 CA 로 직접 검증한다.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			opts := x509.VerifyOptions{
+				Roots:         c.certPool(),
+				DNSName:       serverName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range state.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := state.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	}
+	return tlsConfig
+}
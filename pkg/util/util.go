@@ -1,5 +1,10 @@
 package util
 
+import (
+	"fmt"
+	"strings"
+)
+
 func And(fns ...func() error) error {
 	for _, fn := range fns {
 		err := fn()
@@ -9,3 +14,39 @@ func And(fns ...func() error) error {
 	}
 	return nil
 }
+
+// All 은 And 와 달리 오류가 나도 fns 를 모두 실행하고, 실패한 fn 의 오류를 Errors 로 모아서 반환한다.
+// fn 이 Errors 를 반환하면 펼쳐서 모은다.
+func All(fns ...func() error) error {
+	var errs Errors
+	for _, fn := range fns {
+		err := fn()
+		if nested, ok := err.(Errors); ok {
+			errs = append(errs, nested...)
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Errors 는 All 이 모은 오류들이다.
+type Errors []error
+
+func (es Errors) Error() string {
+	if len(es) == 1 {
+		return es[0].Error()
+	}
+	msgs := make([]string, len(es))
+	for i, err := range es {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors : %s", len(es), strings.Join(msgs, "; "))
+}
+
+func (es Errors) Unwrap() []error {
+	return es
+}